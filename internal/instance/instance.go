@@ -0,0 +1,22 @@
+// Package instance tells apart the running copies of a plugin in their internal statistics.
+package instance
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+// last is the number of the last running copy
+var last int64
+
+// Tags returns the tags of the statistics of a new running copy of a plugin: instance, a number
+// distinct per copy, and alias when the plugin has one. The numbers follow the order in which the
+// copies are initialized, not the configuration: telegraf starts the processors in reverse order
+// and builds a second copy of each of them when aggregators are configured.
+func Tags(alias string) map[string]string {
+	tags := map[string]string{"instance": strconv.FormatInt(atomic.AddInt64(&last, 1), 10)}
+	if alias != "" {
+		tags["alias"] = alias
+	}
+	return tags
+}
@@ -0,0 +1,18 @@
+package instance
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTags(t *testing.T) {
+	first, second := Tags("core"), Tags("core")
+	require.Equal(t, "core", first["alias"])
+	require.Equal(t, "core", second["alias"])
+	require.NotEqual(t, first["instance"], second["instance"])
+
+	tags := Tags("")
+	require.NotContains(t, tags, "alias")
+	require.NotEmpty(t, tags["instance"])
+}
@@ -0,0 +1,41 @@
+package seriescache
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// Save writes the entries to the cache file as JSON - through a temporary file so a crash never
+// leaves a truncated cache
+func Save(path string, entries interface{}) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Load reads the entries of the cache file - they are left untouched when the file doesn't exist
+func Load(path string, entries interface{}) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, entries)
+}
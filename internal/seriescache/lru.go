@@ -0,0 +1,47 @@
+// Package seriescache holds the helpers shared by the processors keeping a cache of series: the
+// eviction order of the entries and the cache file saved across restarts.
+package seriescache
+
+import "container/list"
+
+// LRU orders the ids of the cache entries from the most to the least recently updated
+type LRU struct {
+	list  *list.List
+	index map[uint64]*list.Element
+}
+
+// NewLRU returns an empty LRU
+func NewLRU() *LRU {
+	return &LRU{list: list.New(), index: make(map[uint64]*list.Element)}
+}
+
+// Touch makes the id the most recently updated one, adding it when needed
+func (l *LRU) Touch(id uint64) {
+	if e, ok := l.index[id]; ok {
+		l.list.MoveToFront(e)
+		return
+	}
+	l.index[id] = l.list.PushFront(id)
+}
+
+// Remove forgets the id
+func (l *LRU) Remove(id uint64) {
+	if e, ok := l.index[id]; ok {
+		l.list.Remove(e)
+		delete(l.index, id)
+	}
+}
+
+// Oldest returns the least recently updated id, false when the LRU is empty
+func (l *LRU) Oldest() (uint64, bool) {
+	e := l.list.Back()
+	if e == nil {
+		return 0, false
+	}
+	return e.Value.(uint64), true
+}
+
+// Len returns the number of ids
+func (l *LRU) Len() int {
+	return l.list.Len()
+}
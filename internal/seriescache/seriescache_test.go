@@ -0,0 +1,58 @@
+package seriescache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLRU(t *testing.T) {
+	l := NewLRU()
+	_, ok := l.Oldest()
+	require.False(t, ok)
+
+	l.Touch(1)
+	l.Touch(2)
+	l.Touch(3)
+	l.Touch(1)
+	require.Equal(t, 3, l.Len())
+	oldest, ok := l.Oldest()
+	require.True(t, ok)
+	require.Equal(t, uint64(2), oldest)
+
+	l.Remove(2)
+	l.Remove(4)
+	require.Equal(t, 2, l.Len())
+	oldest, _ = l.Oldest()
+	require.Equal(t, uint64(3), oldest)
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "processor.cache")
+	saved := map[uint64]string{1: "a", 2: "b"}
+	require.NoError(t, Save(path, saved))
+
+	loaded := make(map[uint64]string)
+	require.NoError(t, Load(path, &loaded))
+	require.Equal(t, saved, loaded)
+
+	// the temporary file is renamed
+	files, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	loaded := make(map[uint64]string)
+	require.NoError(t, Load(filepath.Join(t.TempDir(), "processor.cache"), &loaded))
+	require.Empty(t, loaded)
+}
+
+func TestLoadInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "processor.cache")
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0600))
+	loaded := make(map[uint64]string)
+	require.Error(t, Load(path, &loaded))
+}
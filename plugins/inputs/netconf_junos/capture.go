@@ -0,0 +1,78 @@
+package netconf_junos
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf/metric"
+)
+
+// Header written at the top of each captured file - needed to replay it
+const captureHeader = "<!-- netconf_junos capture device=%q subscription=%q time=%q -->\n"
+
+var captureHeaderRe = regexp.MustCompile(`^<!-- netconf_junos capture device="([^"]*)" subscription="([^"]*)" time="([^"]*)" -->\n`)
+
+var captureNameReplacer = strings.NewReplacer(":", "_", "/", "_", " ", "_")
+
+// createCapture creates the capture file of a rpc-reply and writes its header
+func (c *NETCONF) createCapture(address string, r req, timestamp time.Time) (*os.File, error) {
+	name := fmt.Sprintf("%s_%s_%d.xml", address, r.measurement, timestamp.UnixNano())
+	f, err := os.Create(filepath.Join(c.CaptureDir, captureNameReplacer.Replace(name)))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(f, captureHeader, address, r.measurement, timestamp.Format(time.RFC3339Nano)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// replay decodes the captured files of the replay directory through the matching subscriptions
+func (c *NETCONF) replay(r []req) error {
+	files, err := filepath.Glob(filepath.Join(c.ReplayDir, "*.xml"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	metricToSend := newMetricStore(r)
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("unable to read capture %s: %v", file, err)
+		}
+		header := captureHeaderRe.FindSubmatch(content)
+		if header == nil {
+			c.Log.Errorf("Not a netconf_junos capture - skip it: %s", file)
+			continue
+		}
+		address := string(header[1])
+		timestamp, err := time.Parse(time.RFC3339Nano, string(header[3]))
+		if err != nil {
+			c.Log.Errorf("Malformed timestamp in capture %s - skip it: %v", file, err)
+			continue
+		}
+
+		for _, req := range r {
+			if req.measurement != string(header[2]) {
+				continue
+			}
+			c.Log.Debugf("replay capture %s for subscription %s", file, req.measurement)
+			grouper := metric.NewSeriesGrouper()
+			if err := c.decodeReply(req, c.sanitize(bytes.NewReader(content[len(header[0]):]), nil), address, timestamp, metricToSend[req.measurement], grouper); err != nil {
+				c.Log.Errorf("Parsing of capture %s stopped: %v", file, err)
+			}
+			for _, metricToAdd := range applyDerivedFields(req, applyRowPolicy(req, grouper.Metrics())) {
+				c.acc.AddMetric(metricToAdd)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package netconf_junos
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// commitHistory is an entry of the get-commit-information reply
+type commitHistory struct {
+	Sequence int    `xml:"sequence-number"`
+	User     string `xml:"user"`
+	Client   string `xml:"client"`
+	Comment  string `xml:"comment"`
+	DateTime struct {
+		Seconds int64  `xml:"seconds,attr"`
+		Text    string `xml:",chardata"`
+	} `xml:"date-time"`
+}
+
+// time returns the time of the commit
+func (h *commitHistory) time() time.Time {
+	if h.DateTime.Seconds > 0 {
+		return time.Unix(h.DateTime.Seconds, 0)
+	}
+	t, err := time.Parse("2006-01-02 15:04:05 MST", strings.TrimSpace(h.DateTime.Text))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// commitGroup returns the session group polling the commits - the group of the first subscription
+func (c *NETCONF) commitGroup() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.requests) == 0 {
+		return ""
+	}
+	return c.requests[0].group
+}
+
+// pollCommits runs get-commit-information and emits a netconf_commit event for each new commit.
+// The commits done before the first poll are not emitted.
+func (c *NETCONF) pollCommits(session *netconfSession, address string, status *sessionStatus) error {
+	commits := make([]commitHistory, 0)
+	err := c.walkRPC(session, "<get-commit-information/>", func(decoder *xml.Decoder, element *xml.StartElement) error {
+		if element.Name.Local != "commit-history" {
+			return nil
+		}
+		var h commitHistory
+		if err := decoder.DecodeElement(&h, element); err != nil {
+			return err
+		}
+		commits = append(commits, h)
+		return nil
+	})
+	var rpcErr *rpcError
+	if errors.As(err, &rpcErr) {
+		c.Log.Warnf("cannot get commit information of device %s: %v", address, err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error while getting commit information of device %s: %v", address, err)
+	}
+
+	// the most recent commit comes first
+	first := status.lastCommit.IsZero()
+	last := status.lastCommit
+	for i := len(commits) - 1; i >= 0; i-- {
+		h := commits[i]
+		t := h.time()
+		if !t.After(status.lastCommit) {
+			continue
+		}
+		if t.After(last) {
+			last = t
+		}
+		if first {
+			continue
+		}
+		tags := map[string]string{
+			"device": address,
+			"user":   strings.TrimSpace(h.User),
+			"method": strings.TrimSpace(h.Client),
+		}
+		fields := map[string]interface{}{
+			"sequence_number": h.Sequence,
+			"comment":         strings.TrimSpace(h.Comment),
+		}
+		c.acc.AddFields("netconf_commit", fields, tags, t)
+	}
+	status.lastCommit = last
+	if first && last.IsZero() {
+		// no commit yet - the next ones are new
+		status.lastCommit = time.Unix(0, 0)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package netconf_junos
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// resolveSecret returns the value of a credential given as "env:<VARIABLE>", "file:<path>" or in
+// plain text. The credentials are resolved on each dial, so rotated credentials are used without restart.
+func resolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "env:"):
+		name := strings.TrimPrefix(value, "env:")
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s not set", name)
+		}
+		return v, nil
+	case strings.HasPrefix(value, "file:"):
+		content, err := os.ReadFile(strings.TrimPrefix(value, "file:"))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	default:
+		return value, nil
+	}
+}
+
+// sshAuth resolves the credentials and returns the ssh user and authentication methods
+func (c *NETCONF) sshAuth(u string, p string) (string, []ssh.AuthMethod, error) {
+	user, err := resolveSecret(u)
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot resolve username: %v", err)
+	}
+
+	auth := make([]ssh.AuthMethod, 0, 2)
+	if c.PrivateKey != "" {
+		key, err := resolveSecret(c.PrivateKey)
+		if err != nil {
+			return "", nil, fmt.Errorf("cannot resolve private key: %v", err)
+		}
+		passphrase, err := resolveSecret(c.PrivateKeyPassphrase)
+		if err != nil {
+			return "", nil, fmt.Errorf("cannot resolve private key passphrase: %v", err)
+		}
+		var signer ssh.Signer
+		if passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(key), []byte(passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey([]byte(key))
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("cannot parse private key: %v", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+
+	if p != "" {
+		password, err := resolveSecret(p)
+		if err != nil {
+			return "", nil, fmt.Errorf("cannot resolve password: %v", err)
+		}
+		auth = append(auth, ssh.Password(password))
+	}
+	return user, auth, nil
+}
@@ -0,0 +1,212 @@
+package netconf_junos
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/influxdata/telegraf"
+)
+
+// derivedField is a field computed from the other fields of a list entry
+type derivedField struct {
+	name string
+	expr exprNode
+}
+
+// exprNode is a node of an arithmetic expression
+type exprNode interface {
+	eval(m telegraf.Metric) (float64, bool)
+}
+
+type exprNumber float64
+
+func (n exprNumber) eval(telegraf.Metric) (float64, bool) {
+	return float64(n), true
+}
+
+type exprField string
+
+func (f exprField) eval(m telegraf.Metric) (float64, bool) {
+	v, ok := m.GetField(string(f))
+	if !ok {
+		return 0, false
+	}
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+type exprBinary struct {
+	op          byte
+	left, right exprNode
+}
+
+func (b *exprBinary) eval(m telegraf.Metric) (float64, bool) {
+	l, ok := b.left.eval(m)
+	if !ok {
+		return 0, false
+	}
+	r, ok := b.right.eval(m)
+	if !ok {
+		return 0, false
+	}
+	switch b.op {
+	case '+':
+		return l + r, true
+	case '-':
+		return l - r, true
+	case '*':
+		return l * r, true
+	default:
+		if r == 0 {
+			return 0, false
+		}
+		return l / r, true
+	}
+}
+
+// parseDerivedField parses "name = expression". The expression supports + - * /, parentheses,
+// numbers and the names of the fields of the entry - as the names may contain "-", the operators
+// must be separated by spaces.
+func parseDerivedField(s string) (derivedField, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return derivedField{}, fmt.Errorf("derived field must be \"name = expression\": %q", s)
+	}
+	p := &exprParser{tokens: tokenizeExpr(parts[1])}
+	expr, err := p.parseSum()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	if err != nil {
+		return derivedField{}, fmt.Errorf("derived field %q: %v", s, err)
+	}
+	return derivedField{name: strings.TrimSpace(parts[0]), expr: expr}, nil
+}
+
+// tokenizeExpr splits an expression in operators, parentheses and operands
+func tokenizeExpr(s string) []string {
+	var tokens []string
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, current.String())
+			current.Reset()
+		}
+	}
+	for _, c := range s {
+		switch {
+		case unicode.IsSpace(c):
+			flush()
+		case strings.ContainsRune("+*/()", c), c == '-' && current.Len() == 0:
+			flush()
+			tokens = append(tokens, string(c))
+		default:
+			current.WriteRune(c)
+		}
+	}
+	flush()
+	return tokens
+}
+
+type exprParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) parseSum() (exprNode, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == "+" || op == "-"; op = p.peek() {
+		p.pos++
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = &exprBinary{op: op[0], left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseProduct() (exprNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == "*" || op == "/"; op = p.peek() {
+		p.pos++
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		left = &exprBinary{op: op[0], left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseOperand() (exprNode, error) {
+	token := p.peek()
+	p.pos++
+	switch token {
+	case "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case "(":
+		expr, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return expr, nil
+	case "-":
+		operand, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return &exprBinary{op: '-', left: exprNumber(0), right: operand}, nil
+	case "+", "*", "/", ")":
+		return nil, fmt.Errorf("unexpected %q", token)
+	}
+	if v, err := strconv.ParseFloat(token, 64); err == nil {
+		return exprNumber(v), nil
+	}
+	return exprField(token), nil
+}
+
+// applyDerivedFields adds the derived fields to the rows of the subscriptions. A field is skipped
+// for a row missing one of its operands or dividing by zero.
+func applyDerivedFields(r req, metrics []telegraf.Metric) []telegraf.Metric {
+	for _, m := range metrics {
+		for _, s := range r.all() {
+			if _, ok := s.rowFields[m.Name()]; !ok {
+				continue
+			}
+			for _, d := range s.derived {
+				if v, ok := d.expr.eval(m); ok {
+					m.AddField(d.name, v)
+				}
+			}
+			break
+		}
+	}
+	return metrics
+}
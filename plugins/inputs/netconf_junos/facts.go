@@ -0,0 +1,125 @@
+package netconf_junos
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// factsElements maps the elements of the facts RPCs to the tags - the first occurrence is used
+var factsElements = map[string]string{
+	"product-model": "model",
+	"junos-version": "version",
+	"serial-number": "serial",
+}
+
+// deviceState is the state of a device kept for the lifetime of a session
+type deviceState struct {
+	// protects the metrics emission
+	mu sync.Mutex
+
+	cache   *redundancyCache
+	facts   map[string]string
+	joiners []*joiner
+
+	// values discovered per iteration
+	iterations map[string][]string
+
+	// outcome of the RPCs since the last backoff update
+	busy      bool
+	succeeded bool
+}
+
+// deviceFacts runs the facts RPCs once on session establishment and returns the tags of the device
+func (c *NETCONF) deviceFacts(session *netconfSession, address string) (map[string]string, error) {
+	rpcs := []string{"<get-software-information/>"}
+	if c.DeviceFactsInventory {
+		rpcs = append(rpcs, "<get-chassis-inventory/>")
+	}
+
+	facts := make(map[string]string)
+	for _, rpc := range rpcs {
+		err := c.factsRPC(session, rpc, facts)
+		var rpcErr *rpcError
+		if errors.As(err, &rpcErr) {
+			c.Log.Warnf("cannot get facts %s of device %s: %v", rpc, address, err)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error while getting facts %s of device %s: %v", rpc, address, err)
+		}
+	}
+	c.Log.Debugf("facts of device %s: %v", address, facts)
+	return facts, nil
+}
+
+// factsRPC executes a facts RPC and fills the facts found in its reply
+func (c *NETCONF) factsRPC(session *netconfSession, rpc string, facts map[string]string) error {
+	return c.walkRPC(session, rpc, func(decoder *xml.Decoder, element *xml.StartElement) error {
+		tag, ok := factsElements[element.Name.Local]
+		if !ok || facts[tag] != "" {
+			return nil
+		}
+		var value string
+		if err := decoder.DecodeElement(&value, element); err != nil {
+			return err
+		}
+		facts[tag] = strings.TrimSpace(value)
+		return nil
+	})
+}
+
+// walkRPC executes a RPC outside of the subscriptions and calls fn for each element of its reply.
+// A rpc-error in the reply is returned as an *rpcError.
+func (c *NETCONF) walkRPC(session *netconfSession, rpc string, fn func(*xml.Decoder, *xml.StartElement) error) error {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	// the session is closed if the device doesn't answer in time
+	timer := time.AfterFunc(time.Duration(c.RPCTimeout), func() { session.Close() })
+	defer timer.Stop()
+
+	if _, err := session.sendRPC(rpc); err != nil {
+		return err
+	}
+	reply, _, err := session.reply()
+	if err != nil {
+		return err
+	}
+
+	decoder := xml.NewDecoder(reply)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			break
+		}
+		element, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if element.Name.Local == "rpc-error" {
+			rpcErr := &rpcError{}
+			if err := decoder.DecodeElement(rpcErr, &element); err != nil {
+				break
+			}
+			if _, err := io.Copy(io.Discard, reply); err != nil {
+				return err
+			}
+			return rpcErr
+		}
+		if err := fn(decoder, &element); err != nil {
+			break
+		}
+	}
+
+	// consume the end of the reply to stay in sync with the device
+	_, err = io.Copy(io.Discard, reply)
+	return err
+}
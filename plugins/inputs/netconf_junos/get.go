@@ -0,0 +1,146 @@
+package netconf_junos
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// filterNode is a node of the subtree filter built from the field paths
+type filterNode struct {
+	name     string
+	value    string
+	match    bool
+	children []*filterNode
+}
+
+// child returns the child node with this name - and value for a content match node - and creates it if needed
+func (n *filterNode) child(name string, value string, match bool) *filterNode {
+	for _, c := range n.children {
+		if c.name == name && c.value == value && c.match == match {
+			return c
+		}
+	}
+	c := &filterNode{name: name, value: value, match: match}
+	n.children = append(n.children, c)
+	return c
+}
+
+// write serializes the node - namespaces are given per top-level element
+func (n *filterNode) write(b *strings.Builder, namespaces map[string]string) {
+	b.WriteString("<" + n.name)
+	if ns, ok := namespaces[n.name]; ok {
+		b.WriteString(" xmlns=\"")
+		xml.EscapeText(b, []byte(ns))
+		b.WriteString("\"")
+	}
+	switch {
+	case n.match:
+		b.WriteString(">")
+		xml.EscapeText(b, []byte(n.value))
+	case len(n.children) == 0:
+		b.WriteString("/>")
+		return
+	default:
+		b.WriteString(">")
+		for _, c := range n.children {
+			c.write(b, nil)
+		}
+	}
+	b.WriteString("</" + n.name + ">")
+}
+
+// pathSegment is an element of a field path with the keys and predicates given between []
+type pathSegment struct {
+	name       string
+	keys       []string
+	predicates [][2]string
+}
+
+// parsePath splits the xpath of a field into its segments
+func parsePath(field string) ([]pathSegment, error) {
+	parts := splitOutside(field, ':')
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("malformed field %q", field)
+	}
+	segments := make([]pathSegment, 0)
+	for _, e := range splitOutside(parts[0], '/') {
+		if e == "" {
+			continue
+		}
+		i := strings.Index(e, "[")
+		if i < 0 {
+			segments = append(segments, pathSegment{name: e})
+			continue
+		}
+		s := pathSegment{name: e[:i]}
+		for _, a := range splitOutside(e[i+1:strings.LastIndex(e, "]")], ',') {
+			a = strings.TrimSpace(a)
+			if j := strings.Index(a, "="); j >= 0 {
+				s.predicates = append(s.predicates, [2]string{strings.TrimSpace(a[:j]), strings.Trim(strings.TrimSpace(a[j+1:]), "'\"")})
+				continue
+			}
+			s.keys = append(s.keys, a)
+		}
+		segments = append(segments, s)
+	}
+	return segments, nil
+}
+
+// buildGetRPC builds a standard <get> RPC with a subtree or XPath filter selecting the fields
+// of the subscription, for NETCONF servers which don't implement the Junos RPCs
+func buildGetRPC(s Subscription) (string, error) {
+	root := &filterNode{}
+	selects := make([]string, 0)
+	for _, f := range s.Fields {
+		segments, err := parsePath(f)
+		if err != nil {
+			return "", fmt.Errorf("subscription %s: %v", s.Name, err)
+		}
+		node := root
+		xpath := ""
+		for _, seg := range segments {
+			node = node.child(seg.name, "", false)
+			xpath += "/" + seg.name
+			for _, p := range seg.predicates {
+				node.child(p[0], p[1], true)
+				xpath += fmt.Sprintf("[%s='%s']", p[0], p[1])
+			}
+			for _, k := range seg.keys {
+				node.child(k, "", false)
+				selects = append(selects, xpath+"/"+k)
+			}
+		}
+		selects = append(selects, xpath)
+	}
+
+	var b strings.Builder
+	switch s.GetFilter {
+	case "subtree":
+		b.WriteString("<get><filter type=\"subtree\">")
+		for _, c := range root.children {
+			c.write(&b, s.GetNamespaces)
+		}
+		b.WriteString("</filter></get>")
+	case "xpath":
+		b.WriteString("<get><filter type=\"xpath\" select=\"")
+		xml.EscapeText(&b, []byte(strings.Join(unique(selects), " | ")))
+		b.WriteString("\"/></get>")
+	default:
+		return "", fmt.Errorf("subscription %s: unknown get_filter %q", s.Name, s.GetFilter)
+	}
+	return b.String(), nil
+}
+
+// unique removes the duplicates keeping the order
+func unique(values []string) []string {
+	seen := make(map[string]bool)
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
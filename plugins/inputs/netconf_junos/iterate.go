@@ -0,0 +1,157 @@
+package netconf_junos
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// iteration describes how to discover the values a subscription iterates over
+type iteration struct {
+	// RPC listing the values, element of each entry and child element holding the value
+	rpc   string
+	entry string
+	value string
+	// only keep the entries whose filter child element has this value
+	filter      string
+	filterValue string
+	// parameter added to the RPC when it doesn't contain the {{<iterate>}} placeholder
+	param string
+	// value of the tag for the RPC sent without parameter - not sent if empty
+	defaultValue string
+}
+
+var iterations = map[string]iteration{
+	"logical_system": {
+		rpc:          "<get-configuration><configuration><logical-systems><name/></logical-systems></configuration></get-configuration>",
+		entry:        "logical-systems",
+		value:        "name",
+		param:        "logical-system",
+		defaultValue: "default",
+	},
+	"routing_instance": {
+		rpc:   "<get-configuration><configuration><routing-instances><instance><name/></instance></routing-instances></configuration></get-configuration>",
+		entry: "instance",
+		value: "name",
+		param: "instance",
+	},
+	"fpc": {
+		rpc:         "<get-fpc-information/>",
+		entry:       "fpc",
+		value:       "slot",
+		filter:      "state",
+		filterValue: "Online",
+		param:       "fpc",
+	},
+	"member": {
+		rpc:         "<get-virtual-chassis-information/>",
+		entry:       "member",
+		value:       "member-id",
+		filter:      "member-status",
+		filterValue: "Prsnt",
+		param:       "member",
+	},
+}
+
+// xmlNode is a generic element used to read the entries of the discovery RPCs
+type xmlNode struct {
+	XMLName  xml.Name
+	Text     string    `xml:",chardata"`
+	Children []xmlNode `xml:",any"`
+}
+
+// child returns the text of the first child element with this name
+func (n *xmlNode) child(name string) string {
+	for _, c := range n.Children {
+		if c.XMLName.Local == name {
+			return strings.TrimSpace(c.Text)
+		}
+	}
+	return ""
+}
+
+// discover runs the discovery RPC of an iteration and returns the values found on the device
+func (c *NETCONF) discover(session *netconfSession, it iteration) ([]string, error) {
+	values := make([]string, 0)
+	err := c.walkRPC(session, it.rpc, func(decoder *xml.Decoder, element *xml.StartElement) error {
+		if element.Name.Local != it.entry {
+			return nil
+		}
+		var entry xmlNode
+		if err := decoder.DecodeElement(&entry, element); err != nil {
+			return err
+		}
+		if it.filter != "" && entry.child(it.filter) != it.filterValue {
+			return nil
+		}
+		if v := entry.child(it.value); v != "" {
+			values = append(values, v)
+		}
+		return nil
+	})
+	return values, err
+}
+
+// expandRPC returns the RPCs to execute for a request: the request itself, or one RPC per discovered
+// value when the subscription iterates over logical systems, routing instances, online FPCs or
+// virtual-chassis members. The values are discovered once per session.
+func (c *NETCONF) expandRPC(session *netconfSession, dev *deviceState, address string, p *pendingRPC) ([]*pendingRPC, error) {
+	if p.req.iterate == "" {
+		return []*pendingRPC{p}, nil
+	}
+	it := iterations[p.req.iterate]
+	values, ok := dev.iterations[p.req.iterate]
+	if !ok {
+		var err error
+		values, err = c.discover(session, it)
+		var rpcErr *rpcError
+		if errors.As(err, &rpcErr) {
+			c.Log.Warnf("cannot discover %s of device %s: %v", p.req.iterate, address, err)
+		} else if err != nil {
+			return nil, fmt.Errorf("error while discovering %s of device %s: %v", p.req.iterate, address, err)
+		}
+		c.Log.Debugf("%s discovered on device %s: %v", p.req.iterate, address, values)
+		dev.iterations[p.req.iterate] = values
+	}
+
+	expanded := make([]*pendingRPC, 0, len(values)+1)
+	if it.defaultValue != "" && !strings.Contains(p.req.rpc, "{{"+p.req.iterate+"}}") {
+		e := *p
+		e.tags = map[string]string{p.req.iterate: it.defaultValue}
+		expanded = append(expanded, &e)
+	}
+	for _, v := range values {
+		e := *p
+		e.req.rpc = expandTemplate(p.req.rpc, p.req.iterate, it.param, v)
+		e.tags = map[string]string{p.req.iterate: v}
+		expanded = append(expanded, &e)
+	}
+	return expanded, nil
+}
+
+// expandTemplate replaces the {{<name>}} placeholder of a RPC by the value. Without placeholder, the
+// value is added as the first parameter of the RPC: <get-bgp-summary-information><logical-system>LS1</logical-system>...
+func expandTemplate(rpc string, name string, param string, value string) string {
+	var escaped strings.Builder
+	xml.EscapeText(&escaped, []byte(value))
+	placeholder := "{{" + name + "}}"
+	if strings.Contains(rpc, placeholder) {
+		return strings.ReplaceAll(rpc, placeholder, escaped.String())
+	}
+	if value == "" || param == "" {
+		return rpc
+	}
+
+	i := strings.Index(rpc, ">")
+	if i < 1 {
+		return rpc
+	}
+	element := "<" + param + ">" + escaped.String() + "</" + param + ">"
+	if rpc[i-1] != '/' {
+		return rpc[:i+1] + element + rpc[i+1:]
+	}
+	// self-closing RPC: <get-bgp-summary-information/>
+	name = strings.Fields(rpc[1 : i-1])[0]
+	return rpc[:i-1] + ">" + element + "</" + name + ">" + rpc[i+1:]
+}
@@ -0,0 +1,104 @@
+package netconf_junos
+
+import (
+	"strings"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/metric"
+)
+
+// Join merges the fields of several measurements sharing the join tags into one measurement
+type Join struct {
+	Name         string   `toml:"name"`
+	Measurements []string `toml:"measurements"`
+	Tags         []string `toml:"tags"`
+}
+
+// joiner holds the rows of a join being filled for a device
+type joiner struct {
+	join Join
+	rows map[string]*joinRow
+}
+
+// joinRow is the metric of a join key with the measurements already merged into it
+type joinRow struct {
+	metric  telegraf.Metric
+	sources map[string]bool
+}
+
+func newJoiner(j Join) *joiner {
+	return &joiner{join: j, rows: make(map[string]*joinRow)}
+}
+
+// accepts returns true if the metric is one of the joined measurements
+func (j *joiner) accepts(m telegraf.Metric) bool {
+	for _, name := range j.join.Measurements {
+		if m.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// add merges a metric into the row of its join key and returns the rows complete - when all the
+// measurements have been merged - or superseded by the next cycle
+func (j *joiner) add(m telegraf.Metric) []telegraf.Metric {
+	values := make([]string, 0, len(j.join.Tags))
+	for _, t := range j.join.Tags {
+		v, ok := m.GetTag(t)
+		if !ok {
+			// no join key - left untouched
+			return []telegraf.Metric{m}
+		}
+		values = append(values, v)
+	}
+	key := strings.Join(values, "\x00")
+
+	out := make([]telegraf.Metric, 0)
+	row, ok := j.rows[key]
+	if ok && row.sources[m.Name()] {
+		// measurement already merged - a new cycle starts
+		out = append(out, row.metric)
+		ok = false
+	}
+	if !ok {
+		tags := make(map[string]string)
+		for i, t := range j.join.Tags {
+			tags[t] = values[i]
+		}
+		row = &joinRow{metric: metric.New(j.join.Name, tags, map[string]interface{}{}, m.Time()), sources: make(map[string]bool)}
+		j.rows[key] = row
+	}
+	for _, f := range m.FieldList() {
+		row.metric.AddField(f.Key, f.Value)
+	}
+	row.sources[m.Name()] = true
+
+	if len(row.sources) == len(j.join.Measurements) {
+		out = append(out, row.metric)
+		delete(j.rows, key)
+	}
+	return out
+}
+
+// join merges the metrics of the joined measurements - the other metrics are returned as is
+func (d *deviceState) join(metrics []telegraf.Metric) []telegraf.Metric {
+	if len(d.joiners) == 0 {
+		return metrics
+	}
+	out := make([]telegraf.Metric, 0, len(metrics))
+	for _, m := range metrics {
+		joined := false
+		for _, j := range d.joiners {
+			if j.accepts(m) {
+				out = append(out, j.add(m)...)
+				joined = true
+				break
+			}
+		}
+		if !joined {
+			out = append(out, m)
+		}
+	}
+	return out
+}
@@ -0,0 +1,1173 @@
+package netconf_junos
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/config"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplay(t *testing.T) {
+	c := &NETCONF{
+		ReplayDir: "testdata",
+		Redial:    config.Duration(10 * time.Second),
+		Subscriptions: []Subscription{
+			{
+				Name: "ifcounters",
+				Rpc:  "<get-interface-information><statistics/></get-interface-information>",
+				Fields: []string{
+					"/interface-information/physical-interface[name]/speed:string",
+					"/interface-information/physical-interface[name]/traffic-statistics/input-packets:int",
+					"/interface-information/physical-interface[name]/traffic-statistics/output-packets:int",
+				},
+				SampleInterval: config.Duration(30 * time.Second),
+			},
+		},
+		Log: testutil.Logger{},
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, c.Start(&acc))
+	c.Stop()
+	require.Empty(t, acc.Errors)
+
+	timestamp := time.Date(2021, 10, 15, 8, 0, 0, 0, time.UTC)
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"ifcounters",
+			map[string]string{"device": "10.0.0.1", "name": "xe-0/0/0"},
+			map[string]interface{}{"speed": "10Gbps", "input-packets": int64(1000), "output-packets": int64(2000)},
+			timestamp,
+		),
+		testutil.MustMetric(
+			"ifcounters",
+			map[string]string{"device": "10.0.0.1", "name": "xe-0/0/1"},
+			map[string]interface{}{"speed": "100Gbps", "input-packets": int64(3000), "output-packets": int64(4000)},
+			timestamp,
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.SortMetrics())
+}
+
+func TestReplayCompositeTag(t *testing.T) {
+	c := &NETCONF{
+		ReplayDir: "testdata",
+		Redial:    config.Duration(10 * time.Second),
+		Subscriptions: []Subscription{
+			{
+				Name: "ifcounters",
+				Rpc:  "<get-interface-information><statistics/></get-interface-information>",
+				Fields: []string{
+					"/interface-information/physical-interface/logical-interface[name,local-index]/traffic-statistics/input-packets:int",
+				},
+				CompositeTag:   true,
+				SampleInterval: config.Duration(30 * time.Second),
+			},
+		},
+		Log: testutil.Logger{},
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, c.Start(&acc))
+	c.Stop()
+
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"ifcounters",
+			map[string]string{"device": "10.0.0.1", "name": "xe-0/0/0.0", "local-index": "331", "name_local-index": "xe-0/0/0.0.331"},
+			map[string]interface{}{"input-packets": int64(900)},
+			time.Date(2021, 10, 15, 8, 0, 0, 0, time.UTC),
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.SortMetrics())
+}
+
+func TestReplayPresence(t *testing.T) {
+	c := &NETCONF{
+		ReplayDir: "testdata",
+		Redial:    config.Duration(10 * time.Second),
+		Subscriptions: []Subscription{
+			{
+				Name: "ifflags",
+				Rpc:  "<get-interface-information/>",
+				Fields: []string{
+					"/interface-information/physical-interface[name]/if-device-flags/ifdf-running:presence",
+					"/interface-information/physical-interface[name]/if-device-flags/ifdf-down:presence",
+				},
+				SampleInterval: config.Duration(30 * time.Second),
+			},
+		},
+		Log: testutil.Logger{},
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, c.Start(&acc))
+	c.Stop()
+
+	timestamp := time.Date(2021, 10, 15, 8, 0, 0, 0, time.UTC)
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"ifflags",
+			map[string]string{"device": "10.0.0.1", "name": "xe-0/0/0"},
+			map[string]interface{}{"ifdf-running": true, "ifdf-down": false},
+			timestamp,
+		),
+		testutil.MustMetric(
+			"ifflags",
+			map[string]string{"device": "10.0.0.1", "name": "xe-0/0/1"},
+			map[string]interface{}{"ifdf-running": false, "ifdf-down": true},
+			timestamp,
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.SortMetrics())
+}
+
+func TestReplayPredicate(t *testing.T) {
+	c := &NETCONF{
+		ReplayDir: "testdata",
+		Redial:    config.Duration(10 * time.Second),
+		Subscriptions: []Subscription{
+			{
+				Name: "ifcounters",
+				Rpc:  "<get-interface-information><statistics/></get-interface-information>",
+				Fields: []string{
+					"/interface-information/physical-interface[name,speed='100Gbps']/traffic-statistics/input-packets:int",
+					"/interface-information/physical-interface[name='xe-0/0/0']/traffic-statistics/output-packets:int",
+				},
+				SampleInterval: config.Duration(30 * time.Second),
+			},
+		},
+		Log: testutil.Logger{},
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, c.Start(&acc))
+	c.Stop()
+
+	timestamp := time.Date(2021, 10, 15, 8, 0, 0, 0, time.UTC)
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"ifcounters",
+			map[string]string{"device": "10.0.0.1", "name": "xe-0/0/1"},
+			map[string]interface{}{"input-packets": int64(3000)},
+			timestamp,
+		),
+		testutil.MustMetric(
+			"ifcounters",
+			map[string]string{"device": "10.0.0.1"},
+			map[string]interface{}{"output-packets": int64(2000)},
+			timestamp,
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.SortMetrics())
+}
+
+func TestReplaySharedRPC(t *testing.T) {
+	c := &NETCONF{
+		ReplayDir: "testdata",
+		Redial:    config.Duration(10 * time.Second),
+		Subscriptions: []Subscription{
+			{
+				Name: "ifcounters",
+				Rpc:  "<get-interface-information><statistics/></get-interface-information>",
+				Fields: []string{
+					"/interface-information/physical-interface[name]/traffic-statistics/input-packets:int",
+				},
+				SampleInterval: config.Duration(30 * time.Second),
+			},
+			{
+				Name: "ifspeed",
+				Rpc:  "<get-interface-information><statistics/></get-interface-information>",
+				Fields: []string{
+					"/interface-information/physical-interface[name]/speed:string",
+				},
+				SampleInterval: config.Duration(30 * time.Second),
+			},
+		},
+		Log: testutil.Logger{},
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, c.Start(&acc))
+	c.Stop()
+	require.Len(t, c.requests, 1)
+
+	timestamp := time.Date(2021, 10, 15, 8, 0, 0, 0, time.UTC)
+	expected := []telegraf.Metric{
+		testutil.MustMetric("ifcounters", map[string]string{"device": "10.0.0.1", "name": "xe-0/0/0"}, map[string]interface{}{"input-packets": int64(1000)}, timestamp),
+		testutil.MustMetric("ifcounters", map[string]string{"device": "10.0.0.1", "name": "xe-0/0/1"}, map[string]interface{}{"input-packets": int64(3000)}, timestamp),
+		testutil.MustMetric("ifspeed", map[string]string{"device": "10.0.0.1", "name": "xe-0/0/0"}, map[string]interface{}{"speed": "10Gbps"}, timestamp),
+		testutil.MustMetric("ifspeed", map[string]string{"device": "10.0.0.1", "name": "xe-0/0/1"}, map[string]interface{}{"speed": "100Gbps"}, timestamp),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.SortMetrics())
+}
+
+func TestReplayRowPolicy(t *testing.T) {
+	run := func(policy string) []telegraf.Metric {
+		c := &NETCONF{
+			ReplayDir: "testdata",
+			Redial:    config.Duration(10 * time.Second),
+			Subscriptions: []Subscription{
+				{
+					Name: "ifcounters",
+					Rpc:  "<get-interface-information><statistics/></get-interface-information>",
+					Fields: []string{
+						"/interface-information/physical-interface[name]/traffic-statistics/input-packets:int",
+						"/interface-information/physical-interface[name]/mtu:int",
+					},
+					RowPolicy:      policy,
+					FillValues:     map[string]string{"mtu": "1514"},
+					SampleInterval: config.Duration(30 * time.Second),
+				},
+			},
+			Log: testutil.Logger{},
+		}
+		var acc testutil.Accumulator
+		require.NoError(t, c.Start(&acc))
+		c.Stop()
+		return acc.GetTelegrafMetrics()
+	}
+
+	require.Len(t, run("partial"), 2)
+	require.Empty(t, run("complete"))
+
+	timestamp := time.Date(2021, 10, 15, 8, 0, 0, 0, time.UTC)
+	expected := []telegraf.Metric{
+		testutil.MustMetric("ifcounters", map[string]string{"device": "10.0.0.1", "name": "xe-0/0/0"}, map[string]interface{}{"input-packets": int64(1000), "mtu": int64(1514)}, timestamp),
+		testutil.MustMetric("ifcounters", map[string]string{"device": "10.0.0.1", "name": "xe-0/0/1"}, map[string]interface{}{"input-packets": int64(3000), "mtu": int64(1514)}, timestamp),
+	}
+	testutil.RequireMetricsEqual(t, expected, run("fill"), testutil.SortMetrics())
+}
+
+func TestReplayDerivedFields(t *testing.T) {
+	c := &NETCONF{
+		ReplayDir: "testdata",
+		Redial:    config.Duration(10 * time.Second),
+		Subscriptions: []Subscription{
+			{
+				Name: "ifcounters",
+				Rpc:  "<get-interface-information><statistics/></get-interface-information>",
+				Fields: []string{
+					"/interface-information/physical-interface[name]/traffic-statistics/input-packets:int",
+					"/interface-information/physical-interface[name]/traffic-statistics/output-packets:int",
+				},
+				DerivedFields: []string{
+					"total-packets = input-packets + output-packets",
+					"input-ratio = input-packets * 100 / (input-packets + output-packets)",
+					"missing = input-packets / mtu",
+				},
+				SampleInterval: config.Duration(30 * time.Second),
+			},
+		},
+		Log: testutil.Logger{},
+	}
+	var acc testutil.Accumulator
+	require.NoError(t, c.Start(&acc))
+	c.Stop()
+
+	timestamp := time.Date(2021, 10, 15, 8, 0, 0, 0, time.UTC)
+	expected := []telegraf.Metric{
+		testutil.MustMetric("ifcounters", map[string]string{"device": "10.0.0.1", "name": "xe-0/0/0"}, map[string]interface{}{"input-packets": int64(1000), "output-packets": int64(2000), "total-packets": 3000.0, "input-ratio": 100000.0 / 3000}, timestamp),
+		testutil.MustMetric("ifcounters", map[string]string{"device": "10.0.0.1", "name": "xe-0/0/1"}, map[string]interface{}{"input-packets": int64(3000), "output-packets": int64(4000), "total-packets": 7000.0, "input-ratio": 300000.0 / 7000}, timestamp),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.SortMetrics())
+
+	_, err := parseDerivedField("utilization = input-bytes * (8")
+	require.Error(t, err)
+	_, err = parseDerivedField("input-bytes * 8")
+	require.Error(t, err)
+}
+
+func TestReplayCommand(t *testing.T) {
+	c := &NETCONF{
+		ReplayDir: "testdata",
+		Redial:    config.Duration(10 * time.Second),
+		Subscriptions: []Subscription{
+			{
+				Name:    "MBUFS",
+				Command: "show system buffers",
+				TextPatterns: []string{
+					`^(?P<current>\d+)/(?P<cache>\d+)/(?P<total>\d+) mbufs in use`,
+					`^(?P<denied>\d+) requests for (?P<kind>mbufs) denied`,
+				},
+				Fields:         []string{"current:int", "cache:int", "total:int", "denied:int", "kind:tag"},
+				SampleInterval: config.Duration(30 * time.Second),
+			},
+		},
+		Log: testutil.Logger{},
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, c.Start(&acc))
+	c.Stop()
+
+	timestamp := time.Date(2021, 10, 15, 8, 0, 0, 0, time.UTC)
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"MBUFS",
+			map[string]string{"device": "10.0.0.1"},
+			map[string]interface{}{"current": int64(2048), "cache": int64(4096), "total": int64(6144)},
+			timestamp,
+		),
+		testutil.MustMetric(
+			"MBUFS",
+			map[string]string{"device": "10.0.0.1", "kind": "mbufs"},
+			map[string]interface{}{"denied": int64(0)},
+			timestamp,
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.SortMetrics())
+}
+
+func TestEOMReader(t *testing.T) {
+	stream := bufio.NewReaderSize(strings.NewReader("<rpc-reply><a>]]></a></rpc-reply>]]>]]><rpc-reply/>]]>]]>"), 16)
+
+	first, err := io.ReadAll(&eomReader{r: stream})
+	require.NoError(t, err)
+	require.Equal(t, "<rpc-reply><a>]]></a></rpc-reply>", string(first))
+
+	second, err := io.ReadAll(&eomReader{r: stream})
+	require.NoError(t, err)
+	require.Equal(t, "<rpc-reply/>", string(second))
+
+	_, err = io.ReadAll(&eomReader{r: stream})
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestChunkReader(t *testing.T) {
+	stream := bufio.NewReaderSize(strings.NewReader("\n#4\n<rpc\n#23\n-reply><a/></rpc-reply>\n##\n\n#12\n<rpc-reply/>\n##\n"), 16)
+
+	first, err := io.ReadAll(&chunkReader{r: stream})
+	require.NoError(t, err)
+	require.Equal(t, "<rpc-reply><a/></rpc-reply>", string(first))
+
+	second, err := io.ReadAll(&chunkReader{r: stream})
+	require.NoError(t, err)
+	require.Equal(t, "<rpc-reply/>", string(second))
+
+	_, err = io.ReadAll(&chunkReader{r: bufio.NewReader(strings.NewReader("\n#abc\n"))})
+	require.Error(t, err)
+}
+
+func TestReplyMessageID(t *testing.T) {
+	session := &netconfSession{stdout: bufio.NewReader(strings.NewReader(
+		"<rpc-reply xmlns=\"urn:ietf:params:xml:ns:netconf:base:1.0\" message-id=\"12\"><ok/></rpc-reply>]]>]]><rpc-reply><ok/></rpc-reply>]]>]]>"))}
+
+	reply, id, err := session.reply()
+	require.NoError(t, err)
+	require.Equal(t, uint64(12), id)
+	data, err := io.ReadAll(reply)
+	require.NoError(t, err)
+	require.Equal(t, "<rpc-reply xmlns=\"urn:ietf:params:xml:ns:netconf:base:1.0\" message-id=\"12\"><ok/></rpc-reply>", string(data))
+
+	// message-id not echoed
+	_, id, err = session.reply()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), id)
+}
+
+func TestMaxReplySize(t *testing.T) {
+	data := &countingReader{r: strings.NewReader("<rpc-reply><a>1</a></rpc-reply>"), max: 10}
+	_, err := io.ReadAll(data)
+	require.ErrorIs(t, err, errReplyTooLarge)
+}
+
+func TestSuppressRedundant(t *testing.T) {
+	cache := newRedundancyCache(10 * time.Minute)
+	start := time.Date(2021, 10, 15, 8, 0, 0, 0, time.UTC)
+	tags := map[string]string{"device": "10.0.0.1", "name": "xe-0/0/0"}
+
+	m := testutil.MustMetric("ifstate", tags, map[string]interface{}{"oper-status": "up", "mtu": int64(9192)}, start)
+	require.True(t, cache.filter(m))
+	require.Len(t, m.FieldList(), 2)
+
+	// only the changed field is emitted
+	m = testutil.MustMetric("ifstate", tags, map[string]interface{}{"oper-status": "down", "mtu": int64(9192)}, start.Add(time.Minute))
+	require.True(t, cache.filter(m))
+	require.Equal(t, map[string]interface{}{"oper-status": "down"}, m.Fields())
+
+	// nothing changed
+	m = testutil.MustMetric("ifstate", tags, map[string]interface{}{"oper-status": "down", "mtu": int64(9192)}, start.Add(2*time.Minute))
+	require.False(t, cache.filter(m))
+
+	// heartbeat elapsed for mtu only
+	m = testutil.MustMetric("ifstate", tags, map[string]interface{}{"oper-status": "down", "mtu": int64(9192)}, start.Add(10*time.Minute))
+	require.True(t, cache.filter(m))
+	require.Equal(t, map[string]interface{}{"mtu": int64(9192)}, m.Fields())
+}
+
+type discardCloser struct {
+	io.Writer
+}
+
+func (discardCloser) Close() error { return nil }
+
+func TestDeviceFacts(t *testing.T) {
+	replies := "<rpc-reply message-id=\"1\"><software-information><host-name>r1</host-name><product-model>mx960</product-model>" +
+		"<junos-version>21.2R1.10</junos-version></software-information></rpc-reply>]]>]]>" +
+		"<rpc-reply message-id=\"2\"><chassis-inventory><chassis><name>Chassis</name><serial-number>JN1234</serial-number>" +
+		"<chassis-module><serial-number>ABC</serial-number></chassis-module></chassis></chassis-inventory></rpc-reply>]]>]]>"
+	session := &netconfSession{stdin: discardCloser{io.Discard}, stdout: bufio.NewReader(strings.NewReader(replies))}
+	c := &NETCONF{DeviceFactsInventory: true, RPCTimeout: config.Duration(time.Minute), Log: testutil.Logger{}}
+
+	facts, err := c.deviceFacts(session, "10.0.0.1")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"model": "mx960", "version": "21.2R1.10", "serial": "JN1234"}, facts)
+}
+
+func TestScale(t *testing.T) {
+	scale, err := parseScale("0.000001")
+	require.NoError(t, err)
+	require.InDelta(t, 1.5, scaleValue(1500000.0, scale), 1e-9)
+
+	scale, err = parseScale("/1000")
+	require.NoError(t, err)
+	require.Equal(t, 12, scaleValue(12345, scale))
+	require.Equal(t, "up", scaleValue("up", scale))
+
+	_, err = parseScale("/0")
+	require.Error(t, err)
+}
+
+func TestJoin(t *testing.T) {
+	dev := &deviceState{joiners: []*joiner{newJoiner(Join{Name: "interface", Measurements: []string{"ifcounters", "ifqueues"}, Tags: []string{"device", "name"}})}}
+	start := time.Date(2021, 10, 15, 8, 0, 0, 0, time.UTC)
+	tags := map[string]string{"device": "10.0.0.1", "name": "xe-0/0/0"}
+
+	out := dev.join([]telegraf.Metric{
+		testutil.MustMetric("ifcounters", tags, map[string]interface{}{"input-packets": int64(1000)}, start),
+		testutil.MustMetric("cpu", map[string]string{"device": "10.0.0.1"}, map[string]interface{}{"load": int64(5)}, start),
+	})
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("cpu", map[string]string{"device": "10.0.0.1"}, map[string]interface{}{"load": int64(5)}, start),
+	}, out)
+
+	// row complete
+	out = dev.join([]telegraf.Metric{
+		testutil.MustMetric("ifqueues", tags, map[string]interface{}{"queued-packets": int64(10)}, start.Add(time.Second)),
+	})
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("interface", tags, map[string]interface{}{"input-packets": int64(1000), "queued-packets": int64(10)}, start),
+	}, out)
+
+	// a new cycle flushes the partial row
+	dev.join([]telegraf.Metric{testutil.MustMetric("ifcounters", tags, map[string]interface{}{"input-packets": int64(2000)}, start.Add(time.Minute))})
+	out = dev.join([]telegraf.Metric{testutil.MustMetric("ifcounters", tags, map[string]interface{}{"input-packets": int64(3000)}, start.Add(2*time.Minute))})
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		testutil.MustMetric("interface", tags, map[string]interface{}{"input-packets": int64(2000)}, start.Add(time.Minute)),
+	}, out)
+}
+
+func TestExpandTemplate(t *testing.T) {
+	require.Equal(t, "<get-bgp-summary-information><logical-system>LS1</logical-system></get-bgp-summary-information>",
+		expandTemplate("<get-bgp-summary-information/>", "logical_system", "logical-system", "LS1"))
+	require.Equal(t, "<get-route-summary-information><logical-system>LS&amp;1</logical-system><brief/></get-route-summary-information>",
+		expandTemplate("<get-route-summary-information><brief/></get-route-summary-information>", "logical_system", "logical-system", "LS&1"))
+	require.Equal(t, "<get-route-information><table>VRF1.inet.0</table></get-route-information>",
+		expandTemplate("<get-route-information><table>{{routing_instance}}.inet.0</table></get-route-information>", "routing_instance", "instance", "VRF1"))
+}
+
+func TestDiscoverFPC(t *testing.T) {
+	replies := "<rpc-reply message-id=\"1\"><fpc-information><fpc><slot>0</slot><state>Online</state></fpc>" +
+		"<fpc><slot>1</slot><state>Empty</state></fpc><fpc><slot>2</slot><state>Online</state></fpc></fpc-information></rpc-reply>]]>]]>"
+	session := &netconfSession{stdin: discardCloser{io.Discard}, stdout: bufio.NewReader(strings.NewReader(replies))}
+	c := &NETCONF{RPCTimeout: config.Duration(time.Minute), Log: testutil.Logger{}}
+	dev := &deviceState{iterations: make(map[string][]string)}
+
+	r := req{measurement: "pfe", rpc: "<get-pfe-statistics><fpc>{{fpc}}</fpc></get-pfe-statistics>", iterate: "fpc"}
+	expanded, err := c.expandRPC(session, dev, "10.0.0.1", &pendingRPC{req: r})
+	require.NoError(t, err)
+	require.Len(t, expanded, 2)
+	require.Equal(t, "<get-pfe-statistics><fpc>0</fpc></get-pfe-statistics>", expanded[0].req.rpc)
+	require.Equal(t, map[string]string{"fpc": "2"}, expanded[1].tags)
+}
+
+func TestBusyBackoff(t *testing.T) {
+	status := newSessionStatus("10.0.0.1", "default")
+	require.True(t, status.updateBackoff(true, false, 4))
+	require.True(t, status.updateBackoff(true, true, 4))
+	require.False(t, status.updateBackoff(true, false, 4))
+	require.Equal(t, int64(4), status.backoff)
+
+	// no RPC executed - unchanged
+	require.False(t, status.updateBackoff(false, false, 4))
+	require.True(t, status.updateBackoff(false, true, 4))
+	require.Equal(t, int64(2), status.backoff)
+
+	require.True(t, isBusy(&rpcError{Tag: "resource-denied", Message: "too many sessions"}))
+}
+
+func TestBuildGetRPC(t *testing.T) {
+	s := Subscription{
+		Name:          "oc_interfaces",
+		GetFilter:     "subtree",
+		GetNamespaces: map[string]string{"interfaces": "http://openconfig.net/yang/interfaces"},
+		Fields: []string{
+			"/interfaces/interface[name,type='ethernetCsmacd']/state/counters/in-octets:int",
+			"/interfaces/interface[name,type='ethernetCsmacd']/state/counters/out-octets:int",
+		},
+	}
+	rpc, err := buildGetRPC(s)
+	require.NoError(t, err)
+	require.Equal(t, `<get><filter type="subtree"><interfaces xmlns="http://openconfig.net/yang/interfaces"><interface>`+
+		`<type>ethernetCsmacd</type><name/><state><counters><in-octets/><out-octets/></counters></state></interface></interfaces></filter></get>`, rpc)
+
+	s.GetFilter = "xpath"
+	rpc, err = buildGetRPC(s)
+	require.NoError(t, err)
+	require.Equal(t, `<get><filter type="xpath" select="/interfaces/interface[type=&#39;ethernetCsmacd&#39;]/name | `+
+		`/interfaces/interface[type=&#39;ethernetCsmacd&#39;]/state/counters/in-octets | `+
+		`/interfaces/interface[type=&#39;ethernetCsmacd&#39;]/state/counters/out-octets"/></get>`, rpc)
+}
+
+func TestCommitEvents(t *testing.T) {
+	history := func(seq int, user string, seconds int64) string {
+		return fmt.Sprintf("<commit-history><sequence-number>%d</sequence-number><user>%s</user><client>cli</client>"+
+			"<date-time junos:seconds=\"%d\">-</date-time></commit-history>", seq, user, seconds)
+	}
+	replies := "<rpc-reply message-id=\"1\"><commit-information>" + history(0, "lab", 1634284800) + "</commit-information></rpc-reply>]]>]]>" +
+		"<rpc-reply message-id=\"2\"><commit-information>" + history(0, "ops", 1634284900) + history(1, "lab", 1634284800) +
+		"</commit-information></rpc-reply>]]>]]>"
+	session := &netconfSession{stdin: discardCloser{io.Discard}, stdout: bufio.NewReader(strings.NewReader(replies))}
+
+	var acc testutil.Accumulator
+	c := &NETCONF{RPCTimeout: config.Duration(time.Minute), Log: testutil.Logger{}, acc: &acc}
+	status := newSessionStatus("10.0.0.1", "default")
+
+	// the commits before the first poll are not emitted
+	require.NoError(t, c.pollCommits(session, "10.0.0.1", status))
+	require.Empty(t, acc.GetTelegrafMetrics())
+
+	require.NoError(t, c.pollCommits(session, "10.0.0.1", status))
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"netconf_commit",
+			map[string]string{"device": "10.0.0.1", "user": "ops", "method": "cli"},
+			map[string]interface{}{"sequence_number": int64(0), "comment": ""},
+			time.Unix(1634284900, 0),
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+}
+
+func TestResolveSecret(t *testing.T) {
+	t.Setenv("NETCONF_TEST_PASSWORD", "secret1")
+	v, err := resolveSecret("env:NETCONF_TEST_PASSWORD")
+	require.NoError(t, err)
+	require.Equal(t, "secret1", v)
+
+	file := filepath.Join(t.TempDir(), "password")
+	require.NoError(t, os.WriteFile(file, []byte("secret2\n"), 0600))
+	v, err = resolveSecret("file:" + file)
+	require.NoError(t, err)
+	require.Equal(t, "secret2", v)
+
+	v, err = resolveSecret("lab123")
+	require.NoError(t, err)
+	require.Equal(t, "lab123", v)
+
+	_, err = resolveSecret("env:NETCONF_TEST_UNSET")
+	require.Error(t, err)
+}
+
+func TestDisabledSubscription(t *testing.T) {
+	disabled := false
+	c := &NETCONF{Log: testutil.Logger{}}
+	requests, err := c.buildRequests([]Subscription{
+		{Name: "ifcounters", Rpc: "<get-interface-information/>", Enabled: &disabled},
+		{Name: "chassis", Rpc: "<get-chassis-inventory/>", StartDelay: config.Duration(90 * time.Second)},
+	})
+	require.NoError(t, err)
+	require.Len(t, requests, 1)
+	require.Equal(t, "chassis", requests[0].measurement)
+	require.Equal(t, 90*time.Second, requests[0].startDelay)
+}
+
+func TestDecodeWorkers(t *testing.T) {
+	var acc testutil.Accumulator
+	c := &NETCONF{DecodeWorkers: 2, acc: &acc, Log: testutil.Logger{}}
+	requests, err := c.buildRequests([]Subscription{
+		{
+			Name: "ifcounters",
+			Rpc:  "<get-interface-information><statistics/></get-interface-information>",
+			Fields: []string{
+				"/interface-information/physical-interface[name]/traffic-statistics/input-packets:int",
+			},
+		},
+	})
+	require.NoError(t, err)
+	data, err := os.ReadFile(filepath.Join("testdata", "10.0.0.1_ifcounters_1634284800000000000.xml"))
+	require.NoError(t, err)
+
+	c.startDecodeWorkers()
+	timestamp := time.Date(2021, 10, 15, 8, 0, 0, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		p := &pendingRPC{req: requests[0], timestamp: timestamp, sent: time.Now(), stats: newRPCStats("10.0.0.1", requests[0])}
+		c.decodeJobs <- &decodeJob{p: p, dev: &deviceState{}, address: "10.0.0.1", data: data}
+	}
+	close(c.decodeJobs)
+	c.workers.Wait()
+
+	require.Empty(t, acc.Errors)
+	require.Len(t, acc.GetTelegrafMetrics(), 8)
+	require.True(t, acc.HasPoint("ifcounters", map[string]string{"device": "10.0.0.1", "name": "xe-0/0/1"}, "input-packets", int64(3000)))
+}
+
+func TestSharedSessions(t *testing.T) {
+	m := &sessionManager{sessions: make(map[string]*sharedSession)}
+	dials := 0
+	dial := func() (*netconfSession, error) {
+		dials++
+		return &netconfSession{}, nil
+	}
+
+	s1, err := m.acquire("lab@10.0.0.1/", dial)
+	require.NoError(t, err)
+	s2, err := m.acquire("lab@10.0.0.1/", dial)
+	require.NoError(t, err)
+	require.Same(t, s1, s2)
+	require.Equal(t, 1, dials)
+	require.Equal(t, 2, m.sessions["lab@10.0.0.1/"].refs)
+
+	// a dial error is not cached
+	_, err = m.acquire("lab@10.0.0.2/", func() (*netconfSession, error) { return nil, fmt.Errorf("refused") })
+	require.Error(t, err)
+	require.NotContains(t, m.sessions, "lab@10.0.0.2/")
+}
+
+func TestSanitize(t *testing.T) {
+	reply := "<description>core\x01 link \xff\xfeAé</description>"
+	for mode, expected := range map[string]string{
+		"keep":    reply,
+		"strip":   "<description>core link Aé</description>",
+		"replace": "<description>core� link ��Aé</description>",
+	} {
+		c := &NETCONF{InvalidCharacters: mode}
+		// small reads to split the runes between the read buffers
+		data, err := io.ReadAll(iotest.OneByteReader(c.sanitize(strings.NewReader(reply), nil)))
+		require.NoError(t, err)
+		require.Equal(t, expected, string(data), mode)
+	}
+	require.Error(t, validInvalidCharacters("escape"))
+}
+
+func TestMergeRequests(t *testing.T) {
+	rpc := "<get-interface-information><statistics/></get-interface-information>"
+	requests := mergeRequests([]req{
+		{rpc: rpc, interval: 30},
+		{rpc: rpc, interval: 30},
+		{rpc: rpc, interval: 30, maxReplySize: 1024},
+		{rpc: rpc, interval: 30, maxReplySize: 1024, cooldown: time.Hour},
+		{rpc: rpc, interval: 60},
+	})
+	require.Len(t, requests, 4)
+	require.Len(t, requests[0].shared, 1)
+	require.Equal(t, int64(1024), requests[1].maxReplySize)
+	require.Empty(t, requests[1].shared)
+	require.Equal(t, time.Hour, requests[2].cooldown)
+	require.Empty(t, requests[2].shared)
+}
+
+func TestReloadRemovesSessionGroup(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "subscriptions.toml")
+	slow := `
+[[subscription]]
+  name = "inventory"
+  junos_rpc = "<get-chassis-inventory/>"
+  fields = ["/chassis-inventory/chassis[name]/serial-number:string"]
+  sample_interval = "1h"
+  session_group = "slow"
+`
+	reload := func(content string, modTime time.Time) {
+		require.NoError(t, os.WriteFile(file, []byte(content), 0600))
+		require.NoError(t, os.Chtimes(file, modTime, modTime))
+	}
+	reload(slow, time.Now())
+
+	c := &NETCONF{
+		// nothing listens on the NETCONF port of the address
+		Addresses:         []string{"127.0.0.1"},
+		Username:          "lab",
+		Password:          "lab",
+		SubscriptionsFile: file,
+		ReloadInterval:    config.Duration(10 * time.Millisecond),
+		Redial:            config.Duration(10 * time.Millisecond),
+		Subscriptions: []Subscription{
+			{
+				Name:           "ifcounters",
+				Rpc:            "<get-interface-information><statistics/></get-interface-information>",
+				Fields:         []string{"/interface-information/physical-interface[name]/traffic-statistics/input-packets:int"},
+				SampleInterval: config.Duration(30 * time.Second),
+			},
+		},
+		Log: testutil.Logger{},
+	}
+	groups := func() map[string]uint64 {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		groups := make(map[string]uint64)
+		for k, v := range c.groups {
+			groups[k] = v
+		}
+		return groups
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, c.Start(&acc))
+	defer c.Stop()
+	require.Contains(t, groups(), "slow")
+
+	// the goroutines of the removed group exit and the group is forgotten
+	reload("", time.Now().Add(time.Minute))
+	require.Eventually(t, func() bool {
+		_, ok := groups()["slow"]
+		return !ok
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Contains(t, groups(), "default")
+
+	// a later reload starts it again
+	reload(slow, time.Now().Add(2*time.Minute))
+	require.Eventually(t, func() bool {
+		return groups()["slow"] == 2
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// A field with too many keys is skipped along with its predicates
+func TestReplayPredicateTooManyKeys(t *testing.T) {
+	c := &NETCONF{
+		ReplayDir: "testdata",
+		Redial:    config.Duration(10 * time.Second),
+		Subscriptions: []Subscription{
+			{
+				Name: "ifcounters",
+				Rpc:  "<get-interface-information><statistics/></get-interface-information>",
+				Fields: []string{
+					"/interface-information/physical-interface[name,k1,k2,k3,k4,k5,speed='100Gbps']/traffic-statistics/input-packets:int",
+					"/interface-information/physical-interface[name='xe-0/0/0']/traffic-statistics/output-packets:int",
+				},
+				SampleInterval: config.Duration(30 * time.Second),
+			},
+		},
+		Log: testutil.Logger{},
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, c.Start(&acc))
+	c.Stop()
+
+	timestamp := time.Date(2021, 10, 15, 8, 0, 0, 0, time.UTC)
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"ifcounters",
+			map[string]string{"device": "10.0.0.1"},
+			map[string]interface{}{"output-packets": int64(2000)},
+			timestamp,
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics(), testutil.SortMetrics())
+}
+
+func TestAlignedNext(t *testing.T) {
+	now := time.Date(2021, 10, 15, 8, 0, 17, 0, time.UTC)
+	tests := []struct {
+		name     string
+		now      time.Time
+		interval time.Duration
+		offset   time.Duration
+		expected time.Time
+	}{
+		{"next boundary", now, 30 * time.Second, 0, time.Date(2021, 10, 15, 8, 0, 30, 0, time.UTC)},
+		{"offset", now, 30 * time.Second, 5 * time.Second, time.Date(2021, 10, 15, 8, 0, 35, 0, time.UTC)},
+		{"offset before now", now, 30 * time.Second, 10 * time.Second, time.Date(2021, 10, 15, 8, 0, 40, 0, time.UTC)},
+		{"offset within the current interval", now, time.Minute, 20 * time.Second, time.Date(2021, 10, 15, 8, 0, 20, 0, time.UTC)},
+		{"offset greater than the interval", now, 30 * time.Second, 65 * time.Second, time.Date(2021, 10, 15, 8, 0, 35, 0, time.UTC)},
+		{"on a boundary", now.Truncate(time.Minute), time.Minute, 0, time.Date(2021, 10, 15, 8, 1, 0, 0, time.UTC)},
+		{"no interval", now, 0, time.Second, now},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, alignedNext(tt.now, tt.interval, tt.offset))
+		})
+	}
+}
+
+func TestDeviceOffset(t *testing.T) {
+	c := &NETCONF{}
+	require.Zero(t, c.deviceOffset("10.0.0.1"))
+
+	c.CollectionOffset = config.Duration(10 * time.Second)
+	offsets := make(map[time.Duration]bool)
+	for i := 1; i <= 20; i++ {
+		address := fmt.Sprintf("10.0.0.%d", i)
+		offset := c.deviceOffset(address)
+		require.GreaterOrEqual(t, offset, time.Duration(0))
+		require.Less(t, offset, 10*time.Second)
+		// stable for a device
+		require.Equal(t, offset, c.deviceOffset(address))
+		offsets[offset] = true
+	}
+	// the devices are spread over the offset
+	require.Greater(t, len(offsets), 1)
+}
+
+func TestMeasurementOverride(t *testing.T) {
+	c := &NETCONF{Log: testutil.Logger{}}
+	requests, err := c.buildRequests([]Subscription{
+		{
+			Name: "ifcounters",
+			Rpc:  "<get-interface-information><statistics/></get-interface-information>",
+			Fields: []string{
+				"/interface-information/physical-interface[name]/traffic-statistics/input-packets:int",
+				"/interface-information/physical-interface[name]/queue-counters/queue[queue-number]/queued-packets:int",
+				"/interface-information/physical-interface[name]/queue-counters/queue[queue-number]/red-drop-packets:int",
+			},
+			MeasurementOverride: map[string]string{
+				"/interface-information/physical-interface[name]/queue-counters":                              "COS_QUEUES",
+				"/interface-information/physical-interface[name]/queue-counters/queue[queue-number]/red-drop": "COS_DROPS",
+				"/interface-information/logical-interface":                                                    "LOGICAL",
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, requests, 1)
+
+	measurements := make(map[string]string)
+	for _, f := range requests[0].fieldList {
+		measurements[f.fieldName] = f.measurement
+	}
+	require.Equal(t, map[string]string{
+		"/interface-information/physical-interface[name]/traffic-statistics/input-packets:int":                    "ifcounters",
+		"/interface-information/physical-interface[name]/queue-counters/queue[queue-number]/queued-packets:int":   "COS_QUEUES",
+		"/interface-information/physical-interface[name]/queue-counters/queue[queue-number]/red-drop-packets:int": "COS_DROPS",
+	}, measurements)
+	require.Len(t, requests[0].rowFields["COS_QUEUES"], 1)
+	require.Len(t, requests[0].rowFields["COS_DROPS"], 1)
+}
+
+func TestRPCErrorClass(t *testing.T) {
+	tests := []struct {
+		err   rpcError
+		class string
+	}{
+		{rpcError{Severity: "warning", Tag: "in-use"}, "warning"},
+		{rpcError{Severity: " error ", Tag: " operation-not-supported "}, "not_supported"},
+		{rpcError{Severity: "error", Tag: "unknown-element"}, "not_supported"},
+		{rpcError{Severity: "error", Message: "syntax error, expecting <command>"}, "not_supported"},
+		{rpcError{Severity: "error", Message: "RPC Not Supported"}, "not_supported"},
+		{rpcError{Severity: "error", Tag: "resource-denied"}, "resource_busy"},
+		{rpcError{Severity: "error", Tag: "lock-denied"}, "resource_busy"},
+		{rpcError{Severity: "error", Message: "Device Busy"}, "resource_busy"},
+		{rpcError{Severity: "error", Tag: "operation-failed", Message: "invalid interface"}, "error"},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.class, tt.err.class(), tt.err.Error())
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	busy := "<rpc-reply><rpc-error><error-severity>error</error-severity><error-tag>in-use</error-tag>" +
+		"<error-message>busy</error-message></rpc-error></rpc-reply>]]>]]>"
+	ok := "<rpc-reply><ok/></rpc-reply>]]>]]>"
+	c := &NETCONF{
+		RPCTimeout: config.Duration(time.Minute),
+		RetryPolicies: []RetryPolicy{
+			{Class: "resource_busy", Retries: 2, Backoff: config.Duration(time.Millisecond), Hold: config.Duration(time.Minute)},
+			{Class: "not_supported", Hold: config.Duration(time.Hour)},
+		},
+		Log: testutil.Logger{},
+	}
+	r := req{measurement: "ifcounters", rpc: "<get-interface-information/>"}
+	retry := func(replies string, err error) (time.Duration, string, error) {
+		session := &netconfSession{stdin: discardCloser{io.Discard}, stdout: bufio.NewReader(strings.NewReader(replies))}
+		p := &pendingRPC{req: r, metricToSend: []map[string]netconfMetric{{}}, stats: newRPCStats("10.0.0.1", r)}
+		hold, err := c.retry(context.Background(), session, "10.0.0.1", p, &deviceState{}, err)
+		// the replies left unread
+		left, _ := io.ReadAll(session.stdout)
+		return hold, string(left), err
+	}
+	busyErr := &rpcError{Severity: "error", Tag: "in-use"}
+
+	// retried until it succeeds
+	hold, left, err := retry(ok+ok, busyErr)
+	require.NoError(t, err)
+	require.Zero(t, hold)
+	require.Equal(t, ok, left)
+
+	// retries exhausted: put on hold
+	hold, left, err = retry(busy+busy+ok, busyErr)
+	require.NoError(t, err)
+	require.Equal(t, time.Minute, hold)
+	require.Equal(t, ok, left)
+
+	// not retried
+	hold, left, err = retry(ok, &rpcError{Severity: "error", Tag: "operation-not-supported"})
+	require.NoError(t, err)
+	require.Equal(t, time.Hour, hold)
+	require.Equal(t, ok, left)
+
+	// no policy for the class
+	hold, _, err = retry(ok, &rpcError{Severity: "error", Tag: "operation-failed"})
+	require.NoError(t, err)
+	require.Zero(t, hold)
+
+	// not an rpc-error
+	_, left, err = retry(ok, fmt.Errorf("session closed"))
+	require.EqualError(t, err, "session closed")
+	require.Equal(t, ok, left)
+}
+
+func TestSessionGroups(t *testing.T) {
+	rpc := "<get-interface-information><statistics/></get-interface-information>"
+	fields := []string{"/interface-information/physical-interface[name]/traffic-statistics/input-packets:int"}
+	c := &NETCONF{
+		// nothing listens on the NETCONF port of the addresses
+		Addresses: []string{"127.0.0.1", "127.0.0.2"},
+		Username:  "lab",
+		Password:  "lab",
+		Redial:    config.Duration(10 * time.Millisecond),
+		Subscriptions: []Subscription{
+			{Name: "ifcounters", Rpc: rpc, Fields: fields, SampleInterval: config.Duration(30 * time.Second)},
+			// same RPC and interval in another group: not shared
+			{Name: "ifcounters_slow", Rpc: rpc, Fields: fields, SampleInterval: config.Duration(30 * time.Second), SessionGroup: "slow"},
+		},
+		Log: testutil.Logger{},
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, c.Start(&acc))
+	c.mu.Lock()
+	require.Equal(t, map[string]uint64{"default": 0, "slow": 0}, c.groups)
+	c.mu.Unlock()
+
+	for group, measurement := range map[string]string{"default": "ifcounters", "slow": "ifcounters_slow"} {
+		requests, _ := c.groupRequests(group)
+		require.Len(t, requests, 1)
+		require.Equal(t, measurement, requests[0].measurement)
+		require.Empty(t, requests[0].shared)
+	}
+	// one session per device and group: each of them failed to dial
+	acc.WaitError(4)
+
+	// the goroutines of all the groups exit
+	stopped := make(chan struct{})
+	go func() {
+		c.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sessions not stopped")
+	}
+}
+
+func TestEmitSession(t *testing.T) {
+	var acc testutil.Accumulator
+	c := &NETCONF{acc: &acc}
+	status := newSessionStatus("10.0.0.1", "slow")
+	status.up = 1
+	status.connectLatency = 250 * time.Millisecond
+	status.reconnects = 3
+
+	// disabled by default
+	c.emitSession(status)
+	require.Empty(t, acc.GetTelegrafMetrics())
+	require.False(t, status.last.IsZero())
+
+	c.SessionMetrics = true
+	c.emitSession(status)
+	expected := []telegraf.Metric{
+		testutil.MustMetric(
+			"netconf_session",
+			map[string]string{"device": "10.0.0.1", "session_group": "slow"},
+			map[string]interface{}{
+				"up":                 1,
+				"connect_latency_ns": int64(250 * time.Millisecond),
+				"reconnect_count":    int64(3),
+				"backoff_factor":     int64(1),
+			},
+			status.last,
+		),
+	}
+	testutil.RequireMetricsEqual(t, expected, acc.GetTelegrafMetrics())
+}
+
+// A device not reachable is reported down, with the number of reconnections
+func TestSessionMetricsUnreachable(t *testing.T) {
+	c := &NETCONF{
+		// nothing listens on the NETCONF port of the address
+		Addresses:      []string{"127.0.0.1"},
+		Username:       "lab",
+		Password:       "lab",
+		Redial:         config.Duration(10 * time.Millisecond),
+		SessionMetrics: true,
+		Subscriptions: []Subscription{
+			{
+				Name:           "ifcounters",
+				Rpc:            "<get-interface-information><statistics/></get-interface-information>",
+				Fields:         []string{"/interface-information/physical-interface[name]/traffic-statistics/input-packets:int"},
+				SampleInterval: config.Duration(30 * time.Second),
+			},
+		},
+		Log: testutil.Logger{},
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, c.Start(&acc))
+	acc.Wait(2)
+	c.Stop()
+
+	metrics := acc.GetTelegrafMetrics()
+	for i, m := range metrics[:2] {
+		require.Equal(t, "netconf_session", m.Name())
+		require.Equal(t, map[string]string{"device": "127.0.0.1", "session_group": "default"}, m.Tags())
+		up, _ := m.GetField("up")
+		require.Equal(t, int64(0), up)
+		reconnects, _ := m.GetField("reconnect_count")
+		require.Equal(t, int64(i), reconnects)
+	}
+}
+
+func TestLoadRequests(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "subscriptions.toml")
+	require.NoError(t, os.WriteFile(file, []byte(`
+[[subscription]]
+  name = "inventory"
+  junos_rpc = "<get-chassis-inventory/>"
+  fields = ["/chassis-inventory/chassis[name]/serial-number:string"]
+  sample_interval = "1h"
+`), 0600))
+	c := &NETCONF{
+		SubscriptionsFile: file,
+		Subscriptions: []Subscription{
+			{Name: "ifcounters", Rpc: "<get-interface-information/>", SampleInterval: config.Duration(30 * time.Second)},
+		},
+		Log: testutil.Logger{},
+	}
+	requests, err := c.loadRequests()
+	require.NoError(t, err)
+	require.Len(t, requests, 2)
+	require.Equal(t, "ifcounters", requests[0].measurement)
+	require.Equal(t, "inventory", requests[1].measurement)
+	info, err := os.Stat(file)
+	require.NoError(t, err)
+	require.Equal(t, info.ModTime(), c.fileModTime)
+
+	require.NoError(t, os.WriteFile(file, []byte("[[subscription]\n"), 0600))
+	_, err = c.loadRequests()
+	require.Error(t, err)
+
+	c.SubscriptionsFile = filepath.Join(t.TempDir(), "missing.toml")
+	_, err = c.loadRequests()
+	require.Error(t, err)
+}
+
+func TestWatchSubscriptions(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "subscriptions.toml")
+	write := func(content string, modTime time.Time) {
+		require.NoError(t, os.WriteFile(file, []byte(content), 0600))
+		require.NoError(t, os.Chtimes(file, modTime, modTime))
+	}
+	subscription := func(name string) string {
+		return fmt.Sprintf("[[subscription]]\n  name = %q\n  junos_rpc = \"<get-chassis-inventory/>\"\n  sample_interval = \"1h\"\n", name)
+	}
+	write(subscription("inventory"), time.Now())
+
+	c := &NETCONF{
+		SubscriptionsFile: file,
+		ReloadInterval:    config.Duration(10 * time.Millisecond),
+		Log:               testutil.Logger{},
+		groups:            make(map[string]uint64),
+	}
+	requests, err := c.loadRequests()
+	require.NoError(t, err)
+	c.requests = requests
+	current := func() ([]string, uint64) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		var names []string
+		for _, r := range c.requests {
+			names = append(names, r.measurement)
+		}
+		return names, c.generation
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.watchSubscriptions(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// the changed file is reloaded
+	write(subscription("chassis"), time.Now().Add(time.Minute))
+	require.Eventually(t, func() bool {
+		names, generation := current()
+		return generation == 1 && len(names) == 1 && names[0] == "chassis"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// an invalid file keeps the running subscriptions
+	write("[[subscription]\n", time.Now().Add(2*time.Minute))
+	time.Sleep(100 * time.Millisecond)
+	names, generation := current()
+	require.Equal(t, []string{"chassis"}, names)
+	require.Equal(t, uint64(1), generation)
+
+	// and is reloaded once fixed
+	write(subscription("inventory"), time.Now().Add(3*time.Minute))
+	require.Eventually(t, func() bool {
+		names, generation := current()
+		return generation == 2 && len(names) == 1 && names[0] == "inventory"
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestReplySizeCooldown(t *testing.T) {
+	c := &NETCONF{MaxReplySize: config.Size(1 << 20), RPCTimeout: config.Duration(time.Minute), Log: testutil.Logger{}}
+	requests, err := c.buildRequests([]Subscription{
+		{Name: "ifcounters", Rpc: "<get-interface-information/>", SampleInterval: config.Duration(30 * time.Second)},
+		{
+			Name:                 "routes",
+			Rpc:                  "<get-route-information/>",
+			SampleInterval:       config.Duration(30 * time.Second),
+			MaxReplySize:         config.Size(32),
+			MaxReplySizeCooldown: config.Duration(time.Hour),
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, requests, 2)
+	require.Equal(t, int64(1<<20), requests[0].maxReplySize)
+	require.Zero(t, requests[0].cooldown)
+	require.Equal(t, int64(32), requests[1].maxReplySize)
+	require.Equal(t, time.Hour, requests[1].cooldown)
+
+	ok := "<rpc-reply><ok/></rpc-reply>]]>]]>"
+	replies := "<rpc-reply><route-information><route-table>inet.0</route-table></route-information></rpc-reply>]]>]]>" + ok
+	session := &netconfSession{stdin: discardCloser{io.Discard}, stdout: bufio.NewReader(strings.NewReader(replies))}
+	r := requests[1]
+	p := &pendingRPC{req: r, metricToSend: []map[string]netconfMetric{{}}, stats: newRPCStats("10.0.0.2", r)}
+
+	// the reply is dropped and the subscription disabled for the cool-down period
+	hold, err := c.executeWithRetry(context.Background(), session, "10.0.0.2", p, &deviceState{})
+	require.NoError(t, err)
+	require.Equal(t, time.Hour, hold)
+	require.Equal(t, int64(1), p.stats.repliesTooLarge.Get())
+
+	// the session is still usable
+	left, _ := io.ReadAll(session.stdout)
+	require.Equal(t, ok, string(left))
+}
@@ -4,8 +4,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
 	"math/rand"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -15,8 +20,7 @@ import (
 	"github.com/influxdata/telegraf/config"
 	"github.com/influxdata/telegraf/metric"
 	"github.com/influxdata/telegraf/plugins/inputs"
-	"github.com/openshift-telco/go-netconf-client/netconf"
-	"github.com/openshift-telco/go-netconf-client/netconf/message"
+	"github.com/influxdata/telegraf/selfstat"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -27,125 +31,500 @@ type NETCONF struct {
 	Addresses     []string       `toml:"addresses"`
 	Subscriptions []Subscription `toml:"subscription"`
 
-	// Netconf target credentials
-	Username string `toml:"username"`
-	Password string `toml:"password"`
+	// Netconf target credentials - plain text, "env:<VARIABLE>" or "file:<path>"
+	Username             string `toml:"username"`
+	Password             string `toml:"password"`
+	PrivateKey           string `toml:"private_key"`
+	PrivateKeyPassphrase string `toml:"private_key_passphrase"`
 
 	// Redial
 	Redial config.Duration `toml:"redial"`
 
+	// Maximum time to receive a rpc-reply and maximum size of a rpc-reply (0 = unlimited)
+	RPCTimeout   config.Duration `toml:"rpc_timeout"`
+	MaxReplySize config.Size     `toml:"max_reply_size"`
+
+	// Align the RPCs on the wall clock and shift each device by a stable offset
+	RoundInterval    bool            `toml:"round_interval"`
+	CollectionOffset config.Duration `toml:"collection_offset"`
+
+	// Debug: dump raw rpc-reply into a directory and replay captured files
+	CaptureDir string `toml:"capture_dir"`
+	ReplayDir  string `toml:"replay_dir"`
+
+	// Only emit the fields whose value changed - all fields are emitted again after the heartbeat interval
+	SuppressRedundant bool            `toml:"suppress_redundant"`
+	HeartbeatInterval config.Duration `toml:"heartbeat_interval"`
+
+	// Run get-software-information (and get-chassis-inventory) once per session and tag the metrics
+	DeviceFacts          bool `toml:"device_facts"`
+	DeviceFactsInventory bool `toml:"device_facts_inventory"`
+
+	// Merge the measurements of several RPCs sharing the join tags
+	Joins []Join `toml:"join"`
+
+	// Maximum factor applied to the intervals of a busy device (1 = no backoff)
+	BusyBackoffMax int64 `toml:"busy_backoff_max"`
+
+	// Poll get-commit-information and emit a netconf_commit event for each new commit (0 = disabled)
+	CommitEventsInterval config.Duration `toml:"commit_events_interval"`
+
+	// Emit the netconf_session measurement (up, connect latency and reconnects) per device
+	SessionMetrics bool `toml:"session_metrics"`
+
+	// Number of workers decoding the replies (0 = the replies are decoded while they are received)
+	DecodeWorkers int `toml:"decode_workers"`
+
+	// Share the sessions with the other plugin instances polling the same devices with the same
+	// user and session group
+	ShareSessions bool `toml:"share_sessions"`
+
+	// Handling of the invalid XML characters in the replies: keep (default), strip or replace
+	InvalidCharacters string `toml:"invalid_characters"`
+
+	// Maximum number of RPCs sent on a session before reading their replies (default 1 = no pipelining)
+	Pipelining int `toml:"pipelining"`
+
+	// Retry policy per class of rpc-error
+	RetryPolicies []RetryPolicy `toml:"retry_policy"`
+
+	// Additional subscriptions read from a file - changes are applied without dropping the sessions
+	SubscriptionsFile string          `toml:"subscriptions_file"`
+	ReloadInterval    config.Duration `toml:"subscriptions_reload_interval"`
+
 	// Internal state
-	acc    telegraf.Accumulator
-	cancel context.CancelFunc
-	wg     sync.WaitGroup
+	startTime time.Time
+	acc       telegraf.Accumulator
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+
+	// replies waiting for a decode worker
+	decodeJobs chan *decodeJob
+	workers    sync.WaitGroup
+
+	// Current requests - replaced on subscriptions reload
+	mu          sync.Mutex
+	requests    []req
+	generation  uint64
+	groups      map[string]uint64
+	fileModTime time.Time
 
 	Log telegraf.Logger
 }
 
 // Subscription for a Netconf client
 type Subscription struct {
+	// Disabled subscriptions are ignored - they are not started before the start delay elapsed
+	Enabled    *bool           `toml:"enabled"`
+	StartDelay config.Duration `toml:"start_delay"`
+
 	Name   string   `toml:"name"`
 	Rpc    string   `toml:"junos_rpc"`
 	Fields []string `toml:"fields"`
 
+	// Standard <get> with a "subtree" or "xpath" filter built from the fields - instead of junos_rpc
+	GetFilter     string            `toml:"get_filter"`
+	GetNamespaces map[string]string `toml:"get_namespaces"`
+
+	// CLI command returning text - parsed with regexes (named groups are mapped to fields/tags)
+	Command      string   `toml:"junos_command"`
+	TextPatterns []string `toml:"text_patterns"`
+
+	// Concatenate multi-key lists into an extra composite tag
+	CompositeTag       bool   `toml:"composite_tag"`
+	CompositeSeparator string `toml:"composite_separator"`
+
+	// Route fields to another measurement - key is an xpath prefix, value the measurement name
+	MeasurementOverride map[string]string `toml:"measurement_override"`
+
 	// Subscription mode and interval
 	SampleInterval config.Duration `toml:"sample_interval"`
+
+	// Subscriptions of the same group share one session per device
+	SessionGroup string `toml:"session_group"`
+
+	// Maximum size of the rpc-reply (overrides the plugin setting) and how long the subscription
+	// is disabled once exceeded
+	MaxReplySize         config.Size     `toml:"max_reply_size"`
+	MaxReplySizeCooldown config.Duration `toml:"max_reply_size_cooldown"`
+
+	// Rows of a list entry missing some fields: emitted as is ("partial"), dropped ("complete")
+	// or completed with default values ("fill")
+	RowPolicy  string            `toml:"row_policy"`
+	FillValues map[string]string `toml:"fill_values"`
+
+	// Fields computed from the other fields of each list entry, e.g. "utilization = input-bytes * 8 / speed"
+	DerivedFields []string `toml:"derived_fields"`
+
+	// Execute the RPC for each logical_system, routing_instance, fpc or member discovered on the device
+	Iterate string `toml:"iterate"`
 }
 
 type req struct {
 	measurement string
 	interval    uint64
 	rpc         string
+	group       string
 	fieldList   []fieldEntry
 	hashTable   map[string]xpathEntry
+
+	// presence fields per parent xpath - emitted when the parent ends
+	presence map[string][]string
+
+	// predicates per xpath of the tested element and per xpath of the list entry
+	predicates     map[string][]predicateRef
+	predicateLists map[string][]predicateRef
+
+	// text parsing of CLI commands
+	textPatterns []*regexp.Regexp
+	textTypes    map[string]string
+	textScales   map[string]float64
+
+	// subscriptions sharing the RPC - decoded from the same rpc-reply
+	shared []req
+
+	// RPC executed for each discovered value
+	iterate string
+
+	// reply size guard
+	maxReplySize int64
+	cooldown     time.Duration
+
+	// <get> RPC - the reply is wrapped in a <data> element
+	get bool
+
+	// the RPC is not executed before this delay since the start of the plugin
+	startDelay time.Duration
+
+	// rows missing some of the fields of their measurement
+	rowPolicy  string
+	fillValues map[string]string
+	rowFields  map[string][]rowField
+
+	// fields computed per row
+	derived []derivedField
+}
+
+// all returns the request and the subscriptions sharing its RPC
+func (r req) all() []req {
+	return append([]req{r}, r.shared...)
+}
+
+// mergeRequests merges the requests with the same RPC, interval, session group and reply size
+// guard, so the RPC is executed once and its reply is decoded into the measurements of all of them
+func mergeRequests(requests []req) []req {
+	merged := make([]req, 0, len(requests))
+	for _, r := range requests {
+		found := false
+		for i, m := range merged {
+			if len(r.textPatterns) == 0 && len(m.textPatterns) == 0 && m.get == r.get && m.startDelay == r.startDelay && m.rpc == r.rpc && m.interval == r.interval && m.group == r.group && m.iterate == r.iterate && m.maxReplySize == r.maxReplySize && m.cooldown == r.cooldown {
+				merged[i].shared = append(merged[i].shared, r)
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, r)
+		}
+	}
+	return merged
 }
 
 type fieldEntry struct {
+	fieldName   string
+	measurement string
+	tagLength   int
+	composites  []compositeTag
+	predicates  int
+}
+
+// predicateRef is the predicate idx of a field: the list entry is kept if the element value is equal to value
+type predicateRef struct {
 	fieldName string
-	tagLength int
+	idx       int
+	value     string
+}
+
+// pendingPredicate is a predicate of a field with the xpath of its element and of its list
+type pendingPredicate struct {
+	element string
+	list    string
+	ref     predicateRef
 }
 
 type xpathEntry struct {
 	shortName  string
 	masterKeys []string
 	metricType string
+	scale      float64
 	tagIdx     int
+	groupEnd   int
+}
+
+// compositeTag concatenates the values of the keys [first..last] of a multi-key list
+type compositeTag struct {
+	name      string
+	separator string
+	first     int
+	last      int
 }
 
 type netconfMetric struct {
+	measurement string
 	tagLength   int
 	keyTag      []string
 	valueTag    []string
 	keyField    string
 	valueField  interface{}
 	valueFilled int
+	composites  []compositeTag
+	matched     []bool
+}
+
+// rpcStats holds the self-monitoring statistics of one RPC for one device
+type rpcStats struct {
+	latency             selfstat.Stat
+	replySize           selfstat.Stat
+	parseDuration       selfstat.Stat
+	errors              selfstat.Stat
+	consecutiveFailures selfstat.Stat
+	repliesTooLarge     selfstat.Stat
+	invalidChars        selfstat.Stat
+}
+
+func newRPCStats(address string, r req) *rpcStats {
+	tags := map[string]string{
+		"device":       address,
+		"subscription": r.measurement,
+	}
+	return &rpcStats{
+		latency:             selfstat.RegisterTiming("netconf_junos", "rpc_latency_ns", tags),
+		replySize:           selfstat.Register("netconf_junos", "reply_size_bytes", tags),
+		parseDuration:       selfstat.RegisterTiming("netconf_junos", "parse_duration_ns", tags),
+		errors:              selfstat.Register("netconf_junos", "rpc_errors", tags),
+		consecutiveFailures: selfstat.Register("netconf_junos", "consecutive_failures", tags),
+		repliesTooLarge:     selfstat.Register("netconf_junos", "replies_too_large", tags),
+		invalidChars:        selfstat.Register("netconf_junos", "invalid_characters", tags),
+	}
 }
 
 // Start the ssh listener service
 func (c *NETCONF) Start(acc telegraf.Accumulator) error {
 	var ctx context.Context
-	var requests []req
 
 	c.acc = acc
+	c.startTime = time.Now()
 	ctx, c.cancel = context.WithCancel(context.Background())
 
 	// Validate configuration
 	if time.Duration(c.Redial).Nanoseconds() <= 0 {
 		return fmt.Errorf("redial duration must be positive")
 	}
+	if time.Duration(c.RPCTimeout) <= 0 {
+		c.RPCTimeout = config.Duration(60 * time.Second)
+	}
+	if time.Duration(c.ReloadInterval) <= 0 {
+		c.ReloadInterval = config.Duration(time.Minute)
+	}
+	if c.BusyBackoffMax <= 0 {
+		c.BusyBackoffMax = 8
+	}
+	if err := validInvalidCharacters(c.InvalidCharacters); err != nil {
+		return err
+	}
 
 	// parse the configuration to create the requests
-	requests = make([]req, 0)
-	for _, s := range c.Subscriptions {
+	requests, err := c.loadRequests()
+	if err != nil {
+		return err
+	}
+	c.requests = requests
+
+	// Replay mode - decode captured replies instead of connecting to the devices
+	if c.ReplayDir != "" {
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			if err := c.replay(requests); err != nil {
+				acc.AddError(err)
+			}
+		}()
+		return nil
+	}
+
+	// Decode the replies in a bounded pool of workers so the RPC scheduling stays on time
+	if c.DecodeWorkers > 0 {
+		c.startDecodeWorkers()
+	}
+
+	// Create a goroutine for each device and session group
+	c.groups = make(map[string]uint64)
+	c.startGroups(ctx)
+
+	// Watch the subscriptions file to apply changes without dropping the sessions
+	if c.SubscriptionsFile != "" {
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			c.watchSubscriptions(ctx)
+		}()
+	}
+	return nil
+}
+
+// buildRequests parses the subscriptions to create the requests
+func (c *NETCONF) buildRequests(subscriptions []Subscription) ([]req, error) {
+	requests := make([]req, 0)
+	for _, s := range subscriptions {
+		if s.Enabled != nil && !*s.Enabled {
+			c.Log.Debugf("Subscription %s disabled", s.Name)
+			continue
+		}
 		var r req
 		r.measurement = s.Name
+		r.startDelay = time.Duration(s.StartDelay)
 		r.rpc = s.Rpc
 		r.interval = uint64(time.Duration(s.SampleInterval).Nanoseconds())
+		r.group = s.SessionGroup
+		if r.group == "" {
+			r.group = "default"
+		}
+		if _, ok := iterations[s.Iterate]; s.Iterate != "" && !ok {
+			return nil, fmt.Errorf("subscription %s: unknown iterate %q", s.Name, s.Iterate)
+		}
+		r.iterate = s.Iterate
+		r.maxReplySize = int64(s.MaxReplySize)
+		if r.maxReplySize == 0 {
+			r.maxReplySize = int64(c.MaxReplySize)
+		}
+		r.cooldown = time.Duration(s.MaxReplySizeCooldown)
 		r.hashTable = make(map[string]xpathEntry)
 		r.fieldList = make([]fieldEntry, 0)
+		r.presence = make(map[string][]string)
+		r.predicates = make(map[string][]predicateRef)
+		r.predicateLists = make(map[string][]predicateRef)
+		if err := validateRowPolicy(s); err != nil {
+			return nil, err
+		}
+		r.rowPolicy = s.RowPolicy
+		r.fillValues = s.FillValues
+		r.rowFields = make(map[string][]rowField)
+		for _, d := range s.DerivedFields {
+			derived, err := parseDerivedField(d)
+			if err != nil {
+				return nil, fmt.Errorf("subscription %s: %v", s.Name, err)
+			}
+			r.derived = append(r.derived, derived)
+		}
+		if s.CompositeSeparator == "" {
+			s.CompositeSeparator = "."
+		}
+
+		// standard <get> with a filter built from the fields
+		if s.GetFilter != "" {
+			rpc, err := buildGetRPC(s)
+			if err != nil {
+				return nil, err
+			}
+			r.rpc = rpc
+			r.get = true
+		}
+
+		// CLI command with text output - fields are the named groups of the patterns
+		if s.Command != "" {
+			if err := r.parseCommand(s); err != nil {
+				return nil, err
+			}
+			requests = append(requests, r)
+			continue
+		}
 
 		// first parse paths
 		for _, p := range s.Fields {
-			split_field := strings.Split(p, ":")
-			if len(split_field) != 2 {
+			// <xpath>:<type> with an optional scale factor: <xpath>:<type>:<scale>
+			split_field := splitOutside(p, ':')
+			if len(split_field) != 2 && len(split_field) != 3 {
 				c.Log.Errorf("Malformed field - skip it: %p", p)
 				continue
 			}
-			split_xpath := strings.Split(split_field[0], "/")
+			scale := 1.0
+			if len(split_field) == 3 {
+				var err error
+				if scale, err = parseScale(split_field[2]); err != nil {
+					c.Log.Errorf("Malformed scale factor - skip field %s: %v", p, err)
+					continue
+				}
+			}
+			split_xpath := splitOutside(split_field[0], '/')
 			xpath := ""
 			last := ""
 			numberOfTags := 0
 			tag_idx := 0
+			composites := make([]compositeTag, 0)
+			predicates := 0
+			// registered once the field is accepted
+			pending := make([]pendingPredicate, 0)
 			for _, e := range split_xpath {
 				// there is an attribute
 				if strings.Contains(e, "[") && strings.Contains(e, "]") {
-					numberOfTags += 1
-					// extract the key and concatenate with xpath
+					// extract the key(s) and concatenate with xpath - several keys may be given: [name,unit]
 					text := e[0:strings.Index(e, "[")]
-					attribut := e[strings.Index(e, "[")+1 : strings.Index(e, "]")]
 					xpath += text + "/"
-					// create the hashtable for fast search
-					mapInstance, ok := r.hashTable[xpath+attribut]
-					if !ok {
-						r.hashTable[xpath+attribut] = xpathEntry{masterKeys: make([]string, 0), metricType: "tag", shortName: attribut, tagIdx: tag_idx}
-						tag_idx += 1
-						mapInstance = r.hashTable[xpath+attribut]
-						mapInstance.masterKeys = append(mapInstance.masterKeys, p)
-						r.hashTable[xpath+attribut] = mapInstance
-					} else {
-						mapInstance.masterKeys = append(mapInstance.masterKeys, p)
-						// to manage tag hierarchy
-						tag_idx += 1
-						r.hashTable[xpath+attribut] = mapInstance
+					attributs := make([]string, 0)
+					for _, a := range splitOutside(e[strings.Index(e, "[")+1:strings.LastIndex(e, "]")], ',') {
+						a = strings.TrimSpace(a)
+						// predicate on the value of an element of the list entry: [admin-status='up']
+						if i := strings.Index(a, "="); i >= 0 {
+							ref := predicateRef{fieldName: p, idx: predicates, value: strings.Trim(strings.TrimSpace(a[i+1:]), "'\"")}
+							pending = append(pending, pendingPredicate{element: xpath + strings.TrimSpace(a[:i]), list: xpath[:len(xpath)-1], ref: ref})
+							predicates += 1
+							continue
+						}
+						attributs = append(attributs, a)
+					}
+					groupEnd := tag_idx + len(attributs) - 1
+					for i := range attributs {
+						attribut := attributs[i]
+						numberOfTags += 1
+						// create the hashtable for fast search
+						mapInstance, ok := r.hashTable[xpath+attribut]
+						if !ok {
+							r.hashTable[xpath+attribut] = xpathEntry{masterKeys: make([]string, 0), metricType: "tag", shortName: attribut, tagIdx: tag_idx, groupEnd: groupEnd}
+							tag_idx += 1
+							mapInstance = r.hashTable[xpath+attribut]
+							mapInstance.masterKeys = append(mapInstance.masterKeys, p)
+							r.hashTable[xpath+attribut] = mapInstance
+						} else {
+							mapInstance.masterKeys = append(mapInstance.masterKeys, p)
+							// to manage tag hierarchy
+							tag_idx += 1
+							r.hashTable[xpath+attribut] = mapInstance
+						}
+					}
+					if s.CompositeTag && len(attributs) > 1 {
+						composites = append(composites, compositeTag{name: strings.Join(attributs, "_"), separator: s.CompositeSeparator, first: groupEnd - len(attributs) + 1, last: groupEnd})
 					}
 				} else {
 					xpath += e + "/"
 					last = e
 				}
 			}
+			if numberOfTags > maxTagStackDepth {
+				c.Log.Errorf("Too many keys (max %d) - skip field: %s", maxTagStackDepth, p)
+				continue
+			}
+			for _, pp := range pending {
+				r.predicates[pp.element] = append(r.predicates[pp.element], pp.ref)
+				r.predicateLists[pp.list] = append(r.predicateLists[pp.list], pp.ref)
+			}
 			mapInstance, ok := r.hashTable[xpath[0:len(xpath)-1]]
 			if !ok {
-				r.hashTable[xpath[0:len(xpath)-1]] = xpathEntry{masterKeys: make([]string, 0), metricType: split_field[1], shortName: last}
+				r.hashTable[xpath[0:len(xpath)-1]] = xpathEntry{masterKeys: make([]string, 0), metricType: split_field[1], scale: scale, shortName: last}
+				if split_field[1] == "presence" {
+					// empty element - true when it appears under its parent
+					parent := xpath[0:strings.LastIndex(xpath[0:len(xpath)-1], "/")]
+					r.presence[parent] = append(r.presence[parent], xpath[0:len(xpath)-1])
+				}
 				mapInstance = r.hashTable[xpath[0:len(xpath)-1]]
 				mapInstance.masterKeys = append(mapInstance.masterKeys, p)
 				r.hashTable[xpath[0:len(xpath)-1]] = mapInstance
@@ -153,214 +532,320 @@ func (c *NETCONF) Start(acc telegraf.Accumulator) error {
 				mapInstance.masterKeys = append(mapInstance.masterKeys, p)
 				r.hashTable[xpath[0:len(xpath)-1]] = mapInstance
 			}
-			r.fieldList = append(r.fieldList, fieldEntry{fieldName: p, tagLength: numberOfTags})
+			// the longest matching xpath prefix wins
+			measurement := s.Name
+			longest := 0
+			for prefix, m := range s.MeasurementOverride {
+				if strings.HasPrefix(split_field[0], prefix) && len(prefix) > longest {
+					measurement = m
+					longest = len(prefix)
+				}
+			}
+			r.fieldList = append(r.fieldList, fieldEntry{fieldName: p, measurement: measurement, tagLength: numberOfTags, composites: composites, predicates: predicates})
+			r.rowFields[measurement] = append(r.rowFields[measurement], rowField{name: last, metricType: split_field[1], scale: scale})
 		}
 		requests = append(requests, r)
 	}
+	return mergeRequests(requests), nil
+}
 
-	// Create a goroutine for each device, dial and subscribe
-	c.wg.Add(len(c.Addresses))
-	for _, addr := range c.Addresses {
-		go func(address string) {
-			defer c.wg.Done()
-			for ctx.Err() == nil {
-				if err := c.subscribeNETCONF(ctx, address, c.Username, c.Password, requests); err != nil && ctx.Err() == nil {
-					acc.AddError(err)
-				}
-				select {
-				case <-ctx.Done():
-				case <-time.After(time.Duration(c.Redial)):
+// startGroups creates a goroutine for each device of the session groups not started yet.
+// Each session group has its own session per device.
+func (c *NETCONF) startGroups(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, r := range c.requests {
+		if _, ok := c.groups[r.group]; ok {
+			continue
+		}
+		// the goroutines of a group are told apart by the generation they started with
+		started := c.generation
+		c.groups[r.group] = started
+
+		// dial and subscribe
+		c.wg.Add(len(c.Addresses))
+		for _, addr := range c.Addresses {
+			go func(address string, group string) {
+				defer c.wg.Done()
+				status := newSessionStatus(address, group)
+				for ctx.Err() == nil {
+					if err := c.subscribeNETCONF(ctx, address, c.Username, c.Password, group, status); err != nil && ctx.Err() == nil {
+						c.acc.AddError(err)
+						if isBusy(err) && status.updateBackoff(true, false, c.BusyBackoffMax) {
+							c.Log.Warnf("device %s busy - backoff factor %d", address, status.backoff)
+						}
+					}
+					if !c.groupActive(group, started) {
+						return
+					}
+					if ctx.Err() == nil {
+						// the session is down until the next dial succeeds
+						status.up = 0
+						c.emitSession(status)
+						status.reconnects++
+					}
+					select {
+					case <-ctx.Done():
+					case <-time.After(time.Duration(c.Redial) * time.Duration(status.backoff)):
+					}
 				}
-			}
-		}(addr)
+			}(addr, r.group)
+		}
 	}
-	return nil
+}
+
+// groupActive reports whether the goroutines of a session group started at a generation keep
+// running. A group is forgotten once a reload removed all its requests, so a later reload adding
+// some back starts it again.
+func (c *NETCONF) groupActive(group string, started uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation, ok := c.groups[group]; !ok || generation != started {
+		return false
+	}
+	for _, r := range c.requests {
+		if r.group == group {
+			return true
+		}
+	}
+	c.Log.Infof("Session group %q removed - sessions closed", group)
+	delete(c.groups, group)
+	return false
+}
+
+// groupRequests returns the current requests of a session group and their generation
+func (c *NETCONF) groupRequests(group string) ([]req, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := make([]req, 0)
+	for _, v := range c.requests {
+		if v.group == group {
+			r = append(r, v)
+		}
+	}
+	return r, c.generation
+}
+
+// currentGeneration returns the number of subscriptions reloads
+func (c *NETCONF) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
 }
 
 // subscribeNETCONF and extract telemetry data
-func (c *NETCONF) subscribeNETCONF(ctx context.Context, address string, u string, p string, r []req) error {
-	sshConfig := &ssh.ClientConfig{
-		User:            u,
-		Auth:            []ssh.AuthMethod{ssh.Password(p)},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+func (c *NETCONF) subscribeNETCONF(ctx context.Context, address string, u string, p string, group string, status *sessionStatus) (err error) {
+	r, generation := c.groupRequests(group)
+	if len(r) == 0 {
+		// session group removed by a reload
+		return nil
 	}
 
-	// Open SSH Session
-	session, err := netconf.DialSSH(fmt.Sprintf("%s:%d", address, 830), sshConfig)
+	user, auth, err := c.sshAuth(u, p)
 	if err != nil {
 		return fmt.Errorf("unable to open Netconf session for address %s: %v", address, err)
 	}
-	defer session.Close()
-
-	// Exchange capa... Just send HELLO RPC
-	capabilities := netconf.DefaultCapabilities
-	err = session.SendHello(&message.Hello{Capabilities: capabilities})
-	if err != nil {
-		return fmt.Errorf("error while sending Hello for router %s: %v", address, err)
+	sshConfig := &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 	}
-	c.Log.Debugf("Connection to Netconf device %s established", address)
-	defer c.Log.Debugf("Connection to Netconf device %s closed", address)
 
-	// prepare the map for searching metrics - unique per router - derived from initial request
-	var metricToSend map[string]map[string]netconfMetric
-	metricToSend = make(map[string]map[string]netconfMetric)
-	for _, req := range r {
-		metricToSend[req.rpc] = make(map[string]netconfMetric)
-		for _, k := range req.fieldList {
-			metricToSend[req.rpc][k.fieldName] = netconfMetric{tagLength: k.tagLength, keyTag: make([]string, maxTagStackDepth), valueTag: make([]string, maxTagStackDepth), keyField: "", valueField: "", valueFilled: 0}
+	// Open SSH Session and exchange the hello messages
+	dialStart := time.Now()
+	dial := func() (*netconfSession, error) {
+		return dialNETCONF(fmt.Sprintf("%s:%d", address, 830), sshConfig)
+	}
+	var session *netconfSession
+	if c.ShareSessions {
+		// one session per device, user and session group for all the plugin instances
+		key := fmt.Sprintf("%s@%s/%s", user, address, group)
+		session, err = sharedSessions.acquire(key, dial)
+		if err == nil {
+			defer func() { sharedSessions.release(key, session, err != nil) }()
 		}
+	} else {
+		session, err = dial()
+		if err == nil {
+			defer session.Close()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("unable to open Netconf session for address %s: %v", address, err)
 	}
+	status.up = 1
+	status.connectLatency = time.Since(dialStart)
+	c.emitSession(status)
+	c.Log.Debugf("Connection to Netconf device %s established for session group %s", address, group)
+	defer c.Log.Debugf("Connection to Netconf device %s closed for session group %s", address, group)
 
 	// compute tick - add jitter to avoid thread sync
 	jitter := time.Duration(1000 + rand.Intn(10))
 	tick := jitter * time.Millisecond
+	offset := c.deviceOffset(address)
 
-	// First find out the min interval btw all RPC
-	min := uint64(100000)
-	for _, v := range r {
-		min = minUint64(min, v.interval)
+	// per RPC state - rebuilt when the subscriptions are reloaded
+	var metricToSend map[string][]map[string]netconfMetric
+	var stats map[string]*rpcStats
+	var counters map[string]uint64
+	var nextRun map[string]time.Time
+	holdUntil := make(map[string]time.Time)
+	dev := &deviceState{iterations: make(map[string][]string)}
+	for _, j := range c.Joins {
+		dev.joiners = append(dev.joiners, newJoiner(j))
+	}
+	if c.SuppressRedundant {
+		dev.cache = newRedundancyCache(time.Duration(c.HeartbeatInterval))
 	}
-	// Init counter per RPC - distribute evently the RPC over the min time frame
-	taskInterval := uint64(time.Duration((float64(min) / float64(len(r))) * float64(time.Second)))
-	counters := make(map[string]uint64)
-	for i, v := range r {
-		counters[v.rpc] = uint64(i) * taskInterval
+	if c.DeviceFacts {
+		if dev.facts, err = c.deviceFacts(session, address); err != nil {
+			return err
+		}
 	}
+	prepare := func() {
+		// prepare the map for searching metrics - unique per router - derived from initial request
+		metricToSend = newMetricStore(r)
+
+		// self-monitoring statistics per RPC
+		stats = make(map[string]*rpcStats)
+		for _, req := range r {
+			stats[req.measurement] = newRPCStats(address, req)
+		}
+
+		// First find out the min interval btw all RPC
+		min := uint64(100000)
+		for _, v := range r {
+			min = minUint64(min, v.interval)
+		}
+		// Init counter per RPC - distribute evently the RPC over the min time frame
+		taskInterval := uint64(time.Duration((float64(min) / float64(len(r))) * float64(time.Second)))
+		counters = make(map[string]uint64)
+		for i, v := range r {
+			counters[v.measurement] = uint64(i) * taskInterval
+		}
+
+		// Wall-clock alignment: RPCs are issued on the interval boundaries shifted by the device offset
+		nextRun = make(map[string]time.Time)
+		if c.RoundInterval {
+			now := time.Now()
+			for _, v := range r {
+				nextRun[v.measurement] = alignedNext(now, time.Duration(v.interval), offset)
+			}
+			c.Log.Debugf("RPCs for device %s aligned on wall clock with an offset of %s", address, offset)
+		}
+	}
+	prepare()
 
 	// Loop until end
 	for ctx.Err() == nil {
+		// apply the reloaded subscriptions without dropping the session
+		if g := c.currentGeneration(); g != generation {
+			r, generation = c.groupRequests(group)
+			if len(r) == 0 {
+				c.Log.Infof("No more subscriptions for device %s and session group %s", address, group)
+				return nil
+			}
+			prepare()
+			c.Log.Infof("Subscriptions reloaded for device %s and session group %s", address, group)
+		}
+
+		// session reachability once per shortest sample interval
+		if time.Since(status.last) >= shortestInterval(r) {
+			c.emitSession(status)
+		}
+
+		// configuration changes - polled by one session group per device
+		if c.CommitEventsInterval > 0 && group == c.commitGroup() && time.Since(status.lastCommitPoll) >= time.Duration(c.CommitEventsInterval) {
+			status.lastCommitPoll = time.Now()
+			if err := c.pollCommits(session, address, status); err != nil {
+				return err
+			}
+		}
+
 		start := time.Now().UnixNano()
+		batch := make([]*pendingRPC, 0)
 		for _, req := range r {
+			// staged after the start of the plugin
+			if time.Since(c.startTime) < req.startDelay {
+				continue
+			}
 			// check if it's time to issue RPC
-			if counters[req.rpc] >= req.interval {
+			due := counters[req.measurement] >= req.interval*uint64(status.backoff)
+			if c.RoundInterval {
+				due = !time.Now().Before(nextRun[req.measurement])
+			}
+			if due {
 				timestamp := time.Now()
-				rpc_start := timestamp.UnixNano()
-				// Init metric containers
-				grouper := metric.NewSeriesGrouper()
+				if c.RoundInterval {
+					// use the scheduled time to avoid timestamps wandering
+					timestamp = nextRun[req.measurement]
+					nextRun[req.measurement] = alignedNext(time.Now(), time.Duration(req.interval)*time.Duration(status.backoff), offset)
+				}
 
 				// Reset counter for this RPC
-				counters[req.rpc] = 0
+				counters[req.measurement] = 0
 
 				// Send RPC to router
 				c.Log.Debugf("time to to issue the rpc %s for device %s", req.rpc, address)
-				rpc := message.NewRPC(req.rpc)
-				reply, err := session.SyncRPC(rpc, int32(60))
-				if err != nil || reply == nil || strings.Contains(reply.Data, "<rpc-error>") {
-					c.Log.Debugf("RPC error to Netconf device %s , rpc: %s", address, req.rpc)
+				if time.Now().Before(holdUntil[req.measurement]) {
+					c.Log.Debugf("rpc %s on hold for device %s", req.rpc, address)
 					continue
-				} else {
-					c.Log.Debugf("rpc-reply received for rpc %s and device %s", req.rpc, address)
-
-					// Made a buffer based on reply
-					buffer := bytes.NewBuffer([]byte(reply.Data))
-					decoder := xml.NewDecoder(buffer)
-
-					// Now traverse XML tree and rebuild XPATH and fill expected metric
-					xpath := make([]string, 0)
-					value := ""
-
-					for {
-						token, err := decoder.Token()
-						if err != nil {
-							// EOF
-							break
-						}
-						switch element := token.(type) {
-						case xml.StartElement:
-							// append node to xpath
-							xpath = append(xpath, element.Name.Local)
-						case xml.EndElement:
-							// rebuild the complete xpath
-							s := "/"
-							for _, x := range xpath {
-								s += x + "/"
-							}
-
-							// Remove trailing /
-							s = s[:len(s)-1]
+				}
+				expanded, err := c.expandRPC(session, dev, address, &pendingRPC{req: req, timestamp: timestamp, metricToSend: metricToSend[req.measurement], stats: stats[req.measurement]})
+				if err != nil {
+					return err
+				}
+				if c.Pipelining > 1 {
+					batch = append(batch, expanded...)
+					continue
+				}
+				for _, p := range expanded {
+					hold, err := c.executeWithRetry(ctx, session, address, p, dev)
+					if err != nil {
+						return err
+					}
+					if hold > 0 {
+						holdUntil[req.measurement] = time.Now().Add(hold)
+					}
+				}
+			}
+		}
 
-							// remove the last elem of the xpath list
-							if len(xpath) > 0 {
-								xpath = xpath[:len(xpath)-1]
-							}
+		// pipelining: send several RPCs before reading their replies
+		for len(batch) > 0 {
+			n := c.Pipelining
+			if n > len(batch) {
+				n = len(batch)
+			}
+			holds, err := c.executePipelined(ctx, session, address, batch[:n], dev)
+			if err != nil {
+				return err
+			}
+			for name, hold := range holds {
+				holdUntil[name] = time.Now().Add(hold)
+			}
+			batch = batch[n:]
+		}
 
-							// check if xpath matches one field's xpath
-							data, ok := req.hashTable[s]
-							if ok {
-								// Update TAG of all related metrics
-								if data.metricType == "tag" {
-									tagIdx := data.tagIdx
-
-									for _, k := range data.masterKeys {
-										v, ok := metricToSend[req.rpc][k]
-										if ok {
-											// update TAG for each metric
-											v.keyTag[tagIdx] = data.shortName
-											v.valueTag[tagIdx] = value
-											v.valueFilled = tagIdx + 1
-											metricToSend[req.rpc][k] = v
-										}
-									}
-
-								} else {
-									// Update field of all related metrics
-									for _, k := range data.masterKeys {
-										v, ok := metricToSend[req.rpc][k]
-										if ok {
-											// update TAG for each metric
-											v.keyField = data.shortName
-											switch data.metricType {
-											case "int":
-												v.valueField, err = strconv.Atoi(value)
-												if err != nil {
-													// keep string as type in case of error
-													v.valueField = value
-												}
-											case "float":
-												v.valueField, err = strconv.ParseFloat(value, 64)
-												if err != nil {
-													// keep string as type in case of error
-													v.valueField = value
-												}
-											default:
-												// Keep value as string for all other types
-												v.valueField = value
-											}
-											v.valueFilled += 1
-
-											// check if Metric should be sent
-											if v.valueFilled > v.tagLength {
-												tags := map[string]string{
-													"device": address,
-												}
-												for ind := 0; ind < v.tagLength; ind++ {
-													tags[v.keyTag[ind]] = v.valueTag[ind]
-												}
-												if err := grouper.Add(req.measurement, tags, timestamp, v.keyField, v.valueField); err != nil {
-													c.Log.Errorf("cannot add to grouper: %v", err)
-												}
-												// reduce of one tag - once metric sent
-												v.valueFilled = v.tagLength - 1
-											}
-											metricToSend[req.rpc][k] = v
-										}
-									}
-								}
-							}
-						case xml.CharData:
-							// extract value
-							value = strings.ReplaceAll(string(element), "\n", "")
-						}
+		// busy device: increase the intervals temporarily rather than hammering it every cycle
+		if status.updateBackoff(dev.busy, dev.succeeded, c.BusyBackoffMax) {
+			c.Log.Warnf("device %s busy - backoff factor %d", address, status.backoff)
+		}
+		dev.busy, dev.succeeded = false, false
 
-					}
-					// Add grouped measurements
-					for _, metricToAdd := range grouper.Metrics() {
-						c.acc.AddMetric(metricToAdd)
-					}
-					delta_rpc := time.Now().UnixNano() - rpc_start
-					c.Log.Debugf("rpc handling for rpc %s and device %s toke %s", req.rpc, address, time.Duration(uint64(delta_rpc)).String())
+		if c.RoundInterval {
+			// sleep until the next RPC is due
+			next := time.Now().Add(tick)
+			for _, t := range nextRun {
+				if t.Before(next) {
+					next = t
 				}
 			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Until(next)):
+			}
+			continue
 		}
 		delta := time.Now().UnixNano() - start
 		if uint64(delta) < uint64(tick) {
@@ -375,10 +860,451 @@ func (c *NETCONF) subscribeNETCONF(ctx context.Context, address string, u string
 	return nil
 }
 
+// pendingRPC is a RPC to execute or waiting for its reply
+type pendingRPC struct {
+	req          req
+	timestamp    time.Time
+	sent         time.Time
+	metricToSend []map[string]netconfMetric
+	stats        *rpcStats
+
+	// tags added to the metrics of the reply
+	tags map[string]string
+}
+
+// executeRPC sends the RPC of a request and decodes its reply while it is received.
+// A returned error means the session is no longer usable, except for an *rpcError or errReplyTooLarge.
+func (c *NETCONF) executeRPC(session *netconfSession, address string, p *pendingRPC, dev *deviceState) error {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	id, err := c.sendRPC(session, address, p)
+	if err != nil {
+		return err
+	}
+	_, err = c.readReply(session, address, map[uint64]*pendingRPC{id: p}, dev)
+	return err
+}
+
+// sendRPC sends the RPC of a request without waiting for its reply and returns its message-id
+func (c *NETCONF) sendRPC(session *netconfSession, address string, p *pendingRPC) (uint64, error) {
+	// the session is closed if the device doesn't accept the RPC in time
+	timer := time.AfterFunc(time.Duration(c.RPCTimeout), func() { session.Close() })
+	p.sent = time.Now()
+	id, err := session.sendRPC(p.req.rpc)
+	if !timer.Stop() {
+		err = fmt.Errorf("timeout")
+	}
+	if err != nil {
+		p.stats.errors.Incr(1)
+		p.stats.consecutiveFailures.Incr(1)
+		return 0, fmt.Errorf("error while executing rpc %s on device %s: %v", p.req.rpc, address, err)
+	}
+	return id, nil
+}
+
+// readReply waits for the next rpc-reply, correlates it with a pending RPC by its message-id
+// and decodes it while it is received. The RPC is removed from the pending ones and returned.
+func (c *NETCONF) readReply(session *netconfSession, address string, pending map[uint64]*pendingRPC, dev *deviceState) (*pendingRPC, error) {
+	grouper := metric.NewSeriesGrouper()
+
+	// the session is closed if the device doesn't answer in time
+	timer := time.AfterFunc(time.Duration(c.RPCTimeout), func() { session.Close() })
+	reply, id, err := session.reply()
+	if err != nil {
+		for _, p := range pending {
+			p.stats.errors.Incr(1)
+			p.stats.consecutiveFailures.Incr(1)
+		}
+		if !timer.Stop() {
+			return nil, fmt.Errorf("timeout while waiting for rpc-reply from device %s", address)
+		}
+		return nil, fmt.Errorf("error while waiting for rpc-reply from device %s: %v", address, err)
+	}
+	if id == 0 {
+		// message-id not echoed - the replies come in the order of the RPCs
+		for k := range pending {
+			if id == 0 || k < id {
+				id = k
+			}
+		}
+	}
+	p, ok := pending[id]
+	if !ok {
+		timer.Stop()
+		return nil, fmt.Errorf("unexpected rpc-reply with message-id %d from device %s", id, address)
+	}
+	delete(pending, id)
+	req, timestamp, stats := p.req, p.timestamp, p.stats
+	stats.latency.Set(time.Since(p.sent).Nanoseconds())
+	c.Log.Debugf("rpc-reply received for rpc %s and device %s", req.rpc, address)
+
+	data := &countingReader{r: reply, max: req.maxReplySize}
+	var decoded io.Reader = data
+	if c.CaptureDir != "" {
+		f, err := c.createCapture(address, req, timestamp)
+		if err != nil {
+			c.Log.Errorf("cannot capture rpc-reply for rpc %s and device %s: %v", req.rpc, address, err)
+		} else {
+			defer f.Close()
+			decoded = io.TeeReader(data, f)
+			reply = io.TeeReader(reply, f)
+		}
+	}
+	decoded = c.sanitize(decoded, stats.invalidChars)
+
+	// Decode the reply - or read it to hand it to a decode worker. The replies with a rpc-error are
+	// decoded inline for the retry policy.
+	parse_start := time.Now()
+	var buffered []byte
+	if c.decodeJobs != nil {
+		buffered, err = io.ReadAll(decoded)
+		if err == nil && bytes.Contains(buffered, []byte("<rpc-error")) {
+			err = c.decodeReply(req, bytes.NewReader(buffered), address, timestamp, p.metricToSend, grouper)
+			buffered = nil
+		}
+	} else {
+		err = c.decodeReply(req, decoded, address, timestamp, p.metricToSend, grouper)
+	}
+
+	// consume the end of the reply to stay in sync with the device
+	drained, drainErr := io.Copy(io.Discard, reply)
+	if !timer.Stop() {
+		return p, fmt.Errorf("timeout while executing rpc %s on device %s", req.rpc, address)
+	}
+	if drainErr != nil {
+		return p, fmt.Errorf("error while receiving rpc-reply %s from device %s: %v", req.rpc, address, drainErr)
+	}
+	stats.replySize.Set(data.count + drained)
+
+	if err != nil {
+		var rpcErr *rpcError
+		switch {
+		case errors.As(err, &rpcErr):
+			c.Log.Debugf("RPC error to Netconf device %s , rpc: %s: %v", address, req.rpc, err)
+			if rpcErr.class() == "resource_busy" {
+				dev.busy = true
+			}
+			stats.errors.Incr(1)
+			stats.consecutiveFailures.Incr(1)
+			return p, rpcErr
+		case errors.Is(err, errReplyTooLarge):
+			c.Log.Warnf("rpc-reply for rpc %s and device %s exceeds %d bytes - dropped", req.rpc, address, req.maxReplySize)
+			stats.errors.Incr(1)
+			stats.consecutiveFailures.Incr(1)
+			stats.repliesTooLarge.Incr(1)
+			return p, errReplyTooLarge
+		default:
+			c.Log.Debugf("rpc-reply parsing for rpc %s and device %s stopped: %v", req.rpc, address, err)
+		}
+	}
+	stats.consecutiveFailures.Set(0)
+	dev.succeeded = true
+
+	if buffered != nil {
+		// blocks while all the decode workers are busy
+		c.decodeJobs <- &decodeJob{p: p, dev: dev, address: address, data: buffered}
+		c.Log.Debugf("rpc-reply for rpc %s and device %s handed to a decode worker", req.rpc, address)
+		return p, nil
+	}
+	stats.parseDuration.Set(time.Since(parse_start).Nanoseconds())
+
+	c.emitReply(p, dev, grouper)
+	c.Log.Debugf("rpc handling for rpc %s and device %s toke %s", req.rpc, address, time.Since(p.sent).String())
+	return p, nil
+}
+
+// emitReply adds the metrics decoded from a rpc-reply
+func (c *NETCONF) emitReply(p *pendingRPC, dev *deviceState, grouper *metric.SeriesGrouper) {
+	metrics := applyDerivedFields(p.req, applyRowPolicy(p.req, grouper.Metrics()))
+	for _, m := range metrics {
+		for k, v := range p.tags {
+			m.AddTag(k, v)
+		}
+	}
+
+	// the decode workers may emit the metrics of a device concurrently
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	for _, metricToAdd := range dev.join(metrics) {
+		for k, v := range dev.facts {
+			metricToAdd.AddTag(k, v)
+		}
+		if dev.cache != nil && !dev.cache.filter(metricToAdd) {
+			continue
+		}
+		c.acc.AddMetric(metricToAdd)
+	}
+}
+
+// newMetricStore prepares the maps for searching metrics per request - one per subscription sharing the RPC
+func newMetricStore(r []req) map[string][]map[string]netconfMetric {
+	metricToSend := make(map[string][]map[string]netconfMetric)
+	for _, req := range r {
+		for _, sub := range req.all() {
+			store := make(map[string]netconfMetric)
+			for _, k := range sub.fieldList {
+				store[k.fieldName] = netconfMetric{measurement: k.measurement, tagLength: k.tagLength, keyTag: make([]string, maxTagStackDepth), valueTag: make([]string, maxTagStackDepth), keyField: "", valueField: "", valueFilled: 0, composites: k.composites, matched: make([]bool, k.predicates)}
+			}
+			metricToSend[req.measurement] = append(metricToSend[req.measurement], store)
+		}
+	}
+	return metricToSend
+}
+
+// decodeReply traverses the rpc-reply, rebuilds the xpath of each element and fills the expected metrics
+// of the request and of the subscriptions sharing its RPC
+func (c *NETCONF) decodeReply(req req, data io.Reader, address string, timestamp time.Time, stores []map[string]netconfMetric, grouper *metric.SeriesGrouper) error {
+	if len(req.textPatterns) > 0 {
+		return c.decodeText(req, data, address, timestamp, grouper)
+	}
+
+	decoder := xml.NewDecoder(data)
+
+	// Now traverse XML tree and rebuild XPATH and fill expected metric
+	xpath := make([]string, 0)
+	value := ""
+
+	// presence elements seen under their current parent - per subscription
+	subs := req.all()
+	seen := make([]map[string]bool, len(subs))
+	for i := range seen {
+		seen[i] = make(map[string]bool)
+	}
+
+	// Update field of all related metrics and send the complete ones
+	setField := func(metricToSend map[string]netconfMetric, data xpathEntry, fieldValue interface{}) {
+		for _, k := range data.masterKeys {
+			v, ok := metricToSend[k]
+			if ok {
+				// update TAG for each metric
+				v.keyField = data.shortName
+				v.valueField = fieldValue
+				v.valueFilled += 1
+
+				// check if Metric should be sent - unless a predicate of the list entry doesn't match
+				if v.valueFilled > v.tagLength && allTrue(v.matched) {
+					tags := map[string]string{
+						"device": address,
+					}
+					for ind := 0; ind < v.tagLength; ind++ {
+						tags[v.keyTag[ind]] = v.valueTag[ind]
+					}
+					for _, ct := range v.composites {
+						tags[ct.name] = strings.Join(v.valueTag[ct.first:ct.last+1], ct.separator)
+					}
+					if err := grouper.Add(v.measurement, tags, timestamp, v.keyField, v.valueField); err != nil {
+						c.Log.Errorf("cannot add to grouper: %v", err)
+					}
+				}
+				if v.valueFilled > v.tagLength {
+					// reduce of one tag - once metric sent
+					v.valueFilled = v.tagLength - 1
+				}
+				metricToSend[k] = v
+			}
+		}
+	}
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		switch element := token.(type) {
+		case xml.StartElement:
+			// skip the rpc-reply envelope - and the data envelope of a <get>
+			if len(xpath) == 0 && element.Name.Local == "rpc-reply" {
+				continue
+			}
+			if req.get && len(xpath) == 0 && element.Name.Local == "data" {
+				continue
+			}
+			if element.Name.Local == "rpc-error" {
+				if err := c.decodeRPCError(decoder, &element, address, req); err != nil {
+					return err
+				}
+				continue
+			}
+			// append node to xpath
+			xpath = append(xpath, element.Name.Local)
+			s := ""
+			for i, sub := range subs {
+				if len(sub.presence) == 0 && len(sub.predicateLists) == 0 {
+					continue
+				}
+				if s == "" {
+					s = "/" + strings.Join(xpath, "/")
+				}
+				if data, ok := sub.hashTable[s]; ok && data.metricType == "presence" {
+					seen[i][s] = true
+				}
+				// new list entry - the predicates must be evaluated again
+				for _, ref := range sub.predicateLists[s] {
+					stores[i][ref.fieldName].matched[ref.idx] = false
+				}
+			}
+		case xml.EndElement:
+			// rebuild the complete xpath
+			s := "/"
+			for _, x := range xpath {
+				s += x + "/"
+			}
+
+			// Remove trailing /
+			s = s[:len(s)-1]
+
+			// remove the last elem of the xpath list
+			if len(xpath) > 0 {
+				xpath = xpath[:len(xpath)-1]
+			}
+
+			for i, sub := range subs {
+				metricToSend := stores[i]
+
+				// check if xpath matches one field's xpath
+				data, ok := sub.hashTable[s]
+				if ok {
+					// Update TAG of all related metrics
+					if data.metricType == "tag" {
+						tagIdx := data.tagIdx
+
+						for _, k := range data.masterKeys {
+							v, ok := metricToSend[k]
+							if ok {
+								// update TAG for each metric
+								v.keyTag[tagIdx] = data.shortName
+								v.valueTag[tagIdx] = value
+								v.valueFilled = data.groupEnd + 1
+								metricToSend[k] = v
+							}
+						}
+
+					} else if data.metricType != "presence" {
+						setField(metricToSend, data, scaleValue(convertValue(data.metricType, value), data.scale))
+					}
+				}
+
+				// evaluate the predicates on the element value
+				for _, ref := range sub.predicates[s] {
+					metricToSend[ref.fieldName].matched[ref.idx] = strings.TrimSpace(value) == ref.value
+				}
+
+				// the parent of presence fields ends - emit whether each element appeared
+				for _, p := range sub.presence[s] {
+					setField(metricToSend, sub.hashTable[p], seen[i][p])
+					seen[i][p] = false
+				}
+			}
+		case xml.CharData:
+			// extract value
+			value = strings.ReplaceAll(string(element), "\n", "")
+		}
+
+	}
+}
+
+// allTrue returns true if all the predicates matched
+func allTrue(matched []bool) bool {
+	for _, m := range matched {
+		if !m {
+			return false
+		}
+	}
+	return true
+}
+
+// splitOutside splits s around sep, ignoring the separators inside quotes or brackets
+func splitOutside(s string, sep rune) []string {
+	parts := make([]string, 0)
+	depth := 0
+	var quote rune
+	start := 0
+	for i, ch := range s {
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"':
+			quote = ch
+		case ch == '[':
+			depth++
+		case ch == ']':
+			depth--
+		case ch == sep && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// convertValue encodes a value according to the field type
+func convertValue(metricType string, value string) interface{} {
+	switch metricType {
+	case "int":
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			// keep string as type in case of error
+			return value
+		}
+		return v
+	case "float":
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			// keep string as type in case of error
+			return value
+		}
+		return v
+	default:
+		// Keep value as string for all other types
+		return value
+	}
+}
+
+// parseScale parses a scale factor: a multiplier (0.000001) or a divisor (/1000)
+func parseScale(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "/") {
+		divisor, err := strconv.ParseFloat(s[1:], 64)
+		if err != nil {
+			return 0, err
+		}
+		if divisor == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return 1 / divisor, nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// scaleValue applies the scale factor to a numeric value - an int stays an int once rounded
+func scaleValue(v interface{}, scale float64) interface{} {
+	if scale == 0 || scale == 1 {
+		return v
+	}
+	switch value := v.(type) {
+	case int:
+		return int(math.Round(float64(value) * scale))
+	case float64:
+		return value * scale
+	default:
+		return v
+	}
+}
+
 // Stop listener and cleanup
 func (c *NETCONF) Stop() {
 	c.cancel()
 	c.wg.Wait()
+	if c.decodeJobs != nil {
+		close(c.decodeJobs)
+		c.workers.Wait()
+	}
 }
 
 const sampleConfig = `
@@ -389,11 +1315,109 @@ const sampleConfig = `
   ## define credentials
   username = "lab"
   password = "lab123"
+  ## The credentials can also be read from an environment variable ("env:NETCONF_PASSWORD") or
+  ## from a file ("file:/run/secrets/netconf_password"). They are resolved on each connection,
+  ## so rotated credentials are used without restart.
+  ## Optional: ssh private key (PEM content or reference) - tried before the password
+  # private_key = "file:/etc/telegraf/netconf_id_rsa"
+  # private_key_passphrase = "env:NETCONF_KEY_PASSPHRASE"
 
   ## redial in case of failures after
   redial = "10s"
 
+  ## Run get-software-information once per session and add the model and version tags to all
+  ## the metrics of the device. Also run get-chassis-inventory to add the serial tag.
+  # device_facts = false
+  # device_facts_inventory = false
+
+  ## When a device answers with resource-denied or refuses the session (too many sessions),
+  ## its intervals are doubled up to this factor, then halved again once it answers (default 8)
+  # busy_backoff_max = 8
+
+  ## Poll get-commit-information at this interval and emit a netconf_commit event for each new
+  ## commit, to correlate telemetry anomalies with configuration changes (default 0 = disabled)
+  # commit_events_interval = "1m"
+
+  ## Emit the netconf_session measurement per device and session group on each connection
+  ## attempt and once per shortest sample_interval, to tell a device down from RPC errors
+  # session_metrics = false
+
+  ## Number of workers decoding the rpc-replies. By default the replies are decoded while they
+  ## are received, which delays the next RPC. With workers, the replies are read in memory (up to
+  ## max_reply_size) and decoded by the workers so the RPC scheduling stays on time.
+  # decode_workers = 0
+
+  ## Junos may emit control characters or invalid UTF-8 in the descriptions, which stops the
+  ## decoding of the reply. "strip" removes the invalid characters, "replace" replaces them with
+  ## U+FFFD and "keep" leaves the reply untouched.
+  # invalid_characters = "keep"
+
+  ## Share the NETCONF sessions with the other [[inputs.netconf_junos]] instances polling the same
+  ## devices with the same username and session group, so one session is opened per device
+  ## instead of one per instance. The RPCs of the instances are serialized on the shared session.
+  # share_sessions = false
+
+  ## Maximum number of RPCs sent on a session before reading their replies, to reduce the
+  ## collection time on high-latency links. The replies are correlated by message-id.
+  ## Only set it if the device supports it (default 1 = no pipelining)
+  # pipelining = 1
+
+  ## Only emit the fields whose value changed since the last emission. All fields are
+  ## emitted again once the heartbeat interval elapsed (default 0 = never).
+  # suppress_redundant = false
+  # heartbeat_interval = "10m"
+
+  ## Merge the fields of measurements collected by different RPCs into one measurement
+  ## per join key and cycle. The joined metrics only keep the join tags. The RPCs must
+  ## belong to the same session group.
+  # [[inputs.netconf_junos.join]]
+  #   name = "interface"
+  #   measurements = ["ifcounters", "ifqueues"]
+  #   tags = ["device", "name"]
+
+  ## rpc-errors are classified as: warning, not_supported, resource_busy or error.
+  ## Warnings are logged and the reply is still decoded. For the other classes, the RPC
+  ## can be retried "retries" times waiting "backoff" (doubled on each retry) and then be
+  ## put on hold for the "hold" duration. By default RPCs are not retried.
+  # [[inputs.netconf_junos.retry_policy]]
+  #   class = "resource_busy"
+  #   retries = 2
+  #   backoff = "5s"
+  # [[inputs.netconf_junos.retry_policy]]
+  #   class = "not_supported"
+  #   hold = "1h"
+
+  ## Optional file with additional [[subscription]] tables (same format as below).
+  ## The file is checked periodically and the changes (new RPCs, fields, intervals) are
+  ## applied to the running devices without dropping their sessions
+  # subscriptions_file = "/etc/telegraf/netconf_subscriptions.toml"
+  # subscriptions_reload_interval = "1m"
+
+  ## maximum time to receive a complete rpc-reply - the session is closed and redialed on timeout
+  # rpc_timeout = "60s"
+  ## rpc-replies are decoded while they are received. Replies larger than this size are
+  ## dropped to keep memory bounded (default 0 = unlimited)
+  # max_reply_size = "512MB"
+
+  ## Align the RPCs on the wall clock: a RPC with a 30s interval is issued at :00 and :30
+  ## and the metrics are timestamped with the scheduled time
+  # round_interval = false
+  ## When aligned, each device is shifted by a stable offset within [0, collection_offset)
+  ## derived from its address, so devices don't fire their RPCs simultaneously
+  # collection_offset = "0s"
+
+  ## Debug: dump each raw rpc-reply (with device name and timestamp) into this directory
+  # capture_dir = "/tmp/netconf_capture"
+  ## Debug: do not connect to the devices but decode the files captured in this directory
+  ## through the subscriptions (matched by subscription name) - useful to debug parsing issues
+  # replay_dir = "/tmp/netconf_capture"
+
   [[inputs.netconf_junos.subscription]]
+    ## Optional: disable the subscription without deleting it, or delay its first RPC after
+    ## the start of the plugin (e.g. to stage heavy RPCs after boot-time convergence)
+    # enabled = true
+    # start_delay = "90s"
+
     ## Name of the measurement that will be emitted
     name = "ifcounters"
 
@@ -401,26 +1425,125 @@ const sampleConfig = `
     junos_rpc = "<get-interface-information><statistics/></get-interface-information>"
   
     ## A list of xpath lite + type to collect / encode 
-    ## Each entry in the list is made of: <xpath>:<type>
+    ## Each entry in the list is made of: <xpath>:<type> or <xpath>:<type>:<scale>
     ## - xpath lite 
-    ## - a type of encoding (supported types : int, float, string)
+    ## - a type of encoding (supported types : int, float, string, presence)
+    ##   presence is for empty elements like <iff-up/>: true when the element appears under its parent, false otherwise
+    ## - an optional scale factor applied to int and float values: a multiplier (:float:0.000001)
+    ##   or a divisor (:float:/1000) to convert the values to canonical units
     ## 
     ## The xpath lite should follow the rpc reply XML document. Optional: you can include btw [] the KEY's name that must use to detect the loop 
+    ## When a list has several keys, they can be given in the same bracket separated by a comma: [name,unit]
+    ## A predicate restricts the list entries producing metrics: [name='xe-0/0/0'] or [name,admin-status='up']
+    ## The tested element is not a tag and must come before the fields in the rpc-reply
     fields = ["/interface-information/physical-interface[ifname]/speed:string", 
             "/interface-information/physical-interface[ifname]/traffic-statistics/input-packets:int",
             "/interface-information/physical-interface[ifname]/traffic-statistics/output-packets:int",
             ]
     ## Interval to request the RPC
+    ## Subscriptions with the same junos_rpc, sample_interval and session_group share the RPC:
+    ## it is executed once and its reply is decoded into the measurements of all of them
     sample_interval = "30s"
 
+    ## Optional: subscriptions of the same session group share one NETCONF session per device.
+    ## Use several groups (e.g. "fast" and "slow") so long-running RPCs don't delay short ones
+    # session_group = "default"
+
+    ## Optional: policy for the list entries where only some of the fields are present: emit the
+    ## rows with the fields seen ("partial", default), only the complete rows ("complete") or fill
+    ## the missing fields ("fill") with fill_values or the zero value of their type
+    # row_policy = "partial"
+    # fill_values = {speed = "unknown"}
+
+    ## Optional: fields computed from the numeric fields of each list entry with + - * / and
+    ## parentheses. The operators must be separated by spaces as the field names may contain "-".
+    ## A derived field is not emitted for an entry missing one of its fields or dividing by zero.
+    # derived_fields = ["utilization = input-bytes * 8 / speed"]
+
+    ## Optional: maximum size of the rpc-reply for this subscription (overrides max_reply_size).
+    ## When exceeded, the reply is dropped and the subscription is disabled for the cool-down period
+    # max_reply_size = "64MB"
+    # max_reply_size_cooldown = "1h"
+
+    ## Optional: execute the RPC for each logical system ("logical_system") or routing instance
+    ## ("routing_instance") discovered on the device, tagged with its name. The RPC may contain
+    ## the {{logical_system}} or {{routing_instance}} placeholder, otherwise the name is given as
+    ## the <logical-system> or <instance> parameter - in this case, the RPC is also executed
+    ## without logical system (tag "default"). The values are discovered once per session.
+    ## The RPC can also be executed for each online FPC ("fpc") or present virtual-chassis member
+    ## ("member") with the {{fpc}} or {{member}} placeholder, e.g. PFE statistics per FPC:
+    ## junos_rpc = "<get-pfe-statistics><fpc>{{fpc}}</fpc></get-pfe-statistics>"
+    # iterate = "logical_system"
+
   ## Another example with 2 levels of key
   [[inputs.netconf_junos.subscription]]
     name = "COS"
     junos_rpc = "<get-interface-queue-information></get-interface-queue-information>"
     fields = ["/interface-information/physical-interface[name]/queue-counters/queue[queue-number]/queue-counters-queued-packets:int",]
+
+    ## Optional: route some fields to another measurement instead of the subscription's name.
+    ## The key is an xpath prefix (as written in fields) - the longest matching prefix wins
+    # measurement_override = {"/interface-information/physical-interface[name]/queue-counters" = "COS_QUEUES"}
 	sample_interval = "60s"
+
+  ## Example of a list with 2 keys - a composite tag "name_local-index" (e.g. "ge-0/0/0.0.331") is also added
+  [[inputs.netconf_junos.subscription]]
+    name = "IFL"
+    junos_rpc = "<get-interface-information><statistics/></get-interface-information>"
+    fields = ["/interface-information/physical-interface/logical-interface[name,local-index]/traffic-statistics/input-packets:int",]
+    sample_interval = "60s"
+
+    ## Concatenate the values of multi-key lists into an extra tag named <key1>_<key2>
+    composite_tag = true
+    ## Separator used to join the values of the composite tag
+    composite_separator = "."
+
+  ## Non-Juniper NETCONF servers: a standard <get> with a "subtree" or "xpath" filter is built
+  ## from the fields instead of a Junos RPC. The keys and predicates are part of the filter and
+  ## the xpath of the fields starts below the <data> element of the reply
+  [[inputs.netconf_junos.subscription]]
+    name = "oc_interfaces"
+    get_filter = "subtree"
+    ## namespace of the top-level elements of the filter
+    get_namespaces = {interfaces = "http://openconfig.net/yang/interfaces"}
+    fields = ["/interfaces/interface[name]/state/counters/in-octets:int",
+              "/interfaces/interface[name]/state/counters/out-octets:int",
+             ]
+    sample_interval = "60s"
+
+  ## CLI command without structured XML output - the text is parsed line by line with regexes.
+  ## The named groups of the patterns are mapped to the fields given as <group name>:<type>[:<scale>]
+  ## (supported types : int, float, string and tag to emit the group as a tag)
+  [[inputs.netconf_junos.subscription]]
+    name = "MBUFS"
+    junos_command = "show system buffers"
+    text_patterns = ['^(?P<current>\d+)/(?P<cache>\d+)/(?P<total>\d+) mbufs in use']
+    fields = ["current:int", "cache:int", "total:int"]
+    sample_interval = "60s"
 `
 
+// deviceOffset returns a stable offset within [0, collection_offset) derived from the device address
+func (c *NETCONF) deviceOffset(address string) time.Duration {
+	if c.CollectionOffset <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write([]byte(address))
+	return time.Duration(h.Sum64() % uint64(c.CollectionOffset))
+}
+
+// alignedNext returns the first interval boundary (shifted by offset) after now
+func alignedNext(now time.Time, interval time.Duration, offset time.Duration) time.Time {
+	if interval <= 0 {
+		return now
+	}
+	next := now.Truncate(interval).Add(offset % interval)
+	for !next.After(now) {
+		next = next.Add(interval)
+	}
+	return next
+}
+
 // simple unint64 min func
 func minUint64(a, b uint64) uint64 {
 	if a < b {
@@ -445,7 +1568,8 @@ func (c *NETCONF) Gather(_ telegraf.Accumulator) error {
 }
 func New() telegraf.Input {
 	return &NETCONF{
-		Redial: config.Duration(10 * time.Second),
+		Redial:     config.Duration(10 * time.Second),
+		RPCTimeout: config.Duration(60 * time.Second),
 	}
 }
 func init() {
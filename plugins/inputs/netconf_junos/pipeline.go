@@ -0,0 +1,58 @@
+package netconf_junos
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// executePipelined sends all the RPCs of a batch before reading their replies, which are
+// correlated with the RPCs by their message-id. The RPCs failing with a rpc-error are then
+// retried one by one according to the retry policy. It returns the hold duration per subscription.
+func (c *NETCONF) executePipelined(ctx context.Context, session *netconfSession, address string, batch []*pendingRPC, dev *deviceState) (map[string]time.Duration, error) {
+	failed, err := c.exchangePipelined(session, address, batch, dev)
+	if err != nil {
+		return nil, err
+	}
+
+	holds := make(map[string]time.Duration)
+	for p, err := range failed {
+		hold, err := c.retry(ctx, session, address, p, dev, err)
+		if err != nil {
+			return nil, err
+		}
+		if hold > 0 {
+			holds[p.req.measurement] = hold
+		}
+	}
+	return holds, nil
+}
+
+// exchangePipelined sends the RPCs of a batch and reads their replies. It returns the RPCs to retry.
+func (c *NETCONF) exchangePipelined(session *netconfSession, address string, batch []*pendingRPC, dev *deviceState) (map[*pendingRPC]error, error) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	pending := make(map[uint64]*pendingRPC)
+	for _, p := range batch {
+		id, err := c.sendRPC(session, address, p)
+		if err != nil {
+			return nil, err
+		}
+		pending[id] = p
+	}
+
+	failed := make(map[*pendingRPC]error)
+	for len(pending) > 0 {
+		p, err := c.readReply(session, address, pending, dev)
+		var rpcErr *rpcError
+		if errors.As(err, &rpcErr) || errors.Is(err, errReplyTooLarge) {
+			failed[p] = err
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return failed, nil
+}
@@ -0,0 +1,77 @@
+package netconf_junos
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/influxdata/toml"
+)
+
+// subscriptionsFile is the content of the subscriptions file
+type subscriptionsFile struct {
+	Subscriptions []Subscription `toml:"subscription"`
+}
+
+// loadRequests builds the requests from the configured subscriptions and the subscriptions file
+func (c *NETCONF) loadRequests() ([]req, error) {
+	subscriptions := make([]Subscription, 0, len(c.Subscriptions))
+	subscriptions = append(subscriptions, c.Subscriptions...)
+
+	if c.SubscriptionsFile != "" {
+		info, err := os.Stat(c.SubscriptionsFile)
+		if err != nil {
+			return nil, err
+		}
+		content, err := os.ReadFile(c.SubscriptionsFile)
+		if err != nil {
+			return nil, err
+		}
+		var file subscriptionsFile
+		if err := toml.Unmarshal(content, &file); err != nil {
+			return nil, fmt.Errorf("unable to parse subscriptions file %s: %v", c.SubscriptionsFile, err)
+		}
+		subscriptions = append(subscriptions, file.Subscriptions...)
+		c.fileModTime = info.ModTime()
+	}
+	return c.buildRequests(subscriptions)
+}
+
+// watchSubscriptions reloads the subscriptions file when it changes
+func (c *NETCONF) watchSubscriptions(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(c.ReloadInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(c.SubscriptionsFile)
+		if err != nil {
+			c.Log.Errorf("Unable to check subscriptions file %s: %v", c.SubscriptionsFile, err)
+			continue
+		}
+		if info.ModTime().Equal(c.fileModTime) {
+			continue
+		}
+
+		// keep the running subscriptions if the new file is invalid
+		requests, err := c.loadRequests()
+		if err != nil {
+			c.Log.Errorf("Subscriptions file %s not reloaded: %v", c.SubscriptionsFile, err)
+			c.fileModTime = info.ModTime()
+			continue
+		}
+		c.mu.Lock()
+		c.requests = requests
+		c.generation++
+		c.mu.Unlock()
+		c.Log.Infof("Subscriptions file %s reloaded", c.SubscriptionsFile)
+
+		// session groups added by the file need their own sessions
+		c.startGroups(ctx)
+	}
+}
@@ -0,0 +1,88 @@
+package netconf_junos
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"time"
+
+	"github.com/influxdata/telegraf/config"
+	"github.com/influxdata/telegraf/selfstat"
+)
+
+// RetryPolicy applied to a class of rpc-error
+type RetryPolicy struct {
+	Class   string          `toml:"class"`
+	Retries int             `toml:"retries"`
+	Backoff config.Duration `toml:"backoff"`
+	Hold    config.Duration `toml:"hold"`
+}
+
+// retryPolicy returns the policy of a class - no retry by default
+func (c *NETCONF) retryPolicy(class string) RetryPolicy {
+	for _, p := range c.RetryPolicies {
+		if p.Class == class {
+			return p
+		}
+	}
+	return RetryPolicy{Class: class}
+}
+
+// executeWithRetry executes a RPC and retries it according to the policy of its rpc-error class.
+// It returns how long the RPC must be put on hold once the retries are exhausted.
+func (c *NETCONF) executeWithRetry(ctx context.Context, session *netconfSession, address string, p *pendingRPC, dev *deviceState) (time.Duration, error) {
+	return c.retry(ctx, session, address, p, dev, c.executeRPC(session, address, p, dev))
+}
+
+// retry applies the retry policy to the error of the first execution of a RPC
+func (c *NETCONF) retry(ctx context.Context, session *netconfSession, address string, p *pendingRPC, dev *deviceState, err error) (time.Duration, error) {
+	for attempt := 0; ; attempt++ {
+		if errors.Is(err, errReplyTooLarge) {
+			// not retried - the subscription is disabled for the cool-down period
+			if p.req.cooldown > 0 {
+				c.Log.Warnf("rpc %s for device %s disabled for %s", p.req.rpc, address, p.req.cooldown)
+			}
+			return p.req.cooldown, nil
+		}
+		var rpcErr *rpcError
+		if !errors.As(err, &rpcErr) {
+			return 0, err
+		}
+
+		policy := c.retryPolicy(rpcErr.class())
+		if attempt >= policy.Retries {
+			if policy.Hold > 0 {
+				c.Log.Debugf("rpc %s for device %s put on hold for %s after %s", p.req.rpc, address, time.Duration(policy.Hold), rpcErr.class())
+			}
+			return time.Duration(policy.Hold), nil
+		}
+		backoff := time.Duration(policy.Backoff) << uint(attempt)
+		c.Log.Debugf("retry rpc %s for device %s in %s after %s", p.req.rpc, address, backoff, rpcErr.class())
+		select {
+		case <-ctx.Done():
+			return 0, nil
+		case <-time.After(backoff):
+		}
+		err = c.executeRPC(session, address, p, dev)
+	}
+}
+
+// decodeRPCError decodes an <rpc-error> element and counts it per class.
+// Warnings are only logged and nil is returned so the decoding goes on.
+func (c *NETCONF) decodeRPCError(decoder *xml.Decoder, element *xml.StartElement, address string, r req) error {
+	rpcErr := &rpcError{}
+	if err := decoder.DecodeElement(rpcErr, element); err != nil {
+		return err
+	}
+	tags := map[string]string{
+		"device":       address,
+		"subscription": r.measurement,
+	}
+	selfstat.Register("netconf_junos", "rpc_errors_"+rpcErr.class(), tags).Incr(1)
+
+	if rpcErr.class() == "warning" {
+		c.Log.Debugf("rpc-reply for rpc %s and device %s contains a warning: %v", r.rpc, address, rpcErr)
+		return nil
+	}
+	return rpcErr
+}
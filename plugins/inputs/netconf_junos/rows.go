@@ -0,0 +1,76 @@
+package netconf_junos
+
+import (
+	"fmt"
+
+	"github.com/influxdata/telegraf"
+)
+
+// rowField is a field expected in the rows of a measurement
+type rowField struct {
+	name       string
+	metricType string
+	scale      float64
+}
+
+// rowDefault returns the value of a missing field: the configured fill value or the zero value of its type
+func (r req) rowDefault(f rowField) interface{} {
+	if v, ok := r.fillValues[f.name]; ok {
+		return scaleValue(convertValue(f.metricType, v), f.scale)
+	}
+	switch f.metricType {
+	case "int":
+		return 0
+	case "float":
+		return 0.0
+	case "presence":
+		return false
+	default:
+		return ""
+	}
+}
+
+// validateRowPolicy checks the row policy of a subscription
+func validateRowPolicy(s Subscription) error {
+	switch s.RowPolicy {
+	case "", "partial", "complete", "fill":
+		return nil
+	default:
+		return fmt.Errorf("subscription %s: unknown row_policy %q", s.Name, s.RowPolicy)
+	}
+}
+
+// applyRowPolicy handles the rows of a list entry missing some of the configured fields: they are
+// emitted as is (partial), dropped (complete) or completed with default values (fill)
+func applyRowPolicy(r req, metrics []telegraf.Metric) []telegraf.Metric {
+	out := make([]telegraf.Metric, 0, len(metrics))
+	for _, m := range metrics {
+		var sub *req
+		for _, s := range r.all() {
+			if _, ok := s.rowFields[m.Name()]; ok {
+				s := s
+				sub = &s
+				break
+			}
+		}
+		if sub == nil || sub.rowPolicy == "" || sub.rowPolicy == "partial" {
+			out = append(out, m)
+			continue
+		}
+
+		complete := true
+		for _, f := range sub.rowFields[m.Name()] {
+			if _, ok := m.GetField(f.name); ok {
+				continue
+			}
+			complete = false
+			if sub.rowPolicy == "fill" {
+				m.AddField(f.name, sub.rowDefault(f))
+			}
+		}
+		if complete || sub.rowPolicy == "fill" {
+			out = append(out, m)
+		}
+	}
+	return out
+}
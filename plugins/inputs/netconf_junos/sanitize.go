@@ -0,0 +1,78 @@
+package netconf_junos
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	"github.com/influxdata/telegraf/selfstat"
+)
+
+// sanitizingReader removes or replaces the bytes which are not valid XML characters (control
+// characters, invalid UTF-8) so a bad interface description doesn't stop the decoding of a reply
+type sanitizingReader struct {
+	r       *bufio.Reader
+	replace bool
+	invalid selfstat.Stat
+
+	// encoded rune not fitting in the last read buffer
+	pending []byte
+}
+
+// sanitize wraps the reader according to the invalid_characters option
+func (c *NETCONF) sanitize(r io.Reader, invalid selfstat.Stat) io.Reader {
+	if c.InvalidCharacters == "" || c.InvalidCharacters == "keep" {
+		return r
+	}
+	return &sanitizingReader{r: bufio.NewReaderSize(r, readBufferSize), replace: c.InvalidCharacters == "replace", invalid: invalid}
+}
+
+func validInvalidCharacters(mode string) error {
+	switch mode {
+	case "", "keep", "strip", "replace":
+		return nil
+	}
+	return fmt.Errorf("invalid_characters must be keep, strip or replace: %q", mode)
+}
+
+// validXMLChar reports whether the rune is a valid XML 1.0 character
+func validXMLChar(r rune) bool {
+	return r == 0x09 || r == 0x0A || r == 0x0D ||
+		(r >= 0x20 && r <= 0xD7FF) ||
+		(r >= 0xE000 && r <= 0xFFFD) ||
+		(r >= 0x10000 && r <= utf8.MaxRune)
+}
+
+func (s *sanitizingReader) Read(p []byte) (int, error) {
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+
+	var buf [utf8.UTFMax]byte
+	// don't block once something was read
+	for n < len(p) && (n == 0 || s.r.Buffered() > 0) {
+		r, size, err := s.r.ReadRune()
+		if err != nil {
+			if n > 0 && err == io.EOF {
+				return n, nil
+			}
+			return n, err
+		}
+		if (r == utf8.RuneError && size == 1) || !validXMLChar(r) {
+			if s.invalid != nil {
+				s.invalid.Incr(1)
+			}
+			if !s.replace {
+				continue
+			}
+			r = utf8.RuneError
+		}
+		encoded := buf[:utf8.EncodeRune(buf[:], r)]
+		copied := copy(p[n:], encoded)
+		n += copied
+		if copied < len(encoded) {
+			s.pending = append(s.pending, encoded[copied:]...)
+		}
+	}
+	return n, nil
+}
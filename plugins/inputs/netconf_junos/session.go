@@ -0,0 +1,299 @@
+package netconf_junos
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// NETCONF 1.0 end-of-message delimiter
+	netconfDelimiter = "]]>]]>"
+	netconfBase10    = "urn:ietf:params:netconf:base:1.0"
+	netconfBase11    = "urn:ietf:params:netconf:base:1.1"
+
+	// size of the read buffer of the ssh channel
+	readBufferSize = 64 * 1024
+
+	// size of the beginning of a rpc-reply searched for the message-id
+	replyHeadSize = 4096
+)
+
+var messageIDRe = regexp.MustCompile(`<rpc-reply[^>]*\smessage-id=["'](\d+)["']`)
+
+var errReplyTooLarge = errors.New("rpc-reply exceeds the maximum reply size")
+
+// netconfSession is a minimal NETCONF over SSH client streaming the rpc-replies,
+// so huge replies never need to be buffered in memory
+type netconfSession struct {
+	client       *ssh.Client
+	session      *ssh.Session
+	stdin        io.WriteCloser
+	stdout       *bufio.Reader
+	capabilities []string
+	messageID    uint64
+
+	// NETCONF 1.1 chunked framing negotiated
+	chunked bool
+
+	// serializes the RPC exchanges of the plugin instances sharing the session
+	mu sync.Mutex
+}
+
+type helloMessage struct {
+	XMLName      xml.Name `xml:"hello"`
+	Capabilities []string `xml:"capabilities>capability"`
+	SessionID    string   `xml:"session-id"`
+}
+
+// rpcError is an <rpc-error> returned by the device
+type rpcError struct {
+	Type     string `xml:"error-type"`
+	Tag      string `xml:"error-tag"`
+	Severity string `xml:"error-severity"`
+	Path     string `xml:"error-path"`
+	Message  string `xml:"error-message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("rpc-error [%s/%s] %s", strings.TrimSpace(e.Severity), strings.TrimSpace(e.Tag), strings.TrimSpace(e.Message))
+}
+
+// class returns the class of the error: warning, not_supported, resource_busy or error
+func (e *rpcError) class() string {
+	tag := strings.TrimSpace(e.Tag)
+	message := strings.ToLower(e.Message)
+	switch {
+	case strings.TrimSpace(e.Severity) == "warning":
+		return "warning"
+	case tag == "operation-not-supported" || tag == "unknown-element" || strings.Contains(message, "syntax error") || strings.Contains(message, "not supported"):
+		return "not_supported"
+	case tag == "resource-denied" || tag == "in-use" || tag == "lock-denied" || strings.Contains(message, "busy"):
+		return "resource_busy"
+	default:
+		return "error"
+	}
+}
+
+// dialNETCONF opens a NETCONF over SSH session and exchanges the hello messages
+func dialNETCONF(address string, config *ssh.ClientConfig) (*netconfSession, error) {
+	client, err := ssh.Dial("tcp", address, config)
+	if err != nil {
+		return nil, err
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	s := &netconfSession{client: client, session: session}
+
+	if s.stdin, err = session.StdinPipe(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.stdout = bufio.NewReaderSize(stdout, readBufferSize)
+	if err := session.RequestSubsystem("netconf"); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	if err := s.hello(); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("error during hello exchange: %v", err)
+	}
+	return s, nil
+}
+
+// hello reads the server capabilities and advertises ours
+func (s *netconfSession) hello() error {
+	var server helloMessage
+	msg := s.message()
+	if err := xml.NewDecoder(msg).Decode(&server); err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.Discard, msg); err != nil {
+		return err
+	}
+	s.capabilities = server.Capabilities
+
+	// advertise base:1.1 only when the server supports it - the hello itself is always end-of-message framed
+	capabilities := "<capability>" + netconfBase10 + "</capability>"
+	base11 := false
+	for _, c := range s.capabilities {
+		if strings.TrimSpace(c) == netconfBase11 {
+			base11 = true
+			capabilities += "<capability>" + netconfBase11 + "</capability>"
+		}
+	}
+	if err := s.send("<hello xmlns=\"urn:ietf:params:xml:ns:netconf:base:1.0\"><capabilities>" + capabilities + "</capabilities></hello>"); err != nil {
+		return err
+	}
+	s.chunked = base11
+	return nil
+}
+
+// send writes a complete NETCONF message
+func (s *netconfSession) send(data string) error {
+	data = xml.Header + data
+	if s.chunked {
+		_, err := fmt.Fprintf(s.stdin, "\n#%d\n%s\n##\n", len(data), data)
+		return err
+	}
+	_, err := io.WriteString(s.stdin, data+netconfDelimiter)
+	return err
+}
+
+// sendRPC sends a RPC without waiting for its reply and returns its message-id
+func (s *netconfSession) sendRPC(rpc string) (uint64, error) {
+	s.messageID++
+	err := s.send(fmt.Sprintf("<rpc xmlns=\"urn:ietf:params:xml:ns:netconf:base:1.0\" message-id=\"%d\">%s</rpc>", s.messageID, rpc))
+	return s.messageID, err
+}
+
+// reply waits for the beginning of the next rpc-reply. The returned reader streams the
+// rpc-reply and must be read until EOF before reading another one. The message-id is 0
+// when the device doesn't echo it.
+func (s *netconfSession) reply() (io.Reader, uint64, error) {
+	if _, err := s.stdout.Peek(1); err != nil {
+		return nil, 0, err
+	}
+	msg := bufio.NewReaderSize(s.message(), replyHeadSize)
+	head, _ := msg.Peek(replyHeadSize)
+	var id uint64
+	if m := messageIDRe.FindSubmatch(head); m != nil {
+		id, _ = strconv.ParseUint(string(m[1]), 10, 64)
+	}
+	return msg, id, nil
+}
+
+// message returns a reader over the next NETCONF message
+func (s *netconfSession) message() io.Reader {
+	if s.chunked {
+		return &chunkReader{r: s.stdout}
+	}
+	return &eomReader{r: s.stdout}
+}
+
+// Close the NETCONF session and the underlying ssh connection
+func (s *netconfSession) Close() error {
+	if s.session != nil {
+		s.session.Close()
+	}
+	return s.client.Close()
+}
+
+// eomReader streams a NETCONF 1.0 message until the ]]>]]> end-of-message delimiter
+type eomReader struct {
+	r    *bufio.Reader
+	done bool
+}
+
+func (e *eomReader) Read(p []byte) (int, error) {
+	if e.done {
+		return 0, io.EOF
+	}
+	// make sure enough data is buffered to detect the delimiter
+	if _, err := e.r.Peek(len(netconfDelimiter)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	buf, _ := e.r.Peek(e.r.Buffered())
+
+	if i := bytes.Index(buf, []byte(netconfDelimiter)); i >= 0 {
+		n := copy(p, buf[:i])
+		e.r.Discard(n)
+		if n == i {
+			e.r.Discard(len(netconfDelimiter))
+			e.done = true
+			if n == 0 {
+				return 0, io.EOF
+			}
+		}
+		return n, nil
+	}
+
+	// keep the tail which could be the beginning of the delimiter
+	n := copy(p, buf[:len(buf)-len(netconfDelimiter)+1])
+	e.r.Discard(n)
+	return n, nil
+}
+
+// chunkReader streams a NETCONF 1.1 chunked framed message until the end-of-chunks marker
+type chunkReader struct {
+	r         *bufio.Reader
+	remaining int64
+	done      bool
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if c.done {
+		return 0, io.EOF
+	}
+	for c.remaining == 0 {
+		// chunk header: \n#<size>\n or end-of-chunks: \n##\n
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(c.r, header); err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if string(header) != "\n#" {
+			return 0, fmt.Errorf("invalid chunk header %q", header)
+		}
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "#" {
+			c.done = true
+			return 0, io.EOF
+		}
+		size, err := strconv.ParseUint(line, 10, 32)
+		if err != nil || size == 0 {
+			return 0, fmt.Errorf("invalid chunk size %q", line)
+		}
+		c.remaining = int64(size)
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// countingReader counts the bytes read and enforces an optional maximum size
+type countingReader struct {
+	r     io.Reader
+	count int64
+	max   int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.count += int64(n)
+	if c.max > 0 && c.count > c.max {
+		return n, errReplyTooLarge
+	}
+	return n, err
+}
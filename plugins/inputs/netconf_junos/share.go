@@ -0,0 +1,73 @@
+package netconf_junos
+
+import (
+	"sync"
+)
+
+// sessionManager shares the NETCONF sessions between the plugin instances polling the same
+// devices. A shared session is closed when its last user releases it.
+type sessionManager struct {
+	mu       sync.Mutex
+	sessions map[string]*sharedSession
+}
+
+type sharedSession struct {
+	session *netconfSession
+	err     error
+	refs    int
+
+	// closed once the dial is done
+	ready chan struct{}
+}
+
+var sharedSessions = &sessionManager{sessions: make(map[string]*sharedSession)}
+
+// acquire returns the session of the key, dialing it when no plugin instance holds it yet
+func (m *sessionManager) acquire(key string, dial func() (*netconfSession, error)) (*netconfSession, error) {
+	m.mu.Lock()
+	s, ok := m.sessions[key]
+	if ok {
+		s.refs++
+		m.mu.Unlock()
+		<-s.ready
+		if s.err != nil {
+			return nil, s.err
+		}
+		return s.session, nil
+	}
+	s = &sharedSession{refs: 1, ready: make(chan struct{})}
+	m.sessions[key] = s
+	m.mu.Unlock()
+
+	s.session, s.err = dial()
+	close(s.ready)
+	if s.err != nil {
+		// the users waiting for this dial get the error too
+		m.mu.Lock()
+		delete(m.sessions, key)
+		m.mu.Unlock()
+		return nil, s.err
+	}
+	return s.session, nil
+}
+
+// release gives back a session. A broken session is closed immediately and removed, so the next
+// acquire dials a new one - the other users get an error and redial too.
+func (m *sessionManager) release(key string, session *netconfSession, broken bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[key]
+	if !ok || s.session != session {
+		// already removed as broken by another user
+		session.Close()
+		return
+	}
+	s.refs--
+	if s.refs == 0 || broken {
+		delete(m.sessions, key)
+		if s.session != nil {
+			s.session.Close()
+		}
+	}
+}
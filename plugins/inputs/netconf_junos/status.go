@@ -0,0 +1,89 @@
+package netconf_junos
+
+import (
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf/selfstat"
+)
+
+// sessionStatus is the reachability of a device for a session group
+type sessionStatus struct {
+	address        string
+	group          string
+	up             int
+	connectLatency time.Duration
+	reconnects     int64
+	last           time.Time
+
+	// time of the last commit and of the last poll of the commits
+	lastCommit     time.Time
+	lastCommitPoll time.Time
+
+	// the intervals are multiplied by the backoff factor while the device is busy
+	backoff     int64
+	backoffStat selfstat.Stat
+}
+
+func newSessionStatus(address string, group string) *sessionStatus {
+	tags := map[string]string{
+		"device":        address,
+		"session_group": group,
+	}
+	s := &sessionStatus{address: address, group: group, backoff: 1, backoffStat: selfstat.Register("netconf_junos", "backoff_factor", tags)}
+	s.backoffStat.Set(1)
+	return s
+}
+
+// updateBackoff doubles the backoff factor (up to max) when the device was busy,
+// and halves it when the device answered again. It returns true if the factor changed.
+func (s *sessionStatus) updateBackoff(busy bool, succeeded bool, max int64) bool {
+	previous := s.backoff
+	switch {
+	case busy && s.backoff < max:
+		s.backoff *= 2
+		if s.backoff > max {
+			s.backoff = max
+		}
+	case !busy && succeeded && s.backoff > 1:
+		s.backoff /= 2
+	}
+	s.backoffStat.Set(s.backoff)
+	return s.backoff != previous
+}
+
+// isBusy returns true if the error means the device refuses the session or the RPC for lack of resources
+func isBusy(err error) bool {
+	message := strings.ToLower(err.Error())
+	return strings.Contains(message, "too many") || strings.Contains(message, "resource-denied")
+}
+
+// emitSession adds the netconf_session metric of a device and session group
+func (c *NETCONF) emitSession(s *sessionStatus) {
+	s.last = time.Now()
+	if !c.SessionMetrics {
+		return
+	}
+	tags := map[string]string{
+		"device":        s.address,
+		"session_group": s.group,
+	}
+	fields := map[string]interface{}{
+		"up":                 s.up,
+		"connect_latency_ns": s.connectLatency.Nanoseconds(),
+		"reconnect_count":    s.reconnects,
+		"backoff_factor":     s.backoff,
+	}
+	c.acc.AddFields("netconf_session", fields, tags, s.last)
+}
+
+// shortestInterval returns the shortest sample interval of the requests
+func shortestInterval(r []req) time.Duration {
+	var shortest time.Duration
+	for _, v := range r {
+		if shortest == 0 || time.Duration(v.interval) < shortest {
+			shortest = time.Duration(v.interval)
+		}
+	}
+	return shortest
+}
@@ -0,0 +1,49 @@
+package netconf_junos
+
+import (
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+// cachedValue is the last emitted value of a field
+type cachedValue struct {
+	value interface{}
+	sent  time.Time
+}
+
+// redundancyCache keeps the last value of each field per series to drop the unchanged ones
+type redundancyCache struct {
+	heartbeat time.Duration
+	series    map[uint64]map[string]cachedValue
+}
+
+func newRedundancyCache(heartbeat time.Duration) *redundancyCache {
+	return &redundancyCache{heartbeat: heartbeat, series: make(map[uint64]map[string]cachedValue)}
+}
+
+// filter removes the fields whose value didn't change since the last emission, unless the
+// heartbeat interval elapsed. It returns false when no field is left.
+func (r *redundancyCache) filter(m telegraf.Metric) bool {
+	id := m.HashID()
+	fields, ok := r.series[id]
+	if !ok {
+		fields = make(map[string]cachedValue)
+		r.series[id] = fields
+	}
+
+	now := m.Time()
+	redundant := make([]string, 0)
+	for _, f := range m.FieldList() {
+		last, ok := fields[f.Key]
+		if ok && last.value == f.Value && (r.heartbeat <= 0 || now.Sub(last.sent) < r.heartbeat) {
+			redundant = append(redundant, f.Key)
+			continue
+		}
+		fields[f.Key] = cachedValue{value: f.Value, sent: now}
+	}
+	for _, k := range redundant {
+		m.RemoveField(k)
+	}
+	return len(m.FieldList()) > 0
+}
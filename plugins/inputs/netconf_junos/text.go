@@ -0,0 +1,113 @@
+package netconf_junos
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf/metric"
+)
+
+// parseCommand builds the text RPC of a CLI command and compiles its patterns
+func (r *req) parseCommand(s Subscription) error {
+	var command strings.Builder
+	if err := xml.EscapeText(&command, []byte(s.Command)); err != nil {
+		return err
+	}
+	r.rpc = fmt.Sprintf("<command format=\"text\">%s</command>", command.String())
+
+	if len(s.TextPatterns) == 0 {
+		return fmt.Errorf("subscription %s: junos_command requires at least one text pattern", s.Name)
+	}
+	r.textPatterns = make([]*regexp.Regexp, 0, len(s.TextPatterns))
+	for _, pattern := range s.TextPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("subscription %s: invalid text pattern %q: %v", s.Name, pattern, err)
+		}
+		r.textPatterns = append(r.textPatterns, re)
+	}
+
+	// fields are given as <group name>:<type>[:<scale>] - type "tag" emits the group as a tag
+	r.textTypes = make(map[string]string)
+	r.textScales = make(map[string]float64)
+	for _, f := range s.Fields {
+		split_field := strings.Split(f, ":")
+		if len(split_field) != 2 && len(split_field) != 3 {
+			return fmt.Errorf("subscription %s: malformed text field %q", s.Name, f)
+		}
+		r.textTypes[split_field[0]] = split_field[1]
+		if len(split_field) == 3 {
+			scale, err := parseScale(split_field[2])
+			if err != nil {
+				return fmt.Errorf("subscription %s: malformed scale factor of text field %q: %v", s.Name, f, err)
+			}
+			r.textScales[split_field[0]] = scale
+		}
+	}
+	return nil
+}
+
+// decodeText extracts the text output of a CLI command and applies the patterns line by line.
+// Each matching line produces one row made of the named groups of the pattern.
+func (c *NETCONF) decodeText(req req, data io.Reader, address string, timestamp time.Time, grouper *metric.SeriesGrouper) error {
+	// the text is carried by the <output> element
+	var output strings.Builder
+	decoder := xml.NewDecoder(data)
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		switch element := token.(type) {
+		case xml.StartElement:
+			if element.Name.Local == "rpc-error" {
+				if err := c.decodeRPCError(decoder, &element, address, req); err != nil {
+					return err
+				}
+			}
+		case xml.CharData:
+			output.Write(element)
+		}
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(output.String()))
+	for scanner.Scan() {
+		line := scanner.Text()
+		for _, re := range req.textPatterns {
+			match := re.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+			tags := map[string]string{
+				"device": address,
+			}
+			fields := make(map[string]interface{})
+			for i, name := range re.SubexpNames() {
+				if name == "" || i >= len(match) {
+					continue
+				}
+				metricType := req.textTypes[name]
+				if metricType == "tag" {
+					tags[name] = match[i]
+				} else {
+					fields[name] = scaleValue(convertValue(metricType, match[i]), req.textScales[name])
+				}
+			}
+			for k, v := range fields {
+				if err := grouper.Add(req.measurement, tags, timestamp, k, v); err != nil {
+					c.Log.Errorf("cannot add to grouper: %v", err)
+				}
+			}
+			break
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,45 @@
+package netconf_junos
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/influxdata/telegraf/metric"
+)
+
+// decodeJob is a rpc-reply read in memory waiting for a decode worker
+type decodeJob struct {
+	p       *pendingRPC
+	dev     *deviceState
+	address string
+	data    []byte
+}
+
+// startDecodeWorkers starts the pool of decode workers
+func (c *NETCONF) startDecodeWorkers() {
+	c.decodeJobs = make(chan *decodeJob, c.DecodeWorkers)
+	c.workers.Add(c.DecodeWorkers)
+	for i := 0; i < c.DecodeWorkers; i++ {
+		go func() {
+			defer c.workers.Done()
+			for job := range c.decodeJobs {
+				c.decodeJob(job)
+			}
+		}()
+	}
+}
+
+// decodeJob decodes a rpc-reply and emits its metrics. The replies of the same subscription can be
+// decoded concurrently, so each job has its own metric store.
+func (c *NETCONF) decodeJob(job *decodeJob) {
+	start := time.Now()
+	r := job.p.req
+	grouper := metric.NewSeriesGrouper()
+	if err := c.decodeReply(r, bytes.NewReader(job.data), job.address, job.p.timestamp, newMetricStore([]req{r})[r.measurement], grouper); err != nil {
+		c.Log.Debugf("rpc-reply parsing for rpc %s and device %s stopped: %v", r.rpc, job.address, err)
+	}
+	job.p.stats.parseDuration.Set(time.Since(start).Nanoseconds())
+
+	c.emitReply(job.p, job.dev, grouper)
+	c.Log.Debugf("rpc handling for rpc %s and device %s toke %s", r.rpc, job.address, time.Since(job.p.sent).String())
+}
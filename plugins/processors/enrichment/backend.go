@@ -0,0 +1,276 @@
+package enrichment
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/influxdata/telegraf"
+)
+
+// kvStore reads the enrichment entries from a key-value store - ok is false when the key
+// doesn't exist
+type kvStore interface {
+	get(ctx context.Context, key string) (value []byte, ok bool, err error)
+}
+
+// redisStore reads the entries with GET
+type redisStore struct {
+	client *redis.Client
+}
+
+func (r *redisStore) get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := r.client.WithContext(ctx).Get(key).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	return value, err == nil, err
+}
+
+// etcdStore reads the entries with the JSON gateway of etcd v3
+type etcdStore struct {
+	client  *http.Client
+	address string
+	headers map[string]string
+}
+
+func (e *etcdStore) get(ctx context.Context, key string) ([]byte, bool, error) {
+	body, err := json.Marshal(map[string]string{"key": base64.StdEncoding.EncodeToString([]byte(key))})
+	if err != nil {
+		return nil, false, err
+	}
+	req, err := http.NewRequest("POST", strings.TrimSuffix(e.address, "/")+"/v3/kv/range", bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, err
+	}
+	var reply struct {
+		Kvs []struct {
+			Value []byte `json:"value"`
+		} `json:"kvs"`
+	}
+	if err := json.Unmarshal(content, &reply); err != nil {
+		return nil, false, err
+	}
+	if len(reply.Kvs) == 0 {
+		return nil, false, nil
+	}
+	return reply.Kvs[0].Value, true, nil
+}
+
+// cachedEntry is an entry of the key-value store cached until it expires - nil for the negative
+// entries, when the key doesn't exist or can't be read
+type cachedEntry struct {
+	entry   map[string]interface{}
+	expires time.Time
+}
+
+// queueSize is the number of backend keys or addresses waiting to be read in the background
+const queueSize = 1000
+
+// backend resolves the enrichment entries from a key-value store. The entry of the key values
+// v1, v2... is the JSON object of tags and fields stored at <prefix>v1/v2...
+type backend struct {
+	store       kvStore
+	prefix      string
+	timeout     time.Duration
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedEntry
+	// keys to read by the backend goroutine, and the keys queued
+	queue   chan string
+	pending map[string]bool
+}
+
+// newBackend connects the Redis or etcd backend
+func (p *Enrichment) newBackend() (*backend, error) {
+	b := &backend{
+		prefix:      p.BackendKeyPrefix,
+		timeout:     time.Duration(p.BackendTimeout),
+		ttl:         time.Duration(p.BackendTTL),
+		negativeTTL: time.Duration(p.BackendNegativeTTL),
+		cache:       make(map[string]cachedEntry),
+		queue:       make(chan string, queueSize),
+		pending:     make(map[string]bool),
+	}
+	if b.timeout <= 0 {
+		b.timeout = time.Second
+	}
+	if b.ttl <= 0 {
+		b.ttl = 5 * time.Minute
+	}
+	if b.negativeTTL <= 0 {
+		b.negativeTTL = time.Minute
+	}
+	switch p.Backend {
+	case "redis":
+		tlsConfig, err := p.ClientConfig.TLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		b.store = &redisStore{client: redis.NewClient(&redis.Options{
+			Addr:         p.BackendAddress,
+			Password:     p.BackendPassword,
+			DB:           p.BackendDB,
+			TLSConfig:    tlsConfig,
+			DialTimeout:  b.timeout,
+			ReadTimeout:  b.timeout,
+			WriteTimeout: b.timeout,
+			PoolTimeout:  b.timeout,
+		})}
+	case "etcd":
+		client, err := p.httpClient()
+		if err != nil {
+			return nil, err
+		}
+		b.store = &etcdStore{client: client, address: p.BackendAddress, headers: p.HTTPHeaders}
+	default:
+		return nil, fmt.Errorf("unknown backend %q", p.Backend)
+	}
+	return b, nil
+}
+
+// entry returns the cached entry of the key values - the expired one until it is read again, nil
+// when it is not known yet. The missing and expired entries are queued to be read by the backend
+// goroutine, the store is never read on the metric path.
+func (b *backend) entry(values ...string) map[string]interface{} {
+	key := b.prefix + strings.Join(values, "/")
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cached, ok := b.cache[key]
+	if ok && time.Now().Before(cached.expires) {
+		return cached.entry
+	}
+	if !b.pending[key] {
+		select {
+		case b.queue <- key:
+			b.pending[key] = true
+		default:
+			// the queue is full, the key is queued again by the next metrics
+		}
+	}
+	return cached.entry
+}
+
+// run reads the queued keys until the processor stops, and removes the expired entries from the
+// cache once per ttl
+func (b *backend) run(done <-chan struct{}) {
+	ticker := time.NewTicker(b.ttl)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case key := <-b.queue:
+			b.read(key)
+		case <-ticker.C:
+			b.cleanup()
+		}
+	}
+}
+
+// read reads the key from the store within the timeout. When it can't be reached, the expired
+// entry is kept for another ttl, or the key is cached as missing for the negative ttl.
+func (b *backend) read(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+	value, found, err := b.store.get(ctx, key)
+	cancel()
+	var entry map[string]interface{}
+	if err == nil && found {
+		if err := json.Unmarshal(value, &entry); err != nil {
+			log.Printf("E! [processors.enrichment] Error when parsing the key %s from the backend error is %v", key, err)
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.pending, key)
+	if err != nil {
+		log.Printf("E! [processors.enrichment] Error when reading the key %s from the backend error is %v", key, err)
+		entry = b.cache[key].entry
+	}
+	b.remember(key, entry)
+}
+
+// remember caches the entry of the key for the ttl, or for the negative ttl when it is missing
+func (b *backend) remember(key string, entry map[string]interface{}) {
+	ttl := b.ttl
+	if entry == nil {
+		ttl = b.negativeTTL
+	}
+	b.cache[key] = cachedEntry{entry: entry, expires: time.Now().Add(ttl)}
+}
+
+// cleanup removes the expired entries from the cache
+func (b *backend) cleanup() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	for key, cached := range b.cache {
+		if now.After(cached.expires) {
+			delete(b.cache, key)
+		}
+	}
+}
+
+// enrich adds the level 1 entry and the level 2 entries of the metric. It returns whether the
+// level 1 entry exists.
+func (b *backend) enrich(p *Enrichment, metric telegraf.Metric, level1 string, tags map[string]string) bool {
+	entry := b.entry(level1)
+	if entry == nil {
+		return false
+	}
+	addEntry(metric, entry, "1")
+	if p.TwoLevels {
+		for _, key := range p.Level2TagKey {
+			if level2, ok := tags[key]; ok {
+				addEntry(metric, b.entry(level1, level2), "2")
+			}
+		}
+	}
+	return true
+}
+
+// walk adds the entries of every level matched by the key tags of the metric, the deeper levels
+// winning. It returns whether the first level matched.
+func (b *backend) walk(keyTags []string, metric telegraf.Metric) bool {
+	values := make([]string, 0, len(keyTags))
+	for i, key := range keyTags {
+		value, ok := metric.GetTag(key)
+		if !ok {
+			return i > 0
+		}
+		values = append(values, value)
+		entry := b.entry(values...)
+		if entry == nil {
+			return i > 0
+		}
+		addEntry(metric, entry, key)
+	}
+	return true
+}
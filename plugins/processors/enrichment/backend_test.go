@@ -0,0 +1,126 @@
+package enrichment
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// slowStore is a store which never answers before the deadline of the request
+type slowStore struct {
+	calls int32
+}
+
+func (s *slowStore) get(ctx context.Context, key string) ([]byte, bool, error) {
+	atomic.AddInt32(&s.calls, 1)
+	<-ctx.Done()
+	return nil, false, ctx.Err()
+}
+
+// mapStore is a store of JSON entries
+type mapStore struct {
+	entries map[string]string
+	calls   int32
+}
+
+func (s *mapStore) get(ctx context.Context, key string) ([]byte, bool, error) {
+	atomic.AddInt32(&s.calls, 1)
+	value, ok := s.entries[key]
+	return []byte(value), ok, nil
+}
+
+func newTestBackend(store kvStore, prefix string, timeout, negativeTTL time.Duration) *backend {
+	return &backend{
+		store:       store,
+		prefix:      prefix,
+		timeout:     timeout,
+		ttl:         time.Hour,
+		negativeTTL: negativeTTL,
+		cache:       make(map[string]cachedEntry),
+		queue:       make(chan string, queueSize),
+		pending:     make(map[string]bool),
+	}
+}
+
+func TestBackendTimeout(t *testing.T) {
+	store := &slowStore{}
+	b := newTestBackend(store, "", 50*time.Millisecond, time.Hour)
+
+	start := time.Now()
+	b.read("r1")
+	require.Less(t, int64(time.Since(start)), int64(time.Second))
+
+	// the failure is cached
+	require.Nil(t, b.entry("r1"))
+	require.Empty(t, b.queue)
+	require.Equal(t, int32(1), store.calls)
+}
+
+func TestBackendNegativeCache(t *testing.T) {
+	store := &mapStore{entries: map[string]string{"enrichment:r1": `{"site":"paris"}`}}
+	b := newTestBackend(store, "enrichment:", time.Second, time.Minute)
+
+	// the unknown keys are queued once, without reading the store
+	require.Nil(t, b.entry("r1"))
+	require.Nil(t, b.entry("r2"))
+	require.Nil(t, b.entry("r1"))
+	require.Len(t, b.queue, 2)
+	require.Equal(t, int32(0), store.calls)
+	b.read(<-b.queue)
+	b.read(<-b.queue)
+
+	require.Equal(t, map[string]interface{}{"site": "paris"}, b.entry("r1"))
+	require.Nil(t, b.entry("r2"))
+	require.Empty(t, b.queue)
+	require.Equal(t, int32(2), store.calls)
+
+	// the missing key is read again once the negative entry expired, which is shorter than the found one
+	require.True(t, b.cache["enrichment:r2"].expires.Before(b.cache["enrichment:r1"].expires))
+	b.cache["enrichment:r2"] = cachedEntry{expires: time.Now().Add(-time.Second)}
+	require.Nil(t, b.entry("r2"))
+	require.Equal(t, "enrichment:r2", <-b.queue)
+}
+
+// An expired entry is applied until it is read again, and kept when the store fails
+func TestBackendStaleEntry(t *testing.T) {
+	b := newTestBackend(&slowStore{}, "", 10*time.Millisecond, time.Minute)
+	b.cache["r1"] = cachedEntry{entry: map[string]interface{}{"site": "paris"}, expires: time.Now().Add(-time.Second)}
+	require.Equal(t, map[string]interface{}{"site": "paris"}, b.entry("r1"))
+	b.read(<-b.queue)
+	require.Equal(t, map[string]interface{}{"site": "paris"}, b.entry("r1"))
+	require.Empty(t, b.queue)
+}
+
+// The metrics are enriched once the backend goroutine read their entry
+func TestBackendEnrich(t *testing.T) {
+	store := &mapStore{entries: map[string]string{
+		"r1":          `{"site":"paris","capacity":100}`,
+		"r1/et-0/0/0": `{"role":"uplink"}`,
+	}}
+	p := &Enrichment{Level1TagKey: "device", TwoLevels: true, Level2TagKey: []string{"if_name"}, UnmatchedTag: "enriched"}
+	p.backend = newTestBackend(store, "", time.Second, time.Minute)
+	require.NoError(t, p.Start(nil))
+	defer p.Stop()
+
+	m := deviceMetric("r1")
+	m.AddTag("if_name", "et-0/0/0")
+	out := p.Apply(m)
+	require.Equal(t, map[string]string{"device": "r1", "if_name": "et-0/0/0", "enriched": "false"}, out[0].Tags())
+
+	// the level 2 entry is read once the level 1 entry is known
+	require.Eventually(t, func() bool {
+		m := deviceMetric("r1")
+		m.AddTag("if_name", "et-0/0/0")
+		out := p.Apply(m)
+		if !out[0].HasTag("role") {
+			return false
+		}
+		require.Equal(t, map[string]string{"device": "r1", "if_name": "et-0/0/0", "site": "paris", "role": "uplink"}, out[0].Tags())
+		require.Equal(t, map[string]interface{}{"in_octets": 1.0, "capacity": float64(100)}, out[0].Fields())
+		return true
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, int32(2), atomic.LoadInt32(&store.calls))
+}
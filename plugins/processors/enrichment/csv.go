@@ -0,0 +1,86 @@
+package enrichment
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseCSV builds the enrichment table from a CSV file with a header line. The level 1 key is
+// read from the csv_level1_column, the level 2 key from the csv_level2_column (LEVEL1TAGS when
+// not set or empty), the tags to add from the csv_tag_columns (all the other columns when not
+// set) and the numeric fields to add from the csv_field_columns.
+func (p *Enrichment) parseCSV(content []byte) (map[string]map[string]map[string]interface{}, error) {
+	records, err := csv.NewReader(bytes.NewReader(content)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("missing header line")
+	}
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[strings.TrimSpace(name)] = i
+	}
+	level1, ok := columns[p.CSVLevel1Column]
+	if !ok {
+		return nil, fmt.Errorf("level 1 column %q not found", p.CSVLevel1Column)
+	}
+	level2 := -1
+	if p.CSVLevel2Column != "" {
+		if level2, ok = columns[p.CSVLevel2Column]; !ok {
+			return nil, fmt.Errorf("level 2 column %q not found", p.CSVLevel2Column)
+		}
+	}
+	fields := make(map[string]int)
+	for _, name := range p.CSVFieldColumns {
+		i, ok := columns[name]
+		if !ok {
+			return nil, fmt.Errorf("field column %q not found", name)
+		}
+		fields[name] = i
+	}
+	tags := make(map[string]int)
+	if len(p.CSVTagColumns) > 0 {
+		for _, name := range p.CSVTagColumns {
+			i, ok := columns[name]
+			if !ok {
+				return nil, fmt.Errorf("tag column %q not found", name)
+			}
+			tags[name] = i
+		}
+	} else {
+		for name, i := range columns {
+			if _, field := fields[name]; i != level1 && i != level2 && !field {
+				tags[name] = i
+			}
+		}
+	}
+
+	table := make(map[string]map[string]map[string]interface{})
+	for _, record := range records[1:] {
+		key1 := strings.TrimSpace(record[level1])
+		key2 := "LEVEL1TAGS"
+		if level2 >= 0 && strings.TrimSpace(record[level2]) != "" {
+			key2 = strings.TrimSpace(record[level2])
+		}
+		if table[key1] == nil {
+			table[key1] = make(map[string]map[string]interface{})
+		}
+		if table[key1][key2] == nil {
+			table[key1][key2] = make(map[string]interface{})
+		}
+		for name, i := range tags {
+			table[key1][key2][name] = strings.TrimSpace(record[i])
+		}
+		for name, i := range fields {
+			// an empty or invalid number is not added
+			if value, err := strconv.ParseFloat(strings.TrimSpace(record[i]), 64); err == nil {
+				table[key1][key2][name] = value
+			}
+		}
+	}
+	return table, nil
+}
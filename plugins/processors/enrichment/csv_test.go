@@ -0,0 +1,61 @@
+package enrichment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSVSource(t *testing.T) {
+	path := writeTable(t, "inventory.csv", `device,if_name,role,contracted_bw,comment
+r1,,pe,,
+r1,et-0/0/0,uplink,1000000000,to r2
+r1,et-0/0/1,customer,invalid,
+`)
+	p := &Enrichment{
+		EnrichFilePath:  path,
+		Level1TagKey:    "device",
+		TwoLevels:       true,
+		Level2TagKey:    []string{"if_name"},
+		CSVLevel1Column: "device",
+		CSVLevel2Column: "if_name",
+		CSVTagColumns:   []string{"role"},
+		CSVFieldColumns: []string{"contracted_bw"},
+	}
+	out := enrich(t, p, interfaceMetric("r1", "et-0/0/0"), interfaceMetric("r1", "et-0/0/1"), interfaceMetric("r1", "et-0/0/2"))
+	require.Equal(t, map[string]string{"device": "r1", "if_name": "et-0/0/0", "role": "uplink"}, out[0].Tags())
+	require.Equal(t, map[string]interface{}{"in_octets": 1.0, "contracted_bw": float64(1000000000)}, out[0].Fields())
+	// an invalid number is not added
+	require.Equal(t, map[string]string{"device": "r1", "if_name": "et-0/0/1", "role": "customer"}, out[1].Tags())
+	require.Equal(t, map[string]interface{}{"in_octets": 1.0}, out[1].Fields())
+	require.Equal(t, map[string]string{"device": "r1", "if_name": "et-0/0/2", "role": "pe"}, out[2].Tags())
+}
+
+// Without csv_tag_columns, all the columns but the keys and the fields are tags
+func TestCSVAllColumns(t *testing.T) {
+	path := writeTable(t, "inventory", "device,site,pop\nr1,paris,par1\n")
+	p := &Enrichment{EnrichFilePath: path, Format: "csv", Level1TagKey: "device", CSVLevel1Column: "device"}
+	out := enrich(t, p, deviceMetric("r1"))
+	require.Equal(t, map[string]string{"device": "r1", "site": "paris", "pop": "par1"}, out[0].Tags())
+}
+
+func TestCSVErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		content string
+		p       *Enrichment
+	}{
+		{name: "empty", content: "", p: &Enrichment{}},
+		{name: "level 1 column", content: "name,site\nr1,paris\n", p: &Enrichment{}},
+		{name: "level 2 column", content: "device,site\nr1,paris\n", p: &Enrichment{CSVLevel2Column: "if_name"}},
+		{name: "tag column", content: "device,site\nr1,paris\n", p: &Enrichment{CSVTagColumns: []string{"role"}}},
+		{name: "field column", content: "device,site\nr1,paris\n", p: &Enrichment{CSVFieldColumns: []string{"bw"}}},
+		{name: "malformed", content: "device,site\nr1,paris,extra\n", p: &Enrichment{}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.p.CSVLevel1Column = "device"
+			_, err := tt.p.parseCSV([]byte(tt.content))
+			require.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package enrichment
+
+import (
+	"context"
+	"log"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+// dnsEntry is a cached reverse resolution - the hostname is empty for the negative entries
+type dnsEntry struct {
+	hostname string
+	expires  time.Time
+}
+
+// resolver reverse-resolves the device addresses with a cache of the hostnames and of the
+// failures
+type resolver struct {
+	lookupAddr  func(ctx context.Context, address string) ([]string, error)
+	timeout     time.Duration
+	ttl         time.Duration
+	negativeTTL time.Duration
+	pattern     *regexp.Regexp
+
+	mu    sync.Mutex
+	cache map[string]dnsEntry
+	// addresses to resolve by the resolver goroutine, and the addresses queued
+	queue   chan string
+	pending map[string]bool
+}
+
+// newResolver builds the resolver of the DNS mode
+func (p *Enrichment) newResolver() *resolver {
+	r := &resolver{
+		lookupAddr:  net.DefaultResolver.LookupAddr,
+		timeout:     time.Duration(p.DNSTimeout),
+		ttl:         time.Duration(p.DNSCacheTTL),
+		negativeTTL: time.Duration(p.DNSNegativeTTL),
+		cache:       make(map[string]dnsEntry),
+		queue:       make(chan string, queueSize),
+		pending:     make(map[string]bool),
+	}
+	if r.timeout <= 0 {
+		r.timeout = time.Second
+	}
+	if r.ttl <= 0 {
+		r.ttl = time.Hour
+	}
+	if r.negativeTTL <= 0 {
+		r.negativeTTL = 5 * time.Minute
+	}
+	if p.DNSPattern != "" {
+		pattern, err := regexp.Compile(p.DNSPattern)
+		if err != nil {
+			log.Printf("E! [processors.enrichment] Invalid dns_pattern %q error is %v", p.DNSPattern, err)
+		} else {
+			r.pattern = pattern
+		}
+	}
+	return r
+}
+
+// lookup returns the cached hostname of the address - the expired one until it is resolved
+// again, empty when it is not known yet or can't be resolved. The unknown and expired addresses
+// are queued to be resolved by the resolver goroutine.
+func (r *resolver) lookup(address string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.cache[address]
+	if ok && time.Now().Before(entry.expires) {
+		return entry.hostname
+	}
+	if !r.pending[address] {
+		select {
+		case r.queue <- address:
+			r.pending[address] = true
+		default:
+			// the queue is full, the address is queued again by the next metrics
+		}
+	}
+	return entry.hostname
+}
+
+// run resolves the queued addresses until the processor stops, and removes the expired entries
+// from the cache once per negative ttl
+func (r *resolver) run(done <-chan struct{}) {
+	ticker := time.NewTicker(r.negativeTTL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case address := <-r.queue:
+			r.resolve(address)
+		case <-ticker.C:
+			r.cleanup()
+		}
+	}
+}
+
+// resolve caches the hostname of the address, without the trailing dot, for the ttl - the
+// failures for the negative ttl
+func (r *resolver) resolve(address string) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	names, err := r.lookupAddr(ctx, address)
+	cancel()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.pending, address)
+	if err != nil || len(names) == 0 {
+		logPrintf("Cannot resolve %s: %v", address, err)
+		r.cache[address] = dnsEntry{expires: time.Now().Add(r.negativeTTL)}
+		return
+	}
+	r.cache[address] = dnsEntry{hostname: strings.TrimSuffix(names[0], "."), expires: time.Now().Add(r.ttl)}
+}
+
+// cleanup removes the expired entries from the cache
+func (r *resolver) cleanup() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for key, entry := range r.cache {
+		if now.After(entry.expires) {
+			delete(r.cache, key)
+		}
+	}
+}
+
+// enrich adds the hostname of the address in the dns_tag and the named groups of the dns_pattern
+// matching the hostname
+func (r *resolver) enrich(p *Enrichment, metric telegraf.Metric) {
+	address, ok := metric.GetTag(p.DNSTag)
+	if !ok || net.ParseIP(address) == nil {
+		return
+	}
+	hostname := r.lookup(address)
+	if hostname == "" {
+		return
+	}
+	metric.AddTag(p.DNSHostnameTag, hostname)
+	if r.pattern == nil {
+		return
+	}
+	match := r.pattern.FindStringSubmatch(hostname)
+	if match == nil {
+		return
+	}
+	for i, name := range r.pattern.SubexpNames() {
+		if name != "" && match[i] != "" {
+			metric.AddTag(name, match[i])
+		}
+	}
+}
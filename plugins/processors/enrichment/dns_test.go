@@ -0,0 +1,76 @@
+package enrichment
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDNS resolves the addresses of its table and counts the requests
+type fakeDNS struct {
+	names    map[string]string
+	requests int32
+}
+
+func (f *fakeDNS) lookupAddr(ctx context.Context, address string) ([]string, error) {
+	atomic.AddInt32(&f.requests, 1)
+	name, ok := f.names[address]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return []string{name}, nil
+}
+
+func newDNSEnrichment(dns *fakeDNS) *Enrichment {
+	p := &Enrichment{Level1TagKey: "hostname", DNSTag: "device", DNSPattern: `^(?P<role>[a-z]+)\d+\.(?P<region>[a-z]+)\.`}
+	p.init()
+	p.resolver.lookupAddr = dns.lookupAddr
+	return p
+}
+
+// The addresses are resolved by the resolver goroutine, the hostnames and failures are cached
+func TestDNSEnrich(t *testing.T) {
+	dns := &fakeDNS{names: map[string]string{"192.0.2.1": "pe1.paris.example.net."}}
+	p := newDNSEnrichment(dns)
+	require.NoError(t, p.Start(nil))
+	defer p.Stop()
+
+	// not resolved yet
+	out := p.Apply(deviceMetric("192.0.2.1"))
+	require.Equal(t, map[string]string{"device": "192.0.2.1"}, out[0].Tags())
+
+	require.Eventually(t, func() bool {
+		return p.Apply(deviceMetric("192.0.2.1"))[0].HasTag("hostname")
+	}, time.Second, 10*time.Millisecond)
+	out = p.Apply(deviceMetric("192.0.2.1"), deviceMetric("192.0.2.2"), deviceMetric("r1"))
+	require.Equal(t, map[string]string{"device": "192.0.2.1", "hostname": "pe1.paris.example.net", "role": "pe", "region": "paris"}, out[0].Tags())
+	require.Equal(t, map[string]string{"device": "192.0.2.2"}, out[1].Tags())
+	// not an address
+	require.Equal(t, map[string]string{"device": "r1"}, out[2].Tags())
+
+	// the failure is cached too
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&dns.requests) == 2 }, time.Second, 10*time.Millisecond)
+	for i := 0; i < 10; i++ {
+		p.Apply(deviceMetric("192.0.2.1"), deviceMetric("192.0.2.2"))
+	}
+	require.Equal(t, int32(2), atomic.LoadInt32(&dns.requests))
+}
+
+// The expired hostname is applied until the address is resolved again
+func TestDNSExpired(t *testing.T) {
+	dns := &fakeDNS{names: map[string]string{"192.0.2.1": "pe1.paris.example.net."}}
+	p := newDNSEnrichment(dns)
+	r := p.resolver
+	r.cache["192.0.2.1"] = dnsEntry{hostname: "pe9.lyon.example.net", expires: time.Now().Add(-time.Second)}
+
+	require.Equal(t, "pe9.lyon.example.net", r.lookup("192.0.2.1"))
+	require.Equal(t, "pe9.lyon.example.net", r.lookup("192.0.2.1"))
+	require.Len(t, r.queue, 1)
+	r.resolve(<-r.queue)
+	require.Equal(t, "pe1.paris.example.net", r.lookup("192.0.2.1"))
+	require.Empty(t, r.queue)
+}
@@ -1,17 +1,18 @@
 package enrichment
 
 import (
-    "encoding/json"
-    "crypto/md5"
-    "encoding/hex"
-    "io/ioutil"
-    "io"
-    "os"
     "log"
-    "time"
+    "net/http"
+    "sync"
 
     "github.com/influxdata/telegraf"
+    "github.com/influxdata/telegraf/config"
+    "github.com/influxdata/telegraf/filter"
+    "github.com/influxdata/telegraf/internal/instance"
+    "github.com/influxdata/telegraf/plugins/common/tls"
     "github.com/influxdata/telegraf/plugins/processors"
+    "github.com/influxdata/telegraf/selfstat"
+    "gopkg.in/fsnotify.v1"
 )
 
 var sampleConfig = `
@@ -22,6 +23,8 @@ var sampleConfig = `
   ## If one level of filtering (default) is used the plugin looks for the wellknown level2
   ## Tag "LEVEL1TAGS" in the json file.
   ## The json file as read periodically every RefreshPeriod minutes. (by default 60m)
+  ## The files and URLs are read once at start, then in the background: the metrics are enriched with the last
+  ## table loaded.
   ## See README file for more info about the Json file structure.
   ##
   enrichfilepath = ""
@@ -33,9 +36,97 @@ var sampleConfig = `
   ## Level2tagkey is a list of tag that must match. if several level2 keys match, the tags will be merged
   level1tagkey = ""
   level2tagkey = []
-`
+  ## Instead of level1tagkey and level2tagkey, key_tags walks a JSON tree of any depth: the first level is keyed
+  ## by the value of the first key tag, its children by the value of the second one and so on. The tags of every
+  ## matched level are merged, the deeper levels winning. In each object, the string entries and the entries of
+  ## the "TAGS" object are the tags of the level, the other objects are the next level:
+  ##   {"r1": {"TAGS": {"site": "paris"}, "0": {"TAGS": {"fpc_type": "MPC7"}, "xe-0/0/0": {"role": "uplink"}}}}
+  ## The CSV source is not supported with key_tags.
+  # key_tags = ["device", "slot", "if_name"]
+  ## Only the measurements matching enrich_namepass and not matching enrich_namedrop (globs) are looked up in the
+  ## table, the other metrics pass through untouched.
+  # enrich_namepass = ["interface*"]
+  # enrich_namedrop = []
+  ## The metrics without entry in the table (no level 1 match) get the default_tags they don't have yet and the
+  ## unmatched_tag set to "false", to find the devices missing from the inventory.
+  # default_tags = {site = "unknown"}
+  # unmatched_tag = "enriched"
+  ## Strict mode: drop the metrics without entry instead - all of them while no table could be loaded -
+  ## counted in the internal metric dropped_unmatched, tagged with the alias of the processor and an instance
+  ## number distinct per running copy - telegraf runs a second copy when aggregators are configured.
+  # drop_unmatched = false
+  ## How the keys of every level of the table are matched with the tags: "exact" (default), "regex" or
+  ## "glob" (e.g. "xe-0/0/.*" or "xe-0/0/*"). An exact key wins, else the longest matching pattern.
+  # key_matching = "exact"
+
+  ## With watch = true, the enrichment files are re-read as soon as they change (written or replaced), the
+  ## periodic refresh being kept as a fallback.
+  # watch = false
+
+  ## enrichfilepath (and the path of the sources) can also be an HTTP(S) URL fetched every refreshperiod minutes. The ETag and Last-Modified
+  ## headers of the server are honored so an unchanged table is not downloaded and parsed again. http_headers
+  ## are added to the requests (e.g. the authentication). When the URL can't be fetched, the previous table is
+  ## kept until the next refresh.
+  # http_headers = {Authorization = "Bearer <token>"}
+  # http_timeout = "10s"
+  ## Optional TLS Config
+  # tls_ca = "/etc/telegraf/ca.pem"
+  # tls_cert = "/etc/telegraf/cert.pem"
+  # tls_key = "/etc/telegraf/key.pem"
+  # insecure_skip_verify = false
+
+  ## The entries can also be resolved from a Redis or etcd (v3 JSON gateway, e.g. "http://localhost:2379")
+  ## backend, queried after the files. The entry of a level 1 key is the JSON object of tags and fields stored at
+  ## <backend_key_prefix><level1>, the entry of a level 2 key at <backend_key_prefix><level1>/<level2> (with
+  ## key_tags, <prefix><value1>/<value2>/...). The keys are matched exactly and the entries are cached for
+  ## backend_ttl, the missing ones and the failures for backend_negative_ttl. The entries are read in the
+  ## background, each request bounded by backend_timeout: the metrics arriving before their entry is read are
+  ## handled as unmatched, and an expired entry is applied until it is read again. http_headers and the TLS
+  ## config are also used by the backends.
+  # backend = "redis"
+  # backend_address = "localhost:6379"
+  # backend_password = ""
+  # backend_db = 0
+  # backend_key_prefix = "enrichment:"
+  # backend_ttl = "5m"
+  # backend_negative_ttl = "1m"
+  # backend_timeout = "1s"
 
-var enrich map[string] map[string] map[string] string
+  ## Without inventory, the address in the dns_tag can be reverse-resolved to a hostname added in the
+  ## dns_hostname_tag (default "hostname"), before the lookups in the tables. The named groups of the dns_pattern
+  ## matching the hostname are added as tags. The addresses are resolved in the background and the hostnames are
+  ## cached for dns_cache_ttl, the failures for dns_negative_ttl: the metrics arriving before the resolution of
+  ## their address don't get the hostname.
+  # dns_tag = "device"
+  # dns_hostname_tag = "hostname"
+  # dns_pattern = '^(?P<role>[a-z]+)\d+\.(?P<region>[a-z]+)\.'
+  # dns_timeout = "1s"
+  # dns_cache_ttl = "1h"
+  # dns_negative_ttl = "5m"
+
+  ## Besides the tags (string values), the numeric and boolean values of the JSON table are added as fields,
+  ## e.g. {"r1": {"xe-0/0/0": {"circuit_id": "C42", "contracted_bw": 1000000000}}}.
+  ## A tag value can be a template combining the tags of the metric and the other tags of the same entry, e.g.
+  ## {"r1": {"LEVEL1TAGS": {"pop": "par1", "site": "{{pop}}-{{rack}}"}}} - an unknown tag is replaced by "".
+  ##
+  ## The enrichment table can also be read from a CSV file with a header line (format = "csv" or a .csv
+  ## enrichfilepath). Each line gives the level 1 key in csv_level1_column, the level 2 key in csv_level2_column
+  ## (the line applies to the whole level 1 key when not set or empty), the tags to add in csv_tag_columns
+  ## (all the other columns when not set) and the numeric fields to add in csv_field_columns.
+  # format = "json"
+  # csv_level1_column = "device"
+  # csv_level2_column = "if_name"
+  # csv_tag_columns = ["site", "role"]
+  # csv_field_columns = ["contracted_bw"]
+
+  ## Additional enrichment files or URLs, e.g. a global inventory and regional overrides, each with its own
+  ## refresh period (refreshperiod by default) and format. The sources are merged in order, enrichfilepath
+  ## first: the tags and fields of a source override the ones of the previous sources.
+  # [[processors.enrichment.source]]
+  #   path = "/etc/telegraf/enrichment-paris.json"
+  #   refreshperiod = 5
+  #   format = "json"
+`
 
 type Enrichment struct {
     EnrichFilePath string `toml:"enrichfilepath"`
@@ -43,11 +134,65 @@ type Enrichment struct {
     RefreshPeriod int `toml:"refreshperiod"`
     Level1TagKey string `toml:"level1tagkey"`
     Level2TagKey []string `toml:"level2tagkey"`
+    KeyTags []string `toml:"key_tags"`
+    KeyMatching string `toml:"key_matching"`
+    Sources []Source `toml:"source"`
+
+    // scoping of the enrichment and metrics without entry
+    EnrichNamepass []string `toml:"enrich_namepass"`
+    EnrichNamedrop []string `toml:"enrich_namedrop"`
+    DefaultTags map[string]string `toml:"default_tags"`
+    UnmatchedTag string `toml:"unmatched_tag"`
+    DropUnmatched bool `toml:"drop_unmatched"`
+    dropped selfstat.Stat
+    // names the instance in the internal metrics
+    Alias string `toml:"alias"`
+
+    // CSV sources
+    Format string `toml:"format"`
+    CSVLevel1Column string `toml:"csv_level1_column"`
+    CSVLevel2Column string `toml:"csv_level2_column"`
+    CSVTagColumns []string `toml:"csv_tag_columns"`
+    CSVFieldColumns []string `toml:"csv_field_columns"`
+
+    // HTTP sources
+    HTTPHeaders map[string]string `toml:"http_headers"`
+    HTTPTimeout config.Duration `toml:"http_timeout"`
+    tls.ClientConfig
+    client *http.Client
+
+    // the file sources are re-read as soon as they change
+    Watch bool `toml:"watch"`
+    watcher *fsnotify.Watcher
+    wg sync.WaitGroup
+    // wakes the refresh goroutine up, closed when the processor stops
+    wake chan struct{}
+    done chan struct{}
 
     initialized bool
-    FileError bool
-    LastUpdate time.Time
-    CurrentHash string
+    // Redis or etcd backend queried after the sources
+    Backend string `toml:"backend"`
+    BackendAddress string `toml:"backend_address"`
+    BackendPassword string `toml:"backend_password"`
+    BackendDB int `toml:"backend_db"`
+    BackendKeyPrefix string `toml:"backend_key_prefix"`
+    BackendTTL config.Duration `toml:"backend_ttl"`
+    BackendTimeout config.Duration `toml:"backend_timeout"`
+    BackendNegativeTTL config.Duration `toml:"backend_negative_ttl"`
+    backend *backend
+
+    // reverse resolution of the device addresses
+    DNSTag string `toml:"dns_tag"`
+    DNSHostnameTag string `toml:"dns_hostname_tag"`
+    DNSPattern string `toml:"dns_pattern"`
+    DNSTimeout config.Duration `toml:"dns_timeout"`
+    DNSCacheTTL config.Duration `toml:"dns_cache_ttl"`
+    DNSNegativeTTL config.Duration `toml:"dns_negative_ttl"`
+    resolver *resolver
+
+    // enrichfilepath then the additional sources, by increasing precedence
+    sources []*Source
+    nameFilter filter.Filter
 }
 
 func(p * Enrichment) SampleConfig() string {
@@ -58,107 +203,205 @@ func(p * Enrichment) Description() string {
     return "Enrich with external tags based on existing tags"
 }
 
+// Start loads the sources and starts their refreshes, the backend reads and the DNS resolutions
+// in the background
+func(p * Enrichment) Start(acc telegraf.Accumulator) error {
+    if !p.initialized {
+        p.init()
+    }
+    p.done = make(chan struct{})
+    p.wake = make(chan struct{}, 1)
+    if len(p.sources) > 0 {
+        p.wg.Add(1)
+        go p.refreshSources()
+    }
+    if p.backend != nil {
+        p.wg.Add(1)
+        go func() {
+            defer p.wg.Done()
+            p.backend.run(p.done)
+        }()
+    }
+    if p.resolver != nil {
+        p.wg.Add(1)
+        go func() {
+            defer p.wg.Done()
+            p.resolver.run(p.done)
+        }()
+    }
+    if !p.Watch {
+        return nil
+    }
+    if err := p.watch(); err != nil {
+        // the files are still re-read every refresh period
+        log.Printf("E! [processors.enrichment] Cannot watch the enrichment files error is %v", err)
+    }
+    return nil
+}
+
+func(p * Enrichment) Add(metric telegraf.Metric, acc telegraf.Accumulator) error {
+    for _, m := range p.Apply(metric) {
+        acc.AddMetric(m)
+    }
+    return nil
+}
+
+// Stop stops the refreshes and watching the enrichment files
+func(p * Enrichment) Stop() error {
+    if p.done != nil {
+        close(p.done)
+    }
+    if p.watcher != nil {
+        p.watcher.Close()
+    }
+    p.wg.Wait()
+    return nil
+}
+
 func(p * Enrichment) Apply(metrics...telegraf.Metric)[] telegraf.Metric {
-    currentTime := time.Now()
-    delta := int(currentTime.Sub(p.LastUpdate).Minutes())
-    if !p.initialized || delta >= p.RefreshPeriod {
-        if p.RefreshPeriod <= 0 {
-            p.RefreshPeriod = 60
+    if !p.initialized {
+        p.init()
+    }
+    // the tables loaded by the refreshes, by increasing precedence
+    tables := p.tables()
+    loaded := p.backend != nil || p.resolver != nil || len(tables) > 0
+    if !loaded && !p.DropUnmatched {
+        return metrics
+    }
+    // in strict mode, the metrics are dropped as unmatched until a table is loaded
+
+    kept := metrics[:0]
+    for _, metric := range metrics {
+        if !p.nameFilter.Match(metric.Name()) {
+            kept = append(kept, metric)
+            continue
         }
-        update_db:= false
-        // Open enrichment file
-        jsonFile, err := os.Open(p.EnrichFilePath)
-        
-        if err != nil {
-            log.Printf("E! [processors.enrichment] Error when opening enrichment file %s error is %v", p.EnrichFilePath, err)
-            p.FileError = true
-            p.initialized = false
-        } else {
-            logPrintf("Successfully Open the file %s", p.EnrichFilePath)
-            logPrintf("Check the file hash")
-            hash := md5.New()
-            
-            if _, err := io.Copy(hash, jsonFile); err != nil {
-                logPrintf("Error during computing hash")
-                update_db = true
+        if p.resolver != nil {
+            // before the lookups, so the tables can be keyed by the hostname
+            p.resolver.enrich(p, metric)
+        }
+        if len(p.KeyTags) > 0 {
+            if p.walk(tables, metric) || p.unmatched(metric) {
+                kept = append(kept, metric)
+            }
+            continue
+        }
+        CurrentTags := metric.Tags()
+        Level1Tag := ""
+        Level1Tag = CurrentTags[p.Level1TagKey]
+        logPrintf("Current L1 Tags value %v", Level1Tag)
+        matched := false
+
+        if (Level1Tag != "") {
+            // the tags of a source override the tags of the previous ones
+            for _, t := range tables {
+                // exact key, else the most specific regex/glob key
+                level1 := t.level1Key(Level1Tag)
+                entries, ok := t.table[level1]
+                if !ok {
+                    continue
+                }
+                matched = true
+                // first add the Level 1 tags if present
+                addEntry(metric, entries["LEVEL1TAGS"], "1")
+                // if twolevels is set add level 2 tags if present
+                if p.TwoLevels {
+                    for _, value := range p.Level2TagKey {
+                        Level2Tag := t.level2Key(level1, CurrentTags[value])
+                        logPrintf("Current L2 Tags Value %v", Level2Tag)
+                        addEntry(metric, entries[Level2Tag], "2")
+                    }
+                }
             }
-            defer jsonFile.Close()
-            hashInBytes := hash.Sum(nil)[:16]
-            MD5String := hex.EncodeToString(hashInBytes)
-            if MD5String != p.CurrentHash {
-                logPrintf("Hash is different than the previous one - update DB")
-                p.CurrentHash = MD5String
-                update_db = true
-            } else {
-                logPrintf("Hash is the same than the previous one - no update needed")
-                update_db = false
-                
+            if p.backend != nil && p.backend.enrich(p, metric, Level1Tag, CurrentTags) {
+                matched = true
             }
-            
         }
-        if update_db {
-            jsonFile, err := os.Open(p.EnrichFilePath)
-            if err != nil {
-                log.Printf("E! [processors.enrichment] Error when opening enrichment file %s error is %v", p.EnrichFilePath, err)
-                p.FileError = true
-                p.initialized = false
-            } else {
-                logPrintf("Successfully Open the file %s", p.EnrichFilePath)
-                
-                //reset DB
-                enrich = make(map[string] map[string] map[string] string)
-                byteValue, _ := ioutil.ReadAll(jsonFile)
-                json.Unmarshal([] byte(byteValue), & enrich)
-                p.FileError = false
-                p.initialized = true
-                p.LastUpdate = time.Now()
-                defer jsonFile.Close()
-            } 
+        if matched || p.unmatched(metric) {
+            kept = append(kept, metric)
+        }
+    }
+    return kept
+}
 
+// init builds the list of sources and the measurement filter
+func(p * Enrichment) init() {
+    if p.EnrichFilePath != "" {
+        p.sources = append(p.sources, &Source{Path: p.EnrichFilePath, RefreshPeriod: p.RefreshPeriod, Format: p.Format})
+    }
+    for i := range p.Sources {
+        p.sources = append(p.sources, &p.Sources[i])
+    }
+    if p.Backend != "" {
+        backend, err := p.newBackend()
+        if err != nil {
+            log.Printf("E! [processors.enrichment] Cannot connect the %s backend error is %v", p.Backend, err)
         } else {
-            p.FileError = false
-            p.initialized = true
-            p.LastUpdate = time.Now()
+            p.backend = backend
+        }
+    }
+    if p.DNSTag != "" {
+        if p.DNSHostnameTag == "" {
+            p.DNSHostnameTag = "hostname"
         }
+        p.resolver = p.newResolver()
+    }
+    p.dropped = selfstat.Register("enrichment", "dropped_unmatched", instance.Tags(p.Alias))
+    nameFilter, err := filter.NewIncludeExcludeFilter(p.EnrichNamepass, p.EnrichNamedrop)
+    if err != nil {
+        log.Printf("E! [processors.enrichment] Invalid enrich_namepass/enrich_namedrop error is %v", err)
+        nameFilter, _ = filter.NewIncludeExcludeFilter(nil, nil)
+    }
+    p.nameFilter = nameFilter
+    // the first load, the next ones are done in the background
+    for _, s := range p.sources {
+        s.refresh(p)
+    }
+    p.initialized = true
+}
 
+// unmatched adds the default tags missing from a metric without entry in the enrichment table
+// and the unmatched_tag marker. It returns false when the metric is dropped in strict mode.
+func(p * Enrichment) unmatched(metric telegraf.Metric) bool {
+    logPrintf("No enrichment entry for metric %s", metric.Name())
+    if p.DropUnmatched {
+        p.dropped.Incr(1)
+        metric.Drop()
+        return false
     }
+    for tagKey, tagVal := range p.DefaultTags {
+        if !metric.HasTag(tagKey) {
+            metric.AddTag(tagKey, tagVal)
+        }
+    }
+    if p.UnmatchedTag != "" {
+        metric.AddTag(p.UnmatchedTag, "false")
+    }
+    return true
+}
 
-    if !p.FileError {
-        for _, metric := range metrics {
-            CurrentTags := metric.Tags()
-            Level1Tag := ""
-            Level1Tag = CurrentTags[p.Level1TagKey]
-            logPrintf("Current L1 Tags value %v", Level1Tag)
-       
-            if (Level1Tag != "") {
-                // first add the Level 1 tags if present
-                for tagKey, tagVal := range enrich[Level1Tag]["LEVEL1TAGS"] {
-                        if (tagVal != "") {
-                            logPrintf("Add level 1 Tag %s with value %s added", tagKey, tagVal)
-                            metric.AddTag(tagKey, string(tagVal))
-                        } else {
-                            metric.AddTag(tagKey, string(""))
-                        }
-                    }
-                    // if twolevels is set add level 2 tags if present
-                if p.TwoLevels {
-					for _, value := range p.Level2TagKey {
-						Level2Tag := CurrentTags[value]
-						logPrintf("Current L2 Tags Value %v", Level2Tag)
-						for tagKey, tagVal := range enrich[Level1Tag][Level2Tag] {
-							if (tagVal != "") {
-								logPrintf("Add level 2 Tag %s with value %s added", tagKey, tagVal)
-								metric.AddTag(tagKey, string(tagVal))
-							} else {
-								metric.AddTag(tagKey, string(""))
-							}
-						}
-					}
+// addEntry adds an entry of the enrichment table to the metric: the strings as tags, the numbers
+// and booleans as fields. The templates are rendered once the other tags are added.
+func addEntry(metric telegraf.Metric, entry map[string]interface{}, level string) {
+    var templates map[string]string
+    for key, value := range entry {
+        switch v := value.(type) {
+        case string:
+            if isTemplate(v) {
+                if templates == nil {
+                    templates = make(map[string]string)
                 }
+                templates[key] = v
+                continue
             }
+            logPrintf("Add level %s Tag %s with value %s added", level, key, v)
+            metric.AddTag(key, v)
+        case float64, bool:
+            logPrintf("Add level %s Field %s with value %v added", level, key, v)
+            metric.AddField(key, v)
         }
     }
-    return metrics
+    addTemplates(metric, templates, level)
 }
 
 func logPrintf(format string, v...interface {}) {
@@ -166,7 +409,7 @@ func logPrintf(format string, v...interface {}) {
 }
 
 func init() {
-    processors.Add("enrichment", func() telegraf.Processor {
+    processors.AddStreaming("enrichment", func() telegraf.StreamingProcessor {
         return &Enrichment {}
     })
 }
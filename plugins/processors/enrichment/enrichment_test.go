@@ -0,0 +1,171 @@
+package enrichment
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/metric"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTable writes an enrichment file in a directory of the test
+func writeTable(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func interfaceMetric(device, ifName string) telegraf.Metric {
+	return metric.New("interface", map[string]string{"device": device, "if_name": ifName}, map[string]interface{}{"in_octets": 1.0}, time.Unix(0, 0))
+}
+
+// enrich starts the processor, applies the metrics and stops it
+func enrich(t *testing.T, p *Enrichment, metrics ...telegraf.Metric) []telegraf.Metric {
+	require.NoError(t, p.Start(nil))
+	defer p.Stop()
+	return p.Apply(metrics...)
+}
+
+// The dropped metrics of the running copies are counted apart, even with the same alias
+func TestInstanceStats(t *testing.T) {
+	first, second := &Enrichment{Alias: "inventory"}, &Enrichment{Alias: "inventory"}
+	first.init()
+	second.init()
+	first.dropped.Incr(1)
+	require.Equal(t, "inventory", first.dropped.Tags()["alias"])
+	require.NotEqual(t, first.dropped.Tags(), second.dropped.Tags())
+	require.Equal(t, int64(0), second.dropped.Get())
+}
+
+// Strict mode drops the metrics in scope while no table can be loaded
+func TestDropUnmatchedWithoutTable(t *testing.T) {
+	p := &Enrichment{
+		EnrichFilePath: filepath.Join(t.TempDir(), "missing.json"),
+		Level1TagKey:   "device",
+		DropUnmatched:  true,
+		EnrichNamedrop: []string{"cpu"},
+	}
+	out := p.Apply(
+		metric.New("interface", map[string]string{"device": "r1"}, map[string]interface{}{"in_octets": 1.0}, time.Unix(0, 0)),
+		metric.New("cpu", map[string]string{"device": "r1"}, map[string]interface{}{"idle": 1.0}, time.Unix(0, 0)),
+	)
+	require.Len(t, out, 1)
+	require.Equal(t, "cpu", out[0].Name())
+	require.Equal(t, int64(1), p.dropped.Get())
+
+	// without strict mode the metrics pass untouched
+	p = &Enrichment{EnrichFilePath: filepath.Join(t.TempDir(), "missing.json"), Level1TagKey: "device", UnmatchedTag: "enriched"}
+	out = p.Apply(metric.New("interface", map[string]string{"device": "r1"}, map[string]interface{}{"in_octets": 1.0}, time.Unix(0, 0)))
+	require.Len(t, out, 1)
+	require.False(t, out[0].HasTag("enriched"))
+}
+
+// The numbers and booleans of the table are added as fields, the strings as tags
+func TestFields(t *testing.T) {
+	p := &Enrichment{
+		EnrichFilePath: writeTable(t, "inventory.json", `{"r1": {"LEVEL1TAGS": {"site": "paris", "sla": 99.9}, "xe-0/0/0": {"circuit_id": "C42", "contracted_bw": 1000000000, "protected": true, "ignored": ["a"]}}}`),
+		Level1TagKey:   "device",
+		TwoLevels:      true,
+		Level2TagKey:   []string{"if_name"},
+	}
+	out := enrich(t, p, interfaceMetric("r1", "xe-0/0/0"))
+	require.Equal(t, map[string]string{"device": "r1", "if_name": "xe-0/0/0", "site": "paris", "circuit_id": "C42"}, out[0].Tags())
+	require.Equal(t, map[string]interface{}{"in_octets": 1.0, "sla": 99.9, "contracted_bw": float64(1000000000), "protected": true}, out[0].Fields())
+}
+
+// The metrics without entry get the default tags they don't have and the unmatched marker
+func TestUnmatched(t *testing.T) {
+	p := &Enrichment{
+		EnrichFilePath: writeTable(t, "inventory.json", `{"r1": {"LEVEL1TAGS": {"site": "paris"}}}`),
+		Level1TagKey:   "device",
+		DefaultTags:    map[string]string{"site": "unknown", "device": "none"},
+		UnmatchedTag:   "enriched",
+	}
+	out := enrich(t, p, deviceMetric("r1"), deviceMetric("r9"), metric.New("cpu", nil, map[string]interface{}{"idle": 1.0}, time.Unix(0, 0)))
+	require.Equal(t, map[string]string{"device": "r1", "site": "paris"}, out[0].Tags())
+	require.Equal(t, map[string]string{"device": "r9", "site": "unknown", "enriched": "false"}, out[1].Tags())
+	// without level 1 tag
+	require.Equal(t, map[string]string{"device": "none", "site": "unknown", "enriched": "false"}, out[2].Tags())
+}
+
+// Strict mode drops the metrics without entry
+func TestDropUnmatched(t *testing.T) {
+	p := &Enrichment{
+		EnrichFilePath: writeTable(t, "inventory.json", `{"r1": {"LEVEL1TAGS": {"site": "paris"}}}`),
+		Level1TagKey:   "device",
+		DropUnmatched:  true,
+		DefaultTags:    map[string]string{"site": "unknown"},
+	}
+	out := enrich(t, p, deviceMetric("r1"), deviceMetric("r9"), deviceMetric("r1"))
+	require.Len(t, out, 2)
+	require.Equal(t, int64(1), p.dropped.Get())
+}
+
+// Only the measurements in scope are enriched, the others pass through untouched
+func TestMeasurementScoping(t *testing.T) {
+	p := &Enrichment{
+		EnrichFilePath: writeTable(t, "inventory.json", `{"r1": {"LEVEL1TAGS": {"site": "paris"}}}`),
+		Level1TagKey:   "device",
+		EnrichNamepass: []string{"interface*"},
+		EnrichNamedrop: []string{"interface_rate"},
+		UnmatchedTag:   "enriched",
+	}
+	at := func(name string) telegraf.Metric {
+		return metric.New(name, map[string]string{"device": "r1"}, map[string]interface{}{"value": 1.0}, time.Unix(0, 0))
+	}
+	out := enrich(t, p, at("interface"), at("interface_errors"), at("interface_rate"), at("cpu"))
+	for i, site := range []string{"paris", "paris", "", ""} {
+		value, _ := out[i].GetTag("site")
+		require.Equal(t, site, value, out[i].Name())
+		require.False(t, out[i].HasTag("enriched"))
+	}
+}
+
+// The sources are merged in order, the tags and fields of a source overriding the previous ones
+func TestSourcePrecedence(t *testing.T) {
+	global := writeTable(t, "global.json", `{"r1": {"LEVEL1TAGS": {"site": "paris", "role": "pe", "bw": 10}}, "r2": {"LEVEL1TAGS": {"site": "lyon"}}}`)
+	regional := writeTable(t, "regional.csv", "device,role,bw\nr1,p,100\n")
+	p := &Enrichment{
+		EnrichFilePath:  global,
+		Level1TagKey:    "device",
+		CSVLevel1Column: "device",
+		CSVTagColumns:   []string{"role"},
+		CSVFieldColumns: []string{"bw"},
+		Sources:         []Source{{Path: regional, RefreshPeriod: 5}},
+	}
+	out := enrich(t, p, deviceMetric("r1"), deviceMetric("r2"))
+	require.Equal(t, map[string]string{"device": "r1", "site": "paris", "role": "p"}, out[0].Tags())
+	require.Equal(t, map[string]interface{}{"in_octets": 1.0, "bw": float64(100)}, out[0].Fields())
+	require.Equal(t, map[string]string{"device": "r2", "site": "lyon"}, out[1].Tags())
+}
+
+// A source missing doesn't prevent the others from being applied
+func TestSourceMissing(t *testing.T) {
+	p := &Enrichment{
+		EnrichFilePath: filepath.Join(t.TempDir(), "missing.json"),
+		Level1TagKey:   "device",
+		Sources:        []Source{{Path: writeTable(t, "regional.json", `{"r1": {"LEVEL1TAGS": {"site": "paris"}}}`)}},
+	}
+	out := enrich(t, p, deviceMetric("r1"))
+	require.Equal(t, map[string]string{"device": "r1", "site": "paris"}, out[0].Tags())
+}
+
+// Each source is re-read on its own refresh period, refreshperiod by default
+func TestSourceRefreshPeriod(t *testing.T) {
+	global := writeTable(t, "global.json", `{"r1": {"LEVEL1TAGS": {"site": "paris"}}}`)
+	regional := writeTable(t, "regional.json", `{"r1": {"LEVEL1TAGS": {"role": "pe"}}}`)
+	p := &Enrichment{EnrichFilePath: global, Level1TagKey: "device", RefreshPeriod: 60, Sources: []Source{{Path: regional, RefreshPeriod: 5}}}
+	p.init()
+
+	require.NoError(t, ioutil.WriteFile(global, []byte(`{"r1": {"LEVEL1TAGS": {"site": "lyon"}}}`), 0644))
+	require.NoError(t, ioutil.WriteFile(regional, []byte(`{"r1": {"LEVEL1TAGS": {"role": "p"}}}`), 0644))
+	for _, s := range p.sources {
+		s.lastUpdate = s.lastUpdate.Add(-6 * time.Minute)
+		s.refresh(p)
+	}
+	out := p.Apply(deviceMetric("r1"))
+	require.Equal(t, map[string]string{"device": "r1", "site": "paris", "role": "p"}, out[0].Tags())
+}
@@ -0,0 +1,70 @@
+package enrichment
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// httpClient returns the HTTP client of the URL sources and of the etcd backend
+func (p *Enrichment) httpClient() (*http.Client, error) {
+	if p.client != nil {
+		return p.client, nil
+	}
+	tlsConfig, err := p.ClientConfig.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	timeout := time.Duration(p.HTTPTimeout)
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	p.client = &http.Client{
+		Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+		Timeout:   timeout,
+	}
+	return p.client, nil
+}
+
+// fetch downloads the enrichment table with a conditional request - the content is nil when
+// the table is not modified
+func (p *Enrichment) fetch(s *Source) ([]byte, error) {
+	client, err := p.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", s.Path, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range p.HTTPHeaders {
+		req.Header.Set(k, v)
+	}
+	if s.etag != "" {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+	if s.lastModified != "" {
+		req.Header.Set("If-Modified-Since", s.lastModified)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil, nil
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	s.etag = resp.Header.Get("ETag")
+	s.lastModified = resp.Header.Get("Last-Modified")
+	return content, nil
+}
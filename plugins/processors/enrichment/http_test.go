@@ -0,0 +1,111 @@
+package enrichment
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/metric"
+	"github.com/stretchr/testify/require"
+)
+
+// inventory serves the enrichment table with an ETag, answering 304 to the unchanged requests
+type inventory struct {
+	sync.Mutex
+	content  string
+	etag     string
+	requests int32
+	modified int32
+}
+
+func (i *inventory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&i.requests, 1)
+	i.Lock()
+	defer i.Unlock()
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.Header.Get("If-None-Match") == i.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	atomic.AddInt32(&i.modified, 1)
+	w.Header().Set("ETag", i.etag)
+	w.Write([]byte(i.content))
+}
+
+func (i *inventory) set(content, etag string) {
+	i.Lock()
+	defer i.Unlock()
+	i.content, i.etag = content, etag
+}
+
+func deviceMetric(device string) telegraf.Metric {
+	return metric.New("interface", map[string]string{"device": device}, map[string]interface{}{"in_octets": 1.0}, time.Unix(0, 0))
+}
+
+// siteOf applies the metric of the device and returns its site tag
+func siteOf(p *Enrichment, device string) string {
+	out := p.Apply(deviceMetric(device))
+	if len(out) == 0 {
+		return ""
+	}
+	site, _ := out[0].GetTag("site")
+	return site
+}
+
+// The URL is fetched at start then refreshed in the background with conditional requests
+func TestHTTPSource(t *testing.T) {
+	inv := &inventory{}
+	inv.set(`{"r1": {"LEVEL1TAGS": {"site": "paris"}}}`, `"v1"`)
+	server := httptest.NewServer(inv)
+	defer server.Close()
+
+	p := &Enrichment{EnrichFilePath: server.URL, Level1TagKey: "device", HTTPHeaders: map[string]string{"Authorization": "Bearer secret"}}
+	require.NoError(t, p.Start(nil))
+	defer p.Stop()
+	require.Equal(t, "paris", siteOf(p, "r1"))
+	require.Equal(t, "paris", siteOf(p, "r1"))
+	// the metrics don't trigger any request
+	require.Equal(t, int32(1), atomic.LoadInt32(&inv.requests))
+
+	// unchanged, the table is kept
+	refresh := func() {
+		atomic.StoreInt32(&p.sources[0].changed, 1)
+		p.wake <- struct{}{}
+	}
+	refresh()
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&inv.requests) == 2 }, time.Second, 10*time.Millisecond)
+	require.Equal(t, int32(1), atomic.LoadInt32(&inv.modified))
+	require.Equal(t, "paris", siteOf(p, "r1"))
+
+	inv.set(`{"r1": {"LEVEL1TAGS": {"site": "lyon"}}}`, `"v2"`)
+	refresh()
+	require.Eventually(t, func() bool { return siteOf(p, "r1") == "lyon" }, time.Second, 10*time.Millisecond)
+	require.Equal(t, int32(2), atomic.LoadInt32(&inv.modified))
+}
+
+// The previous table is kept when the URL can't be fetched
+func TestHTTPSourceError(t *testing.T) {
+	inv := &inventory{}
+	inv.set(`{"r1": {"LEVEL1TAGS": {"site": "paris"}}}`, `"v1"`)
+	server := httptest.NewServer(inv)
+	defer server.Close()
+
+	p := &Enrichment{EnrichFilePath: server.URL, Level1TagKey: "device", HTTPHeaders: map[string]string{"Authorization": "Bearer secret"}}
+	require.NoError(t, p.Start(nil))
+	defer p.Stop()
+	require.Equal(t, "paris", siteOf(p, "r1"))
+
+	p.HTTPHeaders["Authorization"] = "Bearer expired"
+	inv.set(`{"r1": {"LEVEL1TAGS": {"site": "lyon"}}}`, `"v2"`)
+	atomic.StoreInt32(&p.sources[0].changed, 1)
+	p.wake <- struct{}{}
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&inv.requests) == 2 }, time.Second, 10*time.Millisecond)
+	require.Equal(t, "paris", siteOf(p, "r1"))
+}
@@ -0,0 +1,98 @@
+package enrichment
+
+import (
+	"log"
+	"regexp"
+	"sort"
+
+	"github.com/influxdata/telegraf/filter"
+)
+
+// keyPattern is a level 1 or level 2 key of the enrichment table matched as a regex or a glob
+type keyPattern struct {
+	key   string
+	match func(string) bool
+}
+
+// patternIndex holds the pattern keys of the enrichment table, the longest first
+type patternIndex struct {
+	level1 []keyPattern
+	level2 map[string][]keyPattern
+}
+
+// indexKeys compiles the keys of the enrichment table as patterns when key_matching is "regex"
+// or "glob". The regexes are anchored to match the whole tag value.
+func (t *sourceTable) indexKeys(p *Enrichment) {
+	t.patterns = patternIndex{level2: make(map[string][]keyPattern)}
+	if p.KeyMatching == "" || p.KeyMatching == "exact" {
+		return
+	}
+	for level1, entries := range t.table {
+		t.patterns.level1 = p.appendPattern(t.patterns.level1, level1)
+		for level2 := range entries {
+			if level2 != "LEVEL1TAGS" {
+				t.patterns.level2[level1] = p.appendPattern(t.patterns.level2[level1], level2)
+			}
+		}
+		sortPatterns(t.patterns.level2[level1])
+	}
+	sortPatterns(t.patterns.level1)
+}
+
+func (p *Enrichment) appendPattern(patterns []keyPattern, key string) []keyPattern {
+	switch p.KeyMatching {
+	case "regex":
+		regex, err := regexp.Compile("^(?:" + key + ")$")
+		if err != nil {
+			log.Printf("E! [processors.enrichment] Invalid regex key %q - only matched exactly: %v", key, err)
+			return patterns
+		}
+		return append(patterns, keyPattern{key: key, match: regex.MatchString})
+	case "glob":
+		glob, err := filter.Compile([]string{key})
+		if err != nil {
+			log.Printf("E! [processors.enrichment] Invalid glob key %q - only matched exactly: %v", key, err)
+			return patterns
+		}
+		return append(patterns, keyPattern{key: key, match: glob.Match})
+	}
+	return patterns
+}
+
+// sortPatterns sorts the patterns from the most specific (longest) to the least specific one
+func sortPatterns(patterns []keyPattern) {
+	sort.Slice(patterns, func(i, j int) bool {
+		if len(patterns[i].key) != len(patterns[j].key) {
+			return len(patterns[i].key) > len(patterns[j].key)
+		}
+		return patterns[i].key < patterns[j].key
+	})
+}
+
+// matchKey returns the key of the table matching the tag value: the exact key, else the most
+// specific pattern matching it
+func matchKey(value string, exact bool, patterns []keyPattern) (string, bool) {
+	if exact {
+		return value, true
+	}
+	for _, pattern := range patterns {
+		if pattern.match(value) {
+			return pattern.key, true
+		}
+	}
+	return "", false
+}
+
+// level1Key returns the level 1 key of the table matching the tag value
+func (t *sourceTable) level1Key(value string) string {
+	_, exact := t.table[value]
+	key, _ := matchKey(value, exact, t.patterns.level1)
+	return key
+}
+
+// level2Key returns the level 2 key of the level 1 entry matching the tag value
+func (t *sourceTable) level2Key(level1 string, value string) string {
+	_, exact := t.table[level1][value]
+	key, _ := matchKey(value, exact, t.patterns.level2[level1])
+	return key
+}
@@ -0,0 +1,68 @@
+package enrichment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyMatching(t *testing.T) {
+	tests := []struct {
+		name     string
+		matching string
+		table    string
+		expected []string
+	}{
+		{
+			name:     "exact",
+			table:    `{"r1": {"xe-0/0/.*": {"role": "access"}, "xe-0/0/1": {"role": "uplink"}}}`,
+			expected: []string{"", "uplink", ""},
+		},
+		{
+			// an exact key wins, else the longest pattern
+			name:     "regex",
+			matching: "regex",
+			table:    `{"r.*": {"xe-.*": {"role": "any"}, "xe-0/0/.*": {"role": "access"}, "xe-0/0/1": {"role": "uplink"}, "xe-[": {"role": "invalid"}}}`,
+			expected: []string{"access", "uplink", "any"},
+		},
+		{
+			name:     "regex anchored",
+			matching: "regex",
+			table:    `{"r1": {"0/0": {"role": "access"}}}`,
+			expected: []string{"", "", ""},
+		},
+		{
+			name:     "glob",
+			matching: "glob",
+			table:    `{"r?": {"xe-*": {"role": "any"}, "xe-0/0/*": {"role": "access"}, "xe-0/0/1": {"role": "uplink"}}}`,
+			expected: []string{"access", "uplink", "any"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Enrichment{
+				EnrichFilePath: writeTable(t, "inventory.json", tt.table),
+				Level1TagKey:   "device",
+				TwoLevels:      true,
+				Level2TagKey:   []string{"if_name"},
+				KeyMatching:    tt.matching,
+			}
+			out := enrich(t, p, interfaceMetric("r1", "xe-0/0/0"), interfaceMetric("r1", "xe-0/0/1"), interfaceMetric("r1", "xe-1/0/0"))
+			for i, m := range out {
+				role, _ := m.GetTag("role")
+				require.Equal(t, tt.expected[i], role, i)
+			}
+		})
+	}
+}
+
+// The children of the tree are matched as patterns too
+func TestKeyMatchingTree(t *testing.T) {
+	p := &Enrichment{
+		EnrichFilePath: writeTable(t, "inventory.json", `{"r*": {"TAGS": {"site": "paris"}, "xe-0/0/*": {"role": "access"}}}`),
+		KeyTags:        []string{"device", "if_name"},
+		KeyMatching:    "glob",
+	}
+	out := enrich(t, p, interfaceMetric("r1", "xe-0/0/0"))
+	require.Equal(t, map[string]string{"device": "r1", "if_name": "xe-0/0/0", "site": "paris", "role": "access"}, out[0].Tags())
+}
@@ -0,0 +1,158 @@
+package enrichment
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Source is an enrichment file or URL with its own refresh period
+type Source struct {
+	Path          string `toml:"path"`
+	RefreshPeriod int    `toml:"refreshperiod"`
+	Format        string `toml:"format"`
+
+	loaded       bool
+	lastUpdate   time.Time
+	hash         string
+	etag         string
+	lastModified string
+	// set by the watcher when the file changed
+	changed int32
+
+	// *sourceTable, replaced as a whole by the refreshes - nil until a table is loaded
+	current atomic.Value
+}
+
+// sourceTable is the content of a source read by Apply: the two levels table, or the tree walked
+// with the key_tags
+type sourceTable struct {
+	table    map[string]map[string]map[string]interface{}
+	patterns patternIndex
+	tree     *enrichNode
+}
+
+// snapshot returns the last table loaded, nil when none could be loaded yet
+func (s *Source) snapshot() *sourceTable {
+	t, _ := s.current.Load().(*sourceTable)
+	return t
+}
+
+// url reports whether the path of the source is an HTTP(S) URL
+func (s *Source) url() bool {
+	path := strings.ToLower(s.Path)
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// csv reports whether the source is a CSV file: format = "csv" or a .csv file
+func (s *Source) csv() bool {
+	if s.Format != "" {
+		return strings.ToLower(s.Format) == "csv"
+	}
+	return strings.ToLower(filepath.Ext(s.Path)) == ".csv"
+}
+
+// refresh re-reads the source every refresh period, or as soon as the watcher saw the file
+// change. The table is only parsed again when the content changed. On error, the previous table
+// is kept until the next refresh. Only the refresh goroutine calls it once the processor started.
+func (s *Source) refresh(p *Enrichment) {
+	period := s.RefreshPeriod
+	if period <= 0 {
+		period = p.RefreshPeriod
+	}
+	if period <= 0 {
+		period = 60
+	}
+	changed := atomic.SwapInt32(&s.changed, 0) == 1
+	if !changed && !s.lastUpdate.IsZero() && time.Since(s.lastUpdate) < time.Duration(period)*time.Minute {
+		return
+	}
+	s.lastUpdate = time.Now()
+
+	var content []byte
+	var err error
+	if s.url() {
+		content, err = p.fetch(s)
+	} else {
+		content, err = ioutil.ReadFile(s.Path)
+	}
+	if err != nil {
+		log.Printf("E! [processors.enrichment] Error when opening enrichment file %s error is %v", s.Path, err)
+		return
+	}
+	if content == nil {
+		logPrintf("Enrichment URL %s not modified - no update needed", s.Path)
+		return
+	}
+	hash := md5.Sum(content)
+	if hex.EncodeToString(hash[:]) == s.hash {
+		logPrintf("Hash of %s is the same than the previous one - no update needed", s.Path)
+		return
+	}
+	logPrintf("Hash of %s is different than the previous one - update DB", s.Path)
+	s.hash = hex.EncodeToString(hash[:])
+	s.parse(p, content)
+}
+
+// parse replaces the table of the source by the JSON or CSV content
+func (s *Source) parse(p *Enrichment, content []byte) {
+	t := &sourceTable{}
+	var err error
+	switch {
+	case len(p.KeyTags) > 0:
+		t.tree, err = p.parseTree(content)
+	case s.csv():
+		t.table, err = p.parseCSV(content)
+	default:
+		// the entries with an unexpected type are skipped
+		t.table = make(map[string]map[string]map[string]interface{})
+		if err = json.Unmarshal(content, &t.table); err != nil {
+			if _, ok := err.(*json.UnmarshalTypeError); ok {
+				err = nil
+			}
+		}
+	}
+	if err != nil {
+		// keep the previous table
+		log.Printf("E! [processors.enrichment] Error when parsing enrichment file %s error is %v", s.Path, err)
+		return
+	}
+	t.indexKeys(p)
+	s.current.Store(t)
+}
+
+// refreshSources re-reads the sources in the background until the processor stops: every minute
+// for their refresh period, and as soon as the watcher flags a change
+func (p *Enrichment) refreshSources() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		case <-p.wake:
+		}
+		for _, s := range p.sources {
+			s.refresh(p)
+		}
+	}
+}
+
+// tables returns the tables loaded, by increasing precedence
+func (p *Enrichment) tables() []*sourceTable {
+	tables := make([]*sourceTable, 0, len(p.sources))
+	for _, s := range p.sources {
+		if t := s.snapshot(); t != nil {
+			tables = append(tables, t)
+		}
+	}
+	return tables
+}
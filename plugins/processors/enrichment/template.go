@@ -0,0 +1,29 @@
+package enrichment
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/influxdata/telegraf"
+)
+
+var placeholderRe = regexp.MustCompile(`\{\{\s*([^{}\s]+)\s*\}\}`)
+
+// isTemplate reports whether a tag value of the table is a template like "{{pop}}-{{rack}}"
+func isTemplate(value string) bool {
+	return strings.Contains(value, "{{") && placeholderRe.MatchString(value)
+}
+
+// addTemplates adds the template tags rendered with the current tags of the metric, so they
+// combine the tags of the metric and the plain tags just added from the table - an unknown tag
+// is empty
+func addTemplates(metric telegraf.Metric, templates map[string]string, level string) {
+	for key, template := range templates {
+		value := placeholderRe.ReplaceAllStringFunc(template, func(placeholder string) string {
+			tag, _ := metric.GetTag(placeholderRe.FindStringSubmatch(placeholder)[1])
+			return tag
+		})
+		logPrintf("Add level %s Tag %s with value %s added", level, key, value)
+		metric.AddTag(key, value)
+	}
+}
@@ -0,0 +1,46 @@
+package enrichment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplates(t *testing.T) {
+	table := `{"r1": {
+		"LEVEL1TAGS": {"pop": "par1", "site": "{{pop}}-{{ rack }}", "label": "{{device}}:{{unknown}}", "plain": "{not a template}"},
+		"xe-0/0/0": {"port": "{{if_name}}@{{site}}"}
+	}}`
+	p := &Enrichment{
+		EnrichFilePath: writeTable(t, "inventory.json", table),
+		Level1TagKey:   "device",
+		TwoLevels:      true,
+		Level2TagKey:   []string{"if_name"},
+	}
+	m := interfaceMetric("r1", "xe-0/0/0")
+	m.AddTag("rack", "r12")
+	out := enrich(t, p, m)
+	require.Equal(t, map[string]string{
+		"device":  "r1",
+		"if_name": "xe-0/0/0",
+		"rack":    "r12",
+		"pop":     "par1",
+		// the tags of the metric and of the same entry
+		"site": "par1-r12",
+		// an unknown tag is empty
+		"label": "r1:",
+		"plain": "{not a template}",
+		// the tags of the previous level
+		"port": "xe-0/0/0@par1-r12",
+	}, out[0].Tags())
+}
+
+func TestTemplatesTree(t *testing.T) {
+	p := &Enrichment{
+		EnrichFilePath: writeTable(t, "inventory.json", `{"r1": {"TAGS": {"pop": "par1"}, "xe-0/0/0": {"circuit": "{{pop}}/{{if_name}}"}}}`),
+		KeyTags:        []string{"device", "if_name"},
+	}
+	out := enrich(t, p, interfaceMetric("r1", "xe-0/0/0"))
+	circuit, _ := out[0].GetTag("circuit")
+	require.Equal(t, "par1/xe-0/0/0", circuit)
+}
@@ -0,0 +1,115 @@
+package enrichment
+
+import (
+	"encoding/json"
+
+	"github.com/influxdata/telegraf"
+)
+
+// enrichNode is a level of the enrichment tree walked with the key_tags: the tags added for the
+// level and the children keyed by the value of the next key tag
+type enrichNode struct {
+	tags     map[string]string
+	fields   map[string]interface{}
+	children map[string]*enrichNode
+	patterns []keyPattern
+}
+
+// parseTree builds the enrichment tree from the JSON source. In each object, the string entries
+// and the entries of the "TAGS" (or "LEVEL1TAGS") object are the tags of the level - the numbers
+// and booleans its fields - the other objects are the children, so the two levels files are
+// valid trees.
+func (p *Enrichment) parseTree(content []byte) (*enrichNode, error) {
+	var root map[string]interface{}
+	if err := json.Unmarshal(content, &root); err != nil {
+		return nil, err
+	}
+	return p.buildNode(root), nil
+}
+
+func (p *Enrichment) buildNode(object map[string]interface{}) *enrichNode {
+	node := &enrichNode{tags: make(map[string]string), fields: make(map[string]interface{}), children: make(map[string]*enrichNode)}
+	for key, value := range object {
+		switch v := value.(type) {
+		case string:
+			node.tags[key] = v
+		case float64, bool:
+			node.fields[key] = v
+		case map[string]interface{}:
+			if key == "TAGS" || key == "LEVEL1TAGS" {
+				for tagKey, tagVal := range v {
+					switch t := tagVal.(type) {
+					case string:
+						node.tags[tagKey] = t
+					case float64, bool:
+						node.fields[tagKey] = t
+					}
+				}
+				continue
+			}
+			node.children[key] = p.buildNode(v)
+			node.patterns = p.appendPattern(node.patterns, key)
+		}
+	}
+	sortPatterns(node.patterns)
+	return node
+}
+
+// child returns the child matching the tag value: the exact key, else the most specific
+// regex/glob key
+func (n *enrichNode) child(value string) *enrichNode {
+	_, exact := n.children[value]
+	key, ok := matchKey(value, exact, n.patterns)
+	if !ok {
+		return nil
+	}
+	return n.children[key]
+}
+
+// walk adds the tags and fields of the trees of the sources then of the backend, by increasing
+// precedence. It returns
+// whether the first level of a tree matched.
+func (p *Enrichment) walk(tables []*sourceTable, metric telegraf.Metric) bool {
+	matched := false
+	for _, t := range tables {
+		if walkTree(t.tree, p.KeyTags, metric) {
+			matched = true
+		}
+	}
+	if p.backend != nil && p.backend.walk(p.KeyTags, metric) {
+		matched = true
+	}
+	return matched
+}
+
+// walkTree adds the tags and fields of every level of the tree matched by the key tags of the
+// metric - the deeper levels win. It returns whether the first level matched.
+func walkTree(node *enrichNode, keyTags []string, metric telegraf.Metric) bool {
+	for i, key := range keyTags {
+		value, ok := metric.GetTag(key)
+		if !ok || node == nil {
+			return i > 0
+		}
+		if node = node.child(value); node == nil {
+			return i > 0
+		}
+		var templates map[string]string
+		for tagKey, tagVal := range node.tags {
+			if isTemplate(tagVal) {
+				if templates == nil {
+					templates = make(map[string]string)
+				}
+				templates[tagKey] = tagVal
+				continue
+			}
+			logPrintf("Add %s level Tag %s with value %s added", key, tagKey, tagVal)
+			metric.AddTag(tagKey, tagVal)
+		}
+		addTemplates(metric, templates, key)
+		for fieldKey, fieldVal := range node.fields {
+			logPrintf("Add %s level Field %s with value %v added", key, fieldKey, fieldVal)
+			metric.AddField(fieldKey, fieldVal)
+		}
+	}
+	return true
+}
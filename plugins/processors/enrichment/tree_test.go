@@ -0,0 +1,77 @@
+package enrichment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/metric"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyTags(t *testing.T) {
+	table := `{
+		"r1": {
+			"TAGS": {"site": "paris", "role": "pe"},
+			"0": {
+				"TAGS": {"fpc_type": "MPC7"},
+				"xe-0/0/0": {"role": "uplink", "port": "0", "1": {"channel_role": "backup"}}
+			}
+		},
+		"r2": {"site": "lyon"}
+	}`
+	p := &Enrichment{
+		EnrichFilePath: writeTable(t, "inventory.json", table),
+		KeyTags:        []string{"device", "slot", "if_name", "channel"},
+		UnmatchedTag:   "enriched",
+	}
+	tests := []struct {
+		name     string
+		tags     map[string]string
+		expected map[string]string
+	}{
+		{
+			// every level is merged, the deeper ones winning
+			name:     "all levels",
+			tags:     map[string]string{"device": "r1", "slot": "0", "if_name": "xe-0/0/0", "channel": "1"},
+			expected: map[string]string{"device": "r1", "slot": "0", "if_name": "xe-0/0/0", "channel": "1", "site": "paris", "fpc_type": "MPC7", "role": "uplink", "port": "0", "channel_role": "backup"},
+		},
+		{
+			name:     "stops at the first unmatched level",
+			tags:     map[string]string{"device": "r1", "slot": "1", "if_name": "xe-0/0/0"},
+			expected: map[string]string{"device": "r1", "slot": "1", "if_name": "xe-0/0/0", "site": "paris", "role": "pe"},
+		},
+		{
+			name:     "stops at the first missing tag",
+			tags:     map[string]string{"device": "r1", "if_name": "xe-0/0/0"},
+			expected: map[string]string{"device": "r1", "if_name": "xe-0/0/0", "site": "paris", "role": "pe"},
+		},
+		{
+			name:     "string entries of a level",
+			tags:     map[string]string{"device": "r2", "slot": "0"},
+			expected: map[string]string{"device": "r2", "slot": "0", "site": "lyon"},
+		},
+		{
+			name:     "unmatched first level",
+			tags:     map[string]string{"device": "r3", "slot": "0"},
+			expected: map[string]string{"device": "r3", "slot": "0", "enriched": "false"},
+		},
+	}
+	require.NoError(t, p.Start(nil))
+	defer p.Stop()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := p.Apply(metric.New("interface", tt.tags, map[string]interface{}{"in_octets": 1.0}, time.Unix(0, 0)))
+			require.Equal(t, tt.expected, out[0].Tags())
+		})
+	}
+}
+
+// The two levels files are valid trees
+func TestKeyTagsTwoLevelsFile(t *testing.T) {
+	p := &Enrichment{
+		EnrichFilePath: writeTable(t, "inventory.json", `{"r1": {"LEVEL1TAGS": {"site": "paris"}, "xe-0/0/0": {"role": "uplink"}}}`),
+		KeyTags:        []string{"device", "if_name"},
+	}
+	out := enrich(t, p, interfaceMetric("r1", "xe-0/0/0"))
+	require.Equal(t, map[string]string{"device": "r1", "if_name": "xe-0/0/0", "site": "paris", "role": "uplink"}, out[0].Tags())
+}
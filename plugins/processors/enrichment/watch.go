@@ -0,0 +1,66 @@
+package enrichment
+
+import (
+	"log"
+	"path/filepath"
+	"sync/atomic"
+
+	"gopkg.in/fsnotify.v1"
+)
+
+// watch watches the directories of the file sources - the files replaced by a rename are seen
+// too - and flags the changed sources to be re-read by the refresh goroutine
+func (p *Enrichment) watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	dirs := make(map[string]bool)
+	for _, s := range p.sources {
+		if s.url() {
+			continue
+		}
+		dir := filepath.Dir(s.Path)
+		if dirs[dir] {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return err
+		}
+		dirs[dir] = true
+	}
+	p.watcher = watcher
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+					continue
+				}
+				for _, s := range p.sources {
+					if !s.url() && filepath.Clean(s.Path) == filepath.Clean(event.Name) {
+						logPrintf("Enrichment file %s changed", s.Path)
+						atomic.StoreInt32(&s.changed, 1)
+						select {
+						case p.wake <- struct{}{}:
+						default:
+						}
+					}
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("E! [processors.enrichment] Error when watching the enrichment files error is %v", err)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,29 @@
+package enrichment
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// The file is re-read as soon as it is written or replaced, before its refresh period
+func TestWatch(t *testing.T) {
+	path := writeTable(t, "inventory.json", `{"r1": {"LEVEL1TAGS": {"site": "paris"}}}`)
+	p := &Enrichment{EnrichFilePath: path, Level1TagKey: "device", Watch: true}
+	require.NoError(t, p.Start(nil))
+	defer p.Stop()
+	require.Equal(t, "paris", siteOf(p, "r1"))
+
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"r1": {"LEVEL1TAGS": {"site": "lyon"}}}`), 0644))
+	require.Eventually(t, func() bool { return siteOf(p, "r1") == "lyon" }, 5*time.Second, 10*time.Millisecond)
+
+	// replaced by a rename
+	tmp := filepath.Join(filepath.Dir(path), ".inventory.json.tmp")
+	require.NoError(t, ioutil.WriteFile(tmp, []byte(`{"r1": {"LEVEL1TAGS": {"site": "nice"}}}`), 0644))
+	require.NoError(t, os.Rename(tmp, path))
+	require.Eventually(t, func() bool { return siteOf(p, "r1") == "nice" }, 5*time.Second, 10*time.Millisecond)
+}
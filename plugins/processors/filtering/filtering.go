@@ -2,21 +2,45 @@ package filtering
 
 import (
 	"regexp"
+	"time"
 
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/plugins/processors"
+	"github.com/influxdata/telegraf/selfstat"
 )
 
 type Filtering struct {
+	Measurements []rule
 	Tags       []rule
 	Fields     []rule
+	Groups     []group `toml:"group"`
+	// tag the metrics with the would-be actions instead of applying them
+	DryRun     bool `toml:"dry_run"`
+	// names the processor in the internal metrics
+	Alias      string `toml:"alias"`
 	regexCache map[string]*regexp.Regexp
+	hits       map[hitKey]selfstat.Stat
+	statTags   map[string]string
+	thinning   map[thinKey]*thinState
+	cleaned    time.Time
 }
 
 type rule struct {
 	Key         string
 	Pattern     string
 	Action      string
+	// numeric comparison of the field with the value instead of the pattern
+	Operator    string
+	Value       interface{}
+	// the matching metrics are thinned instead of applying the action
+	Sample      float64
+	RateLimit   string `toml:"rate_limit"`
+	// matches the presence (true) or the absence (false) of the key instead of its value
+	Exists      *bool
+	// the rule is only active during these time windows
+	Windows     []window `toml:"window"`
+	// replacement of the matching part of the value for the rewrite action
+	Replacement string
 }
 
 const sampleConfig = `
@@ -25,6 +49,51 @@ const sampleConfig = `
   # if Accept is set = Accept these metrics - drop others
   # Once a metric is flagged to be dropped it can't be accept by a successive filter
 
+  # With dry_run = true, the metrics are tagged with the would-be actions
+  # (filtering_action = "drop", "drop_tag", ...) instead of applying them, to
+  # validate new rules. The metrics dropped, accepted and modified by each rule
+  # are counted in the internal filtering measurement, tagged with the list and
+  # the index of the rule, the alias of the processor and an instance number
+  # distinct per running copy - telegraf runs a second copy of the processor
+  # when aggregators are configured.
+  # dry_run = false
+
+  # Action = "drop_tag" (tag rules) or "drop_field" (field rules) removes only
+  # the matching tag or field from the metric instead of dropping it
+
+  # Action = "rewrite" replaces the parts of the tag or string field value
+  # matching the pattern with the replacement, which can refer to the capture
+  # groups, e.g. mask the customer identifiers before export
+  #   key = "customer"
+  #   pattern = "^(\\w{2})\\w*$"
+  #   Action = "rewrite"
+  #   replacement = "${1}***"
+
+  # With exists = true|false instead of a pattern, a tag or field rule matches
+  # the presence or the absence of the key, e.g. drop the metrics without
+  # if_name with key = "if_name", exists = false and Action = "drop"
+
+  # With sample (fraction of the metrics kept, e.g. 0.1) or rate_limit (e.g.
+  # "100/s", "10/m"), the matching metrics of each series are thinned
+  # deterministically instead of applying the Action: a regular subset is kept
+  # and the others are dropped
+  #   sample = 0.1
+  #   rate_limit = "100/s"
+
+  # The window sub-tables restrict a rule to time windows, evaluated against the
+  # metric time: absolute RFC3339 start and end times, or daily "HH:MM" start
+  # and end times on the listed days (every day when not set)
+  #   [[processors.filtering.tags.window]]
+  #     start = "22:00"
+  #     end = "02:00"
+  #     days = ["mon", "tue", "wed", "thu", "fri"]
+  #     timezone = "Europe/Paris"
+
+  # Rules on the measurement name (no key)
+  # [[processors.filtering.measurements]]
+  #   pattern = "^/interfaces/"
+  #   Action = "drop|accept"
+
   # Only STRINGS fields are supported
   # [[processors.filtering.tags]]
   #   ## Tag to change
@@ -37,10 +106,32 @@ const sampleConfig = `
   #   key = "value"
   #   pattern = "^(\\d)\\d\\d$"
   #   Action = "drop|accept"
+
+  # Numeric fields (int, uint and float) are compared with the value using
+  # the operator gt, lt, ge, le, eq or ne instead of the pattern
+  # [[processors.filtering.fields]]
+  #   key = "temperature"
+  #   operator = "lt"
+  #   value = 0
+  #   Action = "drop"
+
+  # Groups combine tag and field conditions (same keys as the rules, without
+  # Action) with logic = "and" (default) or "or" before applying their action
+  # [[processors.filtering.group]]
+  #   logic = "and"
+  #   action = "drop"
+  #   [[processors.filtering.group.tags]]
+  #     key = "device"
+  #     pattern = "^r1$"
+  #   [[processors.filtering.group.tags]]
+  #     key = "if_name"
+  #     pattern = "^ge-.*"
 `
 func NewFiler() *Filtering {
 	return &Filtering{
 		regexCache: make(map[string]*regexp.Regexp),
+		thinning:   make(map[thinKey]*thinState),
+		hits:       make(map[hitKey]selfstat.Stat),
 	}
 }
 
@@ -52,52 +143,105 @@ func (r *Filtering) Description() string {
 	return "Filter tag and field values with Filtering pattern"
 }
 
-// Remove single item from slice
-func remove(slice []telegraf.Metric, i int) []telegraf.Metric {
-	slice[len(slice)-1], slice[i] = slice[i], slice[len(slice)-1]
-	return slice[:len(slice)-1]
-}
-
 func (r *Filtering) Apply(metrics ...telegraf.Metric) []telegraf.Metric {
 	metric_to_drop := false
-	for idx, metric := range metrics {
+	kept := metrics[:0]
+	for _, metric := range metrics {
 		metric_to_drop = false
-		for _, rule := range r.Tags {
+		for i, rule := range r.Measurements {
+			if !rule.active(metric.Time()) {
+				continue
+			}
+			if r.decide("measurements", i, rule, r.checkregex(rule, metric.Name()), metric) {
+				metric_to_drop = true
+			}
+		}
+		for i, rule := range r.Tags {
+			if !rule.active(metric.Time()) {
+				continue
+			}
+			if rule.Exists != nil {
+				// presence or absence of the tag
+				if r.decide("tags", i, rule, metric.HasTag(rule.Key) == *rule.Exists, metric) {
+					metric_to_drop = true
+				}
+				continue
+			}
 			if value, ok := metric.GetTag(rule.Key); ok {
-				if r.checkregex(rule, value) {
-					if rule.Action == "drop" {
-						metric_to_drop= true
+				if rule.Action == "drop_tag" {
+					// only the tag is removed
+					if r.checkregex(rule, value) && r.apply("tags", i, rule, metric) {
+						metric.RemoveTag(rule.Key)
 					}
-				} else {
-					if rule.Action == "accept" {
-						metric_to_drop= true
+					continue
+				}
+				if rule.Action == "rewrite" {
+					if r.checkregex(rule, value) && r.apply("tags", i, rule, metric) {
+						metric.AddTag(rule.Key, r.rewrite(rule, value))
 					}
+					continue
+				}
+				if r.decide("tags", i, rule, r.checkregex(rule, value), metric) {
+					metric_to_drop = true
 				}
 			}
 		}
-		for _, rule := range r.Fields {
+		for i, rule := range r.Fields {
+			if !rule.active(metric.Time()) {
+				continue
+			}
+			if rule.Exists != nil {
+				// presence or absence of the field
+				if r.decide("fields", i, rule, metric.HasField(rule.Key) == *rule.Exists, metric) {
+					metric_to_drop = true
+				}
+				continue
+			}
 			if value, ok := metric.GetField(rule.Key); ok {
+				if rule.Action == "drop_field" {
+					// only the field is removed
+					if r.matchField(rule, metric) && r.apply("fields", i, rule, metric) {
+						metric.RemoveField(rule.Key)
+					}
+					continue
+				}
+				if rule.Action == "rewrite" {
+					// only string fields are rewritten
+					if value, ok := value.(string); ok && r.checkregex(rule, value) && r.apply("fields", i, rule, metric) {
+						metric.AddField(rule.Key, r.rewrite(rule, value))
+					}
+					continue
+				}
+				if rule.Operator != "" {
+					if number, ok := toFloat(value); ok && r.decide("fields", i, rule, rule.compare(number), metric) {
+						metric_to_drop = true
+					}
+					continue
+				}
 				switch value := value.(type) {
 				case string:
-					if r.checkregex(rule, value) {
-						if rule.Action == "drop" {
-							metric_to_drop= true
-						}
-					} else {
-						if rule.Action == "accept" {
-							metric_to_drop= true
-						}
+					if r.decide("fields", i, rule, r.checkregex(rule, value), metric) {
+						metric_to_drop = true
 					}
 				}
 			}
 		}
 
-		if metric_to_drop {
-			metrics = remove(metrics, idx)
+		for i, g := range r.Groups {
+			if r.dropGroup(i, g, metric) {
+				metric_to_drop = true
+			}
 		}
 
+		if metric_to_drop && r.DryRun {
+			flag(metric, "drop")
+		} else if metric_to_drop {
+			metric.Drop()
+			continue
+		}
+		kept = append(kept, metric)
 	}
-	return metrics
+	return kept
 }
 
 func (r *Filtering) checkregex(c rule, src string) (bool) {
@@ -115,6 +259,67 @@ func (r *Filtering) checkregex(c rule, src string) (bool) {
 	return found
 }
 
+// apply counts an in-place action of a matching rule and returns whether it is applied - in
+// dry-run mode, the metric is only tagged with the action
+func (r *Filtering) apply(list string, i int, c rule, metric telegraf.Metric) bool {
+	r.hit(list, i, "applied")
+	if r.DryRun {
+		flag(metric, c.Action)
+		return false
+	}
+	return true
+}
+
+// rewrite replaces the parts of the value matching the pattern with the replacement, which can
+// refer to the capture groups ($1, ${name})
+func (r *Filtering) rewrite(c rule, src string) string {
+	return r.regexCache[c.Pattern].ReplaceAllString(src, c.Replacement)
+}
+
+// drop returns whether the metric is dropped by the rule: when it matches a drop rule or doesn't
+// match an accept rule
+func (c rule) drop(matched bool) bool {
+	if matched {
+		return c.Action == "drop"
+	}
+	return c.Action == "accept"
+}
+
+// compare compares the numeric field with the value of the rule according to its operator
+func (c rule) compare(value float64) bool {
+	threshold, ok := toFloat(c.Value)
+	if !ok {
+		return false
+	}
+	switch c.Operator {
+	case "gt":
+		return value > threshold
+	case "lt":
+		return value < threshold
+	case "ge":
+		return value >= threshold
+	case "le":
+		return value <= threshold
+	case "eq":
+		return value == threshold
+	case "ne":
+		return value != threshold
+	}
+	return false
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
 func init() {
 	processors.Add("filtering", func() telegraf.Processor {
 		return NewFiler()
@@ -0,0 +1,395 @@
+package filtering
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/metric"
+	"github.com/stretchr/testify/require"
+)
+
+func newFiltering(t *testing.T, setup func(r *Filtering)) *Filtering {
+	r := NewFiler()
+	setup(r)
+	require.NoError(t, r.Init())
+	return r
+}
+
+func interfaceMetric(ifName string, fields map[string]interface{}) telegraf.Metric {
+	return metric.New("interface",
+		map[string]string{"device": "r1", "if_name": ifName},
+		fields,
+		time.Unix(0, 0),
+	)
+}
+
+// names returns the interface names of the metrics kept
+func names(metrics []telegraf.Metric) []string {
+	out := make([]string, 0, len(metrics))
+	for _, m := range metrics {
+		name, _ := m.GetTag("if_name")
+		out = append(out, name)
+	}
+	return out
+}
+
+// Several metrics of the same Apply are dropped, the others are kept in order
+func TestDropSeveral(t *testing.T) {
+	r := newFiltering(t, func(r *Filtering) {
+		r.Tags = []rule{{Key: "if_name", Pattern: "^ge-", Action: "drop"}}
+	})
+	out := r.Apply(
+		interfaceMetric("ge-0/0/0", nil),
+		interfaceMetric("et-0/0/0", nil),
+		interfaceMetric("ge-0/0/1", nil),
+		interfaceMetric("ge-0/0/2", nil),
+		interfaceMetric("et-0/0/1", nil),
+	)
+	require.Equal(t, []string{"et-0/0/0", "et-0/0/1"}, names(out))
+}
+
+// In dry-run mode, the metrics are tagged with the would-be actions and left untouched
+func TestDryRun(t *testing.T) {
+	r := newFiltering(t, func(r *Filtering) {
+		r.DryRun = true
+		r.Tags = []rule{
+			{Key: "if_name", Pattern: "^ge-", Action: "drop"},
+			{Key: "device", Pattern: ".*", Action: "drop_tag"},
+			{Key: "if_name", Pattern: "^ge-", Action: "rewrite", Replacement: "ge"},
+		}
+		r.Fields = []rule{{Key: "in_errors", Operator: "eq", Value: int64(0), Action: "drop_field"}}
+	})
+	out := r.Apply(
+		interfaceMetric("ge-0/0/0", map[string]interface{}{"in_errors": int64(0)}),
+		interfaceMetric("et-0/0/0", map[string]interface{}{"in_errors": int64(1)}),
+	)
+	require.Len(t, out, 2)
+	require.Equal(t, map[string]string{"device": "r1", "if_name": "ge-0/0/0", "filtering_action": "drop_tag,rewrite,drop_field,drop"}, out[0].Tags())
+	require.Equal(t, map[string]interface{}{"in_errors": int64(0)}, out[0].Fields())
+	require.Equal(t, map[string]string{"device": "r1", "if_name": "et-0/0/0", "filtering_action": "drop_tag"}, out[1].Tags())
+}
+
+// The decisions are counted per rule, with distinct counters for each running copy
+func TestHitCounters(t *testing.T) {
+	setup := func(r *Filtering) {
+		r.Alias = "edge"
+		r.Tags = []rule{
+			{Key: "if_name", Pattern: "^ge-", Action: "drop"},
+			{Key: "if_name", Pattern: "^et-", Action: "accept"},
+			{Key: "device", Pattern: "^r1$", Action: "rewrite", Replacement: "core-r1"},
+		}
+	}
+	first, second := newFiltering(t, setup), newFiltering(t, setup)
+	first.Apply(interfaceMetric("ge-0/0/0", nil), interfaceMetric("et-0/0/0", nil), interfaceMetric("et-0/0/1", nil))
+	second.Apply(interfaceMetric("ge-0/0/0", nil))
+
+	dropped := first.hits[hitKey{list: "tags", rule: 0, name: "dropped"}]
+	require.Equal(t, int64(1), dropped.Get())
+	require.Equal(t, int64(2), first.hits[hitKey{list: "tags", rule: 1, name: "accepted"}].Get())
+	require.Equal(t, int64(3), first.hits[hitKey{list: "tags", rule: 2, name: "applied"}].Get())
+	require.Equal(t, "tags", dropped.Tags()["list"])
+	require.Equal(t, "0", dropped.Tags()["rule"])
+	require.Equal(t, "edge", dropped.Tags()["alias"])
+
+	other := second.hits[hitKey{list: "tags", rule: 0, name: "dropped"}]
+	require.Equal(t, int64(1), other.Get())
+	require.NotEqual(t, dropped.Tags()["instance"], other.Tags()["instance"])
+}
+
+func TestOperators(t *testing.T) {
+	tests := []struct {
+		operator string
+		value    interface{}
+		field    interface{}
+		dropped  bool
+	}{
+		{operator: "lt", value: int64(0), field: float64(-4.5), dropped: true},
+		{operator: "lt", value: int64(0), field: float64(0)},
+		{operator: "le", value: int64(0), field: int64(0), dropped: true},
+		{operator: "gt", value: float64(100), field: uint64(101), dropped: true},
+		{operator: "gt", value: float64(100), field: uint64(100)},
+		{operator: "ge", value: float64(100), field: int64(100), dropped: true},
+		{operator: "eq", value: int64(0), field: uint64(0), dropped: true},
+		{operator: "eq", value: int64(0), field: float64(0.1)},
+		{operator: "ne", value: int64(0), field: int64(1), dropped: true},
+		// the non numeric fields and values never match
+		{operator: "eq", value: int64(0), field: "0"},
+		{operator: "eq", value: "0", field: int64(0)},
+		{operator: "between", value: int64(0), field: int64(0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.operator, func(t *testing.T) {
+			r := newFiltering(t, func(r *Filtering) {
+				r.Fields = []rule{{Key: "speed", Operator: tt.operator, Value: tt.value, Action: "drop"}}
+			})
+			out := r.Apply(interfaceMetric("et-0/0/0", map[string]interface{}{"speed": tt.field}))
+			require.Equal(t, tt.dropped, len(out) == 0)
+		})
+	}
+}
+
+// An accept rule with an operator drops the metrics outside of the range
+func TestOperatorAccept(t *testing.T) {
+	r := newFiltering(t, func(r *Filtering) {
+		r.Fields = []rule{{Key: "temperature", Operator: "gt", Value: int64(0), Action: "accept"}}
+	})
+	out := r.Apply(
+		interfaceMetric("et-0/0/0", map[string]interface{}{"temperature": int64(40)}),
+		interfaceMetric("et-0/0/1", map[string]interface{}{"temperature": int64(-273)}),
+		interfaceMetric("et-0/0/2", map[string]interface{}{"in_octets": int64(1)}),
+	)
+	require.Equal(t, []string{"et-0/0/0", "et-0/0/2"}, names(out))
+}
+
+func TestGroups(t *testing.T) {
+	device := rule{Key: "device", Pattern: "^r1$"}
+	ge := rule{Key: "if_name", Pattern: "^ge-"}
+	down := rule{Key: "oper_status", Pattern: "^down$"}
+	tests := []struct {
+		name     string
+		group    group
+		expected []string
+	}{
+		{
+			name:     "and",
+			group:    group{Action: "drop", Tags: []rule{device, ge}},
+			expected: []string{"et-0/0/0"},
+		},
+		{
+			name:     "and with a field",
+			group:    group{Logic: "and", Action: "drop", Tags: []rule{ge}, Fields: []rule{down}},
+			expected: []string{"ge-0/0/0", "et-0/0/0"},
+		},
+		{
+			name:     "or",
+			group:    group{Logic: "OR", Action: "drop", Tags: []rule{ge}, Fields: []rule{down}},
+			expected: []string{"et-0/0/0"},
+		},
+		{
+			name:     "numeric and missing conditions",
+			group:    group{Logic: "or", Action: "drop", Fields: []rule{{Key: "speed", Operator: "eq", Value: int64(0)}}, Tags: []rule{{Key: "role", Pattern: ".*"}}},
+			expected: []string{"ge-0/0/0", "et-0/0/0"},
+		},
+		{
+			name:     "accept",
+			group:    group{Action: "accept", Tags: []rule{device, ge}},
+			expected: []string{"ge-0/0/0", "ge-0/0/1"},
+		},
+		{
+			name:     "without condition",
+			group:    group{Action: "accept"},
+			expected: []string{"ge-0/0/0", "ge-0/0/1", "et-0/0/0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newFiltering(t, func(r *Filtering) {
+				r.Groups = []group{tt.group}
+			})
+			out := r.Apply(
+				interfaceMetric("ge-0/0/0", map[string]interface{}{"oper_status": "up", "speed": int64(1000)}),
+				interfaceMetric("ge-0/0/1", map[string]interface{}{"oper_status": "down", "speed": int64(0)}),
+				interfaceMetric("et-0/0/0", map[string]interface{}{"oper_status": "up", "speed": int64(100000)}),
+			)
+			require.Equal(t, tt.expected, names(out))
+		})
+	}
+}
+
+func TestMeasurementRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     rule
+		expected []string
+	}{
+		{name: "drop", rule: rule{Pattern: "^/interfaces/", Action: "drop"}, expected: []string{"/components/component/state", "interface"}},
+		{name: "accept", rule: rule{Pattern: "^/interfaces/", Action: "accept"}, expected: []string{"/interfaces/interface/state/counters"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newFiltering(t, func(r *Filtering) {
+				r.Measurements = []rule{tt.rule}
+			})
+			var in []telegraf.Metric
+			for _, name := range []string{"/interfaces/interface/state/counters", "/components/component/state", "interface"} {
+				in = append(in, metric.New(name, map[string]string{"device": "r1"}, map[string]interface{}{"value": int64(1)}, time.Unix(0, 0)))
+			}
+			var out []string
+			for _, m := range r.Apply(in...) {
+				out = append(out, m.Name())
+			}
+			require.Equal(t, tt.expected, out)
+		})
+	}
+}
+
+// drop_field and drop_tag remove only the matching field or tag, the metric is kept
+func TestDropFieldAndTag(t *testing.T) {
+	r := newFiltering(t, func(r *Filtering) {
+		r.Tags = []rule{{Key: "if_name", Pattern: "^ge-", Action: "drop_tag"}}
+		r.Fields = []rule{
+			{Key: "description", Pattern: ".*", Action: "drop_field"},
+			{Key: "in_errors", Operator: "eq", Value: int64(0), Action: "drop_field"},
+		}
+	})
+	out := r.Apply(
+		interfaceMetric("ge-0/0/0", map[string]interface{}{"description": "to customer", "in_errors": int64(0), "in_octets": int64(10)}),
+		interfaceMetric("et-0/0/0", map[string]interface{}{"description": "core", "in_errors": int64(2)}),
+	)
+	require.Len(t, out, 2)
+	require.Equal(t, map[string]string{"device": "r1"}, out[0].Tags())
+	require.Equal(t, map[string]interface{}{"in_octets": int64(10)}, out[0].Fields())
+	require.Equal(t, map[string]string{"device": "r1", "if_name": "et-0/0/0"}, out[1].Tags())
+	require.Equal(t, map[string]interface{}{"in_errors": int64(2)}, out[1].Fields())
+}
+
+func TestSample(t *testing.T) {
+	r := newFiltering(t, func(r *Filtering) {
+		r.Tags = []rule{{Key: "if_name", Pattern: "^ge-", Sample: 0.25}}
+	})
+	// a regular subset of each series is kept, the other series are left untouched
+	var kept []string
+	for i := 0; i < 8; i++ {
+		kept = append(kept, names(r.Apply(interfaceMetric("ge-0/0/0", nil), interfaceMetric("ge-0/0/1", nil), interfaceMetric("et-0/0/0", nil)))...)
+	}
+	require.Equal(t, []string{
+		"et-0/0/0", "et-0/0/0", "et-0/0/0",
+		"ge-0/0/0", "ge-0/0/1", "et-0/0/0",
+		"et-0/0/0", "et-0/0/0", "et-0/0/0",
+		"ge-0/0/0", "ge-0/0/1", "et-0/0/0",
+	}, kept)
+	require.Equal(t, int64(12), r.hits[hitKey{list: "tags", rule: 0, name: "dropped"}].Get())
+}
+
+func TestRateLimit(t *testing.T) {
+	r := newFiltering(t, func(r *Filtering) {
+		r.Fields = []rule{{Key: "queue", Pattern: ".*", RateLimit: "2/m"}}
+	})
+	at := func(seconds int64) telegraf.Metric {
+		return metric.New("queue", map[string]string{"device": "r1"}, map[string]interface{}{"queue": "q0"}, time.Unix(seconds, 0))
+	}
+	var kept []int64
+	for _, seconds := range []int64{0, 10, 20, 59, 60, 70, 80, 200} {
+		for _, m := range r.Apply(at(seconds)) {
+			kept = append(kept, m.Time().Unix())
+		}
+	}
+	// the limit applies per minute of metric time
+	require.Equal(t, []int64{0, 10, 60, 70, 200}, kept)
+}
+
+func TestInvalidRateLimit(t *testing.T) {
+	for _, limit := range []string{"100", "100/d", "x/s", "-1/s"} {
+		r := NewFiler()
+		r.Tags = []rule{{Key: "if_name", Pattern: ".*", RateLimit: limit}}
+		require.Error(t, r.Init(), limit)
+	}
+}
+
+func TestExists(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name     string
+		tags     []rule
+		fields   []rule
+		expected []string
+	}{
+		{name: "drop without tag", tags: []rule{{Key: "if_name", Exists: &no, Action: "drop"}}, expected: []string{"ge-0/0/0", "et-0/0/0"}},
+		{name: "drop with tag", tags: []rule{{Key: "if_name", Exists: &yes, Action: "drop"}}, expected: []string{""}},
+		{name: "accept with field", fields: []rule{{Key: "in_errors", Exists: &yes, Action: "accept"}}, expected: []string{"ge-0/0/0"}},
+		{name: "drop without field", fields: []rule{{Key: "in_errors", Exists: &no, Action: "drop"}}, expected: []string{"ge-0/0/0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newFiltering(t, func(r *Filtering) {
+				r.Tags, r.Fields = tt.tags, tt.fields
+			})
+			out := r.Apply(
+				interfaceMetric("ge-0/0/0", map[string]interface{}{"in_errors": int64(0)}),
+				interfaceMetric("et-0/0/0", map[string]interface{}{"in_octets": int64(0)}),
+				metric.New("interface", map[string]string{"device": "r1"}, map[string]interface{}{"in_octets": int64(0)}, time.Unix(0, 0)),
+			)
+			require.Equal(t, tt.expected, names(out))
+		})
+	}
+}
+
+func TestWindows(t *testing.T) {
+	tests := []struct {
+		name   string
+		window window
+		// UTC times of the metrics, on monday 2024-01-01 and the following days
+		times    []string
+		expected []bool
+	}{
+		{
+			name:     "absolute",
+			window:   window{Start: "2024-01-01T10:00:00Z", End: "2024-01-01T12:00:00Z"},
+			times:    []string{"2024-01-01T09:59:59Z", "2024-01-01T10:00:00Z", "2024-01-01T11:59:59Z", "2024-01-01T12:00:00Z"},
+			expected: []bool{false, true, true, false},
+		},
+		{
+			name:     "daily",
+			window:   window{Start: "02:00", End: "04:30"},
+			times:    []string{"2024-01-01T01:59:00Z", "2024-01-01T02:00:00Z", "2024-01-03T04:29:59Z", "2024-01-06T04:30:00Z"},
+			expected: []bool{false, true, true, false},
+		},
+		{
+			// the window of friday night ends on saturday morning, the one of sunday night doesn't exist
+			name:     "crossing midnight on days",
+			window:   window{Start: "22:00", End: "02:00", Days: []string{"monday", "tue", "wed", "thu", "fri"}},
+			times:    []string{"2024-01-05T23:00:00Z", "2024-01-06T01:00:00Z", "2024-01-06T23:00:00Z", "2024-01-08T01:00:00Z", "2024-01-08T22:00:00Z", "2024-01-08T03:00:00Z"},
+			expected: []bool{true, true, false, false, true, false},
+		},
+		{
+			name:     "timezone",
+			window:   window{Start: "22:00", End: "02:00", Timezone: "Europe/Paris"},
+			times:    []string{"2024-01-01T20:59:00Z", "2024-01-01T21:00:00Z", "2024-01-02T00:59:00Z", "2024-01-02T01:00:00Z"},
+			expected: []bool{false, true, true, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newFiltering(t, func(r *Filtering) {
+				r.Tags = []rule{{Key: "if_name", Pattern: ".*", Action: "drop", Windows: []window{tt.window}}}
+			})
+			for i, s := range tt.times {
+				tm, err := time.Parse(time.RFC3339, s)
+				require.NoError(t, err)
+				out := r.Apply(metric.New("optics", map[string]string{"if_name": "et-0/0/0"}, map[string]interface{}{"rx_power": -3.2}, tm))
+				require.Equal(t, tt.expected[i], len(out) == 0, s)
+			}
+		})
+	}
+}
+
+func TestInvalidWindows(t *testing.T) {
+	for _, w := range []window{
+		{Start: "2024-01-01T10:00:00Z", End: "12:00"},
+		{Start: "25:00", End: "02:00"},
+		{Start: "22:00", End: "02:00", Days: []string{"someday"}},
+		{Start: "22:00", End: "02:00", Timezone: "Mars/Olympus"},
+	} {
+		r := NewFiler()
+		r.Fields = []rule{{Key: "rx_power", Operator: "lt", Value: int64(-30), Action: "drop", Windows: []window{w}}}
+		require.Error(t, r.Init(), w.Start)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	r := newFiltering(t, func(r *Filtering) {
+		r.Tags = []rule{{Key: "customer", Pattern: `^(\w{2})\w*$`, Action: "rewrite", Replacement: "${1}***"}}
+		r.Fields = []rule{
+			{Key: "description", Pattern: `cust-\d+`, Action: "rewrite", Replacement: "cust-X"},
+			{Key: "in_octets", Pattern: ".*", Action: "rewrite", Replacement: "0"},
+		}
+	})
+	m := interfaceMetric("ge-0/0/0", map[string]interface{}{"description": "to cust-42 and cust-7", "in_octets": int64(10)})
+	m.AddTag("customer", "acme")
+	out := r.Apply(m)
+	require.Len(t, out, 1)
+	require.Equal(t, map[string]string{"device": "r1", "if_name": "ge-0/0/0", "customer": "ac***"}, out[0].Tags())
+	// only the string fields are rewritten
+	require.Equal(t, map[string]interface{}{"description": "to cust-X and cust-X", "in_octets": int64(10)}, out[0].Fields())
+}
@@ -0,0 +1,67 @@
+package filtering
+
+import (
+	"strings"
+
+	"github.com/influxdata/telegraf"
+)
+
+// group combines several tag and field conditions with "and" (default) or "or" before applying
+// its action
+type group struct {
+	Logic  string
+	Action string
+	Tags   []rule
+	Fields []rule
+}
+
+// dropGroup returns whether the metric is dropped by the group - a group without condition
+// never matches
+func (r *Filtering) dropGroup(i int, g group, metric telegraf.Metric) bool {
+	if len(g.Tags) == 0 && len(g.Fields) == 0 {
+		return false
+	}
+	conditions := make([]bool, 0, len(g.Tags)+len(g.Fields))
+	for _, c := range g.Tags {
+		if c.Exists != nil {
+			conditions = append(conditions, metric.HasTag(c.Key) == *c.Exists)
+			continue
+		}
+		value, ok := metric.GetTag(c.Key)
+		conditions = append(conditions, ok && r.checkregex(c, value))
+	}
+	for _, c := range g.Fields {
+		conditions = append(conditions, r.matchField(c, metric))
+	}
+
+	// "and": all the conditions match, "or": any of them
+	or := strings.ToLower(g.Logic) == "or"
+	matched := !or
+	for _, m := range conditions {
+		if m == or {
+			matched = or
+			break
+		}
+	}
+	dropped := rule{Action: g.Action}.drop(matched)
+	r.count("group", i, g.Action, matched, dropped)
+	return dropped
+}
+
+// matchField reports whether the field of the metric matches the condition: its presence or
+// absence, the numeric comparison with the operator, else the pattern for the string fields
+func (r *Filtering) matchField(c rule, metric telegraf.Metric) bool {
+	if c.Exists != nil {
+		return metric.HasField(c.Key) == *c.Exists
+	}
+	value, ok := metric.GetField(c.Key)
+	if !ok {
+		return false
+	}
+	if c.Operator != "" {
+		number, ok := toFloat(value)
+		return ok && c.compare(number)
+	}
+	s, ok := value.(string)
+	return ok && r.checkregex(c, s)
+}
@@ -0,0 +1,63 @@
+package filtering
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/instance"
+	"github.com/influxdata/telegraf/selfstat"
+)
+
+// dryRunTag lists the actions which would have been applied to the metric in dry-run mode
+const dryRunTag = "filtering_action"
+
+// hitKey identifies a hit counter of a rule
+type hitKey struct {
+	list string
+	rule int
+	name string
+}
+
+// hit increments the counter of the rule: dropped, accepted or applied (in-place actions)
+func (r *Filtering) hit(list string, i int, name string) {
+	key := hitKey{list: list, rule: i, name: name}
+	stat, ok := r.hits[key]
+	if !ok {
+		if r.statTags == nil {
+			r.statTags = instance.Tags(r.Alias)
+		}
+		tags := map[string]string{"list": list, "rule": strconv.Itoa(i)}
+		for k, v := range r.statTags {
+			tags[k] = v
+		}
+		stat = selfstat.Register("filtering", name, tags)
+		r.hits[key] = stat
+	}
+	stat.Incr(1)
+}
+
+// count records the decision of a drop or accept rule
+func (r *Filtering) count(list string, i int, action string, matched, dropped bool) {
+	switch {
+	case dropped:
+		r.hit(list, i, "dropped")
+	case matched && action == "accept":
+		r.hit(list, i, "accepted")
+	}
+}
+
+// flag adds the action to the dry-run tag of the metric
+func flag(metric telegraf.Metric, action string) {
+	actions, ok := metric.GetTag(dryRunTag)
+	if !ok {
+		metric.AddTag(dryRunTag, action)
+		return
+	}
+	for _, a := range strings.Split(actions, ",") {
+		if a == action {
+			return
+		}
+	}
+	metric.AddTag(dryRunTag, actions+","+action)
+}
@@ -0,0 +1,126 @@
+package filtering
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+// thinKey is a series matched by a rule
+type thinKey struct {
+	list string
+	rule int
+	id   uint64
+}
+
+// thinState counts the metrics of a series matched by a sampling or rate limiting rule
+type thinState struct {
+	count  uint64
+	window time.Time
+	kept   int
+	last   time.Time
+}
+
+// decide returns whether the metric is dropped by the rule: the action applied to the match, or
+// the thinning of the matching metrics with sample or rate_limit
+func (r *Filtering) decide(list string, i int, c rule, matched bool, metric telegraf.Metric) bool {
+	if c.Sample == 0 && c.RateLimit == "" {
+		dropped := c.drop(matched)
+		r.count(list, i, c.Action, matched, dropped)
+		return dropped
+	}
+	if !matched {
+		return false
+	}
+	if time.Since(r.cleaned) > time.Minute {
+		r.cleanup()
+	}
+	key := thinKey{list: list, rule: i, id: metric.HashID()}
+	state, ok := r.thinning[key]
+	if !ok {
+		state = &thinState{}
+		r.thinning[key] = state
+	}
+	state.last = time.Now()
+	if (c.Sample > 0 && !state.sample(c.Sample)) || (c.RateLimit != "" && !state.limit(c.RateLimit, metric.Time())) {
+		r.hit(list, i, "dropped")
+		return true
+	}
+	return false
+}
+
+// sample keeps the fraction of the metrics evenly: the metric n is kept when floor(n*fraction)
+// increments
+func (s *thinState) sample(fraction float64) bool {
+	s.count++
+	return math.Floor(float64(s.count)*fraction) > math.Floor(float64(s.count-1)*fraction)
+}
+
+// limit keeps at most N metrics per window of the rate limit, by the metric time
+func (s *thinState) limit(rateLimit string, tm time.Time) bool {
+	limit, window, ok := parseRateLimit(rateLimit)
+	if !ok {
+		return true
+	}
+	if tm.Sub(s.window) >= window || tm.Before(s.window) {
+		s.window = tm.Truncate(window)
+		s.kept = 0
+	}
+	if s.kept >= limit {
+		return false
+	}
+	s.kept++
+	return true
+}
+
+// parseRateLimit parses N/s, N/m or N/h
+func parseRateLimit(rateLimit string) (int, time.Duration, bool) {
+	parts := strings.SplitN(rateLimit, "/", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || limit < 0 {
+		return 0, 0, false
+	}
+	switch strings.TrimSpace(parts[1]) {
+	case "s":
+		return limit, time.Second, true
+	case "m":
+		return limit, time.Minute, true
+	case "h":
+		return limit, time.Hour, true
+	}
+	return 0, 0, false
+}
+
+// Init checks the rate limits and compiles the time windows of the rules
+func (r *Filtering) Init() error {
+	for _, rules := range [][]rule{r.Measurements, r.Tags, r.Fields} {
+		for _, c := range rules {
+			if _, _, ok := parseRateLimit(c.RateLimit); c.RateLimit != "" && !ok {
+				return fmt.Errorf("invalid rate_limit %q, expected N/s, N/m or N/h", c.RateLimit)
+			}
+			for i := range c.Windows {
+				if err := c.Windows[i].compile(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// cleanup forgets the series not seen for 10 minutes
+func (r *Filtering) cleanup() {
+	r.cleaned = time.Now()
+	for key, state := range r.thinning {
+		if r.cleaned.Sub(state.last) > 10*time.Minute {
+			delete(r.thinning, key)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package filtering
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// window is a time window during which a rule is active: absolute RFC3339 start and end times, or
+// a daily "HH:MM" start and end (crossing midnight when the end is before the start) on the
+// listed days (every day when empty), in the timezone (UTC by default)
+type window struct {
+	Start    string
+	End      string
+	Days     []string
+	Timezone string
+
+	absolute bool
+	from, to time.Time
+	daily    [2]time.Duration
+	days     map[time.Weekday]bool
+	location *time.Location
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// compile parses the times of the window
+func (w *window) compile() error {
+	w.location = time.UTC
+	if w.Timezone != "" {
+		location, err := time.LoadLocation(w.Timezone)
+		if err != nil {
+			return err
+		}
+		w.location = location
+	}
+	if from, err := time.Parse(time.RFC3339, w.Start); err == nil {
+		to, err := time.Parse(time.RFC3339, w.End)
+		if err != nil {
+			return fmt.Errorf("invalid window end %q: %v", w.End, err)
+		}
+		w.absolute, w.from, w.to = true, from, to
+		return nil
+	}
+	for i, s := range []string{w.Start, w.End} {
+		t, err := time.Parse("15:04", s)
+		if err != nil {
+			return fmt.Errorf("invalid window time %q, expected RFC3339 or HH:MM", s)
+		}
+		w.daily[i] = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	if len(w.Days) > 0 {
+		w.days = make(map[time.Weekday]bool)
+		for _, d := range w.Days {
+			// the days are matched on their first three letters
+			name := strings.ToLower(d)
+			if len(name) > 3 {
+				name = name[:3]
+			}
+			day, ok := weekdays[name]
+			if !ok {
+				return fmt.Errorf("invalid window day %q", d)
+			}
+			w.days[day] = true
+		}
+	}
+	return nil
+}
+
+// contains reports whether the time is within the window. A daily window crossing midnight
+// belongs to the day it starts.
+func (w *window) contains(tm time.Time) bool {
+	if w.absolute {
+		return !tm.Before(w.from) && tm.Before(w.to)
+	}
+	tm = tm.In(w.location)
+	offset := time.Duration(tm.Hour())*time.Hour + time.Duration(tm.Minute())*time.Minute + time.Duration(tm.Second())*time.Second
+	start, end := w.daily[0], w.daily[1]
+	day := tm.Weekday()
+	switch {
+	case start <= end:
+		if offset < start || offset >= end {
+			return false
+		}
+	case offset >= start:
+	case offset < end:
+		// after midnight: the window started the day before
+		day = (day + 6) % 7
+	default:
+		return false
+	}
+	return w.days == nil || w.days[day]
+}
+
+// active reports whether the rule applies at the time of the metric: always without window
+func (c rule) active(tm time.Time) bool {
+	if len(c.Windows) == 0 {
+		return true
+	}
+	for i := range c.Windows {
+		if c.Windows[i].contains(tm) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,219 @@
+package Monitoring
+
+import (
+	"hash/fnv"
+	"strconv"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/metric"
+)
+
+// alarmState is an active alarm of a probe on a series
+type alarmState struct {
+	probe    Probe
+	severity string
+	since    time.Time
+	last     time.Time
+	notified time.Time
+	// tags and value of the last alarm
+	tags  map[string]string
+	value interface{}
+	// with a rearm delay, the alarm is cleared once the value stays clear for the delay - the
+	// alarms raised again in the meantime are counted as flaps
+	clearing time.Time
+	flaps    int64
+}
+
+// rearm returns whether the clear of an active alarm is held until the rearm delay of the probe
+// elapses since the value went clear
+func rearm(probe Probe, state *alarmState, tm time.Time) bool {
+	delay, _ := time.ParseDuration(probe.RearmDelay)
+	if delay <= 0 {
+		return false
+	}
+	if state.clearing.IsZero() {
+		state.clearing = tm
+	}
+	return tm.Sub(state.clearing) < delay
+}
+
+// notify reports whether an active alarm is emitted: on every sample, or only every renotify
+// interval when configured
+func notify(probe Probe, state *alarmState, tm time.Time) bool {
+	renotify, _ := time.ParseDuration(probe.Renotify)
+	if renotify <= 0 {
+		return true
+	}
+	if !state.notified.IsZero() && tm.Sub(state.notified) < renotify {
+		return false
+	}
+	state.notified = tm
+	return true
+}
+
+// Level is a threshold of a probe with its severity
+type Level struct {
+	Severity  string  `toml:"severity"`
+	Threshold float64 `toml:"threshold"`
+}
+
+// level returns the most severe level violated by the value - the levels are listed from the
+// least to the most severe. Without levels, the threshold of the probe is used.
+func (probe Probe) level(value float64) (Level, bool) {
+	if len(probe.Levels) == 0 {
+		return Level{Threshold: probe.Threshold}, compare(probe.Operator, value, probe.Threshold)
+	}
+	for i := len(probe.Levels) - 1; i >= 0; i-- {
+		if compare(probe.Operator, value, probe.Levels[i].Threshold) {
+			return probe.Levels[i], true
+		}
+	}
+	return Level{}, false
+}
+
+// alarmID identifies the alarm of a probe on a series
+func alarmID(probe Probe, id uint64) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(probe.AlarmName + "/" + probe.Field + "/" + strconv.FormatUint(id, 10)))
+	return h.Sum64()
+}
+
+// violation counts the consecutive samples violating the threshold of a probe on a series
+type violation struct {
+	count int
+	since time.Time
+	last  time.Time
+}
+
+// confirmed reports whether the threshold is violated for for_samples consecutive samples and
+// for at least for_duration
+func (p *Monitoring) confirmed(probe Probe, key uint64, raised bool, tm time.Time) bool {
+	forDuration, _ := time.ParseDuration(probe.ForDuration)
+	if probe.ForSamples <= 1 && forDuration <= 0 {
+		return raised
+	}
+	if !raised {
+		delete(p.violations, key)
+		return false
+	}
+	v, ok := p.violations[key]
+	if !ok {
+		v = &violation{since: tm}
+		p.violations[key] = v
+	}
+	v.count++
+	v.last = tm
+	if v.count < probe.ForSamples || tm.Sub(v.since) < forDuration {
+		logPrintf("Threshold reached for field %s since %v samples - not confirmed yet", probe.Field, v.count)
+		return false
+	}
+	return true
+}
+
+// evaluate compares the probe value with the threshold and returns the Monitoring metrics. With a
+// clear threshold, the alarm stays active until the value recrosses it and a clear metric is emitted.
+func (p *Monitoring) evaluate(probe Probe, id uint64, value float64, a compute, holds []bool) []telegraf.Metric {
+	key := alarmID(probe, id)
+	level, raised := probe.level(value)
+	if raised {
+		logPrintf("Threshold reached for field %s. %f %s %f", probe.Field, value, probe.Operator, level.Threshold)
+	}
+	raised = p.confirmed(probe, key, combine(probe, raised, holds), a.tm)
+	alarm := func(state *alarmState) telegraf.Metric {
+		newAlarm := p.newAlarm(probe, a, value)
+		if state.severity != "" {
+			newAlarm.AddTag("severity", state.severity)
+		}
+		if probe.RearmDelay != "" {
+			newAlarm.AddField("flaps", state.flaps)
+		}
+		return newAlarm
+	}
+	// the alarms are active while the threshold is violated - with a clear threshold, until the
+	// value recrosses it. The missing probes always emit a clear when the series is seen again.
+	clearThreshold := probe.ClearThreshold
+	if clearThreshold == nil && probe.ProbeType == "missing" {
+		clearThreshold = &probe.Threshold
+	}
+	hysteresis := clearThreshold != nil
+	state, active := p.alarms[key]
+	switch {
+	case raised:
+		flapped := false
+		if !active {
+			state = &alarmState{probe: probe, since: a.tm}
+			p.alarms[key] = state
+		} else if !state.clearing.IsZero() {
+			// raised again during the rearm delay: a flap, the alarm is still active
+			state.clearing = time.Time{}
+			state.flaps++
+			flapped = true
+		}
+		state.last = a.tm
+		state.severity = level.Severity
+		newAlarm := alarm(state)
+		state.tags = newAlarm.Tags()
+		state.value, _ = newAlarm.GetField("exception")
+		if flapped {
+			logPrintf("Alarm %s flapping (%v flaps) - notification suppressed", probe.AlarmName, state.flaps)
+			return nil
+		}
+		if !notify(probe, state, a.tm) {
+			logPrintf("Alarm %s still active - notification suppressed until the renotify interval", probe.AlarmName)
+			return nil
+		}
+		if hysteresis {
+			newAlarm.AddTag("state", "raised")
+		}
+		return []telegraf.Metric{newAlarm}
+	case active && hysteresis && !compare(probe.Operator, value, *clearThreshold):
+		logPrintf("Clear threshold crossed for field %s. %f", probe.Field, value)
+		if rearm(probe, state, a.tm) {
+			state.last = a.tm
+			return nil
+		}
+		delete(p.alarms, key)
+		cleared := alarm(state)
+		cleared.AddTag("state", "cleared")
+		return []telegraf.Metric{cleared}
+	case active && hysteresis:
+		// between the clear threshold and the threshold - still active
+		state.last = a.tm
+		state.clearing = time.Time{}
+	case active:
+		if rearm(probe, state, a.tm) {
+			state.last = a.tm
+			return nil
+		}
+		delete(p.alarms, key)
+	}
+	return nil
+}
+
+// activeAlarms returns one metric per active alarm with its age, every active_alarms_interval
+func (p *Monitoring) activeAlarms() []telegraf.Metric {
+	interval, _ := time.ParseDuration(p.ActiveAlarmsInterval)
+	if interval <= 0 || time.Since(p.active_emitted) < interval {
+		return nil
+	}
+	p.active_emitted = time.Now()
+	name := p.ActiveAlarmsMeasurement
+	if name == "" {
+		name = p.Measurement + "_ACTIVE"
+	}
+	active := make([]telegraf.Metric, 0, len(p.alarms))
+	for _, state := range p.alarms {
+		fields := map[string]interface{}{
+			"age":       int64(p.active_emitted.Sub(state.since).Seconds()),
+			"exception": state.value,
+		}
+		m := metric.New(name, state.tags, fields, p.active_emitted)
+		if state.severity != "" {
+			m.AddTag("severity", state.severity)
+		}
+		active = append(active, m)
+	}
+	logPrintf("%v active alarms", len(active))
+	return active
+}
@@ -0,0 +1,44 @@
+package Monitoring
+
+import (
+	"math"
+	"time"
+)
+
+// baseline is the exponentially weighted mean and variance of a field of a series
+type baseline struct {
+	count    int
+	mean     float64
+	variance float64
+	last     time.Time
+}
+
+// anomaly returns the deviation of the value from the baseline of the series in standard
+// deviations, then adds the value to the baseline. Nothing is returned while the baseline has
+// less than anomaly_samples samples or no variance.
+func (p *Monitoring) anomaly(probe Probe, id uint64, value float64, tm time.Time) (float64, bool) {
+	logPrintf("Mode Anomaly")
+	samples := probe.AnomalySamples
+	if samples <= 1 {
+		samples = 30
+	}
+	key := alarmID(probe, id)
+	b, ok := p.baselines[key]
+	if !ok {
+		b = &baseline{mean: value}
+		p.baselines[key] = b
+	}
+
+	deviation, valid := 0.0, false
+	if b.count >= samples && b.variance > 0 {
+		deviation, valid = math.Abs(value-b.mean)/math.Sqrt(b.variance), true
+	}
+
+	alpha := 2 / (float64(samples) + 1)
+	diff := value - b.mean
+	b.mean += alpha * diff
+	b.variance = (1 - alpha) * (b.variance + alpha*diff*diff)
+	b.count++
+	b.last = tm
+	return deviation, valid
+}
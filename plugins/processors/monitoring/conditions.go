@@ -0,0 +1,68 @@
+package Monitoring
+
+import (
+	"strings"
+
+	"github.com/influxdata/telegraf"
+)
+
+// Condition is an additional condition of a probe on another field of the same metric
+type Condition struct {
+	Field     string  `toml:"field"`
+	ProbeType string  `toml:"probe_type"`
+	Operator  string  `toml:"operator"`
+	Threshold float64 `toml:"threshold"`
+	// compared with a string field with the operators eq and ne
+	Value string `toml:"value"`
+}
+
+// conditions evaluates the additional conditions of a probe on the metric
+func (p *Monitoring) conditions(probe Probe, m telegraf.Metric, last compute, cached bool, a compute) []bool {
+	holds := make([]bool, 0, len(probe.Conditions))
+	for _, c := range probe.Conditions {
+		holds = append(holds, p.holds(c, m, last, cached, a))
+	}
+	return holds
+}
+
+// holds reports whether a condition holds - a missing field never holds
+func (p *Monitoring) holds(c Condition, m telegraf.Metric, last compute, cached bool, a compute) bool {
+	v, ok := m.GetField(c.Field)
+	if !ok {
+		return false
+	}
+	if s, ok := v.(string); ok {
+		switch c.Operator {
+		case "eq":
+			return s == c.Value
+		case "ne":
+			return s != c.Value
+		}
+		return false
+	}
+	value, ok := convert(v)
+	if !ok {
+		return false
+	}
+	probeType := c.ProbeType
+	if probeType == "" {
+		probeType = "current"
+	}
+	conditionValue, ok := p.probeValue(Probe{Field: c.Field, ProbeType: probeType}, value, last, cached, a)
+	return ok && compare(c.Operator, conditionValue, c.Threshold)
+}
+
+// combine combines the threshold of a probe with its conditions: all of them must hold ("and",
+// default) or any of them ("or")
+func combine(probe Probe, raised bool, holds []bool) bool {
+	or := strings.ToLower(probe.Logic) == "or"
+	for _, h := range holds {
+		if or && h {
+			return true
+		}
+		if !or && !h {
+			return false
+		}
+	}
+	return raised
+}
@@ -0,0 +1,139 @@
+package Monitoring
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+// Maintenance is a maintenance window: the alarms of the matching metrics raised between start
+// and end are tagged suppressed=true or dropped
+type Maintenance struct {
+	// regex per tag of the original metric - all of them must match
+	Tags map[string]string `toml:"tags" json:"tags"`
+	// alarm names - empty for all the probes
+	Alarms []string `toml:"alarms" json:"alarms"`
+	// RFC3339 times
+	Start string `toml:"start" json:"start"`
+	End   string `toml:"end" json:"end"`
+
+	start, end time.Time
+	tags       map[string]*regexp.Regexp
+}
+
+// compile parses the times and the regexes of a maintenance window
+func (w *Maintenance) compile() error {
+	var err error
+	if w.start, err = time.Parse(time.RFC3339, w.Start); err != nil {
+		return err
+	}
+	if w.end, err = time.Parse(time.RFC3339, w.End); err != nil {
+		return err
+	}
+	w.tags = make(map[string]*regexp.Regexp)
+	for k, v := range w.Tags {
+		if w.tags[k], err = regexp.Compile(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matches reports whether the window covers an alarm of the probe on the metric
+func (w *Maintenance) matches(probe Probe, a compute) bool {
+	if w.tags == nil || a.tm.Before(w.start) || !a.tm.Before(w.end) {
+		return false
+	}
+	if len(w.Alarms) > 0 {
+		found := false
+		for _, name := range w.Alarms {
+			found = found || name == probe.AlarmName
+		}
+		if !found {
+			return false
+		}
+	}
+	for k, regex := range w.tags {
+		if value, ok := a.tags[k]; !ok || !regex.MatchString(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// compileMaintenances compiles the maintenance windows of the configuration - an invalid window is ignored
+func (p *Monitoring) compileMaintenances() {
+	for i := range p.Maintenances {
+		if err := p.Maintenances[i].compile(); err != nil {
+			log.Printf("E! [processors.monitoring] Invalid maintenance window %v - ignored: %v", p.Maintenances[i], err)
+		}
+	}
+}
+
+// refreshMaintenances re-reads the maintenance file every thresholds_refresh minutes
+func (p *Monitoring) refreshMaintenances() {
+	if p.MaintenanceFile == "" {
+		return
+	}
+	refresh := time.Duration(p.ThresholdsRefresh) * time.Minute
+	if refresh <= 0 {
+		refresh = time.Hour
+	}
+	if !p.maintenance_update.IsZero() && time.Since(p.maintenance_update) < refresh {
+		return
+	}
+	p.maintenance_update = time.Now()
+
+	content, err := ioutil.ReadFile(p.MaintenanceFile)
+	if err != nil {
+		log.Printf("E! [processors.monitoring] Error when opening maintenance file %s error is %v", p.MaintenanceFile, err)
+		return
+	}
+	windows := make([]Maintenance, 0)
+	if err := json.Unmarshal(content, &windows); err != nil {
+		log.Printf("E! [processors.monitoring] Error when parsing maintenance file %s error is %v", p.MaintenanceFile, err)
+		return
+	}
+	for i := range windows {
+		if err := windows[i].compile(); err != nil {
+			log.Printf("E! [processors.monitoring] Invalid maintenance window %v in %s - ignored: %v", windows[i], p.MaintenanceFile, err)
+		}
+	}
+	logPrintf("Maintenance file %s loaded with %v windows", p.MaintenanceFile, len(windows))
+	p.maintenance_windows = windows
+}
+
+// suppress tags or drops the alarms of a probe covered by a maintenance window - the clears are
+// never dropped, so an alarm raised before the window is resolved downstream
+func (p *Monitoring) suppress(probe Probe, a compute, alarms []telegraf.Metric) []telegraf.Metric {
+	if len(alarms) == 0 {
+		return alarms
+	}
+	windows := append(p.Maintenances[:len(p.Maintenances):len(p.Maintenances)], p.maintenance_windows...)
+	for i := range windows {
+		if !windows[i].matches(probe, a) {
+			continue
+		}
+		if p.MaintenanceAction == "drop" {
+			kept := alarms[:0]
+			for _, m := range alarms {
+				if state, _ := m.GetTag("state"); state == "cleared" {
+					kept = append(kept, m)
+				}
+			}
+			if len(kept) < len(alarms) {
+				logPrintf("Alarm %s dropped during maintenance", probe.AlarmName)
+			}
+			return kept
+		}
+		for _, m := range alarms {
+			m.AddTag("suppressed", "true")
+		}
+		break
+	}
+	return alarms
+}
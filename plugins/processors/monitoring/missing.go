@@ -0,0 +1,36 @@
+package Monitoring
+
+import (
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+// missingData evaluates the missing probes against the age of the cached series, every
+// missing_check_interval, so an alarm is raised even though no metric of the series arrives
+func (p *Monitoring) missingData() []telegraf.Metric {
+	interval, err := time.ParseDuration(p.MissingCheckInterval)
+	if err != nil || interval <= 0 {
+		interval = time.Minute
+	}
+	if len(p.missing_probes) == 0 || time.Since(p.missing_checked) < interval {
+		return nil
+	}
+	p.missing_checked = time.Now()
+	logPrintf("Checking missing data of %v cache entries", len(p.cache))
+
+	alarms := []telegraf.Metric{}
+	for id, v := range p.cache {
+		for _, probe := range p.missing_probes {
+			if _, ok := v.fields[probe.Field]; !ok {
+				continue
+			}
+			probe = p.override(probe, v.tags)
+			// seconds since the series was last seen
+			age := p.missing_checked.Sub(v.tm).Seconds()
+			a := compute{name: v.name, tags: v.tags, tm: p.missing_checked, fields: v.fields}
+			alarms = append(alarms, p.suppress(probe, a, p.evaluate(probe, id, age, a, nil))...)
+		}
+	}
+	return alarms
+}
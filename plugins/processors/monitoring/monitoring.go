@@ -1,7 +1,11 @@
 package Monitoring
 
 import (
+	"fmt"
 	"log"
+	"regexp"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/influxdata/telegraf"
@@ -24,6 +28,41 @@ var sampleConfig = `
   tag_name = "ALARM_TYPE"
   period = "10m"
   retention = "1h"
+
+  ## The thresholds of the probes can be overridden per device and interface by an external file re-read every
+  ## thresholds_refresh minutes (like the enrichment plugin). The JSON file is keyed by the value of the level 1
+  ## tag, then by the value of the level 2 tag or "LEVEL1THRESHOLDS" for the whole level 1 value, then by alarm_name:
+  ##   {"r1": {"LEVEL1THRESHOLDS": {"CPU_HIGH": 20.0}, "xe-0/0/0": {"CRC": 10.0}}}
+  ## A .csv file has the lines level1,level2,alarm_name,threshold (empty level2 for the whole level 1 value).
+  ## thresholds_level1_tag is required. For the multi-level probes, the levels are shifted together so the least
+  ## severe one gets the threshold of the file.
+  # thresholds_file = "/etc/telegraf/thresholds.json"
+  # thresholds_refresh = 60
+  # thresholds_level1_tag = "device"
+  # thresholds_level2_tag = "if_name"
+
+  ## Every active_alarms_interval, one metric per currently active alarm is emitted in the active_alarms_measurement
+  ## (default <measurement>_ACTIVE) with the tags of the alarm, its severity and the fields "age" (seconds since
+  ## the alarm was raised) and "exception" (last value), even when no metric arrives.
+  # active_alarms_interval = "1m"
+  # active_alarms_measurement = "ALARMING_ACTIVE"
+
+  ## During a maintenance window, the probes are evaluated but the alarms of the matching metrics are tagged
+  ## suppressed = "true" (maintenance_action = "tag", default) or dropped (maintenance_action = "drop") - the
+  ## clears are never dropped, so the alarms raised before the window are resolved downstream.
+  ## A window matches the metrics whose tags match all the regexes of "tags", for the listed alarm names (all the
+  ## probes when empty), between the RFC3339 start and end times. The windows can also be read from a JSON file
+  ## holding a list of windows with the same keys, re-read every thresholds_refresh minutes.
+  # maintenance_action = "tag"
+  # maintenance_file = "/etc/telegraf/maintenance.json"
+  # [[processors.monitoring.maintenance]]
+  #   tags = {device = "^r1$"}
+  #   alarms = ["CPU_HIGH"]
+  #   start = "2026-10-20T22:00:00Z"
+  #   end = "2026-10-21T02:00:00Z"
+
+  ## The series of the "missing" probes are checked every missing_check_interval.
+  # missing_check_interval = "1m"
   
   ## For each monitoring probe we provide :
   ## The "alarm_name" of the alarm. It is actually the value of tag_name specified before 
@@ -33,14 +72,49 @@ var sampleConfig = `
   ##   "delta"        : we compare the diff/delta of the field with the threshold
   ##   "delta_rate"   : we compare the rate of the field with the threshold
   ##   "delta_percent"   : we compare the diff/delta in percentage of the field with the threshold
+  ##   "anomaly"         : we compare the deviation of the value from the rolling mean of the series, in standard
+  ##                       deviations, with the threshold (e.g. threshold = 3.0 and operator = "gt" for 3 sigmas).
+  ##                       The mean and the standard deviation are exponentially weighted over "anomaly_samples"
+  ##                       samples (default 30) and no alarm is raised before this number of samples.
+  ##   "missing"         : we compare the number of seconds since the field of the series was last seen with the
+  ##                       threshold (operator "gt"), so dead sensors or stopped RPCs raise an alarm. The series are
+  ##                       checked every missing_check_interval (default "1m"), even when no metric arrives, and a
+  ##                       clear (tag state = "cleared") is emitted when the series is seen again. The retention
+  ##                       must be greater than the threshold.
   ##   "min_value"       : Trigger alarm only if current value is greater than min_value 
   ## The "threshold field is a float field that defines the threshold of the probe
   ## The "operator" = ["lt", "gt", "eq"]. How we compare the value and the threshold (lower than, greater than, equal)
+  ## String fields are monitored with the operators "match" and "not_match" against the regex "pattern" (e.g. an
+  ## oper_status not matching "^up$"), the probe_type is ignored and "exception" holds the offending string.
   ## The "copy_tag" option specifies if we need to copy some tags from the original's metric to the Monitoring's metric 
   ## If copy_tag is set we check "tags" list. If empty, all tags are copied, else only specified tags are copied into the Monitoring's metric
   ## 
   ## 
   ## The Monitoring metric has a single field named "exception" with conveys either the current value, the delta value or the rate value that triggered the Monitoring
+  ##
+  ## The optional "clear_threshold" enables the alarm state (hysteresis): the alarms get a tag state = "raised"
+  ## and once raised, the alarm stays active until the value recrosses the clear threshold (e.g. threshold 90,
+  ## operator "gt", clear_threshold 80: cleared when the value is not greater than 80). A Monitoring metric
+  ## with the tag state = "cleared" is then emitted so the downstream alerting can auto-resolve.
+  ## The optional "for_samples" and "for_duration" require the threshold to be violated for N consecutive
+  ## samples and/or for a duration before the alarm fires, suppressing the one-off spikes.
+  ## The optional "levels" replace the threshold by several thresholds with a severity, listed from the least
+  ## to the most severe. The alarm gets a tag severity with the most severe level reached.
+  ## The optional "condition" sub-tables add conditions on other fields of the same metric (current, delta,
+  ## delta_rate or delta_percent values compared with a threshold, or string fields compared with "value" using
+  ## the operators "eq" and "ne"). With logic = "and" (default) the threshold and all the conditions must hold,
+  ## with logic = "or" any of them.
+  ## The optional "override" sub-tables replace the threshold for the metrics whose tag matches the regex
+  ## pattern - the first matching override wins. The thresholds file has precedence over the overrides. The
+  ## "levels" of an override replace the levels of a multi-level probe, else they are shifted together so the
+  ## least severe one gets the threshold of the override.
+  ## By default an alarm is emitted for each sample violating the threshold. With "renotify", an active alarm
+  ## is emitted when raised, then only once per renotify interval while it stays active.
+  ## With "rearm_delay", an active alarm is cleared only once the value stays clear for the delay, so a value
+  ## oscillating around the threshold produces one alarm and one clear per flap window instead of a storm.
+  ## The alarms raised again during the delay are not emitted but counted in the field "flaps" of the alarms.
+  ## The optional "message" template adds a string field "message" to the alarm. The placeholders {{value}},
+  ## {{alarm_name}}, {{field}} and {{<tag>}} (tags of the original metric) are replaced.
   ## 
   [[processors.monitoring.probe]]
     alarm_name = "CPU_HIGH"
@@ -51,6 +125,23 @@ var sampleConfig = `
     operator = "gt"
     copy_tag = true
 	tags = ["device","component_name"]
+    # clear_threshold = 5.0
+    # for_samples = 3
+    # for_duration = "2m"
+    # levels = [{severity="warning", threshold=20.0}, {severity="major", threshold=40.0}, {severity="critical", threshold=60.0}]
+    # renotify = "15m"
+    # rearm_delay = "5m"
+    # anomaly_samples = 30
+    # message = "CPU idle of {{device}}/{{component_name}} changed by {{value}}%"
+    # logic = "and"
+    # [[processors.monitoring.probe.override]]
+    #   tag = "if_name"
+    #   pattern = "^ae"
+    #   threshold = 80.0
+    # [[processors.monitoring.probe.condition]]
+    #   field = "oper_status"
+    #   operator = "eq"
+    #   value = "up"
 
 
 `
@@ -62,11 +153,48 @@ type Monitoring struct {
 	Period		string		`toml:"period"`
 	Retention 	string		`toml:"retention"`
 
+	// thresholds per device (level 1 tag) and interface (level 2 tag) overriding the probes
+	ThresholdsFile	string	`toml:"thresholds_file"`
+	ThresholdsRefresh	int	`toml:"thresholds_refresh"`
+	ThresholdsLevel1Tag	string	`toml:"thresholds_level1_tag"`
+	ThresholdsLevel2Tag	string	`toml:"thresholds_level2_tag"`
+	thresholds	thresholdTable
+	thresholds_hash	string
+	thresholds_update	time.Time
+
+	// periodic measurement of the active alarms
+	ActiveAlarmsInterval	string	`toml:"active_alarms_interval"`
+	ActiveAlarmsMeasurement	string	`toml:"active_alarms_measurement"`
+	active_emitted	time.Time
+
+	// maintenance windows from the configuration and from a JSON file
+	Maintenances	[]Maintenance	`toml:"maintenance"`
+	MaintenanceFile	string	`toml:"maintenance_file"`
+	MaintenanceAction	string	`toml:"maintenance_action"`
+	maintenance_windows	[]Maintenance
+	maintenance_update	time.Time
+
+	// the series of the missing probes are checked every missing_check_interval
+	MissingCheckInterval	string	`toml:"missing_check_interval"`
+	missing_probes	[]Probe
+	missing_checked	time.Time
+
 	Probe []Probe    `toml:"probe"`
 	fields_map	map[string]Probe
+	// fields whose previous value is cached
+	cached_fields	map[string]struct{}
 	initialized bool
 	last_cleared	time.Time
 	cache       map[uint64]compute
+	alarms		map[uint64]*alarmState
+	violations	map[uint64]*violation
+	baselines	map[uint64]*baseline
+
+	// the periodic checks run on a ticker, so they don't depend on the incoming metrics
+	acc	telegraf.Accumulator
+	mu	sync.Mutex
+	done	chan struct{}
+	wg	sync.WaitGroup
 	}
 
 	// Subscription for a GNMI client
@@ -79,10 +207,35 @@ type Probe struct {
 	Operator string `toml:"operator"`
 	CopyTag bool `toml:"copy_tag"`
 	Tags []string `toml:"tags"`
+	// hysteresis: the alarm is cleared when the value recrosses this threshold
+	ClearThreshold *float64 `toml:"clear_threshold"`
+	// the threshold must be violated for N consecutive samples and/or for a duration
+	ForSamples int `toml:"for_samples"`
+	ForDuration string `toml:"for_duration"`
+	// thresholds with their severity, from the least to the most severe
+	Levels []Level `toml:"levels"`
+	// conditions on other fields of the metric combined with the threshold ("and" or "or")
+	Conditions []Condition `toml:"condition"`
+	Logic string `toml:"logic"`
+	// thresholds for the metrics with a tag matching a regex
+	Overrides []Override `toml:"override"`
+	// an active alarm is emitted again only after this interval
+	Renotify string `toml:"renotify"`
+	// human-readable description emitted in the field "message"
+	Message string `toml:"message"`
+	// an active alarm is cleared only once the value stays clear for this delay
+	RearmDelay string `toml:"rearm_delay"`
+	// number of samples of the baseline of the anomaly probes
+	AnomalySamples int `toml:"anomaly_samples"`
+	// regex of the string probes (operators "match" and "not_match")
+	Pattern string `toml:"pattern"`
+	regex *regexp.Regexp
 }
 
 type compute struct {
 	fields map[string]float64
+	// values of the string fields of the string probes
+	strings map[string]string
 	name   string
 	tags   map[string]string
 	tm time.Time
@@ -96,6 +249,101 @@ func(p * Monitoring) Description() string {
     return "Monitor some KPI"
 }
 
+// Init checks the configuration
+func (p *Monitoring) Init() error {
+	if p.ThresholdsFile != "" && p.ThresholdsLevel1Tag == "" {
+		return fmt.Errorf("thresholds_level1_tag is required with thresholds_file")
+	}
+	return nil
+}
+
+// tick is the resolution of the periodic checks
+const tick = time.Second
+
+// Start runs the periodic checks (missing data and active alarms) on a ticker
+func (p *Monitoring) Start(acc telegraf.Accumulator) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.initialized {
+		p.init()
+	}
+	p.acc = acc
+	p.done = make(chan struct{})
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.done:
+				return
+			case <-ticker.C:
+				p.mu.Lock()
+				for _, m := range p.periodic() {
+					p.acc.AddMetric(m)
+				}
+				p.mu.Unlock()
+			}
+		}
+	}()
+	return nil
+}
+
+func (p *Monitoring) Add(m telegraf.Metric, acc telegraf.Accumulator) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, m := range p.Apply(m) {
+		acc.AddMetric(m)
+	}
+	return nil
+}
+
+// Stop stops the periodic checks
+func (p *Monitoring) Stop() error {
+	if p.done != nil {
+		close(p.done)
+		p.wg.Wait()
+	}
+	return nil
+}
+
+// periodic returns the metrics of the periodic checks
+func (p *Monitoring) periodic() []telegraf.Metric {
+	return append(p.missingData(), p.activeAlarms()...)
+}
+
+func (p *Monitoring) init() {
+	logPrintf("Initializing...")
+	p.cache = make(map[uint64]compute)
+	p.alarms = make(map[uint64]*alarmState)
+	p.violations = make(map[uint64]*violation)
+	p.baselines = make(map[uint64]*baseline)
+	p.compileOverrides()
+	p.compilePatterns()
+	p.compileMaintenances()
+	p.fields_map = make(map[string]Probe)
+	p.cached_fields = make(map[string]struct{})
+	p.missing_probes = nil
+	for _, monitor := range p.Probe{
+		p.fields_map[monitor.Field] = monitor
+		logPrintf("Adding field %v", monitor.Field)
+		if monitor.ProbeType != "current" {
+			p.cached_fields[monitor.Field] = struct{}{}
+		}
+		if monitor.ProbeType == "missing" {
+			p.missing_probes = append(p.missing_probes, monitor)
+		}
+		for _, c := range monitor.Conditions {
+			if c.ProbeType != "" && c.ProbeType != "current" {
+				p.cached_fields[c.Field] = struct{}{}
+			}
+		}
+	}
+	p.initialized = true
+	p.last_cleared = time.Now()
+}
+
 func(p * Monitoring) Apply(metrics...telegraf.Metric) []telegraf.Metric {
 	//var nb_deleted int
 	//var t_period time.Duration
@@ -103,15 +351,7 @@ func(p * Monitoring) Apply(metrics...telegraf.Metric) []telegraf.Metric {
 	t_period,_ := time.ParseDuration(p.Period)
 	t_retention,_ := time.ParseDuration(p.Retention)
 	if !p.initialized {
-		logPrintf("Initializing...")
-		p.cache = make(map[uint64]compute)
-		p.fields_map = make(map[string]Probe)
-		for _, monitor := range p.Probe{
-			p.fields_map[monitor.Field] = monitor
-			logPrintf("Adding field %v", monitor.Field)
-		}
-		p.initialized = true
-		p.last_cleared = time.Now()
+		p.init()
 	}
 	if time.Now().After(p.last_cleared.Add(t_period)) {
 		logPrintf("Time to clean the cache, nb cache entries %v",len(p.cache))
@@ -125,8 +365,26 @@ func(p * Monitoring) Apply(metrics...telegraf.Metric) []telegraf.Metric {
 			}
 		}
 		logPrintf("%v entries deleted from cache",nb_deleted)
+		for k, v := range p.alarms {
+			if time.Now().After(v.last.Add(t_retention)) {
+				logPrintf("delete active alarm %v %v", v.probe.AlarmName, k)
+				delete(p.alarms, k)
+			}
+		}
+		for k, v := range p.violations {
+			if time.Now().After(v.last.Add(t_retention)) {
+				delete(p.violations, k)
+			}
+		}
+		for k, v := range p.baselines {
+			if time.Now().After(v.last.Add(t_retention)) {
+				delete(p.baselines, k)
+			}
+		}
 		p.last_cleared = time.Now()
 	}
+	p.refreshThresholds()
+	p.refreshMaintenances()
 	alarmMetric := []telegraf.Metric{}
 
 	for _, mymetric := range metrics {
@@ -137,248 +395,186 @@ func(p * Monitoring) Apply(metrics...telegraf.Metric) []telegraf.Metric {
 			tags:   mymetric.Tags(),
 			tm:		mymetric.Time(),
 			fields:	make(map[string]float64),
+			strings:	make(map[string]string),
 		}
 		for _, field := range mymetric.FieldList() {
-			if _, ok := p.fields_map[field.Key]; ok{
+			if probe, ok := p.fields_map[field.Key]; ok && probe.stringProbe() {
+				// the string probes are evaluated as 1 when violated, else 0
+				if value, ok := field.Value.(string); ok {
+					a.strings[field.Key] = value
+					a.fields[field.Key] = probe.violated(value)
+					hasField = true
+				}
+			} else if ok {
 				if a.fields[field.Key], ok = convert(field.Value); ok {
 					hasField = true
 				}
+			} else if _, ok := p.cached_fields[field.Key]; ok {
+				// field of a delta condition
+				if value, ok := convert(field.Value); ok {
+					a.fields[field.Key] = value
+				}
 			}
 		}
-		if hasField {
-			for key, value := range a.fields {
-				if value >= p.fields_map[key].MinValue {
-					thresholdReached := false
-					switch p.fields_map[key].ProbeType {
-					case "current":
-						logPrintf("Mode Current")
-						switch p.fields_map[key].Operator {
-						case "lt":
-							if value < p.fields_map[key].Threshold {
-								logPrintf("Threshold reached for field %s. %f < %f",key,value,p.fields_map[key].Threshold)
-								thresholdReached = true 
-							}
-						case "gt":
-							if value > p.fields_map[key].Threshold {
-								logPrintf("Threshold reached for field %s. %f > %f",key,value,p.fields_map[key].Threshold)
-								thresholdReached = true 
-							}
-						case "eq":
-							if value == p.fields_map[key].Threshold {
-								logPrintf("Threshold reached for field %s. %f == %f",key,value,p.fields_map[key].Threshold)
-								thresholdReached = true 
-							}
-						}
-						if thresholdReached {
-							newAlarm := metric.New(p.Measurement, map[string]string{}, map[string]interface{}{"exception": value},mymetric.Time())
-							newAlarm.AddTag(p.TagName,p.fields_map[key].AlarmName)
-							
-
-							if p.fields_map[key].CopyTag {
-								logPrintf("Copy Tags from original metric into monitoring metric")
-								if len(p.fields_map[key].Tags) > 0 {
-									logPrintf("Tags list is not empty - filetring tags")
-									for _,v := range p.fields_map[key].Tags {
-										if _, ok := a.tags[v]; ok{
-											logPrintf("Copy Tags %s with value %s",v,a.tags[v])
-											newAlarm.AddTag(v,a.tags[v])
-										}
-									}
-								} else {
-									logPrintf("Tags list is empty - copy all tags")
-									for k,v := range a.tags {
-										logPrintf("Copy Tags %s with value %s",k,v)
-										newAlarm.AddTag(k,v)
-									}
-
-								}
-							}
-							alarmMetric = append(alarmMetric, newAlarm)
-						}
-					case "delta":
-						logPrintf("Mode Delta")
-						if _, ok := p.cache[id]; !ok  {
-							logPrintf("Creating cache entry for metric with hashid %v", id)
-							p.cache[id] = a
-						// If cached data are available then the rate is computed
-						} else  {
-							if lv, ok := p.cache[id].fields[key]; ok {
-								field_delta := value - lv
-								switch p.fields_map[key].Operator {
-								case "lt":
-									if field_delta < p.fields_map[key].Threshold {
-										logPrintf("Threshold reached for field %s. %f < %f",key,field_delta,p.fields_map[key].Threshold)
-										thresholdReached = true 
-									}
-								case "gt":
-									if field_delta > p.fields_map[key].Threshold {
-										logPrintf("Threshold reached for field %s. %f > %f",key,field_delta,p.fields_map[key].Threshold)
-										thresholdReached = true 
-									}
-								case "eq":
-									if field_delta == p.fields_map[key].Threshold {
-										logPrintf("Threshold reached for field %s. %f == %f",key,field_delta,p.fields_map[key].Threshold)
-										thresholdReached = true 
-									}
-								}
-								if thresholdReached {
-									newAlarm := metric.New(p.Measurement, map[string]string{}, map[string]interface{}{"exception": field_delta},mymetric.Time())
-									newAlarm.AddTag(p.TagName,p.fields_map[key].AlarmName)
-									
-			
-									if p.fields_map[key].CopyTag {
-										logPrintf("Copy Tags from original metric into monitoring metric")
-										if len(p.fields_map[key].Tags) > 0 {
-											logPrintf("Tags list is not empty - filetring tags")
-											for _,v := range p.fields_map[key].Tags {
-												if _, ok := a.tags[v]; ok{
-													logPrintf("Copy Tags %s with value %s",v,a.tags[v])
-													newAlarm.AddTag(v,a.tags[v])
-												}
-											}
-										} else {
-											logPrintf("Tags list is empty - copy all tags")
-											for k,v := range a.tags {
-												logPrintf("Copy Tags %s with value %s",k,v)
-												newAlarm.AddTag(k,v)
-											}
-			
-										}
-									}
-									alarmMetric = append(alarmMetric, newAlarm)
-								}
-							}
-							
-							// The cache is updated with the latest value
-							logPrintf("Updating cache entry for metric with hashid %v", id)
-							p.cache[id] = a						
-						}
-					case "delta_percent":
-						logPrintf("Mode Delta Percent")
-						if _, ok := p.cache[id]; !ok  {
-							logPrintf("Creating cache entry for metric with hashid %v", id)
-							p.cache[id] = a
-						// If cached data are available then the rate is computed
-						} else  {
-							if lv, ok := p.cache[id].fields[key]; ok {
-
-								field_delta_percent := ((value - lv) / lv) * 100
-
-								switch p.fields_map[key].Operator {
-								case "lt":
-									if field_delta_percent < p.fields_map[key].Threshold {
-										logPrintf("Threshold reached for field %s. %f < %f",key,field_delta_percent,p.fields_map[key].Threshold)
-										thresholdReached = true 
-									}
-								case "gt":
-									if field_delta_percent > p.fields_map[key].Threshold {
-										logPrintf("Threshold reached for field %s. %f > %f",key,field_delta_percent,p.fields_map[key].Threshold)
-										thresholdReached = true 
-									}
-								case "eq":
-									if field_delta_percent == p.fields_map[key].Threshold {
-										logPrintf("Threshold reached for field %s. %f == %f",key,field_delta_percent,p.fields_map[key].Threshold)
-										thresholdReached = true 
-									}
-								} 
-								if thresholdReached {
-									newAlarm := metric.New(p.Measurement, map[string]string{}, map[string]interface{}{"exception": field_delta_percent},mymetric.Time())
-									newAlarm.AddTag(p.TagName,p.fields_map[key].AlarmName)
-									
-			
-									if p.fields_map[key].CopyTag {
-										logPrintf("Copy Tags from original metric into monitoring metric")
-										if len(p.fields_map[key].Tags) > 0 {
-											logPrintf("Tags list is not empty - filetring tags")
-											for _,v := range p.fields_map[key].Tags {
-												if _, ok := a.tags[v]; ok{
-													logPrintf("Copy Tags %s with value %s",v,a.tags[v])
-													newAlarm.AddTag(v,a.tags[v])
-												}
-											}
-										} else {
-											logPrintf("Tags list is empty - copy all tags")
-											for k,v := range a.tags {
-												logPrintf("Copy Tags %s with value %s",k,v)
-												newAlarm.AddTag(k,v)
-											}
-			
-										}
-									}
-									alarmMetric = append(alarmMetric, newAlarm)
-								}
-							}
-							
-							// The cache is updated with the latest value
-							logPrintf("Updating cache entry for metric with hashid %v", id)
-							p.cache[id] = a						
-						}
-					case "delta_rate":
-						logPrintf("Mode Delta Rate")
-						if _, ok := p.cache[id]; !ok  {
-							logPrintf("Creating cache entry for metric with hashid %v", id)
-							p.cache[id] = a
-						// If cached data are available then the rate is computed
-						} else  {
-							delta := mymetric.Time().Sub(p.cache[id].tm).Seconds()
-							if lv, ok := p.cache[id].fields[key]; ok {
-								field_rate := (value - lv)/float64(delta)
-								switch p.fields_map[key].Operator {
-								case "lt":
-									if field_rate < p.fields_map[key].Threshold {
-										logPrintf("Threshold reached for field %s. %f < %f",key,field_rate,p.fields_map[key].Threshold)
-										thresholdReached = true 
-									}
-								case "gt":
-									if field_rate > p.fields_map[key].Threshold {
-										logPrintf("Threshold reached for field %s. %f > %f",key,field_rate,p.fields_map[key].Threshold)
-										thresholdReached = true 
-									}
-								case "eq":
-									if field_rate == p.fields_map[key].Threshold {
-										logPrintf("Threshold reached for field %s. %f == %f",key,field_rate,p.fields_map[key].Threshold)
-										thresholdReached = true 
-									}
-								}
-								if thresholdReached {
-									newAlarm := metric.New(p.Measurement, map[string]string{}, map[string]interface{}{"exception": field_rate},mymetric.Time())
-									newAlarm.AddTag(p.TagName,p.fields_map[key].AlarmName)
-			
-									if p.fields_map[key].CopyTag {
-										logPrintf("Copy Tags from original metric into monitoring metric")
-										if len(p.fields_map[key].Tags) > 0 {
-											logPrintf("Tags list is not empty - filetring tags")
-											for _,v := range p.fields_map[key].Tags {
-												if _, ok := a.tags[v]; ok{
-													logPrintf("Copy Tags %s with value %s",v,a.tags[v])
-													newAlarm.AddTag(v,a.tags[v])
-												}
-											}
-										} else {
-											logPrintf("Tags list is empty - copy all tags")
-											for k,v := range a.tags {
-												logPrintf("Copy Tags %s with value %s",k,v)
-												newAlarm.AddTag(k,v)
-											}
-			
-										}
-									}
-									alarmMetric = append(alarmMetric, newAlarm)
-								}
-							}
-							// The cache is updated with the latest value
-							logPrintf("Updating cache entry for metric with hashid %v", id)
-							p.cache[id] = a	
-						}
-					}
-				}
+		if !hasField {
+			continue
+		}
+		// the deltas are computed against the previous sample of the metric
+		last, cached := p.cache[id]
+		// the cache holds the previous values of the delta probes and of the delta conditions - as
+		// before, a sample below the min_value of its probe is not cached, so the next delta is
+		// computed against the last sample above min_value
+		updateCache := false
+		for key, value := range a.fields {
+			if _, ok := p.cached_fields[key]; !ok {
+				continue
 			}
-
+			if probe, ok := p.fields_map[key]; ok && value < p.override(probe, a.tags).MinValue {
+				continue
+			}
+			updateCache = true
+			break
+		}
+		for key, value := range a.fields {
+			probe, ok := p.fields_map[key]
+			if !ok {
+				continue
+			}
+			probe = p.override(probe, a.tags)
+			if value < probe.MinValue {
+				continue
+			}
+			probeValue, ok := p.probeValue(probe, value, last, cached, a)
+			if probe.ProbeType == "anomaly" {
+				// deviation from the baseline of the series in standard deviations
+				probeValue, ok = p.anomaly(probe, id, value, a.tm)
+			}
+			if !ok {
+				continue
+			}
+			holds := p.conditions(probe, mymetric, last, cached, a)
+			alarmMetric = append(alarmMetric, p.suppress(probe, a, p.evaluate(probe, id, probeValue, a, holds))...)
+		}
+		if updateCache {
+			// The cache is updated with the latest value
+			logPrintf("Updating cache entry for metric with hashid %v", id)
+			p.cache[id] = a
 		}
 	}
+	if p.acc == nil {
+		// not started as a streaming processor: the periodic checks run with the metrics
+		alarmMetric = append(alarmMetric, p.periodic()...)
+	}
 	return append(metrics, alarmMetric...)
 }
 
+// probeValue returns the value compared with the threshold: the current value, the delta, the
+// delta in percent or the rate of the field since the cached sample, or 0 for the missing probes
+func (p *Monitoring) probeValue(probe Probe, value float64, last compute, cached bool, a compute) (float64, bool) {
+	if probe.ProbeType == "current" || probe.stringProbe() {
+		logPrintf("Mode Current")
+		return value, true
+	}
+	if probe.ProbeType == "missing" {
+		// the series is seen: no data missing
+		logPrintf("Mode Missing")
+		return 0, true
+	}
+	if !cached {
+		logPrintf("Creating cache entry for metric %v", a.name)
+		return 0, false
+	}
+	lv, ok := last.fields[probe.Field]
+	if !ok {
+		return 0, false
+	}
+	switch probe.ProbeType {
+	case "delta":
+		logPrintf("Mode Delta")
+		return value - lv, true
+	case "delta_percent":
+		logPrintf("Mode Delta Percent")
+		return ((value - lv) / lv) * 100, true
+	case "delta_rate":
+		logPrintf("Mode Delta Rate")
+		delta := a.tm.Sub(last.tm).Seconds()
+		return (value - lv) / float64(delta), true
+	}
+	return 0, false
+}
+
+var placeholderRe = regexp.MustCompile(`\{\{\s*([^{}\s]+)\s*\}\}`)
+
+// renderMessage replaces the placeholders of the message of a probe: {{value}}, {{alarm_name}},
+// {{field}} and the tags of the original metric, e.g. {{device}} - an unknown tag is empty
+func renderMessage(probe Probe, tags map[string]string, value string) string {
+	return placeholderRe.ReplaceAllStringFunc(probe.Message, func(placeholder string) string {
+		switch key := placeholderRe.FindStringSubmatch(placeholder)[1]; key {
+		case "value":
+			return value
+		case "alarm_name":
+			return probe.AlarmName
+		case "field":
+			return probe.Field
+		default:
+			return tags[key]
+		}
+	})
+}
+
+// compare compares the value with the threshold according to the operator
+func compare(operator string, value float64, threshold float64) bool {
+	switch operator {
+	case "lt":
+		return value < threshold
+	case "gt":
+		return value > threshold
+	case "eq":
+		return value == threshold
+	case "ne":
+		return value != threshold
+	case "match", "not_match":
+		// the value of the string probes is 1 when violated
+		return value != 0
+	}
+	return false
+}
+
+// newAlarm builds the Monitoring metric of a probe - the exception of the string probes is the
+// offending string
+func (p *Monitoring) newAlarm(probe Probe, a compute, value float64) telegraf.Metric {
+	var exception interface{} = value
+	text := strconv.FormatFloat(value, 'f', -1, 64)
+	if s, ok := a.strings[probe.Field]; ok && probe.stringProbe() {
+		exception, text = s, s
+	}
+	newAlarm := metric.New(p.Measurement, map[string]string{}, map[string]interface{}{"exception": exception}, a.tm)
+	newAlarm.AddTag(p.TagName, probe.AlarmName)
+	if probe.Message != "" {
+		newAlarm.AddField("message", renderMessage(probe, a.tags, text))
+	}
+
+	if probe.CopyTag {
+		logPrintf("Copy Tags from original metric into monitoring metric")
+		if len(probe.Tags) > 0 {
+			logPrintf("Tags list is not empty - filetring tags")
+			for _, v := range probe.Tags {
+				if _, ok := a.tags[v]; ok {
+					logPrintf("Copy Tags %s with value %s", v, a.tags[v])
+					newAlarm.AddTag(v, a.tags[v])
+				}
+			}
+		} else {
+			logPrintf("Tags list is empty - copy all tags")
+			for k, v := range a.tags {
+				logPrintf("Copy Tags %s with value %s", k, v)
+				newAlarm.AddTag(k, v)
+			}
+		}
+	}
+	return newAlarm
+}
+
 func logPrintf(format string, v...interface {}) {
     log.Printf("D! [processors.exception] " + format, v...)
 }
@@ -397,7 +593,7 @@ func convert(in interface{}) (float64, bool) {
 }
 
 func init() {
-    processors.Add("monitoring", func() telegraf.Processor {
+    processors.AddStreaming("monitoring", func() telegraf.StreamingProcessor {
         return &Monitoring {}
     })
 }
@@ -0,0 +1,633 @@
+package Monitoring
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/metric"
+	"github.com/influxdata/telegraf/testutil"
+)
+
+func newMonitoring(probes ...Probe) *Monitoring {
+	return &Monitoring{
+		Measurement: "ALARMING",
+		TagName:     "ALARM_TYPE",
+		Period:      "10m",
+		Retention:   "1h",
+		Probe:       probes,
+	}
+}
+
+// alarms returns the Monitoring metrics among the output of the processor
+func alarms(metrics []telegraf.Metric) []telegraf.Metric {
+	var out []telegraf.Metric
+	for _, m := range metrics {
+		if m.Name() == "ALARMING" {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+func TestCurrentProbeWithDeltaCondition(t *testing.T) {
+	p := newMonitoring(Probe{
+		AlarmName: "ERRORS_HOT",
+		Field:     "temperature",
+		ProbeType: "current",
+		Operator:  "gt",
+		Threshold: 50,
+		Conditions: []Condition{
+			{Field: "errors", ProbeType: "delta", Operator: "gt", Threshold: 5},
+		},
+	})
+	now := time.Now()
+	sample := func(errors int64, tm time.Time) telegraf.Metric {
+		return metric.New("components",
+			map[string]string{"device": "r1"},
+			map[string]interface{}{"temperature": 60.0, "errors": errors},
+			tm,
+		)
+	}
+
+	// no previous sample: the delta condition can't hold
+	require.Empty(t, alarms(p.Apply(sample(10, now))))
+
+	out := alarms(p.Apply(sample(20, now.Add(time.Second))))
+	require.Len(t, out, 1)
+	exception, _ := out[0].GetField("exception")
+	require.Equal(t, 60.0, exception)
+
+	// delta of 2: the condition doesn't hold anymore
+	require.Empty(t, alarms(p.Apply(sample(22, now.Add(2*time.Second)))))
+}
+
+func TestMissingProbeWithoutTraffic(t *testing.T) {
+	p := newMonitoring(Probe{
+		AlarmName: "SENSOR_DEAD",
+		Field:     "in_octets",
+		ProbeType: "missing",
+		Operator:  "gt",
+		Threshold: 5,
+	})
+	p.MissingCheckInterval = "1s"
+	acc := &testutil.Accumulator{}
+	require.NoError(t, p.Start(acc))
+	defer p.Stop()
+
+	sample := func(tm time.Time) telegraf.Metric {
+		return metric.New("interfaces",
+			map[string]string{"device": "r1", "if_name": "xe-0/0/0"},
+			map[string]interface{}{"in_octets": int64(10)},
+			tm,
+		)
+	}
+	// last seen 10 seconds ago, then nothing arrives: the ticker raises the alarm
+	require.NoError(t, p.Add(sample(time.Now().Add(-10*time.Second)), acc))
+	acc.Wait(2)
+	raised := acc.GetTelegrafMetrics()[1]
+	require.Equal(t, "ALARMING", raised.Name())
+	state, _ := raised.GetTag("state")
+	require.Equal(t, "raised", state)
+
+	// seen again: the alarm is cleared
+	require.NoError(t, p.Add(sample(time.Now()), acc))
+	var cleared []telegraf.Metric
+	for _, m := range alarms(acc.GetTelegrafMetrics()) {
+		if state, _ := m.GetTag("state"); state == "cleared" {
+			cleared = append(cleared, m)
+		}
+	}
+	require.Len(t, cleared, 1)
+}
+
+func TestActiveAlarmsWithoutTraffic(t *testing.T) {
+	p := newMonitoring(Probe{
+		AlarmName:      "CPU_HIGH",
+		Field:          "cpu",
+		ProbeType:      "current",
+		Operator:       "gt",
+		Threshold:      90,
+		ClearThreshold: new(float64),
+	})
+	p.ActiveAlarmsInterval = "1s"
+	acc := &testutil.Accumulator{}
+	require.NoError(t, p.Start(acc))
+	defer p.Stop()
+
+	m := metric.New("system", map[string]string{"device": "r1"}, map[string]interface{}{"cpu": 95.0}, time.Now())
+	require.NoError(t, p.Add(m, acc))
+	// the metric, the alarm, then the active alarm emitted by the ticker
+	acc.Wait(3)
+	require.True(t, acc.HasMeasurement("ALARMING_ACTIVE"))
+}
+
+func TestMaintenanceDropKeepsClears(t *testing.T) {
+	clearThreshold := 80.0
+	p := newMonitoring(Probe{
+		AlarmName:      "CPU_HIGH",
+		Field:          "cpu",
+		ProbeType:      "current",
+		Operator:       "gt",
+		Threshold:      90,
+		ClearThreshold: &clearThreshold,
+	})
+	start := time.Date(2026, 10, 20, 22, 0, 0, 0, time.UTC)
+	p.MaintenanceAction = "drop"
+	p.Maintenances = []Maintenance{{
+		Tags:  map[string]string{"device": "^r1$"},
+		Start: start.Format(time.RFC3339),
+		End:   start.Add(time.Hour).Format(time.RFC3339),
+	}}
+	sample := func(cpu float64, tm time.Time) telegraf.Metric {
+		return metric.New("system", map[string]string{"device": "r1"}, map[string]interface{}{"cpu": cpu}, tm)
+	}
+
+	// raised before the window
+	require.Len(t, alarms(p.Apply(sample(95, start.Add(-time.Minute)))), 1)
+	// cleared during the window: the clear is kept
+	out := alarms(p.Apply(sample(50, start.Add(time.Minute))))
+	require.Len(t, out, 1)
+	state, _ := out[0].GetTag("state")
+	require.Equal(t, "cleared", state)
+	// raised during the window: dropped
+	require.Empty(t, alarms(p.Apply(sample(95, start.Add(2*time.Minute)))))
+}
+
+func TestThresholdsFileRequiresLevel1Tag(t *testing.T) {
+	p := newMonitoring()
+	p.ThresholdsFile = "/etc/telegraf/thresholds.json"
+	require.Error(t, p.Init())
+	p.ThresholdsLevel1Tag = "device"
+	require.NoError(t, p.Init())
+}
+
+func TestOverrideMultiLevelProbe(t *testing.T) {
+	levels := []Level{{Severity: "warning", Threshold: 50}, {Severity: "critical", Threshold: 80}}
+	file := filepath.Join(t.TempDir(), "thresholds.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{"r2": {"LEVEL1THRESHOLDS": {"CPU_HIGH": 70.0}}}`), 0644))
+	p := newMonitoring(Probe{
+		AlarmName: "CPU_HIGH",
+		Field:     "cpu",
+		ProbeType: "current",
+		Operator:  "gt",
+		Levels:    levels,
+		Overrides: []Override{
+			{Tag: "role", Pattern: "^core$", Levels: []Level{{Severity: "critical", Threshold: 95}}},
+			{Tag: "role", Pattern: "^edge$", Threshold: 60},
+		},
+	})
+	p.ThresholdsFile = file
+	p.ThresholdsLevel1Tag = "device"
+	require.NoError(t, p.Init())
+
+	severity := func(device, role string, cpu float64) string {
+		m := metric.New("system", map[string]string{"device": device, "role": role}, map[string]interface{}{"cpu": cpu}, time.Now())
+		out := alarms(p.Apply(m))
+		if len(out) == 0 {
+			return ""
+		}
+		s, _ := out[0].GetTag("severity")
+		return s
+	}
+	tests := []struct {
+		name     string
+		device   string
+		role     string
+		cpu      float64
+		severity string
+	}{
+		{"static levels", "r1", "access", 85, "critical"},
+		{"override levels", "r1", "core", 85, ""},
+		{"override levels reached", "r1", "core", 96, "critical"},
+		{"shifted override", "r1", "edge", 85, "warning"},
+		{"shifted override reached", "r1", "edge", 91, "critical"},
+		{"shifted file threshold", "r2", "access", 75, "warning"},
+		{"shifted file threshold reached", "r2", "access", 101, "critical"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.severity, severity(tt.device, tt.role, tt.cpu))
+		})
+	}
+}
+
+// systemMetric is a sample of the cpu of r1
+func systemMetric(cpu float64, tm time.Time) telegraf.Metric {
+	return metric.New("system", map[string]string{"device": "r1"}, map[string]interface{}{"cpu": cpu}, tm)
+}
+
+func TestClearThreshold(t *testing.T) {
+	clearThreshold := 80.0
+	p := newMonitoring(Probe{
+		AlarmName:      "CPU_HIGH",
+		Field:          "cpu",
+		ProbeType:      "current",
+		Operator:       "gt",
+		Threshold:      90,
+		ClearThreshold: &clearThreshold,
+	})
+	now := time.Now()
+	state := func(cpu float64, tm time.Time) []string {
+		var states []string
+		for _, m := range alarms(p.Apply(systemMetric(cpu, tm))) {
+			s, _ := m.GetTag("state")
+			states = append(states, s)
+		}
+		return states
+	}
+
+	require.Equal(t, []string{"raised"}, state(95, now))
+	// between the clear threshold and the threshold: still active, nothing emitted
+	require.Empty(t, state(85, now.Add(time.Second)))
+	require.Equal(t, []string{"raised"}, state(95, now.Add(2*time.Second)))
+	// recrosses the clear threshold: cleared once
+	require.Equal(t, []string{"cleared"}, state(79, now.Add(3*time.Second)))
+	require.Empty(t, state(79, now.Add(4*time.Second)))
+	require.Empty(t, state(85, now.Add(5*time.Second)))
+}
+
+func TestWithoutClearThreshold(t *testing.T) {
+	p := newMonitoring(Probe{
+		AlarmName: "CPU_HIGH",
+		Field:     "cpu",
+		ProbeType: "current",
+		Operator:  "gt",
+		Threshold: 90,
+	})
+	now := time.Now()
+	out := alarms(p.Apply(systemMetric(95, now)))
+	require.Len(t, out, 1)
+	require.False(t, out[0].HasTag("state"))
+	// no clear without clear_threshold
+	require.Empty(t, alarms(p.Apply(systemMetric(50, now.Add(time.Second)))))
+}
+
+// A sample below min_value is not cached: the next delta is computed against the last sample above it
+func TestMinValueNotCached(t *testing.T) {
+	p := newMonitoring(Probe{
+		AlarmName: "CPU_JUMP",
+		Field:     "cpu",
+		ProbeType: "delta",
+		Operator:  "gt",
+		Threshold: 5,
+		MinValue:  10,
+	})
+	now := time.Now()
+	require.Empty(t, alarms(p.Apply(systemMetric(20, now))))
+	require.Empty(t, alarms(p.Apply(systemMetric(5, now.Add(time.Second)))))
+	out := alarms(p.Apply(systemMetric(27, now.Add(2*time.Second))))
+	require.Len(t, out, 1)
+	exception, _ := out[0].GetField("exception")
+	require.Equal(t, 7.0, exception)
+}
+
+func TestForSamples(t *testing.T) {
+	p := newMonitoring(Probe{
+		AlarmName:  "CPU_HIGH",
+		Field:      "cpu",
+		ProbeType:  "current",
+		Operator:   "gt",
+		Threshold:  90,
+		ForSamples: 3,
+	})
+	now := time.Now()
+	apply := func(cpu float64, seconds int) []telegraf.Metric {
+		return alarms(p.Apply(systemMetric(cpu, now.Add(time.Duration(seconds)*time.Second))))
+	}
+
+	require.Empty(t, apply(95, 0))
+	require.Empty(t, apply(95, 1))
+	require.Len(t, apply(95, 2), 1)
+	require.Len(t, apply(95, 3), 1)
+	// a sample below the threshold resets the count
+	require.Empty(t, apply(50, 4))
+	require.Empty(t, apply(95, 5))
+	require.Empty(t, apply(95, 6))
+	require.Len(t, apply(95, 7), 1)
+}
+
+func TestForDuration(t *testing.T) {
+	p := newMonitoring(Probe{
+		AlarmName:   "CPU_HIGH",
+		Field:       "cpu",
+		ProbeType:   "current",
+		Operator:    "gt",
+		Threshold:   90,
+		ForDuration: "2m",
+	})
+	now := time.Now()
+	require.Empty(t, alarms(p.Apply(systemMetric(95, now))))
+	require.Empty(t, alarms(p.Apply(systemMetric(95, now.Add(time.Minute)))))
+	require.Len(t, alarms(p.Apply(systemMetric(95, now.Add(2*time.Minute)))), 1)
+	// the duration starts again after a sample below the threshold
+	require.Empty(t, alarms(p.Apply(systemMetric(50, now.Add(3*time.Minute)))))
+	require.Empty(t, alarms(p.Apply(systemMetric(95, now.Add(4*time.Minute)))))
+	require.Empty(t, alarms(p.Apply(systemMetric(95, now.Add(5*time.Minute)))))
+	require.Len(t, alarms(p.Apply(systemMetric(95, now.Add(6*time.Minute)))), 1)
+}
+
+func TestLevels(t *testing.T) {
+	p := newMonitoring(Probe{
+		AlarmName: "CPU_HIGH",
+		Field:     "cpu",
+		ProbeType: "current",
+		Operator:  "gt",
+		Levels: []Level{
+			{Severity: "warning", Threshold: 50},
+			{Severity: "major", Threshold: 70},
+			{Severity: "critical", Threshold: 90},
+		},
+	})
+	tests := []struct {
+		cpu      float64
+		severity string
+	}{
+		{40, ""},
+		{60, "warning"},
+		{75, "major"},
+		{95, "critical"},
+		{55, "warning"},
+	}
+	now := time.Now()
+	for i, tt := range tests {
+		out := alarms(p.Apply(systemMetric(tt.cpu, now.Add(time.Duration(i)*time.Second))))
+		if tt.severity == "" {
+			require.Empty(t, out)
+			continue
+		}
+		require.Len(t, out, 1)
+		severity, _ := out[0].GetTag("severity")
+		require.Equal(t, tt.severity, severity, "cpu %v", tt.cpu)
+	}
+}
+
+func TestLevelsOperatorLt(t *testing.T) {
+	p := newMonitoring(Probe{
+		AlarmName: "LIGHT_LOW",
+		Field:     "rx_power",
+		ProbeType: "current",
+		Operator:  "lt",
+		// the values below min_value are ignored
+		MinValue: -100,
+		Levels: []Level{
+			{Severity: "warning", Threshold: -10},
+			{Severity: "critical", Threshold: -20},
+		},
+	})
+	m := metric.New("optics", map[string]string{"device": "r1"}, map[string]interface{}{"rx_power": -25.0}, time.Now())
+	out := alarms(p.Apply(m))
+	require.Len(t, out, 1)
+	severity, _ := out[0].GetTag("severity")
+	require.Equal(t, "critical", severity)
+}
+
+func TestRenotify(t *testing.T) {
+	p := newMonitoring(Probe{
+		AlarmName: "CPU_HIGH",
+		Field:     "cpu",
+		ProbeType: "current",
+		Operator:  "gt",
+		Threshold: 90,
+		Renotify:  "15m",
+	})
+	now := time.Now()
+	apply := func(cpu float64, minutes int) []telegraf.Metric {
+		return alarms(p.Apply(systemMetric(cpu, now.Add(time.Duration(minutes)*time.Minute))))
+	}
+
+	require.Len(t, apply(95, 0), 1)
+	require.Empty(t, apply(95, 5))
+	require.Empty(t, apply(95, 14))
+	require.Len(t, apply(95, 15), 1)
+	require.Empty(t, apply(95, 20))
+	// once cleared, the alarm raised again is emitted at once
+	require.Empty(t, apply(50, 21))
+	require.Len(t, apply(95, 22), 1)
+}
+
+func TestMessage(t *testing.T) {
+	p := newMonitoring(Probe{
+		AlarmName: "CPU_HIGH",
+		Field:     "cpu",
+		ProbeType: "current",
+		Operator:  "gt",
+		Threshold: 90,
+		Message:   "{{alarm_name}}: {{ field }} of {{device}} at {{value}}%{{site}}",
+	})
+	out := alarms(p.Apply(systemMetric(95.5, time.Now())))
+	require.Len(t, out, 1)
+	message, _ := out[0].GetField("message")
+	require.Equal(t, "CPU_HIGH: cpu of r1 at 95.5%", message)
+}
+
+func TestRenderMessage(t *testing.T) {
+	probe := Probe{AlarmName: "CRC", Field: "in_crc_errors"}
+	tags := map[string]string{"device": "r1", "if_name": "xe-0/0/0"}
+	tests := []struct {
+		message  string
+		expected string
+	}{
+		{"", ""},
+		{"no placeholder", "no placeholder"},
+		{"{{if_name}} on {{device}}", "xe-0/0/0 on r1"},
+		{"{{value}} {{value}}", "12 12"},
+		{"{{unknown}}", ""},
+		{"{{ {device} }}", "{{ {device} }}"},
+	}
+	for _, tt := range tests {
+		probe.Message = tt.message
+		require.Equal(t, tt.expected, renderMessage(probe, tags, "12"), tt.message)
+	}
+}
+
+func TestAnomaly(t *testing.T) {
+	p := newMonitoring(Probe{
+		AlarmName:      "CPU_ANOMALY",
+		Field:          "cpu",
+		ProbeType:      "anomaly",
+		Operator:       "gt",
+		Threshold:      3,
+		AnomalySamples: 5,
+	})
+	now := time.Now()
+	// no alarm while the baseline is built, even for an outlier
+	require.Empty(t, alarms(p.Apply(systemMetric(10, now))))
+	require.Empty(t, alarms(p.Apply(systemMetric(100, now.Add(time.Second)))))
+	// the series oscillates around its mean: no anomaly
+	for i := 2; i < 20; i++ {
+		require.Empty(t, alarms(p.Apply(systemMetric(float64(10+2*(i%2)), now.Add(time.Duration(i)*time.Second)))))
+	}
+	out := alarms(p.Apply(systemMetric(100, now.Add(20*time.Second))))
+	require.Len(t, out, 1)
+	deviation, _ := out[0].GetField("exception")
+	require.Greater(t, deviation.(float64), 3.0)
+}
+
+func TestAnomalyDefaultSamples(t *testing.T) {
+	p := newMonitoring(Probe{
+		AlarmName: "CPU_ANOMALY",
+		Field:     "cpu",
+		ProbeType: "anomaly",
+		Operator:  "gt",
+		Threshold: 3,
+	})
+	now := time.Now()
+	for i := 0; i < 30; i++ {
+		require.Empty(t, alarms(p.Apply(systemMetric(float64(10+2*(i%2)), now.Add(time.Duration(i)*time.Second)))))
+	}
+	require.Len(t, alarms(p.Apply(systemMetric(100, now.Add(30*time.Second)))), 1)
+}
+
+// A series without variance has no anomaly
+func TestAnomalyConstantSeries(t *testing.T) {
+	p := newMonitoring(Probe{
+		AlarmName:      "CPU_ANOMALY",
+		Field:          "cpu",
+		ProbeType:      "anomaly",
+		Operator:       "gt",
+		Threshold:      3,
+		AnomalySamples: 5,
+	})
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		require.Empty(t, alarms(p.Apply(systemMetric(10, now.Add(time.Duration(i)*time.Second)))))
+	}
+}
+
+func TestRearmDelay(t *testing.T) {
+	clearThreshold := 80.0
+	p := newMonitoring(Probe{
+		AlarmName:      "CPU_HIGH",
+		Field:          "cpu",
+		ProbeType:      "current",
+		Operator:       "gt",
+		Threshold:      90,
+		ClearThreshold: &clearThreshold,
+		RearmDelay:     "5m",
+	})
+	now := time.Now()
+	apply := func(cpu float64, minutes int) []telegraf.Metric {
+		return alarms(p.Apply(systemMetric(cpu, now.Add(time.Duration(minutes)*time.Minute))))
+	}
+	flaps := func(m telegraf.Metric) int64 {
+		flaps, _ := m.GetField("flaps")
+		return flaps.(int64)
+	}
+
+	out := apply(95, 0)
+	require.Len(t, out, 1)
+	require.Equal(t, int64(0), flaps(out[0]))
+	// the value oscillates: the clear is held and the alarms raised again are counted
+	require.Empty(t, apply(50, 1))
+	require.Empty(t, apply(95, 2))
+	require.Empty(t, apply(50, 3))
+	require.Empty(t, apply(95, 4))
+	require.Empty(t, apply(50, 5))
+	require.Empty(t, apply(50, 9))
+	// clear for the delay: cleared with the number of flaps
+	out = apply(50, 10)
+	require.Len(t, out, 1)
+	state, _ := out[0].GetTag("state")
+	require.Equal(t, "cleared", state)
+	require.Equal(t, int64(2), flaps(out[0]))
+
+	// a new alarm starts without flaps
+	out = apply(95, 11)
+	require.Len(t, out, 1)
+	require.Equal(t, int64(0), flaps(out[0]))
+}
+
+// Without clear threshold, the alarm is forgotten once the value stays clear for the delay
+func TestRearmDelayWithoutClearThreshold(t *testing.T) {
+	p := newMonitoring(Probe{
+		AlarmName:  "CPU_HIGH",
+		Field:      "cpu",
+		ProbeType:  "current",
+		Operator:   "gt",
+		Threshold:  90,
+		RearmDelay: "5m",
+	})
+	now := time.Now()
+	require.Len(t, alarms(p.Apply(systemMetric(95, now))), 1)
+	require.Empty(t, alarms(p.Apply(systemMetric(50, now.Add(time.Minute)))))
+	require.Empty(t, alarms(p.Apply(systemMetric(95, now.Add(2*time.Minute)))))
+	require.Empty(t, alarms(p.Apply(systemMetric(50, now.Add(3*time.Minute)))))
+	require.Empty(t, alarms(p.Apply(systemMetric(50, now.Add(8*time.Minute)))))
+	out := alarms(p.Apply(systemMetric(95, now.Add(9*time.Minute))))
+	require.Len(t, out, 1)
+	flaps, _ := out[0].GetField("flaps")
+	require.Equal(t, int64(0), flaps)
+}
+
+// interfaceStatus is a sample of the oper_status of an interface of r1
+func interfaceStatus(status interface{}, tm time.Time) telegraf.Metric {
+	return metric.New("interfaces",
+		map[string]string{"device": "r1", "if_name": "xe-0/0/0"},
+		map[string]interface{}{"oper_status": status},
+		tm,
+	)
+}
+
+func TestStringProbe(t *testing.T) {
+	tests := []struct {
+		name     string
+		operator string
+		pattern  string
+		status   interface{}
+		alarm    bool
+	}{
+		{"not_match violated", "not_match", "^up$", "down", true},
+		{"not_match", "not_match", "^up$", "up", false},
+		{"match violated", "match", "^(down|testing)$", "testing", true},
+		{"match", "match", "^(down|testing)$", "up", false},
+		{"not a string", "not_match", "^up$", int64(2), false},
+		{"invalid pattern", "match", "^(down", "down", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newMonitoring(Probe{
+				AlarmName: "IF_DOWN",
+				Field:     "oper_status",
+				ProbeType: "current",
+				Operator:  tt.operator,
+				Pattern:   tt.pattern,
+				Message:   "{{if_name}} is {{value}}",
+			})
+			out := alarms(p.Apply(interfaceStatus(tt.status, time.Now())))
+			if !tt.alarm {
+				require.Empty(t, out)
+				return
+			}
+			require.Len(t, out, 1)
+			require.Equal(t, map[string]interface{}{"exception": tt.status, "message": "xe-0/0/0 is " + tt.status.(string)}, out[0].Fields())
+		})
+	}
+}
+
+func TestStringProbeClear(t *testing.T) {
+	p := newMonitoring(Probe{
+		AlarmName:      "IF_DOWN",
+		Field:          "oper_status",
+		ProbeType:      "current",
+		Operator:       "not_match",
+		Pattern:        "^up$",
+		ClearThreshold: new(float64),
+	})
+	now := time.Now()
+	out := alarms(p.Apply(interfaceStatus("down", now)))
+	require.Len(t, out, 1)
+	state, _ := out[0].GetTag("state")
+	require.Equal(t, "raised", state)
+
+	out = alarms(p.Apply(interfaceStatus("up", now.Add(time.Second))))
+	require.Len(t, out, 1)
+	state, _ = out[0].GetTag("state")
+	require.Equal(t, "cleared", state)
+	exception, _ := out[0].GetField("exception")
+	require.Equal(t, "up", exception)
+}
@@ -0,0 +1,35 @@
+package Monitoring
+
+import (
+	"log"
+	"regexp"
+)
+
+// stringProbe reports whether the probe monitors a string field with a regex
+func (probe Probe) stringProbe() bool {
+	return probe.Operator == "match" || probe.Operator == "not_match"
+}
+
+// compilePatterns compiles the regexes of the string probes
+func (p *Monitoring) compilePatterns() {
+	for i := range p.Probe {
+		if !p.Probe[i].stringProbe() {
+			continue
+		}
+		regex, err := regexp.Compile(p.Probe[i].Pattern)
+		if err != nil {
+			log.Printf("E! [processors.monitoring] Invalid pattern %q for probe %s - probe ignored: %v", p.Probe[i].Pattern, p.Probe[i].AlarmName, err)
+			continue
+		}
+		p.Probe[i].regex = regex
+	}
+}
+
+// violated returns 1 when the string value violates the probe - it matches the pattern with the
+// operator "match" or doesn't match it with "not_match" - else 0
+func (probe Probe) violated(value string) float64 {
+	if probe.regex == nil || probe.regex.MatchString(value) != (probe.Operator == "match") {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,169 @@
+package Monitoring
+
+import (
+	"crypto/md5"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// level1Thresholds is the well-known level 2 key of the thresholds applying to a whole level 1
+// tag value (e.g. a whole device)
+const level1Thresholds = "LEVEL1THRESHOLDS"
+
+// thresholds per level 1 tag value, level 2 tag value and alarm name
+type thresholdTable map[string]map[string]map[string]float64
+
+// refreshThresholds re-reads the thresholds file every thresholds_refresh minutes. The table is
+// only rebuilt when the content of the file changed.
+func (p *Monitoring) refreshThresholds() {
+	if p.ThresholdsFile == "" {
+		return
+	}
+	if p.ThresholdsRefresh <= 0 {
+		p.ThresholdsRefresh = 60
+	}
+	if !p.thresholds_update.IsZero() && time.Since(p.thresholds_update) < time.Duration(p.ThresholdsRefresh)*time.Minute {
+		return
+	}
+	p.thresholds_update = time.Now()
+
+	content, err := ioutil.ReadFile(p.ThresholdsFile)
+	if err != nil {
+		log.Printf("E! [processors.monitoring] Error when opening thresholds file %s error is %v", p.ThresholdsFile, err)
+		return
+	}
+	hash := md5.Sum(content)
+	if hex.EncodeToString(hash[:]) == p.thresholds_hash {
+		logPrintf("Thresholds file hash is the same than the previous one - no update needed")
+		return
+	}
+
+	table := make(thresholdTable)
+	if strings.ToLower(filepath.Ext(p.ThresholdsFile)) == ".csv" {
+		err = table.parseCSV(string(content))
+	} else {
+		err = json.Unmarshal(content, &table)
+	}
+	if err != nil {
+		log.Printf("E! [processors.monitoring] Error when parsing thresholds file %s error is %v", p.ThresholdsFile, err)
+		return
+	}
+	logPrintf("Thresholds file %s loaded with %v entries", p.ThresholdsFile, len(table))
+	p.thresholds = table
+	p.thresholds_hash = hex.EncodeToString(hash[:])
+}
+
+// parseCSV reads the lines level1,level2,alarm_name,threshold - an empty level2 applies to the
+// whole level 1 tag value
+func (t thresholdTable) parseCSV(content string) error {
+	records, err := csv.NewReader(strings.NewReader(content)).ReadAll()
+	if err != nil {
+		return err
+	}
+	for i, record := range records {
+		if len(record) != 4 {
+			return fmt.Errorf("line %d: expected level1,level2,alarm_name,threshold", i+1)
+		}
+		threshold, err := strconv.ParseFloat(strings.TrimSpace(record[3]), 64)
+		if err != nil {
+			if i == 0 {
+				// header
+				continue
+			}
+			return fmt.Errorf("line %d: invalid threshold %q", i+1, record[3])
+		}
+		level1, level2 := strings.TrimSpace(record[0]), strings.TrimSpace(record[1])
+		if level2 == "" {
+			level2 = level1Thresholds
+		}
+		if t[level1] == nil {
+			t[level1] = make(map[string]map[string]float64)
+		}
+		if t[level1][level2] == nil {
+			t[level1][level2] = make(map[string]float64)
+		}
+		t[level1][level2][strings.TrimSpace(record[2])] = threshold
+	}
+	return nil
+}
+
+// Override is a threshold of a probe for the metrics with a tag matching a regex
+type Override struct {
+	Tag       string  `toml:"tag"`
+	Pattern   string  `toml:"pattern"`
+	Threshold float64 `toml:"threshold"`
+	// replace the levels of a multi-level probe
+	Levels []Level `toml:"levels"`
+	regex  *regexp.Regexp
+}
+
+// withThreshold returns the probe with another threshold. The levels of a multi-level probe are
+// shifted together so the least severe one gets the threshold.
+func (probe Probe) withThreshold(threshold float64) Probe {
+	if len(probe.Levels) == 0 {
+		probe.Threshold = threshold
+		return probe
+	}
+	shift := threshold - probe.Levels[0].Threshold
+	levels := make([]Level, len(probe.Levels))
+	for i, l := range probe.Levels {
+		levels[i] = Level{Severity: l.Severity, Threshold: l.Threshold + shift}
+	}
+	probe.Levels = levels
+	return probe
+}
+
+// compileOverrides compiles the regexes of the overrides of the probes - an invalid override is ignored
+func (p *Monitoring) compileOverrides() {
+	for i := range p.Probe {
+		for j := range p.Probe[i].Overrides {
+			o := &p.Probe[i].Overrides[j]
+			regex, err := regexp.Compile(o.Pattern)
+			if err != nil {
+				log.Printf("E! [processors.monitoring] Invalid pattern %q for probe %s - override ignored: %v", o.Pattern, p.Probe[i].AlarmName, err)
+				continue
+			}
+			o.regex = regex
+		}
+	}
+}
+
+// override returns the probe with the threshold matching the tags: the entry of the thresholds
+// file (level 2 first, then level 1), else the first override of the probe matching its tag
+func (p *Monitoring) override(probe Probe, tags map[string]string) Probe {
+	for _, o := range probe.Overrides {
+		if value, ok := tags[o.Tag]; ok && o.regex != nil && o.regex.MatchString(value) {
+			if len(o.Levels) > 0 {
+				probe.Levels = o.Levels
+			} else {
+				probe = probe.withThreshold(o.Threshold)
+			}
+			break
+		}
+	}
+	if p.thresholds == nil {
+		return probe
+	}
+	entry, ok := p.thresholds[tags[p.ThresholdsLevel1Tag]]
+	if !ok {
+		return probe
+	}
+	if p.ThresholdsLevel2Tag != "" {
+		if threshold, ok := entry[tags[p.ThresholdsLevel2Tag]][probe.AlarmName]; ok {
+			return probe.withThreshold(threshold)
+		}
+	}
+	if threshold, ok := entry[level1Thresholds][probe.AlarmName]; ok {
+		return probe.withThreshold(threshold)
+	}
+	return probe
+}
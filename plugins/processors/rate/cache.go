@@ -0,0 +1,22 @@
+package rate
+
+// store adds or updates the cache entry of a series and evicts the least recently updated
+// entries above max_cache_entries
+func (p *Rate) store(id uint64, c compute) {
+	p.cache[id] = c
+	p.lru.Touch(id)
+	for p.MaxCacheEntries > 0 && len(p.cache) > p.MaxCacheEntries {
+		oldest, _ := p.lru.Oldest()
+		logPrintf("Cache full, evicting entry %v", oldest)
+		p.remove(oldest)
+		p.evictions.Incr(1)
+	}
+	p.cacheSize.Set(int64(len(p.cache)))
+}
+
+// remove deletes the cache entry of a series
+func (p *Rate) remove(id uint64) {
+	p.lru.Remove(id)
+	delete(p.cache, id)
+	p.cacheSize.Set(int64(len(p.cache)))
+}
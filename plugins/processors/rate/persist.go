@@ -0,0 +1,60 @@
+package rate
+
+import (
+	"time"
+
+	"github.com/influxdata/telegraf/internal/seriescache"
+)
+
+// persistedEntry is a cache entry saved in the cache file
+type persistedEntry struct {
+	Field string    `json:"field"`
+	Value float64   `json:"value"`
+	Time  time.Time `json:"time"`
+	// samples of the window, oldest first
+	History []persistedSample `json:"history,omitempty"`
+}
+
+// persistedSample is a sample of the window saved in the cache file
+type persistedSample struct {
+	Value float64   `json:"value"`
+	Time  time.Time `json:"time"`
+}
+
+// saveCache writes the cache to the cache file
+func (p *Rate) saveCache() error {
+	entries := make(map[uint64]persistedEntry, len(p.cache))
+	for id, c := range p.cache {
+		e := persistedEntry{Field: c.field_name, Value: c.field_value, Time: c.tm}
+		for _, s := range c.history {
+			e.History = append(e.History, persistedSample{Value: s.value, Time: s.tm})
+		}
+		entries[id] = e
+	}
+	if err := seriescache.Save(p.CacheFile, entries); err != nil {
+		return err
+	}
+	logPrintf("%v cache entries saved to %v", len(entries), p.CacheFile)
+	return nil
+}
+
+// loadCache reloads the entries of the cache file still within the retention
+func (p *Rate) loadCache() error {
+	entries := make(map[uint64]persistedEntry)
+	if err := seriescache.Load(p.CacheFile, &entries); err != nil {
+		return err
+	}
+	retention, _ := time.ParseDuration(p.Retention)
+	for id, e := range entries {
+		if retention > 0 && time.Since(e.Time) > retention {
+			continue
+		}
+		c := compute{field_name: e.Field, field_value: e.Value, tm: e.Time}
+		for _, s := range e.History {
+			c.history = append(c.history, sample{value: s.Value, tm: s.Time})
+		}
+		p.store(id, c)
+	}
+	logPrintf("%v cache entries reloaded from %v", len(p.cache), p.CacheFile)
+	return nil
+}
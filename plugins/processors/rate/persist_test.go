@@ -0,0 +1,67 @@
+package rate
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/metric"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheFileRoundTrip(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rate.cache")
+	now := time.Now()
+	p := newRate()
+	p.CacheFile = file
+	require.NoError(t, p.Start(nil))
+	p.Apply(
+		metric.New("interface", map[string]string{"device": "r1"}, map[string]interface{}{"in_octets": float64(1000)}, now.Add(-10*time.Second)),
+		// older than the retention when reloaded
+		metric.New("interface", map[string]string{"device": "r2"}, map[string]interface{}{"in_octets": float64(1000)}, now.Add(-2*time.Hour)),
+	)
+	require.Len(t, p.cache, 2)
+	require.NoError(t, p.Stop())
+
+	reloaded := newRate()
+	reloaded.CacheFile = file
+	require.NoError(t, reloaded.Start(nil))
+	require.Len(t, reloaded.cache, 1)
+	require.Equal(t, 1, reloaded.lru.Len())
+
+	// the rate of the reloaded series is computed from the first sample after the restart
+	r1 := metric.New("interface", map[string]string{"device": "r1"}, map[string]interface{}{"in_octets": float64(2000)}, now)
+	r2 := metric.New("interface", map[string]string{"device": "r2"}, map[string]interface{}{"in_octets": float64(2000)}, now)
+	reloaded.Apply(r1, r2)
+	rate, ok := r1.GetField("in_octets_rate")
+	require.True(t, ok)
+	require.InDelta(t, 100, rate, 1e-9)
+	require.False(t, r2.HasField("in_octets_rate"))
+}
+
+func TestCacheFileMissing(t *testing.T) {
+	p := newRate()
+	p.CacheFile = filepath.Join(t.TempDir(), "rate.cache")
+	require.NoError(t, p.Start(nil))
+	require.Empty(t, p.cache)
+}
+
+// The window history is reloaded with the cache
+func TestCacheFileWindow(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rate.cache")
+	now := time.Now().Truncate(time.Second)
+	at := func(value float64, seconds int) telegraf.Metric {
+		return metric.New("interface", map[string]string{"device": "r1"}, map[string]interface{}{"in_octets": value}, now.Add(time.Duration(seconds)*time.Second))
+	}
+	p := newRate()
+	p.CacheFile, p.WindowSize = file, 3
+	require.NoError(t, p.Start(nil))
+	require.Equal(t, []interface{}{nil, float64(10), float64(15)}, rates(p, at(0, -30), at(100, -20), at(300, -10)))
+	require.NoError(t, p.Stop())
+
+	reloaded := newRate()
+	reloaded.CacheFile, reloaded.WindowSize = file, 3
+	require.NoError(t, reloaded.Start(nil))
+	require.Equal(t, []interface{}{float64(20)}, rates(reloaded, at(600, 0)))
+}
@@ -1,11 +1,19 @@
 package rate
 
 import (
+	"fmt"
 	"log"
+	"math"
+	"strconv"
+	"strings"
 	"time"
 	"hash/fnv"
     "github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/instance"
+	"github.com/influxdata/telegraf/internal/seriescache"
+	telegrafmetric "github.com/influxdata/telegraf/metric"
     "github.com/influxdata/telegraf/plugins/processors"
+	"github.com/influxdata/telegraf/selfstat"
 )
 
 var sampleConfig = `
@@ -16,6 +24,8 @@ var sampleConfig = `
 ## List of fields for which the rate must be computed
 ## 
 fields = ["in_octets","out_octets"]
+## The factor and the suffix can be overridden per field
+# fields = [{name="in_octets", factor=8, suffix="_bps"}, {name="in_pkts", factor=1}]
 ##
 ## Base rate is /s, factor can be used to adjust (bytes to bits factor = 8 or seconds to minutes factor = 60)
 factor =  8
@@ -24,31 +34,316 @@ delta_min = 10s
 ## Suffix set characters to be appended to the original's field name
 suffix ="_rate"
 ##
+## Mode of the fields (also settable per field): "counter" computes the rate of a monotonic
+## counter (default), "gauge" emits the signed delta (times the factor) between two samples of a
+## gauge such as a queue depth or a route count - negative deltas are kept.
+# mode = "counter"
+# fields = [{name="in_octets"}, {name="route_count", mode="gauge", suffix="_delta"}]
+##
+## Counter wrap: when the fields are 32 or 64-bit counters (counter_bits = 32 or 64, also settable
+## per field), a decreasing counter is considered wrapped and the rate is computed with the wrapped
+## delta, unless this delta exceeds wrap_limit (fraction of the counter range) - the counter was
+## then reset and the sample is discarded as before. By default (0) the negative deltas are discarded.
+# counter_bits = 0
+# wrap_limit = 0.5
+# fields = [{name="in_octets", counter_bits=32}, {name="in_hc_octets", counter_bits=64}]
+##
+## Plausibility clamp: the rates above max_rate (after the factor) are dropped and counted as
+## anomalies (internal_rate measurement). The maximum can be taken per metric from a field or tag
+## holding the interface speed, e.g. "10Gbps" or 10000 with max_rate_unit = "mbps" - it is then
+## used instead of the static max_rate. 0 disables the clamp.
+# max_rate = 0.0
+# max_rate_source = "speed"
+# max_rate_unit = "bps"
+##
+## Windowed rate: the rate is computed over the last window_size intervals and/or the samples of
+## the last window duration rather than over two consecutive samples, smoothing the collection
+## jitter. By default the consecutive samples are used.
+# window_size = 4
+# window = "2m"
+##
+## Utilization: for the utilization fields, the rate (in bps after the factor) is divided by the
+## speed held by the speed_source field or tag and emitted as <field>_util_percent. The speeds
+## without unit (e.g. 10000) are in speed_unit (bps, kbps, mbps, gbps).
+# utilization_fields = ["in_octets", "out_octets"]
+# speed_source = "speed"
+# speed_unit = "bps"
+##
+## Output of the rates: "append" adds the suffixed fields to the metric (default), "replace"
+## removes the counters from the metric and adds the suffixed rates (set suffix = "" to keep the
+## counter names), "measurement" emits the rates in a separate metric named output_measurement
+## with the same tags and timestamp - the source metric is left untouched.
+# output = "append"
+# output_measurement = "rate"
+##
+## Tags identifying a series in the cache - by default all the tags. Set them so that adding an
+## unrelated tag (e.g. by an enrichment processor) doesn't reset the rate history.
+# identity_tags = ["device", "if_name"]
+##
 ##Period set the time to wait between two cache cleanup operation
 period = "5m"
 ##Retention set how long the data are cached before being removed
 ##Each time an arriving metric matches an entry in the cache, the entry is updated. Though, only data that had no matches during this retention window are removed.
 retention = "1h"
+##
+## Maximum number of cache entries - the least recently updated entries are evicted above it.
+## 0 means unlimited. The cache_size, evictions, negative_deltas, delta_min_skipped and anomalies
+## statistics are reported in the internal_rate measurement, tagged with the alias of the
+## processor and an instance number distinct per running copy of the processor - telegraf runs a
+## second copy of each processor when aggregators are configured.
+# max_cache_entries = 0
+##
+## File where the cache is saved on shutdown and reloaded from on start, so a restart doesn't lose
+## the first sample of every series. The window history is saved with it. The entries older than
+## the retention are not reloaded. With aggregators, telegraf runs a second copy of the processor
+## sharing the same file: the copy stopped last overwrites it - use a distinct cache_file (and
+## alias) per processor of the configuration.
+# cache_file = "/var/lib/telegraf/rate.cache"
 `
 
 type Rate struct {
 	Log   		telegraf.Logger
-	Fields		[]string	`toml:"fields"`
+	Alias		string		`toml:"alias"`
+	Fields		[]rateField	`toml:"fields"`
 	Suffix		string		`toml:"suffix"`
 	Factor		float64		`toml:"factor"`
 	Delta_min   string		`toml:"delta_min"`
-	fields_map	map[string]struct{}
+	fields_map	map[string]rateField
 	initialized bool
 	Period		string		`toml:"period"`
 	Retention 	string		`toml:"retention"`
+	CounterBits	int		`toml:"counter_bits"`
+	WrapLimit	float64		`toml:"wrap_limit"`
+	Output		string		`toml:"output"`
+	UtilizationFields	[]string	`toml:"utilization_fields"`
+	SpeedSource	string		`toml:"speed_source"`
+	SpeedUnit	string		`toml:"speed_unit"`
+	utilization_map	map[string]struct{}
+	Mode		string		`toml:"mode"`
+	WindowSize	int		`toml:"window_size"`
+	Window		string		`toml:"window"`
+	CacheFile	string		`toml:"cache_file"`
+	IdentityTags	[]string	`toml:"identity_tags"`
+	MaxRate		float64		`toml:"max_rate"`
+	MaxRateSource	string		`toml:"max_rate_source"`
+	MaxRateUnit	string		`toml:"max_rate_unit"`
+	MaxCacheEntries	int		`toml:"max_cache_entries"`
+	lru		*seriescache.LRU
+	anomalies	selfstat.Stat
+	cacheSize	selfstat.Stat
+	evictions	selfstat.Stat
+	negativeDeltas	selfstat.Stat
+	deltaMinSkipped	selfstat.Stat
+	OutputMeasurement	string	`toml:"output_measurement"`
 	last_cleared	time.Time
 	cache       map[uint64]compute
 	}
 
+// rateField is a field for which the rate is computed, given by its name or by a table overriding
+// the factor and the suffix: {name="in_octets", factor=8, suffix="_bps"}
+type rateField struct {
+	Name	string		`toml:"name"`
+	Factor	*float64	`toml:"factor"`
+	Suffix	*string		`toml:"suffix"`
+	CounterBits	int	`toml:"counter_bits"`
+	Mode	string		`toml:"mode"`
+}
+
+func (f *rateField) UnmarshalTOML(fn func(interface{}) error) error {
+	if err := fn(&f.Name); err == nil {
+		return nil
+	}
+	// the factor may be written as an integer
+	var table struct {
+		Name	string		`toml:"name"`
+		Factor	interface{}	`toml:"factor"`
+		Suffix	*string		`toml:"suffix"`
+		CounterBits	int	`toml:"counter_bits"`
+		Mode	string		`toml:"mode"`
+	}
+	if err := fn(&table); err != nil {
+		return err
+	}
+	f.Name, f.Suffix, f.CounterBits, f.Mode = table.Name, table.Suffix, table.CounterBits, table.Mode
+	if table.Factor != nil {
+		factor, ok := convert(table.Factor)
+		if !ok {
+			return fmt.Errorf("invalid factor %v for field %s", table.Factor, table.Name)
+		}
+		f.Factor = &factor
+	}
+	return nil
+}
+
+// factor returns the factor of the field or the global one
+func (p *Rate) factor(f rateField) float64 {
+	if f.Factor != nil {
+		return *f.Factor
+	}
+	return p.Factor
+}
+
+// mode returns the mode of the field or the global one
+func (p *Rate) mode(f rateField) string {
+	if f.Mode != "" {
+		return f.Mode
+	}
+	return p.Mode
+}
+
+// suffix returns the suffix of the field or the global one
+func (p *Rate) suffix(f rateField) string {
+	if f.Suffix != nil {
+		return *f.Suffix
+	}
+	return p.Suffix
+}
+
+// wrappedDelta returns the delta of a decreasing counter assuming it wrapped. It fails when the
+// field is not a 32 or 64-bit counter or when the delta exceeds the wrap limit (counter reset).
+func (p *Rate) wrappedDelta(f rateField, previous float64, value float64) (float64, bool) {
+	bits := f.CounterBits
+	if bits == 0 {
+		bits = p.CounterBits
+	}
+	if bits != 32 && bits != 64 {
+		return 0, false
+	}
+	limit := p.WrapLimit
+	if limit <= 0 || limit > 1 {
+		limit = 0.5
+	}
+	counterRange := math.Pow(2, float64(bits))
+	if previous >= counterRange {
+		return 0, false
+	}
+	delta := counterRange - previous + value
+	if delta > limit*counterRange {
+		return 0, false
+	}
+	return delta, true
+}
+
+// identity returns the tags identifying the series of the metric in the cache: the identity tags
+// when configured, otherwise all the tags
+func (p *Rate) identity(m telegraf.Metric) string {
+	tags := ""
+	if len(p.IdentityTags) == 0 {
+		for _, tag := range m.TagList() {
+			tags = tags + tag.Key + tag.Value
+		}
+		return tags
+	}
+	for _, key := range p.IdentityTags {
+		if value, ok := m.GetTag(key); ok {
+			tags = tags + key + value
+		}
+	}
+	return tags
+}
+
+// speed prefixes of max_rate_unit and of the speed values
+var speedPrefixes = map[byte]float64{
+	'k': 1e3,
+	'm': 1e6,
+	'g': 1e9,
+	't': 1e12,
+}
+
+// maxRate returns the maximum plausible rate of the metric, 0 when unknown
+func (p *Rate) maxRate(m telegraf.Metric) float64 {
+	if p.MaxRateSource == "" {
+		return p.MaxRate
+	}
+	if speed := speed(m, p.MaxRateSource, p.MaxRateUnit); speed > 0 {
+		return speed
+	}
+	return p.MaxRate
+}
+
+// utilization returns the rate of a utilization field in percent of the speed of the metric
+func (p *Rate) utilization(m telegraf.Metric, field string, rate float64) (float64, bool) {
+	if _, ok := p.utilization_map[field]; !ok {
+		return 0, false
+	}
+	speed := speed(m, p.SpeedSource, p.SpeedUnit)
+	if speed <= 0 {
+		return 0, false
+	}
+	return rate * 100 / speed, true
+}
+
+// speed returns the speed in bps held by a field or a tag of the metric, 0 when unknown. The
+// numbers and the values without unit are in the given unit.
+func speed(m telegraf.Metric, source string, unit string) float64 {
+	value, ok := m.GetField(source)
+	if !ok {
+		tag, ok := m.GetTag(source)
+		if !ok {
+			return 0
+		}
+		value = tag
+	}
+	if speed, ok := convert(value); ok {
+		return speed * parseSpeed("1"+unit)
+	}
+	if speed, ok := value.(string); ok {
+		if strings.HasSuffix(strings.ToLower(speed), "bps") {
+			return parseSpeed(speed)
+		}
+		return parseSpeed(speed) * parseSpeed("1"+unit)
+	}
+	return 0
+}
+
+// parseSpeed parses a speed like "10Gbps", "100m" or "1000" in bps, 0 when invalid
+func parseSpeed(s string) float64 {
+	s = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), "bps")
+	factor := 1.0
+	if len(s) > 0 {
+		if f, ok := speedPrefixes[s[len(s)-1]]; ok {
+			s, factor = s[:len(s)-1], f
+		}
+	}
+	speed, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0
+	}
+	return speed * factor
+}
+
 type compute struct {
 	field_name string
 	field_value   float64
 	tm time.Time
+	// samples before this one within the window, oldest first
+	history []sample
+}
+
+// sample is a previous value of a series kept for the windowed rate
+type sample struct {
+	value float64
+	tm    time.Time
+}
+
+// windowed returns the samples before the current one within the window, oldest first. The
+// previous sample is always kept.
+func (p *Rate) windowed(prev compute, now time.Time) []sample {
+	if p.WindowSize <= 1 && p.Window == "" {
+		return nil
+	}
+	history := make([]sample, 0, len(prev.history)+1)
+	history = append(history, prev.history...)
+	history = append(history, sample{value: prev.field_value, tm: prev.tm})
+	window, _ := time.ParseDuration(p.Window)
+	for len(history) > 1 {
+		if (p.WindowSize > 1 && len(history) > p.WindowSize) || (window > 0 && now.Sub(history[0].tm) > window) {
+			history = history[1:]
+			continue
+		}
+		break
+	}
+	return history
 }
 
 func(p * Rate) SampleConfig() string {
@@ -65,6 +360,59 @@ func hash(s string) uint64 {
 	return h.Sum64()
 }
 
+func (p *Rate) init() {
+	logPrintf("Initializing...")
+	p.cache = make(map[uint64]compute)
+	p.lru = seriescache.NewLRU()
+	p.fields_map = make(map[string]rateField)
+	for _, f := range p.Fields {
+		p.fields_map[f.Name] = f
+		logPrintf("Adding field %v with factor %v and suffix %v", f.Name, p.factor(f), p.suffix(f))
+	}
+	p.utilization_map = make(map[string]struct{})
+	for _, name := range p.UtilizationFields {
+		p.utilization_map[name] = struct{}{}
+	}
+	tags := instance.Tags(p.Alias)
+	p.anomalies = selfstat.Register("rate", "anomalies", tags)
+	p.cacheSize = selfstat.Register("rate", "cache_size", tags)
+	p.evictions = selfstat.Register("rate", "evictions", tags)
+	p.negativeDeltas = selfstat.Register("rate", "negative_deltas", tags)
+	p.deltaMinSkipped = selfstat.Register("rate", "delta_min_skipped", tags)
+	p.initialized = true
+	p.last_cleared = time.Now()
+}
+
+// Start reloads the cache saved at the last shutdown
+func (p *Rate) Start(acc telegraf.Accumulator) error {
+	p.init()
+	if p.CacheFile == "" {
+		return nil
+	}
+	if err := p.loadCache(); err != nil {
+		p.Log.Warnf("Cannot reload the cache from %s: %v", p.CacheFile, err)
+	}
+	return nil
+}
+
+func (p *Rate) Add(m telegraf.Metric, acc telegraf.Accumulator) error {
+	for _, m := range p.Apply(m) {
+		acc.AddMetric(m)
+	}
+	return nil
+}
+
+// Stop saves the cache for the next start
+func (p *Rate) Stop() error {
+	if p.CacheFile == "" {
+		return nil
+	}
+	if err := p.saveCache(); err != nil {
+		return fmt.Errorf("cannot save the cache to %s: %v", p.CacheFile, err)
+	}
+	return nil
+}
+
 func(p * Rate) Apply(metrics...telegraf.Metric)[] telegraf.Metric {
 	//var nb_deleted int
 	//var t_period time.Duration
@@ -73,15 +421,7 @@ func(p * Rate) Apply(metrics...telegraf.Metric)[] telegraf.Metric {
 	t_retention,_ := time.ParseDuration(p.Retention)
 	t_delta_min,_ := time.ParseDuration(p.Delta_min)
 	if !p.initialized {
-		logPrintf("Initializing...")
-		p.cache = make(map[uint64]compute)
-		p.fields_map = make(map[string]struct{})
-		for _,name := range p.Fields{
-			p.fields_map[name] = struct{}{}
-			logPrintf("Adding field %v", name)
-		}
-		p.initialized = true
-		p.last_cleared = time.Now()
+		p.init()
 	}
 	if time.Now().After(p.last_cleared.Add(t_period)) {
 		logPrintf("Time to clean the cache, nb cache entries %v",len(p.cache))
@@ -90,7 +430,7 @@ func(p * Rate) Apply(metrics...telegraf.Metric)[] telegraf.Metric {
 			logPrintf("Hashid %v time %v",k,v.tm)
 			if time.Now().After(v.tm.Add(t_retention)) {
 				logPrintf("delete entry %v from cache",k)
-				delete(p.cache,k)
+				p.remove(k)
 				nb_deleted +=1
 			}
 		}
@@ -98,13 +438,14 @@ func(p * Rate) Apply(metrics...telegraf.Metric)[] telegraf.Metric {
 		p.last_cleared = time.Now()
 	}
 	for _, metric := range metrics {
-		tags := ""
-		for _, tag := range metric.TagList() {
-			tags = tags + tag.Key + tag.Value
-		}
+		// rates computed for this metric - emitted according to the output option
+		rates := make([]*telegraf.Field, 0)
+		counters := make([]string, 0)
+		tags := p.identity(metric)
 		for _, field := range metric.FieldList() {
 			// Check if the field belongs to the list of fields that need to be computed
-			if _, ok := p.fields_map[field.Key]; ok{
+			if f, ok := p.fields_map[field.Key]; ok{
+				counters = append(counters, field.Key)
 				//check if the value of the field can be converted to float64
 				if value, ok := convert(field.Value); ok {
 					a := compute{
@@ -118,35 +459,94 @@ func(p * Rate) Apply(metrics...telegraf.Metric)[] telegraf.Metric {
 					if _, ok := p.cache[id]; ok {
 						delta := metric.Time().Sub(p.cache[id].tm).Seconds()
 						if delta > float64(t_delta_min.Seconds()) {
-							field_rate := (value - p.cache[id].field_value)*p.Factor / float64(delta)
-							if field_rate >= 0 {
-								logPrintf("Adding field %v for metric with hashid %v",field.Key+p.Suffix, id)
-								// The result is then added as a new field to the metric
-								metric.AddField(field.Key+p.Suffix,field_rate)
+							diff := value - p.cache[id].field_value
+							if p.mode(f) == "gauge" {
+								// signed delta of a gauge - not a rate
+								logPrintf("Adding field %v for metric with hashid %v",field.Key+p.suffix(f), id)
+								rates = append(rates, &telegraf.Field{Key: field.Key+p.suffix(f), Value: diff*p.factor(f)})
+								p.store(id, a)
+								continue
+							}
+							if wrapped, ok := p.wrappedDelta(f, p.cache[id].field_value, value); diff < 0 && ok {
+								logPrintf("Counter wrap detected on hashid %v", id)
+								diff = wrapped
+							}
+							field_rate := diff*p.factor(f) / float64(delta)
+							// smoothed over the window - a decreasing counter in the window restarts it
+							history := p.windowed(p.cache[id], metric.Time())
+							if len(history) > 1 && value >= history[0].value && field_rate >= 0 {
+								field_rate = (value - history[0].value)*p.factor(f) / metric.Time().Sub(history[0].tm).Seconds()
+								a.history = history
+							} else if len(history) > 0 && field_rate >= 0 {
+								a.history = history[len(history)-1:]
+							}
+							if max := p.maxRate(metric); max > 0 && field_rate > max {
+								logPrintf("Rate %v above the maximum %v discarded on hashid %v", field_rate, max, id)
+								p.anomalies.Incr(1)
+								p.store(id, a)
+							} else if field_rate >= 0 {
+								logPrintf("Adding field %v for metric with hashid %v",field.Key+p.suffix(f), id)
+								rates = append(rates, &telegraf.Field{Key: field.Key+p.suffix(f), Value: field_rate})
+								if u, ok := p.utilization(metric, field.Key, field_rate); ok {
+									rates = append(rates, &telegraf.Field{Key: field.Key+"_util_percent", Value: u})
+								}
 								// The cache is updated with the latest value
 								logPrintf("Updating cache entry for metric with hashid %v", id)
-								p.cache[id] = a									
+								p.store(id, a)
 							} else {
 								logPrintf("Negative rate discarded, reset counter has occured on hashid %v", id)
+								p.negativeDeltas.Incr(1)
 								logPrintf("Updating cache entry for metric with hashid %v", id)
-								p.cache[id] = a		
+								p.store(id, a)
 							}
 						} else {
 							logPrintf("Skip cause delta_min constraint not met for metric with hashid %v", id)
+							p.deltaMinSkipped.Incr(1)
 						}
 					} else {
 						logPrintf("Creating cache entry for metric with hashid %v", id)
-						p.cache[id] = a
+						p.store(id, a)
 					}
 				} else {
 					logPrintf("Value cannot be converted to float %v", field.Value)
 				}
 			}
 		}
+		if m := p.output(metric, counters, rates); m != nil {
+			metrics = append(metrics, m)
+		}
 	}
 	return metrics
 }
 
+// output adds the rates to the metric, replaces its counters or returns a separate metric
+func (p *Rate) output(m telegraf.Metric, counters []string, rates []*telegraf.Field) telegraf.Metric {
+	switch p.Output {
+	case "measurement":
+		if len(rates) == 0 {
+			return nil
+		}
+		name := p.OutputMeasurement
+		if name == "" {
+			name = "rate"
+		}
+		fields := make(map[string]interface{}, len(rates))
+		for _, rate := range rates {
+			fields[rate.Key] = rate.Value
+		}
+		return telegrafmetric.New(name, m.Tags(), fields, m.Time())
+	case "replace":
+		for _, counter := range counters {
+			m.RemoveField(counter)
+		}
+	}
+	// The results are then added as new fields to the metric
+	for _, rate := range rates {
+		m.AddField(rate.Key, rate.Value)
+	}
+	return nil
+}
+
 func logPrintf(format string, v...interface {}) {
     log.Printf("D! [processors.rate] " + format, v...)
 }
@@ -165,7 +565,7 @@ func convert(in interface{}) (float64, bool) {
 }
 
 func init() {
-    processors.Add("rate", func() telegraf.Processor {
-        return &Rate {}
-    })
+	processors.AddStreaming("rate", func() telegraf.StreamingProcessor {
+		return &Rate{}
+	})
 }
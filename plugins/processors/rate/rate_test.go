@@ -0,0 +1,466 @@
+package rate
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/metric"
+	"github.com/influxdata/toml"
+	"github.com/stretchr/testify/require"
+)
+
+func newRate() *Rate {
+	return &Rate{
+		Fields:    []rateField{{Name: "in_octets"}},
+		Factor:    1,
+		Suffix:    "_rate",
+		Period:    "5m",
+		Retention: "1h",
+	}
+}
+
+func counter(value float64, seconds int64) telegraf.Metric {
+	return metric.New("interface",
+		map[string]string{"device": "r1", "if_name": "et-0/0/0"},
+		map[string]interface{}{"in_octets": value},
+		time.Unix(seconds, 0),
+	)
+}
+
+// interfaceMetric is a metric of the interface with several fields
+func interfaceMetric(fields map[string]interface{}, seconds int64) telegraf.Metric {
+	return metric.New("interface",
+		map[string]string{"device": "r1", "if_name": "et-0/0/0"},
+		fields,
+		time.Unix(seconds, 0),
+	)
+}
+
+// rates applies the samples in order and returns the rate of each of them, nil without rate
+func rates(p *Rate, samples ...telegraf.Metric) []interface{} {
+	out := make([]interface{}, 0, len(samples))
+	for _, m := range samples {
+		p.Apply(m)
+		value, _ := m.GetField("in_octets_rate")
+		out = append(out, value)
+	}
+	return out
+}
+
+func TestWrappedDelta(t *testing.T) {
+	max32 := math.Pow(2, 32)
+	max64 := math.Pow(2, 64)
+	tests := []struct {
+		name        string
+		counterBits int
+		fieldBits   int
+		wrapLimit   float64
+		previous    float64
+		value       float64
+		delta       float64
+		ok          bool
+	}{
+		{name: "32-bit wrap", counterBits: 32, previous: max32 - 100, value: 50, delta: 150, ok: true},
+		{name: "64-bit wrap", counterBits: 64, previous: max64 - 4096, value: 4096, delta: 8192, ok: true},
+		{name: "field bits", fieldBits: 32, previous: max32 - 100, value: 50, delta: 150, ok: true},
+		{name: "field bits override", counterBits: 64, fieldBits: 32, previous: max32 - 100, value: 50, delta: 150, ok: true},
+		{name: "not a counter", previous: max32 - 100, value: 50},
+		{name: "invalid bits", counterBits: 16, previous: 65000, value: 50},
+		{name: "reset above the default limit", counterBits: 32, previous: max32 * 0.4, value: 10},
+		{name: "reset above the wrap limit", counterBits: 32, wrapLimit: 0.1, previous: max32 * 0.8, value: 10},
+		{name: "wrap below the wrap limit", counterBits: 32, wrapLimit: 0.9, previous: max32 * 0.4, value: 10, delta: max32*0.6 + 10, ok: true},
+		{name: "invalid wrap limit", counterBits: 32, wrapLimit: 2, previous: max32 * 0.4, value: 10},
+		{name: "previous out of range", counterBits: 32, previous: max32 + 1, value: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Rate{CounterBits: tt.counterBits, WrapLimit: tt.wrapLimit}
+			delta, ok := p.wrappedDelta(rateField{Name: "in_octets", CounterBits: tt.fieldBits}, tt.previous, tt.value)
+			require.Equal(t, tt.ok, ok)
+			require.Equal(t, tt.delta, delta)
+		})
+	}
+}
+
+func TestCounterWrap(t *testing.T) {
+	max32 := math.Pow(2, 32)
+	tests := []struct {
+		name        string
+		counterBits int
+		wrapLimit   float64
+		samples     []telegraf.Metric
+		expected    []interface{}
+	}{
+		{
+			name:        "32-bit wrap",
+			counterBits: 32,
+			samples:     []telegraf.Metric{counter(max32-1000, 0), counter(1000, 10)},
+			expected:    []interface{}{nil, float64(200)},
+		},
+		{
+			name:        "64-bit wrap",
+			counterBits: 64,
+			samples:     []telegraf.Metric{counter(math.Pow(2, 64)-4096, 0), counter(6144, 10)},
+			expected:    []interface{}{nil, float64(1024)},
+		},
+		{
+			name:     "negative delta discarded without counter bits",
+			samples:  []telegraf.Metric{counter(max32-1000, 0), counter(1000, 10), counter(2000, 20)},
+			expected: []interface{}{nil, nil, float64(100)},
+		},
+		{
+			name:        "reset rejected by the wrap limit",
+			counterBits: 32,
+			wrapLimit:   0.1,
+			samples:     []telegraf.Metric{counter(max32/2, 0), counter(1000, 10), counter(2000, 20)},
+			expected:    []interface{}{nil, nil, float64(100)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newRate()
+			p.CounterBits = tt.counterBits
+			p.WrapLimit = tt.wrapLimit
+			require.Equal(t, tt.expected, rates(p, tt.samples...))
+		})
+	}
+}
+
+func TestWindowedRate(t *testing.T) {
+	max32 := math.Pow(2, 32)
+	tests := []struct {
+		name        string
+		windowSize  int
+		window      string
+		counterBits int
+		samples     []telegraf.Metric
+		expected    []interface{}
+	}{
+		{
+			name:     "consecutive samples by default",
+			samples:  []telegraf.Metric{counter(0, 0), counter(100, 10), counter(300, 20)},
+			expected: []interface{}{nil, float64(10), float64(20)},
+		},
+		{
+			// the window fills up to window_size intervals, then the oldest sample is evicted
+			name:       "window size",
+			windowSize: 3,
+			samples:    []telegraf.Metric{counter(0, 0), counter(100, 10), counter(300, 20), counter(600, 30), counter(1000, 40)},
+			expected:   []interface{}{nil, float64(10), float64(15), float64(20), float64(30)},
+		},
+		{
+			// the samples older than the window are evicted, the previous one is always kept
+			name:     "window duration",
+			window:   "25s",
+			samples:  []telegraf.Metric{counter(0, 0), counter(100, 10), counter(300, 20), counter(600, 30), counter(1000, 90)},
+			expected: []interface{}{nil, float64(10), float64(15), float64(25), float64(400) / 60},
+		},
+		{
+			// the first limit reached evicts the sample
+			name:       "window size and duration",
+			windowSize: 2,
+			window:     "25s",
+			samples:    []telegraf.Metric{counter(0, 0), counter(100, 10), counter(300, 20), counter(600, 30), counter(1000, 40), counter(1300, 70)},
+			expected:   []interface{}{nil, float64(10), float64(15), float64(25), float64(35), float64(10)},
+		},
+		{
+			// a wrap restarts the window from the sample before it
+			name:        "wrap in the window",
+			windowSize:  3,
+			counterBits: 32,
+			samples:     []telegraf.Metric{counter(max32-200, 0), counter(max32-100, 10), counter(100, 20), counter(400, 30), counter(600, 40)},
+			expected:    []interface{}{nil, float64(10), float64(20), float64(30), float64(25)},
+		},
+		{
+			// a reset restarts the window from the reset sample
+			name:       "reset in the window",
+			windowSize: 3,
+			samples:    []telegraf.Metric{counter(1000, 0), counter(2000, 10), counter(100, 20), counter(300, 30), counter(600, 40)},
+			expected:   []interface{}{nil, float64(100), nil, float64(20), float64(25)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newRate()
+			p.WindowSize = tt.windowSize
+			p.Window = tt.window
+			p.CounterBits = tt.counterBits
+			require.Equal(t, tt.expected, rates(p, tt.samples...))
+		})
+	}
+}
+
+// The statistics of the running copies are kept apart, even with the same alias
+func TestInstanceStats(t *testing.T) {
+	first, second := newRate(), newRate()
+	first.Alias, second.Alias = "core", "core"
+	rates(first, counter(100, 0), counter(50, 10))
+	rates(second, counter(100, 0), counter(200, 10))
+	require.Equal(t, int64(1), first.negativeDeltas.Get())
+	require.Equal(t, int64(0), second.negativeDeltas.Get())
+	require.Equal(t, "core", first.negativeDeltas.Tags()["alias"])
+	require.NotEqual(t, first.cacheSize.Tags(), second.cacheSize.Tags())
+}
+
+// The fields are given by name, or by a table overriding the factor (integer or float) and the suffix
+func TestFieldsConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		expected map[string]interface{}
+	}{
+		{
+			name:   "names",
+			config: `fields = ["in_octets", "in_pkts"]`,
+			expected: map[string]interface{}{
+				"in_octets":      int64(1000),
+				"in_octets_rate": float64(200),
+				"in_pkts":        uint64(100),
+				"in_pkts_rate":   float64(20),
+				"out_pkts":       100.0,
+			},
+		},
+		{
+			name:   "tables",
+			config: `fields = [{name="in_octets", factor=8, suffix="_bps"}, {name="in_pkts", factor=0.5}, {name="out_pkts", suffix=""}]`,
+			expected: map[string]interface{}{
+				"in_octets":     int64(1000),
+				"in_octets_bps": float64(800),
+				"in_pkts":       uint64(100),
+				"in_pkts_rate":  float64(5),
+				// an empty suffix replaces the counter
+				"out_pkts": float64(20),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Rate{}
+			require.NoError(t, toml.Unmarshal([]byte(tt.config+"\nfactor = 2.0\nsuffix = \"_rate\"\nperiod = \"5m\"\n"), p))
+			p.Apply(interfaceMetric(map[string]interface{}{"in_octets": int64(0), "in_pkts": uint64(0), "out_pkts": 0.0}, 0))
+			out := p.Apply(interfaceMetric(map[string]interface{}{"in_octets": int64(1000), "in_pkts": uint64(100), "out_pkts": 100.0}, 10))
+			require.Equal(t, tt.expected, out[0].Fields())
+		})
+	}
+}
+
+func TestFieldsConfigInvalidFactor(t *testing.T) {
+	require.Error(t, toml.Unmarshal([]byte(`fields = [{name="in_octets", factor="8"}]`), &Rate{}))
+}
+
+func TestOutput(t *testing.T) {
+	tests := []struct {
+		name        string
+		output      string
+		measurement string
+		suffix      string
+		expected    []telegraf.Metric
+	}{
+		{
+			name:   "append",
+			suffix: "_rate",
+			expected: []telegraf.Metric{
+				interfaceMetric(map[string]interface{}{"in_octets": float64(1000), "in_octets_rate": float64(100), "oper_status": "up"}, 10),
+			},
+		},
+		{
+			name:   "replace",
+			output: "replace",
+			suffix: "_rate",
+			expected: []telegraf.Metric{
+				interfaceMetric(map[string]interface{}{"in_octets_rate": float64(100), "oper_status": "up"}, 10),
+			},
+		},
+		{
+			name:   "replace keeping the names",
+			output: "replace",
+			expected: []telegraf.Metric{
+				interfaceMetric(map[string]interface{}{"in_octets": float64(100), "oper_status": "up"}, 10),
+			},
+		},
+		{
+			name:        "measurement",
+			output:      "measurement",
+			measurement: "interface_rate",
+			suffix:      "_rate",
+			expected: []telegraf.Metric{
+				interfaceMetric(map[string]interface{}{"in_octets": float64(1000), "oper_status": "up"}, 10),
+				metric.New("interface_rate", map[string]string{"device": "r1", "if_name": "et-0/0/0"}, map[string]interface{}{"in_octets_rate": float64(100)}, time.Unix(10, 0)),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newRate()
+			p.Output, p.OutputMeasurement, p.Suffix = tt.output, tt.measurement, tt.suffix
+			// without rate, the separate measurement is not emitted
+			require.Len(t, p.Apply(interfaceMetric(map[string]interface{}{"in_octets": float64(0), "oper_status": "up"}, 0)), 1)
+			out := p.Apply(interfaceMetric(map[string]interface{}{"in_octets": float64(1000), "oper_status": "up"}, 10))
+			require.Len(t, out, len(tt.expected))
+			for i, m := range tt.expected {
+				require.Equal(t, m.Name(), out[i].Name())
+				require.Equal(t, m.Tags(), out[i].Tags())
+				require.Equal(t, m.Fields(), out[i].Fields())
+				require.Equal(t, m.Time(), out[i].Time())
+			}
+		})
+	}
+}
+
+// The default name of the separate measurement is rate
+func TestOutputMeasurementDefault(t *testing.T) {
+	p := newRate()
+	p.Output = "measurement"
+	out := append(p.Apply(counter(0, 0)), p.Apply(counter(1000, 10))...)
+	require.Len(t, out, 3)
+	require.Equal(t, "rate", out[2].Name())
+}
+
+func TestMaxRate(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxRate  float64
+		source   string
+		unit     string
+		speed    interface{}
+		expected interface{}
+	}{
+		{name: "static", maxRate: 1000, expected: nil},
+		{name: "static above", maxRate: 8000, expected: float64(8000)},
+		{name: "speed tag with unit", maxRate: 1e12, source: "speed", speed: "1kbps", expected: nil},
+		{name: "speed tag above", source: "speed", speed: "10Gbps", expected: float64(8000)},
+		{name: "speed field in the unit", source: "speed", unit: "kbps", speed: int64(1), expected: nil},
+		{name: "speed field without unit", source: "speed", speed: uint64(10000), expected: float64(8000)},
+		{name: "speed without unit in the unit", source: "speed", unit: "mbps", speed: "0.001", expected: nil},
+		// the static maximum is used when the speed is unknown
+		{name: "invalid speed", maxRate: 1000, source: "speed", speed: "fast", expected: nil},
+		{name: "missing speed", source: "speed", expected: float64(8000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newRate()
+			p.Factor = 8
+			p.MaxRate, p.MaxRateSource, p.MaxRateUnit = tt.maxRate, tt.source, tt.unit
+			at := func(value float64, seconds int64) telegraf.Metric {
+				m := counter(value, seconds)
+				switch speed := tt.speed.(type) {
+				case string:
+					m.AddTag("speed", speed)
+				case nil:
+				default:
+					m.AddField("speed", speed)
+				}
+				return m
+			}
+			require.Equal(t, []interface{}{nil, tt.expected}, rates(p, at(0, 0), at(10000, 10)))
+			anomalies := int64(0)
+			if tt.expected == nil {
+				anomalies = 1
+			}
+			require.Equal(t, anomalies, p.anomalies.Get())
+		})
+	}
+}
+
+// The sample above the maximum still updates the cache
+func TestMaxRateNextSample(t *testing.T) {
+	p := newRate()
+	p.MaxRate = 1000
+	require.Equal(t, []interface{}{nil, nil, float64(100)}, rates(p, counter(0, 0), counter(1e6, 10), counter(1e6+1000, 20)))
+}
+
+func TestIdentityTags(t *testing.T) {
+	enriched := func(value float64, seconds int64) telegraf.Metric {
+		m := counter(value, seconds)
+		m.AddTag("site", "paris")
+		return m
+	}
+	// by default, all the tags identify the series
+	p := newRate()
+	require.Equal(t, []interface{}{nil, nil, float64(10)}, rates(p, counter(0, 0), enriched(100, 10), counter(200, 20)))
+
+	p = newRate()
+	p.IdentityTags = []string{"device", "if_name"}
+	require.Equal(t, []interface{}{nil, float64(10), float64(10)}, rates(p, counter(0, 0), enriched(100, 10), counter(200, 20)))
+
+	// the other interfaces are other series
+	other := counter(5000, 30)
+	other.AddTag("if_name", "et-0/0/1")
+	require.Equal(t, []interface{}{nil, float64(10)}, rates(p, other, counter(300, 30)))
+}
+
+// The gauges emit the signed delta between two samples, times the factor
+func TestGaugeMode(t *testing.T) {
+	gauge := func(value int64, seconds int64) telegraf.Metric {
+		return interfaceMetric(map[string]interface{}{"queue_depth": value, "in_octets": float64(seconds * 10)}, seconds)
+	}
+	p := newRate()
+	p.Fields = []rateField{{Name: "in_octets"}, {Name: "queue_depth", Mode: "gauge", Suffix: stringPtr("_delta")}}
+	var deltas, counterRates []interface{}
+	for _, m := range []telegraf.Metric{gauge(10, 0), gauge(40, 10), gauge(5, 20), gauge(5, 30)} {
+		p.Apply(m)
+		delta, _ := m.GetField("queue_depth_delta")
+		rate, _ := m.GetField("in_octets_rate")
+		deltas, counterRates = append(deltas, delta), append(counterRates, rate)
+	}
+	require.Equal(t, []interface{}{nil, float64(30), float64(-35), float64(0)}, deltas)
+	require.Equal(t, []interface{}{nil, float64(10), float64(10), float64(10)}, counterRates)
+	require.Equal(t, int64(0), p.negativeDeltas.Get())
+
+	// the global mode applies to the fields without mode
+	p = newRate()
+	p.Mode, p.Factor = "gauge", 2
+	require.Equal(t, []interface{}{nil, float64(-200)}, rates(p, counter(1000, 0), counter(900, 10)))
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func TestUtilization(t *testing.T) {
+	tests := []struct {
+		name     string
+		unit     string
+		speed    interface{}
+		expected interface{}
+	}{
+		{name: "speed tag with unit", speed: "100kbps", expected: float64(8)},
+		{name: "speed tag in the unit", unit: "kbps", speed: "100", expected: float64(8)},
+		{name: "speed tag overriding the unit", unit: "gbps", speed: "16kbps", expected: float64(50)},
+		{name: "speed field in the unit", unit: "kbps", speed: int64(100), expected: float64(8)},
+		{name: "speed field in bps", speed: float64(80000), expected: float64(10)},
+		{name: "invalid speed", speed: "fast"},
+		{name: "zero speed", speed: int64(0)},
+		{name: "missing speed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newRate()
+			p.Fields = []rateField{{Name: "in_octets"}, {Name: "in_pkts"}}
+			p.Factor = 8
+			p.UtilizationFields = []string{"in_octets"}
+			p.SpeedSource, p.SpeedUnit = "speed", tt.unit
+			at := func(octets float64, seconds int64) telegraf.Metric {
+				m := interfaceMetric(map[string]interface{}{"in_octets": octets, "in_pkts": octets}, seconds)
+				switch speed := tt.speed.(type) {
+				case string:
+					m.AddTag("speed", speed)
+				case nil:
+				default:
+					m.AddField("speed", speed)
+				}
+				return m
+			}
+			p.Apply(at(0, 0))
+			m := at(10000, 10)
+			p.Apply(m)
+			util, _ := m.GetField("in_octets_util_percent")
+			require.Equal(t, tt.expected, util)
+			// only for the utilization fields
+			require.False(t, m.HasField("in_pkts_util_percent"))
+			require.True(t, m.HasField("in_pkts_rate"))
+		})
+	}
+}
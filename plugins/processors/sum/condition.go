@@ -0,0 +1,39 @@
+package sum
+
+import (
+	"fmt"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/filter"
+)
+
+// compileConditions compiles the glob patterns of the tag conditions of the rule
+func (c *compute) compileConditions() error {
+	c.whenTags = make(map[string]filter.Filter, len(c.WhenTags))
+	for key, patterns := range c.WhenTags {
+		f, err := filter.Compile(patterns)
+		if err != nil {
+			return fmt.Errorf("invalid when_tags %v of %v: %v", key, c.Target, err)
+		}
+		c.whenTags[key] = f
+	}
+	return nil
+}
+
+// applies reports whether the metric meets the conditions of the rule: every tag of when_tags
+// present and matching one of its patterns (any value without pattern), every field of when_fields
+// present
+func (c compute) applies(metric telegraf.Metric) bool {
+	for key := range c.WhenTags {
+		value, ok := metric.GetTag(key)
+		if !ok || (c.whenTags[key] != nil && !c.whenTags[key].Match(value)) {
+			return false
+		}
+	}
+	for _, key := range c.WhenFields {
+		if !metric.HasField(key) {
+			return false
+		}
+	}
+	return true
+}
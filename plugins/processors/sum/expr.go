@@ -0,0 +1,195 @@
+package sum
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/influxdata/telegraf"
+)
+
+// node is a compiled arithmetic expression evaluated against a metric. The evaluation fails when
+// a referenced field (or numeric tag) is missing or on a division by zero.
+type node interface {
+	eval(metric telegraf.Metric) (float64, bool)
+}
+
+type number float64
+
+func (n number) eval(telegraf.Metric) (float64, bool) {
+	return float64(n), true
+}
+
+// variable is a numeric field, else a tag parsed as a number
+type variable string
+
+func (v variable) eval(metric telegraf.Metric) (float64, bool) {
+	if value, ok := metric.GetField(string(v)); ok {
+		return convert(value)
+	}
+	if value, ok := metric.GetTag(string(v)); ok {
+		f, err := strconv.ParseFloat(value, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+type negate struct {
+	operand node
+}
+
+func (n negate) eval(metric telegraf.Metric) (float64, bool) {
+	value, ok := n.operand.eval(metric)
+	return -value, ok
+}
+
+type binary struct {
+	op          byte
+	left, right node
+}
+
+func (b binary) eval(metric telegraf.Metric) (float64, bool) {
+	left, ok := b.left.eval(metric)
+	if !ok {
+		return 0, false
+	}
+	right, ok := b.right.eval(metric)
+	if !ok {
+		return 0, false
+	}
+	switch b.op {
+	case '+':
+		return left + right, true
+	case '-':
+		return left - right, true
+	case '*':
+		return left * right, true
+	case '/':
+		if right == 0 {
+			return 0, false
+		}
+		return left / right, true
+	}
+	return 0, false
+}
+
+// parser is a recursive descent parser of the expressions:
+//
+//	expr   = term {("+" | "-") term}
+//	term   = factor {("*" | "/") factor}
+//	factor = ["-"] (number | name | "'" name "'" | "(" expr ")")
+//
+// The names are fields or tags made of letters, digits, "_" and "." - the other names are quoted.
+type parser struct {
+	input string
+	pos   int
+}
+
+// parseExpr compiles an expression
+func parseExpr(input string) (node, error) {
+	p := &parser{input: input}
+	n, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.skip(); p.pos < len(p.input) {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.input[p.pos], p.pos)
+	}
+	return n, nil
+}
+
+func (p *parser) skip() {
+	for p.pos < len(p.input) && p.input[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// next returns the next operator among ops, if any
+func (p *parser) next(ops string) (byte, bool) {
+	p.skip()
+	if p.pos < len(p.input) && strings.IndexByte(ops, p.input[p.pos]) >= 0 {
+		p.pos++
+		return p.input[p.pos-1], true
+	}
+	return 0, false
+}
+
+func (p *parser) expr() (node, error) {
+	left, err := p.term()
+	for err == nil {
+		op, ok := p.next("+-")
+		if !ok {
+			break
+		}
+		var right node
+		if right, err = p.term(); err == nil {
+			left = binary{op: op, left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) term() (node, error) {
+	left, err := p.factor()
+	for err == nil {
+		op, ok := p.next("*/")
+		if !ok {
+			break
+		}
+		var right node
+		if right, err = p.factor(); err == nil {
+			left = binary{op: op, left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) factor() (node, error) {
+	if _, ok := p.next("-"); ok {
+		operand, err := p.factor()
+		return negate{operand: operand}, err
+	}
+	if _, ok := p.next("("); ok {
+		n, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.next(")"); !ok {
+			return nil, fmt.Errorf("missing ) at position %d", p.pos)
+		}
+		return n, nil
+	}
+	if _, ok := p.next("'"); ok {
+		end := strings.IndexByte(p.input[p.pos:], '\'')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated name at position %d", p.pos)
+		}
+		name := p.input[p.pos : p.pos+end]
+		p.pos += end + 1
+		return variable(name), nil
+	}
+	start := p.pos
+	for p.pos < len(p.input) {
+		c := rune(p.input[p.pos])
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '_' && c != '.' {
+			break
+		}
+		p.pos++
+	}
+	token := p.input[start:p.pos]
+	if token == "" {
+		if p.pos < len(p.input) {
+			return nil, fmt.Errorf("unexpected %q at position %d", p.input[p.pos], p.pos)
+		}
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	if unicode.IsDigit(rune(token[0])) || token[0] == '.' {
+		f, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", token)
+		}
+		return number(f), nil
+	}
+	return variable(token), nil
+}
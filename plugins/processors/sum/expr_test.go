@@ -0,0 +1,90 @@
+package sum
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/metric"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExprEval(t *testing.T) {
+	m := metric.New("interface",
+		map[string]string{"speed": "1000", "if-name": "et-0/0/0", "device": "r1"},
+		map[string]interface{}{"in_octets_rate": float64(25), "out.pkts": int64(4), "zero": uint64(0), "name": "et-0/0/0", "in-errors": int64(3)},
+		time.Unix(0, 0),
+	)
+	tests := []struct {
+		expr     string
+		expected float64
+		ok       bool
+	}{
+		// precedence and associativity
+		{expr: "1 + 2 * 3", expected: 7, ok: true},
+		{expr: "2 * 3 + 1", expected: 7, ok: true},
+		{expr: "10 - 4 - 3", expected: 3, ok: true},
+		{expr: "24 / 4 / 2", expected: 3, ok: true},
+		{expr: "8 - 6 / 2", expected: 5, ok: true},
+		// parentheses
+		{expr: "(1 + 2) * 3", expected: 9, ok: true},
+		{expr: "((1 + 2) * (3 - 1)) / 4", expected: 1.5, ok: true},
+		// unary minus
+		{expr: "-2 * 3", expected: -6, ok: true},
+		{expr: "-(1 + 2)", expected: -3, ok: true},
+		{expr: "2 - -3", expected: 5, ok: true},
+		{expr: "--2", expected: 2, ok: true},
+		// fields, numeric tags and quoted names
+		{expr: "(in_octets_rate*8)/speed*100", expected: 20, ok: true},
+		{expr: "out.pkts * 2.5", expected: 10, ok: true},
+		{expr: "'in-errors' * 2", expected: 6, ok: true},
+		{expr: "'if-name' + 1", ok: false},
+		{expr: "zero + .5", expected: 0.5, ok: true},
+		// division by zero
+		{expr: "in_octets_rate / zero", ok: false},
+		{expr: "1 / (speed - 1000)", ok: false},
+		// unknown fields and tags, non numeric values
+		{expr: "unknown + 1", ok: false},
+		{expr: "1 + unknown * 0", ok: false},
+		{expr: "name * 1", ok: false},
+		{expr: "device", ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			n, err := parseExpr(tt.expr)
+			require.NoError(t, err)
+			value, ok := n.eval(m)
+			require.Equal(t, tt.ok, ok)
+			if ok {
+				require.InDelta(t, tt.expected, value, 1e-9)
+			}
+		})
+	}
+}
+
+func TestExprErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{expr: "", err: "unexpected end of expression"},
+		{expr: "1 +", err: "unexpected end of expression"},
+		{expr: "(1 + 2", err: "missing ) at position 6"},
+		{expr: "1 + 2)", err: `unexpected ')' at position 5`},
+		{expr: "1 2", err: `unexpected '2' at position 2`},
+		{expr: "1 * * 2", err: `unexpected '*' at position 4`},
+		{expr: "'in octets + 1", err: "unterminated name at position 1"},
+		{expr: "1.2.3 + a", err: `invalid number "1.2.3"`},
+		{expr: "a % b", err: `unexpected '%' at position 2`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := parseExpr(tt.expr)
+			require.EqualError(t, err, tt.err)
+		})
+	}
+}
+
+func TestInitInvalidExpr(t *testing.T) {
+	p := &Sum{Fields: []compute{{Target: "util", Expr: "(in_octets_rate*8"}}}
+	require.Error(t, p.Init())
+}
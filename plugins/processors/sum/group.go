@@ -0,0 +1,115 @@
+package sum
+
+import (
+	"hash/fnv"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/config"
+	"github.com/influxdata/telegraf/metric"
+)
+
+// defaultPeriod is the period of the groups without one
+const defaultPeriod = 10 * time.Second
+
+// groupBy aggregates a field across the metrics sharing the group_by tags and emits one metric
+// per group every period
+type groupBy struct {
+	Field       string          `toml:"field"`
+	GroupBy     []string        `toml:"group_by"`
+	Target      string          `toml:"target"`
+	Measurement string          `toml:"measurement"`
+	Operation   string          `toml:"operation"`
+	Period      config.Duration `toml:"period"`
+	Type        string          `toml:"type"`
+	Precision   *int            `toml:"precision"`
+
+	groups  map[uint64]*group
+	emitted time.Time
+}
+
+// group holds the last value of the field of each series of a group
+type group struct {
+	name   string
+	tags   map[string]string
+	values map[uint64]float64
+	tm     time.Time
+}
+
+// add records the field of the metric in its group - the metrics without all the group_by tags
+// are ignored
+func (g *groupBy) add(m telegraf.Metric) {
+	value, ok := m.GetField(g.Field)
+	if !ok {
+		return
+	}
+	number, ok := convert(value)
+	if !ok {
+		return
+	}
+	h := fnv.New64a()
+	h.Write([]byte(m.Name()))
+	tags := make(map[string]string, len(g.GroupBy))
+	for _, key := range g.GroupBy {
+		v, ok := m.GetTag(key)
+		if !ok {
+			return
+		}
+		tags[key] = v
+		h.Write([]byte{0})
+		h.Write([]byte(v))
+	}
+	if g.groups == nil {
+		g.groups = make(map[uint64]*group)
+	}
+	id := h.Sum64()
+	gr, ok := g.groups[id]
+	if !ok {
+		gr = &group{name: m.Name(), tags: tags, values: make(map[uint64]float64)}
+		g.groups[id] = gr
+	}
+	gr.values[m.HashID()] = number
+	if m.Time().After(gr.tm) {
+		gr.tm = m.Time()
+	}
+}
+
+// flush returns the metrics of the groups once the period elapsed, or right away when forced,
+// and starts a new period
+func (g *groupBy) flush(force bool) []telegraf.Metric {
+	if g.emitted.IsZero() {
+		g.emitted = time.Now()
+	}
+	if !force && time.Since(g.emitted) < time.Duration(g.Period) {
+		return nil
+	}
+	g.emitted = time.Now()
+	target := g.Target
+	if target == "" {
+		target = g.Field
+	}
+	c := compute{Target: target, Operation: g.Operation, Type: g.Type, Precision: g.Precision}
+	out := make([]telegraf.Metric, 0, len(g.groups))
+	for _, gr := range g.groups {
+		values := make([]float64, 0, len(gr.values))
+		for _, v := range gr.values {
+			values = append(values, v)
+		}
+		result, ok := c.operate(values, true)
+		if !ok {
+			continue
+		}
+		value, ok := c.output(result)
+		if !ok {
+			continue
+		}
+		name := g.Measurement
+		if name == "" {
+			name = gr.name
+		}
+		logPrintf("add group metric %v %v with %v = %v", name, gr.tags, target, value)
+		out = append(out, metric.New(name, gr.tags, map[string]interface{}{target: value}, gr.tm))
+	}
+	g.groups = nil
+	return out
+}
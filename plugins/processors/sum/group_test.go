@@ -0,0 +1,106 @@
+package sum
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/config"
+	"github.com/influxdata/telegraf/metric"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func newSum(g *groupBy) *Sum {
+	return &Sum{Groups: []*groupBy{g}}
+}
+
+func interfaceMetric(iface string, value float64) telegraf.Metric {
+	return metric.New("interface",
+		map[string]string{"device": "r1", "interface": iface},
+		map[string]interface{}{"in_octets_rate": value},
+		time.Unix(0, 0),
+	)
+}
+
+func TestGroupInit(t *testing.T) {
+	tests := []struct {
+		name  string
+		group groupBy
+		err   bool
+	}{
+		{name: "default", group: groupBy{Field: "f"}},
+		{name: "avg", group: groupBy{Field: "f", Operation: "avg"}},
+		{name: "sub", group: groupBy{Field: "f", Operation: "sub"}, err: true},
+		{name: "div", group: groupBy{Field: "f", Operation: "div"}, err: true},
+		{name: "unknown operation", group: groupBy{Field: "f", Operation: "median"}, err: true},
+		{name: "negative period", group: groupBy{Field: "f", Period: config.Duration(-time.Second)}, err: true},
+		{name: "invalid type", group: groupBy{Field: "f", Type: "string"}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := tt.group
+			err := newSum(&g).Init()
+			if tt.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, config.Duration(defaultPeriod), g.Period)
+		})
+	}
+}
+
+// The groups are emitted by the ticker once the period elapsed, with the last value of each series
+func TestGroupPeriod(t *testing.T) {
+	p := newSum(&groupBy{Field: "in_octets_rate", GroupBy: []string{"device"}, Target: "total", Period: config.Duration(time.Second)})
+	require.NoError(t, p.Init())
+	acc := &testutil.Accumulator{}
+	require.NoError(t, p.Start(acc))
+
+	require.NoError(t, p.Add(interfaceMetric("et-0/0/0", 1), acc))
+	require.NoError(t, p.Add(interfaceMetric("et-0/0/1", 2), acc))
+	require.NoError(t, p.Add(interfaceMetric("et-0/0/0", 3), acc))
+	require.Len(t, acc.GetTelegrafMetrics(), 3)
+
+	acc.Wait(4)
+	require.NoError(t, p.Stop())
+	out := acc.GetTelegrafMetrics()[3]
+	require.Equal(t, "interface", out.Name())
+	require.Equal(t, map[string]string{"device": "r1"}, out.Tags())
+	require.Equal(t, map[string]interface{}{"total": float64(5)}, out.Fields())
+}
+
+// The groups of the last period are emitted when the processor stops
+func TestGroupFlushOnStop(t *testing.T) {
+	p := newSum(&groupBy{Field: "in_octets_rate", GroupBy: []string{"device"}, Operation: "max", Period: config.Duration(time.Hour)})
+	require.NoError(t, p.Init())
+	acc := &testutil.Accumulator{}
+	require.NoError(t, p.Start(acc))
+
+	require.NoError(t, p.Add(interfaceMetric("et-0/0/0", 1), acc))
+	require.NoError(t, p.Add(interfaceMetric("et-0/0/1", 2), acc))
+	require.Len(t, acc.GetTelegrafMetrics(), 2)
+
+	require.NoError(t, p.Stop())
+	metrics := acc.GetTelegrafMetrics()
+	require.Len(t, metrics, 3)
+	require.Equal(t, map[string]interface{}{"in_octets_rate": float64(2)}, metrics[2].Fields())
+}
+
+// Without the ticker the groups are emitted with the metrics of the Apply after the period
+func TestGroupApplyPeriod(t *testing.T) {
+	g := &groupBy{Field: "in_octets_rate", GroupBy: []string{"device"}, Period: config.Duration(time.Hour)}
+	p := newSum(g)
+	require.NoError(t, p.Init())
+
+	require.Len(t, p.Apply(interfaceMetric("et-0/0/0", 1), interfaceMetric("et-0/0/1", 2)), 2)
+	g.emitted = time.Now().Add(-time.Hour)
+	out := p.Apply(interfaceMetric("et-0/0/1", 4))
+	require.Len(t, out, 2)
+	require.Equal(t, map[string]interface{}{"in_octets_rate": float64(5)}, out[1].Fields())
+
+	// a new period starts empty
+	g.emitted = time.Now().Add(-time.Hour)
+	require.Len(t, p.Apply(), 0)
+}
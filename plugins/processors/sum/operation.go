@@ -0,0 +1,54 @@
+package sum
+
+import (
+	"math"
+)
+
+// operate combines the values of the sources with the operation of the rule. Subtraction and
+// division need all the sources (complete), in order: the first one minus (divided by) the others.
+// A division by zero gives no result.
+func (c compute) operate(values []float64, complete bool) (float64, bool) {
+	if len(values) == 0 {
+		return 0, false
+	}
+	result := values[0]
+	switch c.Operation {
+	case "", "sum", "avg":
+		for _, v := range values[1:] {
+			result += v
+		}
+		if c.Operation == "avg" {
+			result /= float64(len(values))
+		}
+	case "sub", "div":
+		if !complete {
+			return 0, false
+		}
+		for _, v := range values[1:] {
+			if c.Operation == "sub" {
+				result -= v
+				continue
+			}
+			if v == 0 {
+				logPrintf("Division by zero for field %v", c.Target)
+				return 0, false
+			}
+			result /= v
+		}
+	case "mul":
+		for _, v := range values[1:] {
+			result *= v
+		}
+	case "min":
+		for _, v := range values[1:] {
+			result = math.Min(result, v)
+		}
+	case "max":
+		for _, v := range values[1:] {
+			result = math.Max(result, v)
+		}
+	default:
+		return 0, false
+	}
+	return result, true
+}
@@ -0,0 +1,25 @@
+package sum
+
+import (
+	"math"
+)
+
+// output converts the result to the type of the rule (float by default), rounded to the
+// precision (number of decimals) when set. Negative results have no uint output.
+func (c compute) output(value float64) (interface{}, bool) {
+	if c.Precision != nil {
+		scale := math.Pow10(*c.Precision)
+		value = math.Round(value*scale) / scale
+	}
+	switch c.Type {
+	case "int":
+		return int64(math.Round(value)), true
+	case "uint":
+		if value < 0 {
+			logPrintf("Negative value %v for the uint field %v", value, c.Target)
+			return nil, false
+		}
+		return uint64(math.Round(value)), true
+	}
+	return value, true
+}
@@ -1,8 +1,15 @@
 package sum
 
 import (
+	"fmt"
 	"log"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
 	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/config"
+	"github.com/influxdata/telegraf/filter"
 	"github.com/influxdata/telegraf/plugins/processors"
 )
 
@@ -12,16 +19,81 @@ var sampleConfig = `
 [[processors.sum.fields]]
 sources = ["a","b"]
 target = "aplusb"
+## type sets the type of the target: float (default), int or uint (rounded), and precision the
+## number of decimals of the result, e.g. to keep the fields previously emitted as integers
+# type = "int"
+# precision = 2
+## operation combines the sources: sum (default), sub, mul, div, min, max or avg. sub and div
+## need all the sources and apply to the first one in order, e.g. a - b
+# [[processors.sum.fields]]
+# sources = ["a","b"]
+# target = "aminusb"
+# operation = "sub"
+## expr computes the target with an arithmetic expression (+ - * / and parentheses) of the fields
+## and numeric tags instead of the sources - quote the names with other characters than letters,
+## digits, "_" and ".". The target is not set when a name is missing or on a division by zero.
+# [[processors.sum.fields]]
+# target = "util"
+# expr = "(in_octets_rate*8)/speed*100"
+## source_patterns adds the fields matching the regular expressions to the sources, after them
+## and sorted by name, so new fields don't require config changes
+# [[processors.sum.fields]]
+# source_patterns = ["^queue_.*_dropped$"]
+# target = "queue_total_dropped"
+## The target is only computed for the metrics having all the tags of when_tags, with a value
+## matching one of the glob patterns (any value with an empty list), and all the fields of
+## when_fields
+# [[processors.sum.fields]]
+# sources = ["queue_dropped_packets","queue_tail_dropped_packets"]
+# target = "queue_total_dropped"
+# when_fields = ["queue_dropped_packets"]
+# [processors.sum.fields.when_tags]
+#   queue-number = []
+##
+## Group-by aggregation: the last value of the field of each series is combined across the
+## metrics sharing the group_by tags (and measurement) with the operation (sum by default, min,
+## max, avg or mul - sub and div are rejected), and emitted as the target field (the field by
+## default) of a new metric with the group_by tags, named measurement (the source measurement by
+## default). The groups aggregate over a period (10s by default), not per batch, since the agent
+## hands the metrics to the processor one at a time: each series counts once with its last value
+## of the period, the groups are emitted when the period elapses even without new metrics, and
+## reset afterwards. The groups of the last period are emitted when the agent stops.
+# [[processors.sum.groups]]
+# field = "in_octets_rate"
+# group_by = ["device"]
+# target = "total_in_octets_rate"
+# measurement = "device_traffic"
+# period = "30s"
+# type = "int"
 `
 
 type Sum struct {
 	Log   		telegraf.Logger
 	Fields []compute    `toml:"fields"`
+	Groups []*groupBy   `toml:"groups"`
+
+	acc  telegraf.Accumulator
+	mu   sync.Mutex
+	done chan struct{}
+	wg   sync.WaitGroup
 	}
 
+// tick is the resolution of the periods of the groups
+const tick = time.Second
+
 type compute struct {
 	Sources		[]string	`toml:"sources"`
+	SourcePatterns	[]string	`toml:"source_patterns"`
+	sourcePatterns	[]*regexp.Regexp
 	Target		string		`toml:"target"`
+	Operation	string		`toml:"operation"`
+	Type		string		`toml:"type"`
+	Precision	*int		`toml:"precision"`
+	Expr		string		`toml:"expr"`
+	expr		node
+	WhenTags	map[string][]string	`toml:"when_tags"`
+	WhenFields	[]string		`toml:"when_fields"`
+	whenTags	map[string]filter.Filter
 	}
 
 func(p * Sum) SampleConfig() string {
@@ -32,30 +104,205 @@ func(p * Sum) Description() string {
     return "Compute the sum"
 }
 
+// Init compiles the expressions and the conditions
+func (p *Sum) Init() error {
+	for i, c := range p.Fields {
+		if err := p.Fields[i].compileConditions(); err != nil {
+			return err
+		}
+		for _, pattern := range c.SourcePatterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("invalid source pattern %q of %v: %v", pattern, c.Target, err)
+			}
+			p.Fields[i].sourcePatterns = append(p.Fields[i].sourcePatterns, re)
+		}
+		if c.Type != "" && c.Type != "float" && c.Type != "int" && c.Type != "uint" {
+			return fmt.Errorf("invalid type %q of %v", c.Type, c.Target)
+		}
+		if c.Expr == "" {
+			continue
+		}
+		expr, err := parseExpr(c.Expr)
+		if err != nil {
+			return fmt.Errorf("invalid expr %q of %v: %v", c.Expr, c.Target, err)
+		}
+		p.Fields[i].expr = expr
+	}
+	for _, g := range p.Groups {
+		if g.Type != "" && g.Type != "float" && g.Type != "int" && g.Type != "uint" {
+			return fmt.Errorf("invalid type %q of the group of %v", g.Type, g.Field)
+		}
+		switch g.Operation {
+		case "", "sum", "mul", "min", "max", "avg":
+		default:
+			// sub and div depend on the order of the series, which a group doesn't have
+			return fmt.Errorf("invalid operation %q of the group of %v", g.Operation, g.Field)
+		}
+		if g.Period < 0 {
+			return fmt.Errorf("invalid period %v of the group of %v", g.Period, g.Field)
+		}
+		if g.Period == 0 {
+			g.Period = config.Duration(defaultPeriod)
+		}
+	}
+	return nil
+}
+
+// Start emits the groups every period, even when no metric arrives
+func (p *Sum) Start(acc telegraf.Accumulator) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.acc = acc
+	if len(p.Groups) == 0 {
+		return nil
+	}
+	p.done = make(chan struct{})
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.done:
+				return
+			case <-ticker.C:
+				p.mu.Lock()
+				for _, m := range p.flush(false) {
+					p.acc.AddMetric(m)
+				}
+				p.mu.Unlock()
+			}
+		}
+	}()
+	return nil
+}
+
+// Add computes the fields of a metric and adds it to the groups
+func (p *Sum) Add(m telegraf.Metric, acc telegraf.Accumulator) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, m := range p.Apply(m) {
+		acc.AddMetric(m)
+	}
+	return nil
+}
+
+// Stop emits the groups of the last period
+func (p *Sum) Stop() error {
+	if p.done != nil {
+		close(p.done)
+		p.wg.Wait()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.acc != nil {
+		for _, m := range p.flush(true) {
+			p.acc.AddMetric(m)
+		}
+	}
+	return nil
+}
+
+// flush returns the metrics of the groups whose period elapsed, or of all of them when forced
+func (p *Sum) flush(force bool) []telegraf.Metric {
+	var out []telegraf.Metric
+	for _, g := range p.Groups {
+		out = append(out, g.flush(force)...)
+	}
+	return out
+}
+
 func(p * Sum) Apply(metrics...telegraf.Metric)[] telegraf.Metric {
 	for _, metric := range metrics {
 		for _, compute := range p.Fields {
-			result := float64(0)
-			add_field := false
+			if !compute.applies(metric) {
+				continue
+			}
+			if compute.expr != nil {
+				if result, ok := compute.expr.eval(metric); ok {
+					compute.add(metric, result)
+				}
+				continue
+			}
+			values := make([]float64, 0, len(compute.Sources))
 			for _, sum_field := range compute.Sources {
 				logPrintf("Looking for %v field in metric",sum_field)
 				if value, ok := metric.GetField(sum_field); ok {
 					if f_value, ok := convert(value); ok {
 						logPrintf("add %v",f_value)
-						result = result + f_value
-						add_field = true
+						values = append(values, f_value)
 					}
 				}
 			}
-			if add_field {
-				logPrintf("add field %v to metric with value %v",compute.Target,result)
-				metric.AddField(compute.Target,result)
+			complete := len(values) == len(compute.Sources)
+			values = append(values, compute.matching(metric)...)
+			if result, ok := compute.operate(values, complete); ok {
+				compute.add(metric, result)
 			}
 		}
+		// the groups see the fields computed above
+		for _, g := range p.Groups {
+			g.add(metric)
+		}
+	}
+	if p.acc == nil {
+		// not started: the groups are only emitted with the metrics
+		metrics = append(metrics, p.flush(false)...)
 	}
 	return metrics
 }
 
+// add sets the target field of the metric with the type and precision of the rule
+func (c compute) add(metric telegraf.Metric, result float64) {
+	value, ok := c.output(result)
+	if !ok {
+		return
+	}
+	logPrintf("add field %v to metric with value %v", c.Target, value)
+	metric.AddField(c.Target, value)
+}
+
+// matching returns the values of the numeric fields matching the source patterns, sorted by name.
+// The target and the fields already given in sources are skipped.
+func (c compute) matching(metric telegraf.Metric) []float64 {
+	if len(c.sourcePatterns) == 0 {
+		return nil
+	}
+	var names []string
+	for _, field := range metric.FieldList() {
+		if field.Key == c.Target || c.source(field.Key) {
+			continue
+		}
+		for _, re := range c.sourcePatterns {
+			if re.MatchString(field.Key) {
+				names = append(names, field.Key)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	values := make([]float64, 0, len(names))
+	for _, name := range names {
+		value, _ := metric.GetField(name)
+		if f_value, ok := convert(value); ok {
+			values = append(values, f_value)
+		}
+	}
+	return values
+}
+
+// source reports whether the field is one of the sources
+func (c compute) source(name string) bool {
+	for _, s := range c.Sources {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
 func logPrintf(format string, v...interface {}) {
     log.Printf("D! [processors.sum] " + format, v...)
 }
@@ -74,7 +321,7 @@ func convert(in interface{}) (float64, bool) {
 }
 
 func init() {
-    processors.Add("sum", func() telegraf.Processor {
+    processors.AddStreaming("sum", func() telegraf.StreamingProcessor {
         return &Sum {}
     })
 }
\ No newline at end of file
@@ -0,0 +1,205 @@
+package sum
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/metric"
+	"github.com/stretchr/testify/require"
+)
+
+func queueMetric(fields map[string]interface{}) telegraf.Metric {
+	return metric.New("queue", map[string]string{"device": "r1", "queue-number": "0"}, fields, time.Unix(0, 0))
+}
+
+// target applies the rule to the metric and returns the target field, nil when not computed
+func target(t *testing.T, c compute, m telegraf.Metric) interface{} {
+	p := &Sum{Fields: []compute{c}}
+	require.NoError(t, p.Init())
+	p.Apply(m)
+	value, _ := m.GetField(c.Target)
+	return value
+}
+
+func TestSourcePatterns(t *testing.T) {
+	fields := map[string]interface{}{
+		"queue_tail_dropped": int64(1),
+		"queue_red_dropped":  int64(2),
+		"queue_wred_dropped": uint64(4),
+		"queue_name":         "best-effort",
+		"queue_sent":         int64(100),
+	}
+	tests := []struct {
+		name     string
+		rule     compute
+		expected interface{}
+	}{
+		{
+			name:     "patterns",
+			rule:     compute{Target: "total", SourcePatterns: []string{"^queue_.*_dropped$"}},
+			expected: float64(7),
+		},
+		{
+			// a source matching a pattern is counted once
+			name:     "sources and patterns",
+			rule:     compute{Target: "total", Sources: []string{"queue_tail_dropped", "queue_sent"}, SourcePatterns: []string{"^queue_.*_dropped$"}},
+			expected: float64(107),
+		},
+		{
+			name:     "overlapping patterns",
+			rule:     compute{Target: "total", SourcePatterns: []string{"^queue_.*_dropped$", "dropped"}},
+			expected: float64(7),
+		},
+		{
+			// the patterns come after the sources, sorted by name
+			name:     "order",
+			rule:     compute{Target: "total", Sources: []string{"queue_sent"}, SourcePatterns: []string{"_dropped$"}, Operation: "sub"},
+			expected: float64(93),
+		},
+		{
+			name:     "target matching the patterns",
+			rule:     compute{Target: "queue_total_dropped", SourcePatterns: []string{"_dropped$"}},
+			expected: float64(7),
+		},
+		{
+			name: "no match",
+			rule: compute{Target: "total", SourcePatterns: []string{"^octets"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := queueMetric(fields)
+			if tt.rule.Target == "queue_total_dropped" {
+				// previous value of the target
+				m.AddField("queue_total_dropped", int64(1000))
+			}
+			require.Equal(t, tt.expected, target(t, tt.rule, m))
+		})
+	}
+}
+
+func TestInvalidSourcePattern(t *testing.T) {
+	p := &Sum{Fields: []compute{{Target: "total", SourcePatterns: []string{"queue_("}}}}
+	require.Error(t, p.Init())
+}
+
+func TestOperations(t *testing.T) {
+	fields := map[string]interface{}{"a": int64(12), "b": float64(3), "c": uint64(2), "zero": int64(0)}
+	tests := []struct {
+		operation string
+		sources   []string
+		expected  interface{}
+	}{
+		{operation: "", sources: []string{"a", "b", "c"}, expected: float64(17)},
+		{operation: "sum", sources: []string{"a", "b", "c"}, expected: float64(17)},
+		{operation: "sub", sources: []string{"a", "b", "c"}, expected: float64(7)},
+		{operation: "mul", sources: []string{"a", "b", "c"}, expected: float64(72)},
+		{operation: "div", sources: []string{"a", "b", "c"}, expected: float64(2)},
+		{operation: "min", sources: []string{"a", "b", "c"}, expected: float64(2)},
+		{operation: "max", sources: []string{"a", "b", "c"}, expected: float64(12)},
+		{operation: "avg", sources: []string{"a", "b", "c"}, expected: float64(17) / 3},
+		// the missing sources are ignored, except by sub and div which need all of them
+		{operation: "sum", sources: []string{"a", "missing"}, expected: float64(12)},
+		{operation: "max", sources: []string{"b", "missing"}, expected: float64(3)},
+		{operation: "sub", sources: []string{"a", "missing"}},
+		{operation: "div", sources: []string{"a", "missing"}},
+		{operation: "div", sources: []string{"a", "zero"}},
+		{operation: "sum", sources: []string{"missing"}},
+		{operation: "median", sources: []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.operation, func(t *testing.T) {
+			rule := compute{Target: "result", Sources: tt.sources, Operation: tt.operation}
+			require.Equal(t, tt.expected, target(t, rule, queueMetric(fields)))
+		})
+	}
+}
+
+func TestConditions(t *testing.T) {
+	rule := compute{
+		Target:     "total",
+		Sources:    []string{"dropped", "tail_dropped"},
+		WhenTags:   map[string][]string{"queue-number": {}, "device": {"r*", "edge-?"}},
+		WhenFields: []string{"dropped"},
+	}
+	tests := []struct {
+		name     string
+		tags     map[string]string
+		fields   map[string]interface{}
+		expected interface{}
+	}{
+		{
+			name:     "all the conditions",
+			tags:     map[string]string{"device": "r1", "queue-number": "0"},
+			fields:   map[string]interface{}{"dropped": int64(1), "tail_dropped": int64(2)},
+			expected: float64(3),
+		},
+		{
+			name:     "second tag pattern",
+			tags:     map[string]string{"device": "edge-1", "queue-number": "7"},
+			fields:   map[string]interface{}{"dropped": int64(1)},
+			expected: float64(1),
+		},
+		{
+			name:   "missing tag",
+			tags:   map[string]string{"device": "r1"},
+			fields: map[string]interface{}{"dropped": int64(1), "tail_dropped": int64(2)},
+		},
+		{
+			name:   "tag not matching",
+			tags:   map[string]string{"device": "core-1", "queue-number": "0"},
+			fields: map[string]interface{}{"dropped": int64(1), "tail_dropped": int64(2)},
+		},
+		{
+			name:   "missing field",
+			tags:   map[string]string{"device": "r1", "queue-number": "0"},
+			fields: map[string]interface{}{"tail_dropped": int64(2)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := metric.New("queue", tt.tags, tt.fields, time.Unix(0, 0))
+			require.Equal(t, tt.expected, target(t, rule, m))
+		})
+	}
+}
+
+func TestInvalidCondition(t *testing.T) {
+	p := &Sum{Fields: []compute{{Target: "total", Sources: []string{"a"}, WhenTags: map[string][]string{"device": {"r["}}}}}
+	require.Error(t, p.Init())
+}
+
+func TestOutputType(t *testing.T) {
+	precision := func(p int) *int { return &p }
+	tests := []struct {
+		name      string
+		sources   []string
+		operation string
+		typ       string
+		precision *int
+		expected  interface{}
+	}{
+		{name: "float by default", sources: []string{"a", "b"}, expected: float64(3.75)},
+		{name: "float", sources: []string{"a", "b"}, typ: "float", expected: float64(3.75)},
+		{name: "int rounded", sources: []string{"a", "b"}, typ: "int", expected: int64(4)},
+		{name: "negative int", sources: []string{"b", "a"}, operation: "sub", typ: "int", expected: int64(-1)},
+		{name: "uint rounded", sources: []string{"a", "b"}, typ: "uint", expected: uint64(4)},
+		{name: "negative uint", sources: []string{"b", "a"}, operation: "sub", typ: "uint"},
+		{name: "precision", sources: []string{"a", "b"}, precision: precision(1), expected: float64(3.8)},
+		{name: "precision of the avg", sources: []string{"a", "b", "c"}, operation: "avg", precision: precision(2), expected: float64(1.58)},
+		{name: "int with precision", sources: []string{"a", "b"}, typ: "int", precision: precision(1), expected: int64(4)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := compute{Target: "result", Sources: tt.sources, Operation: tt.operation, Type: tt.typ, Precision: tt.precision}
+			m := queueMetric(map[string]interface{}{"a": float64(2.25), "b": float64(1.5), "c": int64(1)})
+			require.Equal(t, tt.expected, target(t, rule, m))
+		})
+	}
+}
+
+func TestInvalidOutputType(t *testing.T) {
+	p := &Sum{Fields: []compute{{Target: "total", Sources: []string{"a"}, Type: "string"}}}
+	require.Error(t, p.Init())
+}
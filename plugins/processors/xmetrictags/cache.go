@@ -0,0 +1,78 @@
+package xmetrictags
+
+import (
+	"github.com/influxdata/telegraf/selfstat"
+)
+
+// ruleStats are the statistics of the cache entries of a rule
+type ruleStats struct {
+	entries   selfstat.Stat
+	hits      selfstat.Stat
+	misses    selfstat.Stat
+	evictions selfstat.Stat
+}
+
+// statsKey identifies a rule by its kind (field or tag) and tag name
+type statsKey struct {
+	kind string
+	name string
+}
+
+// ruleStats returns the statistics of the rule
+func (p *Xmetrictags) ruleStats(kind, name string) *ruleStats {
+	key := statsKey{kind: kind, name: name}
+	s, ok := p.stats[key]
+	if !ok {
+		tags := map[string]string{"rule": name, "kind": kind}
+		for k, v := range p.statTags {
+			tags[k] = v
+		}
+		s = &ruleStats{
+			entries:   selfstat.Register("xmetrictags", "entries", tags),
+			hits:      selfstat.Register("xmetrictags", "hits", tags),
+			misses:    selfstat.Register("xmetrictags", "misses", tags),
+			evictions: selfstat.Register("xmetrictags", "evictions", tags),
+		}
+		p.stats[key] = s
+	}
+	return s
+}
+
+// store adds or updates a cache entry - the least recently updated entries are evicted above
+// max_cache_entries
+func (p *Xmetrictags) store(id uint64, c compute) {
+	if old, ok := p.cache[id]; !ok || old.name != c.name || old.kind != c.kind {
+		if ok {
+			p.ruleStats(old.kind, old.name).entries.Incr(-1)
+		}
+		p.ruleStats(c.kind, c.name).entries.Incr(1)
+	}
+	p.cache[id] = c
+	p.lru.Touch(id)
+	for p.MaxCacheEntries > 0 && len(p.cache) > p.MaxCacheEntries {
+		oldest, _ := p.lru.Oldest()
+		logPrintf("Cache full, evicting entry %v", oldest)
+		p.ruleStats(p.cache[oldest].kind, p.cache[oldest].name).evictions.Incr(1)
+		p.remove(oldest)
+	}
+}
+
+// remove deletes a cache entry
+func (p *Xmetrictags) remove(id uint64) {
+	p.lru.Remove(id)
+	if c, ok := p.cache[id]; ok {
+		p.ruleStats(c.kind, c.name).entries.Incr(-1)
+		delete(p.cache, id)
+	}
+}
+
+// lookup returns the cache entry to attach to a metric, counting the hits and misses of the rule
+func (p *Xmetrictags) lookup(id uint64, kind, name string) (compute, bool) {
+	c, ok := p.cache[id]
+	if ok {
+		p.ruleStats(kind, name).hits.Incr(1)
+	} else {
+		p.ruleStats(kind, name).misses.Incr(1)
+	}
+	return c, ok
+}
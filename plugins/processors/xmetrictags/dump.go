@@ -0,0 +1,40 @@
+package xmetrictags
+
+import (
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/metric"
+)
+
+// dumpCache returns one metric per cache entry, every dump_interval: the key tags and the tag
+// name of the rule as tags, the tracked value and the age of the entry as fields
+func (p *Xmetrictags) dumpCache() []telegraf.Metric {
+	interval, _ := time.ParseDuration(p.DumpInterval)
+	if interval <= 0 || time.Since(p.last_dumped) < interval {
+		return nil
+	}
+	p.last_dumped = time.Now()
+	name := p.DumpMeasurement
+	if name == "" {
+		name = "xmetrictags_cache"
+	}
+	dump := make([]telegraf.Metric, 0, len(p.cache))
+	for _, entry := range p.cache {
+		tags := map[string]string{"tag_name": entry.name}
+		for k, v := range entry.keys {
+			tags[k] = v
+		}
+		var value interface{} = entry.track_key_value
+		if entry.field_value != nil {
+			value = entry.field_value
+		}
+		fields := map[string]interface{}{
+			"value": value,
+			"age":   int64(p.last_dumped.Sub(entry.updated).Seconds()),
+		}
+		dump = append(dump, metric.New(name, tags, fields, p.last_dumped))
+	}
+	logPrintf("%v cache entries dumped", len(dump))
+	return dump
+}
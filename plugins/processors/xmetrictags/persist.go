@@ -0,0 +1,73 @@
+package xmetrictags
+
+import (
+	"time"
+
+	"github.com/influxdata/telegraf/internal/seriescache"
+)
+
+// persistedEntry is a cache entry saved in the cache file
+type persistedEntry struct {
+	Value string `json:"value"`
+	// numeric field value, by type
+	Float   *float64  `json:"float,omitempty"`
+	Int     *int64    `json:"int,omitempty"`
+	Uint    *uint64   `json:"uint,omitempty"`
+	Expires time.Time `json:"expires"`
+	// for the cache dump
+	Keys    map[string]string `json:"keys,omitempty"`
+	Name    string            `json:"name,omitempty"`
+	Updated time.Time         `json:"updated"`
+	Kind    string            `json:"kind,omitempty"`
+}
+
+// field returns the numeric field value of the entry, nil for a tag value
+func (e persistedEntry) field() interface{} {
+	switch {
+	case e.Float != nil:
+		return *e.Float
+	case e.Int != nil:
+		return *e.Int
+	case e.Uint != nil:
+		return *e.Uint
+	}
+	return nil
+}
+
+// saveCache writes the cache to the cache file
+func (p *Xmetrictags) saveCache() error {
+	entries := make(map[uint64]persistedEntry, len(p.cache))
+	for id, c := range p.cache {
+		e := persistedEntry{Value: c.track_key_value, Expires: c.tm, Keys: c.keys, Name: c.name, Updated: c.updated, Kind: c.kind}
+		switch v := c.field_value.(type) {
+		case float64:
+			e.Float = &v
+		case int64:
+			e.Int = &v
+		case uint64:
+			e.Uint = &v
+		}
+		entries[id] = e
+	}
+	if err := seriescache.Save(p.CacheFile, entries); err != nil {
+		return err
+	}
+	logPrintf("%v cache entries saved to %v", len(entries), p.CacheFile)
+	return nil
+}
+
+// loadCache reloads the entries of the cache file not expired yet
+func (p *Xmetrictags) loadCache() error {
+	entries := make(map[uint64]persistedEntry)
+	if err := seriescache.Load(p.CacheFile, &entries); err != nil {
+		return err
+	}
+	for id, e := range entries {
+		if time.Now().After(e.Expires) {
+			continue
+		}
+		p.store(id, compute{tm: e.Expires, track_key_value: e.Value, field_value: e.field(), keys: e.Keys, name: e.Name, updated: e.Updated, kind: e.Kind})
+	}
+	logPrintf("%v cache entries reloaded from %v", len(p.cache), p.CacheFile)
+	return nil
+}
@@ -0,0 +1,51 @@
+package xmetrictags
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheFileRoundTrip(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "xmetrictags.cache")
+	expires := time.Now().Add(time.Hour).Round(time.Second)
+	updated := time.Now().Add(-time.Minute).Round(time.Second)
+	keys := map[string]string{"device": "r1", "if_name": "et-0/0/0"}
+	entries := map[uint64]compute{
+		1: {tm: expires, track_key_value: "core", keys: keys, name: "role", updated: updated, kind: "tag"},
+		2: {tm: expires, field_value: float64(10.5), keys: keys, name: "speed", updated: updated, kind: "field"},
+		3: {tm: expires, field_value: int64(-3), keys: keys, name: "offset", updated: updated, kind: "field"},
+		4: {tm: expires, field_value: uint64(100000000000), keys: keys, name: "capacity", updated: updated, kind: "field"},
+		// expired when reloaded
+		5: {tm: time.Now().Add(-time.Second), track_key_value: "edge", keys: keys, name: "role", updated: updated, kind: "tag"},
+	}
+
+	p := &Xmetrictags{CacheFile: file}
+	require.NoError(t, p.Start(nil))
+	for id, c := range entries {
+		p.store(id, c)
+	}
+	require.NoError(t, p.Stop())
+
+	reloaded := &Xmetrictags{CacheFile: file}
+	require.NoError(t, reloaded.Start(nil))
+	delete(entries, 5)
+	require.Len(t, reloaded.cache, len(entries))
+	require.Equal(t, len(entries), reloaded.lru.Len())
+	for id, expected := range entries {
+		c, ok := reloaded.cache[id]
+		require.True(t, ok, id)
+		require.True(t, expected.tm.Equal(c.tm), id)
+		require.True(t, expected.updated.Equal(c.updated), id)
+		expected.tm, expected.updated = c.tm, c.updated
+		require.Equal(t, expected, c, id)
+	}
+}
+
+func TestCacheFileMissing(t *testing.T) {
+	p := &Xmetrictags{CacheFile: filepath.Join(t.TempDir(), "xmetrictags.cache")}
+	require.NoError(t, p.Start(nil))
+	require.Empty(t, p.cache)
+}
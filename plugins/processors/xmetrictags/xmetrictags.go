@@ -1,20 +1,61 @@
 package xmetrictags
 
 import (
+	"fmt"
 	"log"
+	"regexp"
 	"time"
 	"hash/fnv"
 
     "github.com/influxdata/telegraf"
+    "github.com/influxdata/telegraf/filter"
+    "github.com/influxdata/telegraf/internal/instance"
+    "github.com/influxdata/telegraf/internal/seriescache"
     "github.com/influxdata/telegraf/plugins/processors"
 )
 
 var sampleConfig = `
 [[processor.xmetrictags]]
+## File where the cache is saved on shutdown and reloaded from on start, so the metrics keep their
+## tags after a restart until the source metrics arrive again. The expired entries are not reloaded.
+## With aggregators, telegraf runs a second copy of the processor sharing the same file: the copy
+## stopped last overwrites it - use a distinct cache_file (and alias) per processor of the
+## configuration.
+# cache_file = "/var/lib/telegraf/xmetrictags.cache"
+## Emit the cache entries every dump_interval as the dump_measurement (default xmetrictags_cache),
+## with the key tags and tag_name as tags and the tracked value and age (seconds) as fields, to
+## validate the learned mappings
+# dump_interval = "5m"
+# dump_measurement = "xmetrictags_cache"
+## Maximum number of cache entries - the least recently updated entries are evicted above it.
+## 0 means unlimited. The entries, hits, misses and evictions of each rule are reported in the
+## internal_xmetrictags measurement, tagged with the tag_name and the kind (field or tag) of the
+## rule, the alias of the processor and an instance number distinct per running copy.
+# max_cache_entries = 0
 [[processor.xmetrictags.field]]
 track_key = "parent_ae_name"
 tag_keys = ["device","if_name"]
 tag_name = "lag_id"
+## Several keys can be tracked by the same rule with track_keys, each attached as the tag of the
+## same rank in tag_names (the key itself when tag_names is not set)
+# [[processor.xmetrictags.field]]
+# track_keys = ["parent_ae_name", "description"]
+# tag_names = ["lag_id", "if_description"]
+# tag_keys = ["device","if_name"]
+## The numeric fields (e.g. the interface speed) are cached as well and attached as the field
+## tag_name to the other metrics sharing the same keys
+# [[processor.xmetrictags.field]]
+# track_key = "speed"
+# tag_keys = ["device","if_name"]
+# tag_name = "if_speed"
+## learn_from and apply_to restrict the measurements (glob patterns) the rule learns the values
+## from and attaches them to - all the measurements when not set
+# learn_from = ["/interfaces/interface/state/"]
+# apply_to = ["/interfaces/interface/subinterfaces/*"]
+## The tracked value can be transformed before it is stored and attached: the parts matching the
+## pattern are replaced with the replacement, which can refer to the capture groups
+# pattern = "\\.0$"
+# replacement = ""
 `
 
 type Xmetrictags struct {
@@ -22,21 +63,47 @@ type Xmetrictags struct {
 	Fields []xmetric    `toml:"field"`
 	Tags   []xmetric    `toml:"tag"`
 	Period		string		`toml:"period"`
+	CacheFile	string		`toml:"cache_file"`
+	DumpInterval	string		`toml:"dump_interval"`
+	DumpMeasurement	string		`toml:"dump_measurement"`
+	MaxCacheEntries	int		`toml:"max_cache_entries"`
+	Alias		string		`toml:"alias"`
+	lru		*seriescache.LRU
+	stats		map[statsKey]*ruleStats
+	statTags	map[string]string
 	initialized bool
 	cache       map[uint64]compute
 	last_cleared	time.Time
+	last_dumped	time.Time
 	}
 
 type xmetric struct {
 	Track_key	string	`toml:"track_key"`
 	Tag_keys	[]string `toml:"tag_keys"`
 	Tag_name	string	`toml:"tag_name"`
+	Track_keys	[]string `toml:"track_keys"`
+	Tag_names	[]string `toml:"tag_names"`
+	Pattern		string	`toml:"pattern"`
+	Replacement	string	`toml:"replacement"`
+	regex		*regexp.Regexp
+	Learn_from	[]string `toml:"learn_from"`
+	Apply_to	[]string `toml:"apply_to"`
+	learn		filter.Filter
+	apply		filter.Filter
 	Retention 	string	`toml:"retention"`
 	}
 
 type compute struct {
 	tm time.Time
 	track_key_value string
+	// numeric value of a field rule, attached as a field instead of a tag
+	field_value interface{}
+	// key tags, tag name of the rule and last update, for the cache dump
+	keys map[string]string
+	name string
+	updated time.Time
+	// field or tag rule, for the statistics
+	kind string
 }
 
 func(p * Xmetrictags) SampleConfig() string {
@@ -53,13 +120,121 @@ func hash(s string) uint64 {
 	return h.Sum64()
 }
 
+// Init splits the rules tracking several keys into one rule per key and compiles their patterns
+// and measurement filters
+func (p *Xmetrictags) Init() error {
+	var err error
+	if p.Fields, err = expand(p.Fields); err != nil {
+		return err
+	}
+	if p.Tags, err = expand(p.Tags); err != nil {
+		return err
+	}
+	for _, rules := range [][]xmetric{p.Fields, p.Tags} {
+		for i := range rules {
+			if rules[i].learn, err = filter.Compile(rules[i].Learn_from); err != nil {
+				return fmt.Errorf("invalid learn_from: %v", err)
+			}
+			if rules[i].apply, err = filter.Compile(rules[i].Apply_to); err != nil {
+				return fmt.Errorf("invalid apply_to: %v", err)
+			}
+			if rules[i].Pattern == "" {
+				continue
+			}
+			if rules[i].regex, err = regexp.Compile(rules[i].Pattern); err != nil {
+				return fmt.Errorf("invalid pattern %q: %v", rules[i].Pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// learns reports whether the rule learns the values from the measurement
+func (x xmetric) learns(name string) bool {
+	return x.learn == nil || x.learn.Match(name)
+}
+
+// applies reports whether the rule attaches the values to the measurement
+func (x xmetric) applies(name string) bool {
+	return x.apply == nil || x.apply.Match(name)
+}
+
+// transform applies the pattern of the rule to the tracked value
+func (x xmetric) transform(value string) string {
+	if x.regex == nil {
+		return value
+	}
+	return x.regex.ReplaceAllString(value, x.Replacement)
+}
+
+func expand(rules []xmetric) ([]xmetric, error) {
+	expanded := make([]xmetric, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Track_key != "" {
+			expanded = append(expanded, rule)
+		}
+		if len(rule.Tag_names) > 0 && len(rule.Tag_names) != len(rule.Track_keys) {
+			return nil, fmt.Errorf("tag_names must have as many names as track_keys")
+		}
+		for i, key := range rule.Track_keys {
+			single := rule
+			single.Track_key, single.Tag_name = key, key
+			single.Track_keys, single.Tag_names = nil, nil
+			if len(rule.Tag_names) > 0 {
+				single.Tag_name = rule.Tag_names[i]
+			}
+			expanded = append(expanded, single)
+		}
+	}
+	return expanded, nil
+}
+
+func (p *Xmetrictags) init() {
+	logPrintf("Initializing xmetric...")
+	p.cache = make(map[uint64]compute)
+	p.lru = seriescache.NewLRU()
+	p.stats = make(map[statsKey]*ruleStats)
+	if p.statTags == nil {
+		p.statTags = instance.Tags(p.Alias)
+	}
+	p.initialized = true
+	p.last_cleared = time.Now()
+}
+
+// Start reloads the cache saved at the last shutdown
+func (p *Xmetrictags) Start(acc telegraf.Accumulator) error {
+	p.init()
+	if p.CacheFile == "" {
+		return nil
+	}
+	if err := p.loadCache(); err != nil {
+		p.Log.Warnf("Cannot reload the cache from %s: %v", p.CacheFile, err)
+	}
+	return nil
+}
+
+func (p *Xmetrictags) Add(m telegraf.Metric, acc telegraf.Accumulator) error {
+	for _, m := range p.Apply(m) {
+		acc.AddMetric(m)
+	}
+	return nil
+}
+
+// Stop saves the cache for the next start
+func (p *Xmetrictags) Stop() error {
+	if p.CacheFile == "" {
+		return nil
+	}
+	if err := p.saveCache(); err != nil {
+		return fmt.Errorf("cannot save the cache to %s: %v", p.CacheFile, err)
+	}
+	return nil
+}
+
 func(p * Xmetrictags) Apply(metrics...telegraf.Metric)[] telegraf.Metric {
 	t_period,_ := time.ParseDuration(p.Period)
 	if !p.initialized{
-		logPrintf("Initializing xmetric...")
-		p.cache = make(map[uint64]compute)
-		p.initialized = true
-		p.last_cleared = time.Now()
+		p.init()
 	}
 	if time.Now().After(p.last_cleared.Add(t_period)) {
 		nb_deleted := 0
@@ -68,7 +243,7 @@ func(p * Xmetrictags) Apply(metrics...telegraf.Metric)[] telegraf.Metric {
 			logPrintf("Hashid %v time %v",k,v.tm)
 			if time.Now().After(v.tm) {
 				logPrintf("delete entry %v from cache",k)
-				delete(p.cache,k)
+				p.remove(k)
 				nb_deleted +=1
 			}
 	}
@@ -79,6 +254,7 @@ func(p * Xmetrictags) Apply(metrics...telegraf.Metric)[] telegraf.Metric {
 		for _, xmetric_field := range p.Fields {
 			t_retention, _ := time.ParseDuration(xmetric_field.Retention)
 			hash_string := xmetric_field.Track_key
+			keys := make(map[string]string)
 			hastags := false
 			for _, tag := range xmetric_field.Tag_keys {
 				logPrintf("Check if metric has tag %s",tag)
@@ -88,29 +264,55 @@ func(p * Xmetrictags) Apply(metrics...telegraf.Metric)[] telegraf.Metric {
 				}
 				if value, hastag := metric.GetTag(tag); hastag{
 					hash_string = hash_string+value
+					keys[tag] = value
 					hastags = true
 				}
 			}
 			// La metric dispose des tags et du track_key, on met la donnée dans le cache
-			if value, ok := metric.GetField(xmetric_field.Track_key); ok && hastags{
-				str_value := value.(string)
+			if value, ok := metric.GetField(xmetric_field.Track_key); ok && hastags && xmetric_field.learns(metric.Name()) {
+				switch value.(type) {
+				case int64, uint64, float64:
+					// numeric values are copied as fields
+					id := hash(hash_string)
+					p.store(id, compute {
+						tm:	time.Now().Add(t_retention),
+						field_value: value,
+						keys: keys,
+						name: xmetric_field.Tag_name,
+						kind: "field",
+						updated: time.Now(),
+					})
+					logPrintf("Cache entry with id %v updated with value %v",id,value)
+					metric.AddField(xmetric_field.Tag_name,value)
+					continue
+				}
+				str_value, _ := value.(string)
+				str_value = xmetric_field.transform(str_value)
 				if str_value != "" {
 					id := hash(hash_string)
 					a := compute {
 						tm:	time.Now().Add(t_retention),
 						track_key_value: str_value,
+						keys: keys,
+						name: xmetric_field.Tag_name,
+						kind: "field",
+						updated: time.Now(),
 					}
 					logPrintf("Cache entry with id %v updated with value %v",id,str_value)
-					p.cache[id] = a
+					p.store(id, a)
 					metric.AddTag(xmetric_field.Tag_name,p.cache[id].track_key_value)
 				} else {
 					logPrintf("Metric with hash_string %s has an empty track_key value",hash_string)
 				}
 			}
 			// la metric n'a pas le champ mais dispose des tags, on doit lui ajouter l'info si elle est dans le cache
-			if _, ok := metric.GetField(xmetric_field.Track_key); !ok && hastags {
+			if _, ok := metric.GetField(xmetric_field.Track_key); !ok && hastags && xmetric_field.applies(metric.Name()) {
 				id := hash(hash_string)
-				if _, ok := p.cache[id]; ok {
+				entry, ok := p.lookup(id, "field", xmetric_field.Tag_name)
+				if ok && entry.field_value != nil {
+					logPrintf("Metric needs the field %s with value %v",xmetric_field.Tag_name,entry.field_value)
+					metric.AddField(xmetric_field.Tag_name,entry.field_value)
+				} else if ok {
 					logPrintf("Metric needs the tag %s with value %s",xmetric_field.Tag_name,p.cache[id].track_key_value)
 					metric.AddTag(xmetric_field.Tag_name,p.cache[id].track_key_value)
 				}
@@ -119,6 +321,7 @@ func(p * Xmetrictags) Apply(metrics...telegraf.Metric)[] telegraf.Metric {
 		for _, xmetric_tag := range p.Tags {
 			t_retention, _ := time.ParseDuration(xmetric_tag.Retention)
 			hash_string := xmetric_tag.Track_key
+			keys := make(map[string]string)
 			hastags := false
 			for _, tag := range xmetric_tag.Tag_keys {
 				logPrintf("Check if metric has tag %s",tag)
@@ -128,35 +331,41 @@ func(p * Xmetrictags) Apply(metrics...telegraf.Metric)[] telegraf.Metric {
 				}
 				if value, hastag := metric.GetTag(tag); hastag{
 					hash_string = hash_string+value
+					keys[tag] = value
 					hastags = true
 				}
 			}
 			// La metric dispose des tags et du track_key, on met la donnée dans le cache
-			if str_value, ok := metric.GetTag(xmetric_tag.Track_key); ok && hastags{
+			if str_value, ok := metric.GetTag(xmetric_tag.Track_key); ok && hastags && xmetric_tag.learns(metric.Name()) {
+				str_value = xmetric_tag.transform(str_value)
 				if str_value != "" {
 					id := hash(hash_string)
 					a := compute {
 						tm:	time.Now().Add(t_retention),
 						track_key_value: str_value,
+						keys: keys,
+						name: xmetric_tag.Tag_name,
+						kind: "tag",
+						updated: time.Now(),
 					}
 					logPrintf("Cache entry with id %v updated with value %v",id,str_value)
-					p.cache[id] = a
+					p.store(id, a)
 					metric.AddTag(xmetric_tag.Tag_name,p.cache[id].track_key_value)
 				} else {
 					logPrintf("Metric with hash_string %s has an empty track_key value",hash_string)
 				}
 			}
 			// la metric n'a pas le champ mais dispose des tags, on doit lui ajouter l'info si elle est dans le cache
-			if _, ok := metric.GetTag(xmetric_tag.Track_key); !ok && hastags {
+			if _, ok := metric.GetTag(xmetric_tag.Track_key); !ok && hastags && xmetric_tag.applies(metric.Name()) {
 				id := hash(hash_string)
-				if _, ok := p.cache[id]; ok {
+				if _, ok := p.lookup(id, "tag", xmetric_tag.Tag_name); ok {
 					logPrintf("Metric needs the tag %s with value %s",xmetric_tag.Tag_name,p.cache[id].track_key_value)
 					metric.AddTag(xmetric_tag.Tag_name,p.cache[id].track_key_value)
 				}
 			} 
 		}
 	}
-	return metrics
+	return append(metrics, p.dumpCache()...)
 }	
 
 func logPrintf(format string, v...interface {}) {
@@ -164,7 +373,7 @@ func logPrintf(format string, v...interface {}) {
 }
 
 func init() {
-    processors.Add("xmetrictags", func() telegraf.Processor {
+    processors.AddStreaming("xmetrictags", func() telegraf.StreamingProcessor {
         return &Xmetrictags {}
     })
 }
@@ -0,0 +1,210 @@
+package xmetrictags
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/metric"
+	"github.com/stretchr/testify/require"
+)
+
+func newXmetrictags(t *testing.T, p *Xmetrictags) *Xmetrictags {
+	require.NoError(t, p.Init())
+	return p
+}
+
+func interfaceMetric(name, ifName string, fields map[string]interface{}) telegraf.Metric {
+	return metric.New(name,
+		map[string]string{"device": "r1", "if_name": ifName},
+		fields,
+		time.Unix(0, 0),
+	)
+}
+
+// lagRule copies parent_ae_name as lag_id to the metrics of the same interface
+func lagRule() xmetric {
+	return xmetric{Track_key: "parent_ae_name", Tag_keys: []string{"device", "if_name"}, Tag_name: "lag_id", Retention: "1h"}
+}
+
+// The statistics are kept per rule kind, tag name and running copy, and the entries above
+// max_cache_entries are evicted
+func TestRuleStats(t *testing.T) {
+	setup := func() *Xmetrictags {
+		return newXmetrictags(t, &Xmetrictags{
+			Alias:           "pe",
+			MaxCacheEntries: 2,
+			Fields:          []xmetric{lagRule()},
+			Tags:            []xmetric{{Track_key: "role", Tag_keys: []string{"device"}, Tag_name: "lag_id", Retention: "1h"}},
+		})
+	}
+	p, other := setup(), setup()
+	p.Apply(interfaceMetric("interface", "et-0/0/0", map[string]interface{}{"parent_ae_name": "ae0"}))
+	p.Apply(interfaceMetric("interface", "et-0/0/1", map[string]interface{}{"parent_ae_name": "ae1"}))
+	p.Apply(interfaceMetric("interface", "et-0/0/2", map[string]interface{}{"parent_ae_name": "ae2"}))
+	p.Apply(interfaceMetric("optics", "et-0/0/2", map[string]interface{}{"rx_power": -3.2}))
+	p.Apply(interfaceMetric("optics", "et-0/0/0", map[string]interface{}{"rx_power": -3.2}))
+	p.Apply(metric.New("chassis", map[string]string{"device": "r1", "role": "core"}, map[string]interface{}{"uptime": int64(1)}, time.Unix(0, 0)))
+	other.Apply(interfaceMetric("interface", "et-0/0/0", map[string]interface{}{"parent_ae_name": "ae0"}))
+
+	field, tag := p.stats[statsKey{kind: "field", name: "lag_id"}], p.stats[statsKey{kind: "tag", name: "lag_id"}]
+	require.Equal(t, []int64{1, 1, 1, 2}, []int64{field.entries.Get(), field.hits.Get(), field.misses.Get(), field.evictions.Get()})
+	require.Equal(t, []int64{1, 0, 5, 0}, []int64{tag.entries.Get(), tag.hits.Get(), tag.misses.Get(), tag.evictions.Get()})
+	require.Len(t, p.cache, 2)
+	require.Equal(t, 2, p.lru.Len())
+
+	tags := field.entries.Tags()
+	require.Equal(t, "lag_id", tags["rule"])
+	require.Equal(t, "field", tags["kind"])
+	require.Equal(t, "pe", tags["alias"])
+	require.Equal(t, "tag", tag.entries.Tags()["kind"])
+	require.Equal(t, int64(1), other.stats[statsKey{kind: "field", name: "lag_id"}].entries.Get())
+	require.NotEqual(t, tags["instance"], other.stats[statsKey{kind: "field", name: "lag_id"}].entries.Tags()["instance"])
+}
+
+// A rule tracking several keys attaches each of them, as the tag of the same rank in tag_names
+// or as the key itself
+func TestTrackKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		tagNames []string
+		expected map[string]string
+	}{
+		{
+			name:     "tag names",
+			tagNames: []string{"lag_id", "if_description"},
+			expected: map[string]string{"device": "r1", "if_name": "et-0/0/0", "lag_id": "ae0", "if_description": "to r2"},
+		},
+		{
+			name:     "keys",
+			expected: map[string]string{"device": "r1", "if_name": "et-0/0/0", "parent_ae_name": "ae0", "description": "to r2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newXmetrictags(t, &Xmetrictags{Fields: []xmetric{{
+				Track_keys: []string{"parent_ae_name", "description"},
+				Tag_names:  tt.tagNames,
+				Tag_keys:   []string{"device", "if_name"},
+				Retention:  "1h",
+			}}})
+			p.Apply(interfaceMetric("interface", "et-0/0/0", map[string]interface{}{"parent_ae_name": "ae0", "description": "to r2"}))
+			out := p.Apply(interfaceMetric("optics", "et-0/0/0", map[string]interface{}{"rx_power": -3.2}))
+			require.Equal(t, tt.expected, out[0].Tags())
+		})
+	}
+}
+
+func TestTrackKeysInvalidTagNames(t *testing.T) {
+	p := &Xmetrictags{Tags: []xmetric{{Track_keys: []string{"a", "b"}, Tag_names: []string{"x"}, Tag_keys: []string{"device"}}}}
+	require.Error(t, p.Init())
+}
+
+func TestTransform(t *testing.T) {
+	tests := []struct {
+		name        string
+		pattern     string
+		replacement string
+		value       string
+		expected    string
+	}{
+		{name: "strip the unit", pattern: `\.0$`, value: "ae0.0", expected: "ae0"},
+		{name: "capture groups", pattern: `^(ae\d+)\.(\d+)$`, replacement: "${1}:${2}", value: "ae12.100", expected: "ae12:100"},
+		{name: "no match", pattern: `\.0$`, value: "ae0.100", expected: "ae0.100"},
+		// an empty value is not tracked
+		{name: "empty", pattern: `.*`, value: "ae0.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newXmetrictags(t, &Xmetrictags{Tags: []xmetric{{
+				Track_key:   "parent_ae_name",
+				Tag_keys:    []string{"device", "if_name"},
+				Tag_name:    "lag_id",
+				Pattern:     tt.pattern,
+				Replacement: tt.replacement,
+				Retention:   "1h",
+			}}})
+			learn := interfaceMetric("interface", "et-0/0/0", map[string]interface{}{"in_octets": int64(0)})
+			learn.AddTag("parent_ae_name", tt.value)
+			p.Apply(learn)
+			out := p.Apply(interfaceMetric("optics", "et-0/0/0", map[string]interface{}{"rx_power": -3.2}))
+			value, ok := out[0].GetTag("lag_id")
+			require.Equal(t, tt.expected != "", ok)
+			require.Equal(t, tt.expected, value)
+		})
+	}
+}
+
+func TestInvalidPattern(t *testing.T) {
+	p := &Xmetrictags{Fields: []xmetric{{Track_key: "a", Tag_keys: []string{"device"}, Tag_name: "b", Pattern: "(ae"}}}
+	require.Error(t, p.Init())
+}
+
+// The numeric fields are copied as fields, with their type
+func TestNumericFields(t *testing.T) {
+	for _, speed := range []interface{}{int64(100000), uint64(100000), float64(1e5)} {
+		p := newXmetrictags(t, &Xmetrictags{Fields: []xmetric{{Track_key: "speed", Tag_keys: []string{"device", "if_name"}, Tag_name: "if_speed", Retention: "1h"}}})
+		learned := p.Apply(interfaceMetric("interface", "et-0/0/0", map[string]interface{}{"speed": speed}))
+		require.Equal(t, map[string]interface{}{"speed": speed, "if_speed": speed}, learned[0].Fields())
+
+		out := p.Apply(interfaceMetric("interface_rate", "et-0/0/0", map[string]interface{}{"in_octets_rate": float64(10)}))
+		require.Equal(t, map[string]interface{}{"in_octets_rate": float64(10), "if_speed": speed}, out[0].Fields())
+		require.Equal(t, map[string]string{"device": "r1", "if_name": "et-0/0/0"}, out[0].Tags())
+	}
+}
+
+func TestDumpCache(t *testing.T) {
+	p := newXmetrictags(t, &Xmetrictags{
+		DumpInterval:    "1h",
+		DumpMeasurement: "lags",
+		Fields: []xmetric{
+			lagRule(),
+			{Track_key: "speed", Tag_keys: []string{"device", "if_name"}, Tag_name: "if_speed", Retention: "1h"},
+		},
+	})
+	out := p.Apply(interfaceMetric("interface", "et-0/0/0", map[string]interface{}{"parent_ae_name": "ae0", "speed": int64(100000)}))
+	require.Len(t, out, 3)
+	dumped := map[string]interface{}{}
+	for _, m := range out[1:] {
+		require.Equal(t, "lags", m.Name())
+		name, _ := m.GetTag("tag_name")
+		require.Equal(t, map[string]string{"device": "r1", "if_name": "et-0/0/0", "tag_name": name}, m.Tags())
+		age, _ := m.GetField("age")
+		require.Equal(t, int64(0), age)
+		dumped[name], _ = m.GetField("value")
+	}
+	require.Equal(t, map[string]interface{}{"lag_id": "ae0", "if_speed": int64(100000)}, dumped)
+
+	// the next dump is emitted after dump_interval
+	require.Len(t, p.Apply(interfaceMetric("optics", "et-0/0/0", nil)), 1)
+	p.last_dumped = time.Now().Add(-time.Hour)
+	require.Len(t, p.Apply(interfaceMetric("optics", "et-0/0/0", nil)), 3)
+}
+
+func TestRuleScoping(t *testing.T) {
+	rule := lagRule()
+	rule.Learn_from = []string{"/interfaces/interface/state"}
+	rule.Apply_to = []string{"/interfaces/interface/subinterfaces/*"}
+	p := newXmetrictags(t, &Xmetrictags{Fields: []xmetric{rule}})
+
+	// not learned from the other measurements
+	p.Apply(interfaceMetric("/lacp/interfaces", "et-0/0/0", map[string]interface{}{"parent_ae_name": "ae9"}))
+	require.Empty(t, p.cache)
+	p.Apply(interfaceMetric("/interfaces/interface/state", "et-0/0/0", map[string]interface{}{"parent_ae_name": "ae0"}))
+	require.Len(t, p.cache, 1)
+
+	out := p.Apply(
+		interfaceMetric("/interfaces/interface/subinterfaces/subinterface/state", "et-0/0/0", map[string]interface{}{"in_octets": int64(0)}),
+		interfaceMetric("/components/component/state", "et-0/0/0", map[string]interface{}{"temperature": int64(40)}),
+	)
+	lag, ok := out[0].GetTag("lag_id")
+	require.True(t, ok)
+	require.Equal(t, "ae0", lag)
+	require.False(t, out[1].HasTag("lag_id"))
+}
+
+func TestInvalidScope(t *testing.T) {
+	rule := lagRule()
+	rule.Apply_to = []string{"/interfaces/["}
+	require.Error(t, (&Xmetrictags{Tags: []xmetric{rule}}).Init())
+}